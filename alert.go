@@ -0,0 +1,232 @@
+/*
+	Description:
+		Implements `--exec` and `--webhook`, which turn a query run with
+		--follow or --watch into a lightweight real-time alert: each match
+		is printed as usual, and also handed to a command or POSTed to a
+		URL, one at a time or gathered into batches with --alert-every
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var alert_exec *string = flagset.String("exec", "", "")
+var alert_webhook *string = flagset.String("webhook", "", "")
+var alert_every *time.Duration = flagset.Duration("alert-every", 0, "")
+
+/* longest an alert's command may run, or its webhook take to answer */
+const alert_timeout time.Duration = 30 * time.Second
+
+/* highlighting escapes, which mean nothing to a command or a webhook */
+var color_re *regexp.Regexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+/*
+	Takes the place of the output when alerting, printing each match to
+	out as usual while queueing it to be alerted on. Alerts are sent one
+	after another rather than all at once, so a burst of matches can't
+	start a burst of commands; the matches wait their turn meanwhile
+*/
+type alerter struct {
+	out     io.Writer
+	every   time.Duration
+	logger  *slog.Logger
+	client  *http.Client
+	lock    sync.Mutex
+	pending []string
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func new_alerter(out io.Writer, every time.Duration, logger *slog.Logger) *alerter {
+	a := &alerter{
+		out:     out,
+		every:   every,
+		logger:  logger,
+		client:  &http.Client{Timeout: alert_timeout},
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+
+	return a
+}
+
+func (self *alerter) Write(p []byte) (int, error) {
+	// the Qreader writes its matches many at a time, a line each
+	self.lock.Lock()
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		self.pending = append(self.pending, color_re.ReplaceAllString(line, ""))
+	}
+	self.lock.Unlock()
+
+	// the alerts already have a wake-up coming if this doesn't go through
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+
+	return self.out.Write(p)
+}
+
+/*
+	Sends the queued matches until the alerter is closed: each on its
+	own, or with --alert-every, all those queued since the last alert
+	together, at most once every so often. The first of a quiet spell is
+	sent straight away, and whatever is left when the scan ends is sent
+	without waiting
+*/
+func (self *alerter) run() {
+	defer close(self.done)
+
+	var last time.Time
+	for range self.wake {
+		if wait := self.every - time.Since(last); self.every > 0 && wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-self.closing:
+			}
+		}
+
+		// wake-ups that came in while the last alert was sent may find
+		// its matches gone with it
+		matches := self.take()
+		if len(matches) == 0 {
+			continue
+		}
+		last = time.Now()
+		self.send(matches)
+	}
+
+	self.send(self.take())
+}
+
+/* returns the matches queued so far, emptying the queue */
+func (self *alerter) take() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	matches := self.pending
+	self.pending = nil
+	return matches
+}
+
+func (self *alerter) send(matches []string) {
+	if len(matches) == 0 {
+		return
+	}
+	if self.every > 0 {
+		self.alert(matches)
+		return
+	}
+	for _, match := range matches {
+		self.alert([]string{match})
+	}
+}
+
+/*
+	Runs the command and calls the webhook with these matches. One that
+	fails is reported and the alerts carry on, as the scan does
+*/
+func (self *alerter) alert(matches []string) {
+	if *alert_exec != "" {
+		if err := alert_command(*alert_exec, "", matches); err != nil {
+			self.logger.Error("unable to run --exec", "command", *alert_exec, "matches", len(matches), "err", err)
+		}
+	}
+	if *alert_webhook != "" {
+		if err := alert_webhook_post(self.client, *alert_webhook, "", matches); err != nil {
+			self.logger.Error("unable to call --webhook", "url", *alert_webhook, "matches", len(matches), "err", err)
+		}
+	}
+}
+
+/*
+	Waits for the last alerts to be sent. Nothing may be written to the
+	alerter afterwards
+*/
+func (self *alerter) close() {
+	close(self.closing)
+	close(self.wake)
+	<-self.done
+}
+
+/*
+	Returns the command to run the given one with the shell: sh, or cmd
+	on Windows, which has no sh
+*/
+func shell_command(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+/*
+	Runs the command with the shell, giving it the matches on STDIN, one
+	per line, how many there are in BRO_AWK_MATCHES, and the name of the
+	scheduled query they are from, if any, in BRO_AWK_QUERY. What it
+	prints goes to STDERR, keeping STDOUT for the matches
+*/
+func alert_command(command string, query string, matches []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alert_timeout)
+	defer cancel()
+
+	c := shell_command(ctx, command)
+	c.Env = append(os.Environ(), "BRO_AWK_MATCHES="+strconv.Itoa(len(matches)))
+	if query != "" {
+		c.Env = append(c.Env, "BRO_AWK_QUERY="+query)
+	}
+	c.Stdin = strings.NewReader(strings.Join(matches, "\n") + "\n")
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+
+	return c.Run()
+}
+
+/*
+	POSTs the matches to the URL as JSON. They are in "matches", and in
+	"text" as well, one per line, which is what chat services' incoming
+	webhooks post as the message. The scheduled query they are from, if
+	any, is in "query"
+*/
+func alert_webhook_post(client *http.Client, location string, query string, matches []string) error {
+	body, err := json.Marshal(struct {
+		Text    string   `json:"text"`
+		Query   string   `json:"query,omitempty"`
+		Matches []string `json:"matches"`
+	}{strings.Join(matches, "\n"), query, matches})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(location, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", location, resp.Status)
+	}
+	return nil
+}
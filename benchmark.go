@@ -0,0 +1,45 @@
+/*
+	Description:
+		Implements `--no-output`, which runs a query through the whole
+		pipeline of reading, splitting and filtering but throws the
+		matches away, reporting only how many there were and how fast the
+		logs were scanned -- for measuring a query or a machine, and for
+		planning how long sweeps will take, without flooding the terminal
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+	"time"
+)
+
+var no_output *bool = flagset.Bool("no-output", false, "")
+
+/*
+	Prints how much was scanned in the time elapsed and how fast, and how
+	many of the lines matched. Lines are the records of the logs, so that
+	the share matched is of those, and bytes are those of the logs
+	decompressed
+*/
+func report_throughput(q *qreader.Qreader, elapsed time.Duration) {
+	totals := q.Totals()
+
+	per_second := 0.0
+	if elapsed > 0 {
+		per_second = float64(totals.Lines) / elapsed.Seconds()
+	}
+	share := 0.0
+	if totals.Lines > 0 {
+		share = float64(totals.Matches) * 100 / float64(totals.Lines)
+	}
+
+	fmt.Printf("logs\t%d\n", totals.Logs)
+	fmt.Printf("lines\t%d\n", totals.Lines)
+	fmt.Printf("bytes\t%d (%s)\n", totals.Bytes, format_bytes(totals.Bytes))
+	fmt.Printf("matches\t%d (%.2f%%)\n", totals.Matches, share)
+	fmt.Printf("elapsed\t%s\n", round(elapsed))
+	fmt.Printf("lines/s\t%.0f\n", per_second)
+	fmt.Printf("bytes/s\t%d (%s/s)\n", rate(totals.Bytes, elapsed), format_bytes(rate(totals.Bytes, elapsed)))
+}
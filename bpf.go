@@ -0,0 +1,158 @@
+/*
+	Description:
+		Implements `--bpf`, which turns the connections among the matches
+		into a BPF filter for them, or a tcpdump command ready to run on
+		the sensor, so that what a hunt turns up in the logs can be
+		captured as packets from then on:
+
+			bro-awk 'id.resp_p=4444' --bpf filter conn.log.gz
+			bro-awk 'id.resp_p=4444' --bpf tcpdump:eth1 conn.log.gz | sh
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var bpf *string = flagset.String("bpf", "", "")
+
+/* the file `--bpf tcpdump` writes its capture to */
+const bpf_capture string = "bro-awk.pcap"
+
+/*
+	A service the matches connected to: a responder's address, port and
+	transport protocol, with the originators that connected to it
+*/
+type bpf_service struct {
+	host  string
+	proto string
+	port  string
+	peers map[string]bool
+}
+
+/*
+	Checks that --bpf is filter or tcpdump, the latter with the interface
+	to capture on if given, returning that interface (any, by default)
+*/
+func bpf_mode() (string, string) {
+	mode, iface, _ := strings.Cut(*bpf, ":")
+	if mode != "filter" && mode != "tcpdump" {
+		fail(ErrUsage, fmt.Sprintf("--bpf must be filter or tcpdump[:IFACE], not %s", *bpf))
+	}
+	if mode == "filter" && iface != "" {
+		fail(ErrUsage, fmt.Sprintf("only --bpf tcpdump takes an interface, not --bpf %s", *bpf))
+	}
+
+	return mode, cmp.Or(iface, "any")
+}
+
+/*
+	Writes a BPF filter for the connections among the matches, or a
+	tcpdump command capturing them. Each is the traffic between its two
+	hosts to the responder's port, over the same protocol, since the
+	originator's port changes from one connection to the next. The
+	connections to the same service are gathered into one clause of the
+	filter, so that each pair of hosts and service is in it only once
+*/
+func export_bpf(q *qreader.Qreader, logs []string) {
+	mode, iface := bpf_mode()
+
+	services := make(map[string]*bpf_service)
+	for record, err := range q.Scan(logs...).Seq() {
+		if err != nil {
+			fail_with(err)
+		}
+
+		values := make([]string, 0, 3)
+		for _, field := range []string{"id.orig_h", "id.resp_h", "id.resp_p"} {
+			value, ok := record.Get(field)
+			if !ok {
+				fail(ErrMissingField, fmt.Sprintf("%s has no field named %s, so no connection to filter on", record.Filename, field),
+					"file", record.Filename, "field", field)
+			}
+			values = append(values, value)
+		}
+		orig, resp, port := values[0], values[1], values[2]
+		if orig == "-" || resp == "-" {
+			continue
+		}
+
+		// logs other than conn.log, such as http.log, don't say which
+		// protocol, leaving the port to match either
+		proto, _ := record.Get("proto")
+		if proto == "-" {
+			proto = ""
+		}
+
+		key := resp + "\t" + proto + "\t" + port
+		service, ok := services[key]
+		if !ok {
+			service = &bpf_service{host: resp, proto: proto, port: port, peers: make(map[string]bool)}
+			services[key] = service
+		}
+		service.peers[orig] = true
+	}
+
+	if len(services) == 0 {
+		fail(ErrUsage, "nothing matched, so there is nothing to capture")
+	}
+
+	clauses := make([]string, 0, len(services))
+	for _, key := range slices.Sorted(maps.Keys(services)) {
+		clauses = append(clauses, services[key].clause())
+	}
+	filter := strings.Join(clauses, " or ")
+
+	if mode == "filter" {
+		fmt.Println(filter)
+		return
+	}
+	fmt.Println(quote_args([]string{"tcpdump", "-i", iface, "-nn", "-w", bpf_capture, filter}))
+}
+
+/*
+	Returns the clause of the filter matching the traffic between the
+	service and its peers, e.g.
+
+		(host 10.0.0.5 and tcp port 4444 and (host 192.168.1.7 or host 192.168.1.9))
+
+	ICMP has no ports, Zeek logging its type and code as them instead, so
+	only the protocol and hosts are matched for it
+*/
+func (self *bpf_service) clause() string {
+	terms := []string{"host " + self.host}
+	switch self.proto {
+	case "tcp", "udp":
+		terms = append(terms, self.proto+" port "+self.port)
+	case "icmp":
+		// icmp6 is the protocol of ICMP over IPv6 to BPF
+		if strings.Contains(self.host, ":") {
+			terms = append(terms, "icmp6")
+		} else {
+			terms = append(terms, "icmp")
+		}
+	case "":
+		if self.port != "-" {
+			terms = append(terms, "port "+self.port)
+		}
+	}
+
+	peers := slices.Sorted(maps.Keys(self.peers))
+	if len(peers) == 1 {
+		terms = append(terms, "host "+peers[0])
+	} else {
+		hosts := make([]string, len(peers))
+		for i, peer := range peers {
+			hosts[i] = "host " + peer
+		}
+		terms = append(terms, "("+strings.Join(hosts, " or ")+")")
+	}
+
+	return "(" + strings.Join(terms, " and ") + ")"
+}
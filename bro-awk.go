@@ -10,39 +10,503 @@
 package main
 
 import (
+	"bro-awk/config"
+	"bro-awk/enrich"
 	"bro-awk/qreader"
+	"bro-awk/remote"
+	"bro-awk/schema"
+	"bufio"
+	"cmp"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
 	Prints a detail usage message showing how the script should be used
 */
 func usage() {
-	fmt.Println("USAGE:\n\tbro-awk [OPTIONS...] [FILTERS...] [LOGS...]\n")
-	fmt.Println("OPTIONS:\n\t-d, --debug\t\tturn on program debugging")
-	fmt.Println("\t-p, --print_fields\tonly print the listed fields\n")
-	fmt.Println("FILTER SYNTAX:\n\t[literal strings]\n\t<FIELD>=<VALUE>\n\t<FIELD>!=<VALUE>\n\n\t[regexes]\n\t<FIELD>~<VALUE>\n\t<FIELD!~<VALUE>>\n")
-	fmt.Println("EXAMPLES:\n\tTODO\n")
+	fmt.Print("USAGE:\n\tbro-awk [OPTIONS...] [FILTERS...] [LOGS...]\n")
+	fmt.Print("\tzcat conn.log.gz | bro-awk [OPTIONS...] [FILTERS...] [-]\n")
+	fmt.Print("\tbro-awk fields [LOGS...]\t\tprint the header (field names, types...) of each log\n")
+	fmt.Print("\tbro-awk history [N]\t\t\tlist the queries run before (the last N), numbered\n")
+	fmt.Print("\tbro-awk '!N' [ARGS...]\t\t\trun query N again (!-N counts back, !! is the last),\n")
+	fmt.Print("\t\t\t\t\t\twith ARGS added on\n")
+	fmt.Print("\tbro-awk pivot --uid <UIDS> [LOGS...]\tprint every line of every log about these connections or\n")
+	fmt.Print("\t\t\t\t\t\tfiles (uids, or a file listing them), e.g. of a day's directory\n")
+	fmt.Print("\tbro-awk diff --key <FIELD> [FILTERS...] <BEFORE> <AFTER>\n")
+	fmt.Print("\t\t\t\t\t\tprint the values of FIELD among the matches of only one of two\n")
+	fmt.Print("\t\t\t\t\t\tsets of logs (each a log, directory or glob), + for AFTER\n")
+	fmt.Print("\tbro-awk join --on <FIELD[:FIELD]> [FILTERS...] <LEFT> <RIGHT>\n")
+	fmt.Print("\t\t\t\t\t\tprint each match of LEFT alongside each of RIGHT with the same\n")
+	fmt.Print("\t\t\t\t\t\tvalue of FIELD (LEFT's and RIGHT's, if two are given)\n")
+	fmt.Print("\tbro-awk daemon [QUERIES...]\t\tkeep running the queries under [queries.NAME] in the config\n")
+	fmt.Print("\t\t\t\t\t\tfile (or only those named) on their schedules, each over the\n")
+	fmt.Print("\t\t\t\t\t\tlogs rotated in since it last ran\n")
+	fmt.Print("\tbro-awk serve [--grpc <ADDR>] [--http <ADDR>] <DIRS...>\n")
+	fmt.Print("\t\t\t\t\t\tanswer queries over the logs under DIRS sent to the Query\n")
+	fmt.Print("\t\t\t\t\t\tRPC of bro-awk.proto at the --grpc address, e.g. :9000,\n")
+	fmt.Print("\t\t\t\t\t\tstreaming back their matches, or submitted to the HTTP API\n")
+	fmt.Print("\t\t\t\t\t\tat the --http address, to be polled and downloaded\n\n")
+	fmt.Println("OPTIONS:\n\t    --debug\t\tturn on program debugging")
+	fmt.Println("\t-v, --verbose\t\tlog per-file progress to STDERR")
+	fmt.Println("\t-l, --files-with-matches\tonly print the names of the logs with a match, reading")
+	fmt.Println("\t\t\t\teach only as far as its first")
+	fmt.Println("\t    --count-per-file\tprint each log with how many of its lines matched and how")
+	fmt.Println("\t\t\t\tmany were read, separated by tabs, rather than the matches.")
+	fmt.Println("\t\t\t\tWith -l, only the logs with a match")
+	fmt.Println("\t-q, --quiet\t\tprint nothing and stop at the first match, exiting with 0 if")
+	fmt.Println("\t\t\t\tanything matched, 1 if nothing did or 2 on an error")
+	fmt.Println("\t-p, --print_fields\tonly print the listed fields, or @default for the usual")
+	fmt.Println("\t\t\t\tfields of each known log type")
+	fmt.Println("\t-d, --local-time\twrite time fields as dates and times in local time (or that of")
+	fmt.Println("\t\t\t\t--tz), e.g. 2024-05-01T12:00:00+0200, as zeek-cut -d does")
+	fmt.Println("\t-u, --utc-time\t\twrite time fields as dates and times in UTC, as zeek-cut -u does")
+	fmt.Println("\t    --fields <FIELDS>\tread logs that have lost their header as TSV with these")
+	fmt.Println("\t\t\t\tfields, or @TYPE (e.g. @conn) for those of a known log type, or @N")
+	fmt.Println("\t\t\t\tto name N columns by position, 1 to N")
+	fmt.Println("\t    --delimiter <SEP>\twith --fields, split lines on SEP (e.g. '|' or '\\t') rather than")
+	fmt.Println("\t\t\t\ttabs. A single space splits them on any run of whitespace")
+	fmt.Println("\t    --format <NAME>\tread logs laid out as the named format, e.g. squid, or one")
+	fmt.Println("\t\t\t\tdefined under [formats.NAME] in the config file")
+	fmt.Println("\t    --unzipper <PROG>\tprogram used to decompress .gz logs, or builtin to decompress")
+	fmt.Println("\t\t\t\tthem in process, as is done when none is installed")
+	fmt.Println("\t    --workers <N>\tnumber of parser workers")
+	fmt.Println("\t    --blocksize <N>\tsize of each read from a log, in bytes")
+	fmt.Println("\t    --max-line <N>\tskip lines longer than N bytes as junk, with a warning,")
+	fmt.Println("\t\t\t\tdefault 1048576")
+	fmt.Println("\t    --max-memory <MB>\tmemory past which reading waits for the matches already read")
+	fmt.Println("\t\t\t\tto be written out, e.g. to a slow pipe, default 512")
+	fmt.Println("\t    --verify\t\tcheck each gzip member of compressed logs against its CRC as it")
+	fmt.Println("\t\t\t\tis read, failing on a damaged archive rather than scanning")
+	fmt.Println("\t\t\t\twhatever the unzipper made of it")
+	fmt.Println("\t    --strict\t\tfail on the first line that doesn't fit its log's header, by")
+	fmt.Println("\t\t\t\tits number of fields or the types of its values, giving its")
+	fmt.Println("\t\t\t\tline number, rather than making what can be made of it")
+	fmt.Println("\t    --color <WHEN>\thighlight filtered fields: auto, always or never")
+	fmt.Println("\t    --sanitize <MODE>\tescape (as \\x1b, the default) or strip control characters and")
+	fmt.Println("\t\t\t\tbytes that aren't UTF-8 in printed fields, or none to leave them")
+	fmt.Println("\t    --escapes <MODE>\tprint values Zeek escaped (\\x09 for a tab, say) as the log")
+	fmt.Println("\t\t\t\twrote them (keep, the default) or decode them")
+	fmt.Println("\t    --config <FILE>\tread defaults from FILE instead of ~/.config/bro-awk/config.toml")
+	fmt.Println("\t    --check\t\tonly read each log's header and report which columns the")
+	fmt.Println("\t\t\t\tfilters and printed fields resolved to, without scanning")
+	fmt.Println("\t    --explain\t\tshow what the query would do without scanning: the filters as")
+	fmt.Println("\t\t\t\tparsed, how each value is tested and which are plain text found")
+	fmt.Println("\t\t\t\twithout the regex engine, the column of each field in each log,")
+	fmt.Println("\t\t\t\tand the logs left out by --log-type, --from and --to")
+	fmt.Println("\t    --follow\t\tkeep reading each log as Zeek appends to it, like `tail -f`,")
+	fmt.Println("\t\t\t\tafter scanning what is already there. Survives Zeek rotating it")
+	fmt.Println("\t    --enrich <KIND:FIELDS>\tadd columns worked out from these fields to each match,")
+	fmt.Println("\t\t\t\te.g. geo:id.resp_h for its country and city, from the")
+	fmt.Println("\t\t\t\tdatabase under [geoip] in the config file, or ptr:id.resp_h for its")
+	fmt.Println("\t\t\t\treverse DNS name, or describe:conn_state,history for what their")
+	fmt.Println("\t\t\t\tcodes mean, service:id.resp_p for the name of the port, or")
+	fmt.Println("\t\t\t\tfingerprint:ja3 for the label of a known TLS fingerprint from the")
+	fmt.Println("\t\t\t\tfile named by fingerprints in the config file, or oui:mac for the")
+	fmt.Println("\t\t\t\tvendor of a MAC address. May be given more than once")
+	fmt.Println("\t    --ptr-timeout <DUR>\tlongest a reverse DNS lookup for ptr may take, default 2s")
+	fmt.Println("\t    --intel <FIELD[:TYPE]>\twrite the distinct values of FIELD among the matches as a")
+	fmt.Println("\t\t\t\tZeek intel file instead, with the Intel::TYPE (e.g. ADDR, DOMAIN)")
+	fmt.Println("\t\t\t\tworked out from the field if not given")
+	fmt.Println("\t    --intel-source <NAME>\tmeta.source of the --intel indicators, default bro-awk")
+	fmt.Println("\t    --bpf <MODE>\twrite a BPF filter for the connections among the matches")
+	fmt.Println("\t\t\t\tinstead (filter), or a tcpdump command capturing them on the")
+	fmt.Println("\t\t\t\tinterface given, or any (tcpdump[:IFACE])")
+	fmt.Println("\t    --report <FILE>\twrite an HTML report of the matches to FILE instead: the query,")
+	fmt.Println("\t\t\t\thow much matched, the commonest values of telling fields (or")
+	fmt.Println("\t\t\t\tthose printed) and a sample of the matches")
+	fmt.Println("\t    --dedupe\t\tprint records matched in more than one log only once, e.g. when")
+	fmt.Println("\t\t\t\trotated copies overlap. Records are the same if their log type,")
+	fmt.Println("\t\t\t\tts and uid are. Records are remembered until their ts is an hour")
+	fmt.Println("\t\t\t\tbehind the latest matched, or --dedupe-window")
+	fmt.Println("\t    --dedupe-window <DUR>\thow long --dedupe remembers records for, default 1h")
+	fmt.Println("\t    --progress\t\tshow how much of the logs has been read on STDERR, how fast,")
+	fmt.Println("\t\t\t\tand how long the rest should take, overall and for each log")
+	fmt.Println("\t    --no-output\t\tscan the logs as usual but throw the matches away, printing")
+	fmt.Println("\t\t\t\tonly how many lines (and bytes) were scanned, how many matched,")
+	fmt.Println("\t\t\t\tand how fast")
+	fmt.Println("\t    --no-cache\t\tscan every log, rather than printing the matches of logs")
+	fmt.Println("\t\t\t\tscanned before by the same query from the cache")
+	fmt.Println("\t    --merge-sort-ts\tscan the logs all at once and print their matches merged in")
+	fmt.Println("\t\t\t\torder of their ts field, rather than one log after another")
+	fmt.Println("\t    --resume\t\ttake up an interrupted scan of the same logs with the same")
+	fmt.Println("\t\t\t\tquery where it left off, rather than starting over")
+	fmt.Println("\t    --preview <N>\tshow the first N matches on STDERR, with the size of the scan")
+	fmt.Println("\t\t\t\tand how long it should take, and ask before scanning the rest.")
+	fmt.Println("\t\t\t\tStops there without a terminal to ask on")
+	fmt.Println("\t    --timing\t\tonce the logs have been scanned, show on STDERR how long each took")
+	fmt.Println("\t\t\t\tand where the time went: reading it from storage, decompressing")
+	fmt.Println("\t\t\t\tit, splitting lines, filtering them and writing out the matches")
+	fmt.Println("\t    --tui\t\tshow the matches in a table that can be scrolled, searched,")
+	fmt.Println("\t\t\t\tsorted by any column, and have columns hidden, as they come in")
+	fmt.Println("\t    --fail-fast\t\tstop at the first log that can't be scanned, rather than")
+	fmt.Println("\t\t\t\tscanning the rest and reporting each that couldn't at the end")
+	fmt.Println("\t    --watch <DIR>\tscan each compressed log that appears under DIR from now on,")
+	fmt.Println("\t\t\t\tonce it has been completely written (no logs need be given)")
+	fmt.Println("\t    --exec <CMD>\twith --follow or --watch, run CMD with the shell for each match,")
+	fmt.Println("\t\t\t\tgiving it the match on STDIN, as well as printing it")
+	fmt.Println("\t    --webhook <URL>\twith --follow or --watch, POST each match to URL as JSON, as")
+	fmt.Println("\t\t\t\twell as printing it")
+	fmt.Println("\t    --alert-every <DUR>\tgather the matches for --exec and --webhook into one alert")
+	fmt.Println("\t\t\t\tat most every DUR (e.g. 1m), rather than alerting on each")
+	fmt.Println("\t    --rate-limit <N/UNIT>\twith --follow, --watch or streamed logs (kafka://, tcp://,")
+	fmt.Println("\t\t\t\tudp://), print (and alert on) at most N matches per second (s),")
+	fmt.Println("\t\t\t\tminute (m) or hour (h), e.g. 1000/s")
+	fmt.Println("\t    --rate-limit-policy <POLICY>\twhat becomes of matches over --rate-limit: block to")
+	fmt.Println("\t\t\t\thold up the scan until they can go (the default), or drop")
+	fmt.Println("\t    --state <FILE>\twhere `bro-awk daemon` keeps how far each query has got,")
+	fmt.Println("\t\t\t\tdefault ~/.local/state/bro-awk/daemon.json")
+	fmt.Println("\t    --max-queries <N>\tnumber of queries `bro-awk serve` runs at once, the rest")
+	fmt.Println("\t\t\t\twaiting their turn, default 2")
+	fmt.Println("\t    --query-timeout <DUR>\tlongest a query to `bro-awk serve` may run, default 1h")
+	fmt.Println("\t    --query-max-results <N>\tmost matches a query to `bro-awk serve` may send back,")
+	fmt.Println("\t\t\t\tdefault 1000000")
+	fmt.Println("\t    --kcat <PROG>\tprogram used to consume kafka:// topics, default kcat")
+	fmt.Println("\t    --ssh <PROG>\t\tprogram used to reach ssh:// hosts, default ssh")
+	fmt.Println("\t    --remote-workers <N>\tnumber of ranged requests made at once per remote log")
+	fmt.Println("\t    --header <HEADER>\textra header for http(s):// logs, e.g. \"Authorization: Bearer ...\",")
+	fmt.Println("\t\t\t\tmay be given more than once")
+	fmt.Println("\t    --errors <FORMAT>\treport fatal errors on STDERR as text or json")
+	fmt.Println("\t    --files-from <FILE>\tscan the logs listed in FILE, one per line, or in STDIN for -")
+	fmt.Println("\t    --sort <ORDER>\torder the logs matched by a quoted glob pattern by")
+	fmt.Println("\t\t\t\tname or mtime (oldest first), default name")
+	fmt.Println("\t    --log-type <TYPES>\tonly take these log types (e.g. conn,dns) from directories")
+	fmt.Println("\t    --from <TIME>\tonly take logs from this day (e.g. 2024-05-01) or time")
+	fmt.Println("\t\t\t\t(e.g. 2024-05-01T10:00) on from directories")
+	fmt.Println("\t    --to <TIME>\t\tonly take logs up to the end of this day, or up to this time,")
+	fmt.Println("\t\t\t\tfrom directories")
+	fmt.Println("\t    --tz <ZONE>\t\ttimezone of times given without an offset, in filters,")
+	fmt.Println("\t\t\t\t--from and --to, and of the archive's days and hours: UTC (the")
+	fmt.Println("\t\t\t\tdefault unless TZ is set), local, or a name like Europe/Berlin")
+	fmt.Print("\t    --version\t\tprint version and build information\n\n")
+	fmt.Print("\tOptions may appear anywhere on the command line, in short or long form,\n")
+	fmt.Print("\tas either `--print_fields uid` or `--print_fields=uid`\n\n")
+	fmt.Print("\tLogs may be given as glob patterns, e.g. '/logs/2024-05-01/conn.*.log.gz', which\n")
+	fmt.Print("\tare expanded by bro-awk itself when quoted so that the shell's ARG_MAX isn't a limit.\n")
+	fmt.Print("\tDirectories are searched recursively, dating logs by the YYYY-MM-DD in their path.\n")
+	fmt.Print("\tTar archives (.tar, .tar.gz, .tgz) are read in place, scanning each log inside.\n")
+	fmt.Print("\tLogs in cloud storage are streamed straight from s3://, gs:// and az:// URIs,\n")
+	fmt.Print("\tlogs served over the web from http:// and https:// URLs, and logs on other hosts\n")
+	fmt.Print("\tfrom ssh://host:/path/to/conn.log.gz. Kafka topics are\n")
+	fmt.Print("\tread continuously from kafka://broker:9092/topic[?group=NAME&offset=WHERE&path=TYPE],\n")
+	fmt.Print("\tand lines forwarded by syslog are received on tcp://:PORT[?path=TYPE] or udp://:PORT\n\n")
+	fmt.Print("FILTER SYNTAX:\n\t[literal strings]\n\t<FIELD>=<VALUE>\n\t<FIELD>!=<VALUE>\n\n\t[regexes]\n\t<FIELD>~<VALUE>\n\t<FIELD!~<VALUE>>\n\n\t[comparisons, by the type of the field]\n\t<FIELD><<VALUE>\n\t<FIELD><=<VALUE>\n\t<FIELD>><VALUE>\n\t<FIELD>>=<VALUE>\n\n\t[named predicates]\n\t<FIELD>|<NAME>\n\t<FIELD>!|<NAME>\n\n\t[words, in free text such as weird and notice messages]\n\t<FIELD>%<WORDS>\n\t<FIELD>!%<WORDS>\n\n")
+	fmt.Print("\t[presets from the config file]\n\t@<PRESET>\n\n")
+	fmt.Print("\tDerived fields, such as total_bytes in conn.log or validation_class in ssl.log, can\n")
+	fmt.Print("\tbe filtered on and printed like the others. `bro-awk fields` lists those each log has\n\n")
+	fmt.Print("ENVIRONMENT:\n\tBRO_AWK_UNZIPPER, BRO_AWK_THREADS, BRO_AWK_BLOCKSIZE, BRO_AWK_COLOR\n")
+	fmt.Print("\t\toverride the config file, and are overridden by flags\n")
+	fmt.Print("\tBRO_AWK_CONFIG\n\t\tpath of the config file\n")
+	fmt.Print("\tBRO_AWK_HISTORY\n\t\tpath of the history of queries run, or off to keep none\n")
+	fmt.Print("\tAWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION, AWS_ENDPOINT_URL\n")
+	fmt.Print("\t\tcredentials and location for s3:// logs\n")
+	fmt.Print("\tGOOGLE_OAUTH_ACCESS_TOKEN, GOOGLE_APPLICATION_CREDENTIALS, STORAGE_EMULATOR_HOST\n")
+	fmt.Print("\t\tcredentials and location for gs:// logs\n")
+	fmt.Print("\tAZURE_STORAGE_ACCOUNT, AZURE_STORAGE_SAS_TOKEN, AZURE_STORAGE_KEY, AZURE_STORAGE_BLOB_ENDPOINT\n")
+	fmt.Print("\t\tcredentials and location for az://container/blob logs\n\n")
+	fmt.Print("EXAMPLES:\n\tTODO\n\n")
 	os.Exit(1)
 }
 
+//--------------------------------------------------------------------------------
+//	OPTION FLAGS
+//--------------------------------------------------------------------------------
+
+var flagset *flag.FlagSet = flag.NewFlagSet("bro-awk", flag.ContinueOnError)
+
+var print_fields *string = flagset.String("p", "", "")
+var debug *bool = flagset.Bool("debug", false, "")
+var local_times *bool = flagset.Bool("d", false, "")
+var utc_times *bool = flagset.Bool("u", false, "")
+var verbose *bool = flagset.Bool("v", false, "")
+var help *bool = flagset.Bool("h", false, "")
+var show_version *bool = flagset.Bool("version", false, "")
+var dry_run *bool = flagset.Bool("check", false, "")
+var follow *bool = flagset.Bool("follow", false, "")
+var dedupe *bool = flagset.Bool("dedupe", false, "")
+var dedupe_window *time.Duration = flagset.Duration("dedupe-window", qreader.DefaultDedupeWindow, "")
+var fail_fast *bool = flagset.Bool("fail-fast", false, "")
+var strict *bool = flagset.Bool("strict", false, "")
+var verify *bool = flagset.Bool("verify", false, "")
+
+var unzipper *string = flagset.String("unzipper", "", "")
+var workers *int = flagset.Int("workers", 0, "")
+var blocksize *int = flagset.Int("blocksize", 0, "")
+var max_line *int = flagset.Int("max-line", 0, "")
+var max_memory *int = flagset.Int("max-memory", 0, "")
+var color *string = flagset.String("color", "", "")
+var sanitize *string = flagset.String("sanitize", "", "")
+var escapes *string = flagset.String("escapes", "", "")
+var config_path *string = flagset.String("config", config.DefaultPath(), "")
+var sort_by *string = flagset.String("sort", "name", "")
+var remote_workers *int = flagset.Int("remote-workers", remote.Concurrency, "")
+var kcat *string = flagset.String("kcat", "", "")
+var ssh *string = flagset.String("ssh", remote.SSH, "")
+var header_fields *string = flagset.String("fields", "", "")
+var delimiter *string = flagset.String("delimiter", "", "")
+var format *string = flagset.String("format", "", "")
+var files_from *string = flagset.String("files-from", "", "")
+var ptr_timeout *time.Duration = flagset.Duration("ptr-timeout", enrich.PTRTimeout, "")
+
+/*
+	Value of a flag that may be given more than once, e.g. --header,
+	collecting every value given
+*/
+type repeated []string
+
+func (self *repeated) String() string {
+	return strings.Join(*self, ", ")
+}
+
+func (self *repeated) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
+var headers repeated
+var enrichments repeated
+
+func init() {
+	flagset.Var(&headers, "header", "")
+	flagset.Var(&enrichments, "enrich", "")
+}
+
+/*
+	Long names for each of the short flags above. Both names share the
+	same underlying value
+*/
+var long_flags map[string]string = map[string]string{
+	"print_fields":       "p",
+	"local-time":         "d",
+	"utc-time":           "u",
+	"verbose":            "v",
+	"quiet":              "q",
+	"files-with-matches": "l",
+	"help":               "h",
+}
+
+func init() {
+	flagset.Usage = usage
+	for long, short := range long_flags {
+		flagset.Var(flagset.Lookup(short).Value, long, "")
+	}
+}
+
+/*
+	Separates option flags (and their values) from the rest of the arguments
+	so that flags can be given in any position, then parses them. Returns
+	the remaining positional arguments
+*/
+func parse_flags(args []string) []string {
+	flag_args := make([]string, 0)
+	positional := make([]string, 0)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// everything after a bare `--` is positional
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+
+		// anything not shaped like a flag is positional, including a lone `-`
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+
+		flag_args = append(flag_args, arg)
+
+		// pull the following argument along with the flag if it takes a
+		// value that wasn't given inline as --flag=value
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := flagset.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if i+1 < len(args) {
+			flag_args = append(flag_args, args[i+1])
+			i++
+		}
+	}
+
+	// the flagset reports its own errors and prints the usage message
+	if err := flagset.Parse(flag_args); err != nil {
+		os.Exit(1)
+	}
+
+	if *help {
+		usage()
+	}
+
+	if *show_version {
+		print_version()
+		os.Exit(0)
+	}
+
+	if *error_format != "text" && *error_format != "json" {
+		fail(ErrUsage, fmt.Sprintf("--errors must be text or json, not %s", *error_format))
+	}
+
+	if *sort_by != "name" && *sort_by != "mtime" {
+		fail(ErrUsage, fmt.Sprintf("--sort must be name or mtime, not %s", *sort_by))
+	}
+
+	if *tui && (*intel != "" || *show_progress || *show_timing) {
+		fail(ErrUsage, "--tui can't be combined with --intel, --progress or --timing, which write elsewhere")
+	}
+
+	if (*show_progress || *show_timing) && *follow {
+		fail(ErrUsage, "--progress and --timing can't be combined with --follow, since followed logs never end")
+	}
+
+	if *preview < 0 {
+		fail(ErrUsage, fmt.Sprintf("--preview must be at least 1, not %d", *preview))
+	}
+	if *preview > 0 && (*follow || *watch_dir != "" || *tui || *intel != "") {
+		fail(ErrUsage, "--preview can't be combined with --follow, --watch, --tui or --intel")
+	}
+
+	if (*list_files || *count_per_file) && (*follow || *watch_dir != "" || *tui || *intel != "" || *preview > 0 || *quiet) {
+		fail(ErrUsage, "-l and --count-per-file can't be combined with --follow, --watch, --tui, --intel, --preview or -q")
+	}
+
+	if *no_output && (*follow || *watch_dir != "" || *tui || *intel != "" || *preview > 0 || *quiet || *list_files || *count_per_file) {
+		fail(ErrUsage, "--no-output can't be combined with --follow, --watch, --tui, --intel, --preview, -q, -l or --count-per-file")
+	}
+
+	if *local_times && *utc_times {
+		fail(ErrUsage, "-d and -u can't be combined, times are written either in local time or in UTC")
+	}
+
+	if *report != "" && (*follow || *watch_dir != "" || *tui || *intel != "" || *preview > 0 || *quiet || *list_files || *count_per_file || *no_output) {
+		fail(ErrUsage, "--report can't be combined with --follow, --watch, --tui, --intel, --preview, -q, -l, --count-per-file or --no-output")
+	}
+
+	if *merge_sort_ts && (*follow || *watch_dir != "" || *tui || *intel != "" || *report != "" || *preview > 0 || *quiet || *list_files || *count_per_file) {
+		fail(ErrUsage, "--merge-sort-ts can't be combined with --follow, --watch, --tui, --intel, --report, --preview, -q, -l or --count-per-file")
+	}
+
+	if *resume && *strict {
+		fail(ErrUsage, "--resume can't be combined with --strict, which numbers the lines of each log from its start")
+	}
+	if *resume && (*follow || *watch_dir != "" || *tui || *intel != "" || *report != "" || *preview > 0 || *quiet || *list_files || *count_per_file || *merge_sort_ts) {
+		fail(ErrUsage, "--resume can't be combined with --follow, --watch, --tui, --intel, --report, --preview, -q, -l, --count-per-file or --merge-sort-ts")
+	}
+
+	if *bpf != "" {
+		if *follow || *watch_dir != "" || *tui || *intel != "" || *report != "" || *preview > 0 || *quiet || *list_files || *count_per_file || *no_output || *merge_sort_ts || *resume {
+			fail(ErrUsage, "--bpf can't be combined with --follow, --watch, --tui, --intel, --report, --preview, -q, -l, --count-per-file, --no-output, --merge-sort-ts or --resume")
+		}
+		bpf_mode()
+	}
+
+	if *quiet && (*watch_dir != "" || *tui || *intel != "" || *preview > 0 || *show_progress || *show_timing) {
+		fail(ErrUsage, "-q can't be combined with --watch, --tui, --intel, --preview, --progress or --timing")
+	}
+
+	if *alert_exec != "" || *alert_webhook != "" {
+		if !*follow && *watch_dir == "" {
+			fail(ErrUsage, "--exec and --webhook need --follow or --watch, to alert on matches as they come in")
+		}
+		if *tui || *quiet {
+			fail(ErrUsage, "--exec and --webhook can't be combined with --tui or -q")
+		}
+	}
+	if *rate_limit != "" {
+		if *tui || *quiet {
+			fail(ErrUsage, "--rate-limit can't be combined with --tui or -q")
+		}
+		if _, _, err := parse_rate(*rate_limit); err != nil {
+			fail(ErrUsage, err.Error())
+		}
+	}
+	if *rate_policy != "block" && *rate_policy != "drop" {
+		fail(ErrUsage, fmt.Sprintf("--rate-limit-policy must be block or drop, not %s", *rate_policy))
+	}
+	if *alert_every < 0 {
+		fail(ErrUsage, fmt.Sprintf("--alert-every can't be negative, not %s", *alert_every))
+	}
+	if *alert_webhook != "" {
+		if uri, err := url.Parse(*alert_webhook); err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+			fail(ErrUsage, fmt.Sprintf("--webhook needs an http:// or https:// URL, not %s", *alert_webhook))
+		}
+	}
+
+	if *max_line < 0 {
+		fail(ErrUsage, fmt.Sprintf("--max-line must be at least 1, not %d", *max_line))
+	}
+	if *max_memory < 0 {
+		fail(ErrUsage, fmt.Sprintf("--max-memory must be at least 1, not %d", *max_memory))
+	}
+
+	if *remote_workers < 1 {
+		fail(ErrUsage, fmt.Sprintf("--remote-workers must be at least 1, not %d", *remote_workers))
+	}
+	remote.Concurrency = *remote_workers
+	remote.Kcat = *kcat
+	remote.SSH = *ssh
+
+	if *ptr_timeout <= 0 {
+		fail(ErrUsage, fmt.Sprintf("--ptr-timeout must be more than 0, not %s", *ptr_timeout))
+	}
+	enrich.PTRTimeout = *ptr_timeout
+
+	if *dedupe_window <= 0 {
+		fail(ErrUsage, fmt.Sprintf("--dedupe-window must be more than 0, not %s", *dedupe_window))
+	}
+
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			fail(ErrUsage, fmt.Sprintf("--header must look like \"Name: value\", not %s", header))
+		}
+		remote.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if *watch_dir != "" {
+		if info, err := os.Stat(*watch_dir); err != nil || !info.IsDir() {
+			fail(ErrUsage, fmt.Sprintf("--watch needs a directory, not %s", *watch_dir), "file", *watch_dir)
+		}
+	}
+
+	return positional
+}
+
 /*
 	Parses user arguments so that they don't have to use command-line
 	flags because those are so 1990s
 */
 
-var log_re *regexp.Regexp = regexp.MustCompile(`.*\.log(?:\.gz)?$`)
-var filter_re *regexp.Regexp = regexp.MustCompile(`^\S+(?:=|!=|~|!~)\S+$`)
+var log_re *regexp.Regexp = regexp.MustCompile(`.*\.(?:(?:log|json|ndjson|csv)(?:\.gz)?|tar|tar\.gz|tgz)$`)
+var filter_re *regexp.Regexp = regexp.MustCompile(`^\S+(?:=|!=|~|!~|!?%|<=?|>=?)\S+$|^\S+!?\|\w+(?:,\w+)*$`)
+var preset_re *regexp.Regexp = regexp.MustCompile(`^@\S+$`)
 
-func parse_args(args []string) ([]string, []string) {
+func parse_args(args []string, presets map[string][]string) ([]string, []string) {
 
 	// make sure at least some arguments were supplied
-	if len(args) == 1 {
-		fmt.Println("[ERROR] not enough arguments")
-		os.Exit(1)
+	if len(args) == 0 {
+		fail(ErrUsage, "not enough arguments")
 	}
 
 	// if not, then continue to parse the arguments, adding them
@@ -50,53 +514,628 @@ func parse_args(args []string) ([]string, []string) {
 	logs := make([]string, 0)
 	filters := make([]string, 0)
 
-	for _, arg := range args[1:] {
-		if filter_re.MatchString(arg) {
+	for _, arg := range args {
+		if remote.IsRemote(arg) {
+			logs = append(logs, arg)
+		} else if filter_re.MatchString(arg) {
 			filters = append(filters, arg)
-		} else if log_re.MatchString(arg) {
+		} else if preset_re.MatchString(arg) {
+			preset, ok := presets[arg[1:]]
+			if !ok {
+				fail(ErrUnknownPreset, fmt.Sprintf("no preset named %s in %s", arg[1:], *config_path), "preset", arg[1:])
+			}
+			filters = append(filters, preset...)
+		} else if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			logs = append(logs, walk_dir(arg)...)
+		} else if strings.ContainsAny(arg, "*?[") {
+			logs = append(logs, expand_glob(arg)...)
+		} else if arg == qreader.Stdin || log_re.MatchString(arg) {
 			logs = append(logs, arg)
+		} else if info, err := os.Stat(arg); err == nil && *header_fields != "" && info.Mode().IsRegular() {
+			// extracts given --fields can be named anything
+			logs = append(logs, arg)
+		} else if strings.ContainsAny(arg, "=~!<>|%") {
+			// most likely a filter with a typo, e.g. proto==tcp, which
+			// is better reported as one
+			filters = append(filters, arg)
+		} else {
+			fail(ErrUsage, fmt.Sprintf("%s is neither a filter nor a log. Use `bro-awk --help` for more info", arg))
+		}
+	}
+
+	if *files_from != "" {
+		if *files_from == qreader.Stdin && slices.Contains(logs, qreader.Stdin) {
+			fail(ErrUsage, "STDIN can't be both a log and the list given to --files-from")
 		}
+		logs = append(logs, read_list(*files_from)...)
 	}
 
-	// make sure that some parameters were supplied for both logs and filters
+	// make sure that some parameters were supplied for both logs and filters,
+	// reading from STDIN if something is being piped in
 
-	if len(logs) == 0 {
-		fmt.Println("[ERROR] No logs specified. Use `bro-awk --help` for more info")
-		os.Exit(1)
+	if len(logs) == 0 && *watch_dir == "" && *files_from != qreader.Stdin && !stdin_is_terminal() {
+		logs = append(logs, qreader.Stdin)
+	}
+
+	if len(logs) == 0 && *watch_dir == "" {
+		fail(ErrUsage, "No logs specified. Use `bro-awk --help` for more info")
 	}
 
 	if len(filters) == 0 {
-		fmt.Println("[ERROR] No filters specified. Use `bro-awk --help` for more info")
-		os.Exit(1)
+		fail(ErrUsage, "No filters specified. Use `bro-awk --help` for more info")
 	}
 
 	return logs, filters
 }
 
+/*
+	Takes the delimiter and fields of --format from the named profile,
+	unless they were given themselves, and checks that --delimiter has
+	fields to go with it. Profiles from the config file are registered
+	first, so that they can replace the built-in ones
+*/
+func resolve_format(cfg *config.Config) {
+	for name, f := range cfg.Formats {
+		if len(f.Fields) == 0 {
+			fail(ErrBadConfig, fmt.Sprintf("[formats.%s] in %s has no fields", name, *config_path), "format", name)
+		}
+		qreader.RegisterProfile(name, qreader.Profile{Delimiter: f.Delimiter, Fields: f.Fields})
+	}
+
+	if *format != "" {
+		profile, ok := qreader.LookupProfile(*format)
+		if !ok {
+			fail(ErrUsage, fmt.Sprintf("no format named %s", *format), "format", *format)
+		}
+		if *header_fields == "" {
+			*header_fields = strings.Join(profile.Fields, ",")
+		}
+		if *delimiter == "" {
+			*delimiter = profile.Delimiter
+		}
+	}
+
+	// allow escapes such as \t, which are awkward to type in a shell
+	if unquoted, err := strconv.Unquote(`"` + *delimiter + `"`); err == nil {
+		*delimiter = unquoted
+	}
+	if *delimiter != "" && *header_fields == "" {
+		fail(ErrUsage, "--delimiter needs --fields to name the fields it separates")
+	}
+}
+
+/*
+	Expands a glob pattern into the logs it matches, sorted by the --sort
+	order. Files that don't look like logs are left out
+*/
+func expand_glob(pattern string) []string {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		fail(ErrUsage, fmt.Sprintf("bad glob pattern %s: %s", pattern, err))
+	}
+
+	logs := make([]string, 0, len(matches))
+	mtimes := make(map[string]int64, len(matches))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() || !log_re.MatchString(match) {
+			continue
+		}
+		logs = append(logs, match)
+		mtimes[match] = info.ModTime().UnixNano()
+	}
+
+	if len(logs) == 0 {
+		fail(ErrUsage, fmt.Sprintf("no logs match %s", pattern), "pattern", pattern)
+	}
+
+	// Glob already returns the matches sorted by name
+	if *sort_by == "mtime" {
+		slices.SortStableFunc(logs, func(a, b string) int {
+			return cmp.Compare(mtimes[a], mtimes[b])
+		})
+	}
+
+	return logs
+}
+
+/*
+	Reads a list given in a file (or STDIN, for `-`) one item per line,
+	such as the logs of --files-from when there are too many to fit on
+	the command line. Blank lines are skipped
+*/
+func read_list(fn string) []string {
+	var file *os.File
+	if fn == qreader.Stdin {
+		file = os.Stdin
+	} else {
+		opened, err := os.Open(fn)
+		if err != nil {
+			fail_with(err, "file", fn)
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	items := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			items = append(items, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fail_with(err, "file", fn)
+	}
+
+	return items
+}
+
+/*
+	Reads all of the logs at the same time until they fail. Compressed logs
+	are archives that won't grow, so they can't be followed
+*/
+func follow_logs(q *qreader.Qreader, logs []string) []failed_log {
+	for _, log := range logs {
+		if strings.HasSuffix(log, ".gz") {
+			fail(ErrUsage, fmt.Sprintf("can't follow compressed log %s", log), "file", log)
+		}
+	}
+
+	failures := make([]failed_log, 0)
+	var failures_lock sync.Mutex
+	var wg sync.WaitGroup
+	var following atomic.Int64
+	following.Store(int64(len(logs)))
+	for _, log := range logs {
+		wg.Go(func() {
+			err := q.Parse(log)
+			more := following.Add(-1) > 0 || *watch_dir != ""
+			if err != nil {
+				failures_lock.Lock()
+				defer failures_lock.Unlock()
+				failures = append(failures, log_failed(log, err, more))
+			}
+		})
+	}
+	wg.Wait()
+
+	return failures
+}
+
+/*
+	Scans each of the logs in turn. One that can't be scanned, such as a
+	corrupt archive partway through a sweep of hundreds, doesn't stop the
+	rest unless --fail-fast is given. Results in the cache, if there is one,
+	are printed rather than scanned for, and how far the scan has got is
+	kept in the checkpoint, if there is one, until it is over. Returns
+	those that couldn't be
+*/
+func scan_logs(q *qreader.Qreader, cache *result_cache, checkpoints *checkpointer, logs []string) []failed_log {
+	parse := q.Parse
+	if cache != nil {
+		defer cache.expire()
+		parse = func(log string) error {
+			return cache.parse(q, log)
+		}
+	}
+
+	failures := make([]failed_log, 0)
+	for i, log := range logs {
+		if checkpoints != nil {
+			checkpoints.starting(log)
+		}
+		if err := parse(log); err != nil {
+			failures = append(failures, log_failed(log, err, i < len(logs)-1 || *watch_dir != ""))
+		}
+		if q.Stopped() {
+			break
+		}
+		if checkpoints != nil {
+			checkpoints.finished()
+		}
+	}
+
+	if checkpoints != nil && q.Stopped() {
+		checkpoints.interrupted()
+	} else if checkpoints != nil {
+		checkpoints.clear()
+	}
+
+	return failures
+}
+
+/*
+	Records a log that couldn't be scanned, to be reported once the rest
+	have been. If there are more to scan, it is reported straight away
+	too, since the end of a long scan may be a while off. With
+	--fail-fast, it is reported and bro-awk exits instead
+*/
+func log_failed(log string, err error, more bool) failed_log {
+	if *fail_fast {
+		fail_with(err, "file", log)
+	}
+
+	failure := failed_log{log, err}
+	if more {
+		report_log(failure)
+	}
+
+	return failure
+}
+
+/*
+	Checks whether STDIN is attached to a terminal rather than a pipe or file
+*/
+func stdin_is_terminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+/*
+	Decides whether or not to highlight output based on the --color setting,
+	where "auto" colors only when writing straight to a terminal
+*/
+func use_color(when string) bool {
+	switch when {
+	case "always":
+		return true
+	case "never":
+		return false
+	case "", "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+
+	fail(ErrUsage, fmt.Sprintf("--color must be auto, always or never, not %s", when))
+	return false
+}
+
 /*
 	Using the given arguments, construct the necessary filters and run them against the logs
 */
 func main() {
-	// first, check to see if usage string was requested
-	for _, x := range os.Args {
-		if x == "-h" || x == "--help" {
-			usage()
+	// first, pull the option flags out from wherever they were given,
+	// in a query recalled from the history if asked for
+	argv := recall(os.Args[1:])
+	args := parse_flags(argv)
+
+	// send diagnostics to STDERR so they never mix with the matched lines,
+	// showing more of them the more verbose the user asked for
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	if *debug {
+		level = slog.LevelDebug
+	}
+	// (or, while the --tui table is up, hold them until it closes)
+	var diagnostics io.Writer = os.Stderr
+	if *tui {
+		diagnostics = tui_diagnostics
+	}
+	logger := slog.New(slog.NewTextHandler(diagnostics, &slog.HandlerOptions{Level: level}))
+
+	// next, load the user's defaults (from the config file, then the
+	// environment) for anything not given as a flag
+	cfg, err := config.Load(*config_path)
+	if err != nil {
+		fail(ErrBadConfig, fmt.Sprintf("unable to load config: %s", err), "file", *config_path)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		fail(ErrBadConfig, err.Error())
+	}
+	if *unzipper == "" {
+		*unzipper = cfg.Unzipper
+	}
+	if *workers == 0 {
+		*workers = cfg.Workers
+	}
+	if *blocksize == 0 {
+		*blocksize = cfg.Blocksize
+	}
+	if *max_line == 0 {
+		*max_line = cfg.MaxLine
+	}
+	if *max_memory == 0 {
+		*max_memory = cfg.MaxMemory
+	}
+	if *color == "" {
+		*color = cfg.Color
+	}
+	*sanitize = cmp.Or(*sanitize, cfg.Sanitize, qreader.SanitizeEscape)
+	if !slices.Contains([]string{qreader.SanitizeEscape, qreader.SanitizeStrip, qreader.SanitizeNone}, *sanitize) {
+		fail(ErrUsage, fmt.Sprintf("--sanitize must be escape, strip or none, not %s", *sanitize))
+	}
+	*escapes = cmp.Or(*escapes, cfg.Escapes, qreader.EscapesKeep)
+	if *escapes != qreader.EscapesKeep && *escapes != qreader.EscapesDecode {
+		fail(ErrUsage, fmt.Sprintf("--escapes must be keep or decode, not %s", *escapes))
+	}
+
+	// times are read in the timezone asked for, which --from and --to need
+	check_timezone(cfg)
+	check_dates()
+
+	// a city database also has the countries, so either will do
+	enrich.GeoIP = cmp.Or(cfg.GeoIP["city"], cfg.GeoIP["country"])
+
+	// labels for known TLS fingerprints, such as ja3.label
+	if cfg.Fingerprints != "" {
+		if err := enrich.LoadFingerprints(cfg.Fingerprints); err != nil {
+			fail(ErrBadConfig, fmt.Sprintf("unable to load fingerprints: %s", err), "file", cfg.Fingerprints)
+		}
+	}
+
+	// vendors of MAC address blocks, in place of the bundled few
+	if cfg.OUI != "" {
+		if err := enrich.LoadOUI(cfg.OUI); err != nil {
+			fail(ErrBadConfig, fmt.Sprintf("unable to load OUI database: %s", err), "file", cfg.OUI)
+		}
+	}
+
+	// site overrides of how much each notice type matters
+	for note, severity := range cfg.Severities {
+		if err := schema.SetSeverity(note, severity); err != nil {
+			fail(ErrBadConfig, err.Error(), "table", "severities")
 		}
 	}
 
-	// next, parse the option flags
-	print_fields := flag.String("p", "", "")
-	flag.Parse()
+	// fill in the layout of non-Zeek logs from a named format
+	resolve_format(cfg)
+
+	// run the requested subcommand instead, if there is one
+	if len(args) > 0 && args[0] == "fields" {
+		fields_command(*unzipper, args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "history" {
+		history_command(args[1:])
+		return
+	}
+
+	// keep the query in the history, to be recalled with !N, once it is
+	// known to be one that runs. The daemon and server aren't queries
+	remember := func() {
+		if err := record_history(argv); err != nil {
+			logger.Info("unable to record the query in the history", "file", history_path(), "err", err)
+		}
+	}
+	subcommand_opts := []qreader.Option{
+		qreader.WithUnzipper(*unzipper),
+		qreader.WithWorkers(*workers),
+		qreader.WithBlockSize(*blocksize),
+		qreader.WithMaxLine(*max_line),
+		qreader.WithMaxMemory(int64(*max_memory) << 20),
+		qreader.WithStrict(*strict),
+		qreader.WithVerify(*verify),
+		qreader.WithLogger(logger),
+	}
+	if len(args) > 0 && args[0] == "pivot" {
+		pivot_command(subcommand_opts, args[1:])
+		remember()
+		return
+	}
+	if len(args) > 0 && args[0] == "diff" {
+		diff_command(subcommand_opts, args[1:], cfg.Presets)
+		remember()
+		return
+	}
+	if len(args) > 0 && args[0] == "join" {
+		join_command(subcommand_opts, args[1:], cfg.Presets)
+		remember()
+		return
+	}
+	if len(args) > 0 && args[0] == "daemon" {
+		daemon_opts := slices.Concat(subcommand_opts, []qreader.Option{
+			qreader.WithSanitize(*sanitize),
+			qreader.WithEscapes(*escapes),
+			qreader.WithTimes(display_timezone(cfg)),
+		})
+		daemon_command(daemon_opts, args[1:], cfg, logger)
+		return
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		serve_opts := slices.Concat(subcommand_opts, []qreader.Option{
+			qreader.WithEscapes(*escapes),
+			qreader.WithTimes(display_timezone(cfg)),
+		})
+		serve_command(serve_opts, args[1:], cfg.Presets, logger)
+		return
+	}
 
 	// next, parse through the remaining arguments to find user-supplied filters and logs
-	logs, filters := parse_args(os.Args)
+	logs, filters := parse_args(args, cfg.Presets)
+	logger.Debug("parsed arguments", "logs", logs, "filters", filters)
 
-	// create a new Qreader:
-	// 		unzipper, []string of filters, number of processors, reading blocksize
-	q := qreader.NewQreader("", filters, 0, 0, *print_fields)
+	// matches can only come in faster than they are wanted from logs
+	// that don't end, such as kafka:// topics
+	if *rate_limit != "" && !*follow && *watch_dir == "" && !slices.ContainsFunc(logs, remote.IsStream) {
+		fail(ErrUsage, "--rate-limit needs --follow, --watch or a streamed log such as kafka://, to limit matches as they come in")
+	}
 
-	// iterate through the logs and apply the filter to each of them
-	for _, log := range logs {
-		q.Parse(log)
+	// create a new Qreader, leaving anything not given to its defaults
+	opts := []qreader.Option{
+		qreader.WithUnzipper(*unzipper),
+		qreader.WithWorkers(*workers),
+		qreader.WithBlockSize(*blocksize),
+		qreader.WithMaxLine(*max_line),
+		qreader.WithMaxMemory(int64(*max_memory) << 20),
+		qreader.WithColor(use_color(*color)),
+		qreader.WithSanitize(*sanitize),
+		qreader.WithEscapes(*escapes),
+		qreader.WithTimes(display_timezone(cfg)),
+		qreader.WithLogger(logger),
+		qreader.WithFollow(*follow),
+		qreader.WithDedupe(*dedupe),
+		qreader.WithDedupeWindow(*dedupe_window),
+		qreader.WithStrict(*strict),
+		qreader.WithVerify(*verify),
+		qreader.WithProgress(*show_progress),
+		qreader.WithTiming(*show_timing),
+	}
+	if *print_fields != "" {
+		opts = append(opts, qreader.WithFields(strings.Split(*print_fields, ",")...))
+	}
+	if *header_fields != "" {
+		opts = append(opts, qreader.WithHeaderFields(strings.Split(*header_fields, ",")...))
+	}
+	if *delimiter != "" {
+		opts = append(opts, qreader.WithDelimiter(*delimiter))
+	}
+	if *no_output {
+		opts = append(opts, qreader.WithWriter(io.Discard))
+	}
+	var alerts *alerter
+	if *alert_exec != "" || *alert_webhook != "" {
+		alerts = new_alerter(os.Stdout, *alert_every, logger)
+		opts = append(opts, qreader.WithWriter(alerts))
+	}
+	var limiter *rate_limiter
+	if *rate_limit != "" {
+		// matches over the limit aren't alerted on either
+		var out io.Writer = os.Stdout
+		if alerts != nil {
+			out = alerts
+		}
+		limit, per, _ := parse_rate(*rate_limit)
+		limiter = new_rate_limiter(out, limit, per, *rate_policy == "drop", logger)
+		opts = append(opts, qreader.WithWriter(limiter))
+	}
+	cache := new_result_cache(filters, display_timezone(cfg))
+	if cache != nil {
+		opts = append(opts, qreader.WithWriter(cache))
+	}
+	checkpoints := new_checkpointer(filters, logs, logger)
+	if checkpoints != nil {
+		opts = append(opts, qreader.WithCheckpoints(checkpoints.reached))
+		if *resume {
+			var at map[string]qreader.Checkpoint
+			logs, at = checkpoints.resume()
+			opts = append(opts, qreader.WithResume(at))
+		}
+	} else if *resume {
+		logger.Warn("there is nowhere to keep checkpoints, scanning every log")
+	}
+	if len(enrichments) > 0 {
+		e, err := enrich.Parse(enrichments)
+		var open_err *enrich.OpenError
+		if errors.As(err, &open_err) {
+			fail(ErrBadConfig, err.Error(), "file", *config_path)
+		} else if err != nil {
+			fail(ErrUsage, err.Error())
+		}
+		opts = append(opts, qreader.WithEnrichments(e...))
+	}
+
+	q, err := qreader.NewQreader(filters, opts...)
+	if err != nil {
+		fail_with(err)
+	}
+	remember()
+	if limiter != nil {
+		limiter.stopped = q.Stopped
+	}
+
+	// show what the query would do instead of doing it
+	if *explain {
+		if !explain_query(q, logs) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// stop after resolving the fields if this is only a dry run
+	if *dry_run {
+		if !check(q, logs) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// show the matches in a table rather than printing them
+	if *tui {
+		run_tui(q, logs)
+		return
+	}
+
+	// show the first matches and how big the scan is before going on
+	if *preview > 0 {
+		run_preview(filters, opts, logs)
+	}
+
+	// only say whether anything matches, by how bro-awk exits
+	if *quiet {
+		scan_quietly(filters, opts, logs)
+	}
+
+	// on Ctrl-C, stop reading and say how far the scan got
+	handle_interrupts(q)
+	defer exit_if_interrupted(q)
+
+	// write the values of a field as an intel file rather than the matches
+	if *intel != "" {
+		if *follow || *watch_dir != "" {
+			fail(ErrUsage, "--intel can't be combined with --follow or --watch, since the file has to end")
+		}
+		export_intel(q, logs)
+		return
+	}
+
+	// or a filter capturing the connections among them
+	if *bpf != "" {
+		export_bpf(q, logs)
+		return
+	}
+
+	// or a report of the matches, to be passed on
+	if *report != "" {
+		if failures := write_report(q, logs, argv); len(failures) > 0 {
+			fail_logs(failures, len(logs))
+		}
+		return
+	}
+
+	// followed logs never end, so they all have to be read at once.
+	// Otherwise iterate through the logs and apply the filter to each
+	var failures []failed_log
+	if *follow {
+		failures = follow_logs(q, logs)
+	} else {
+		done := report_progress(q, logs)
+		start := time.Now()
+		if *list_files || *count_per_file {
+			failures = count_logs(q, logs)
+		} else if *merge_sort_ts {
+			failures = merge_logs(q, logs)
+		} else {
+			failures = scan_logs(q, cache, checkpoints, logs)
+		}
+		done()
+		if *no_output {
+			report_throughput(q, time.Since(start))
+		}
+		report_timings(q)
+	}
+
+	// then carry on with any that turn up later, which won't end either
+	if *watch_dir != "" {
+		watch(q, *watch_dir)
+	}
+
+	// send the alerts still waiting on the matches of the last moments
+	if limiter != nil {
+		limiter.close()
+	}
+	if alerts != nil {
+		alerts.close()
+	}
+
+	// and finally report each log that couldn't be scanned
+	if len(failures) > 0 {
+		exit_if_interrupted(q)
+		fail_logs(failures, len(logs))
 	}
 }
@@ -0,0 +1,245 @@
+/*
+	Description:
+		Caches what a query printed for each compressed log it scanned, so
+		that running the same query over the same archives again, as hunts
+		tend to, prints the matches straight from the cache rather than
+		decompressing and filtering every log once more. `--no-cache`
+		scans them regardless
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/remote"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var no_cache *bool = flagset.Bool("no-cache", false, "")
+
+/* most a log's matches may take up to be cached, since a cache is for quick answers */
+const cache_max int = 64 << 20
+
+/* how long a result goes unused before it is cleared out of the cache */
+const cache_expiry time.Duration = 30 * 24 * time.Hour
+
+/*
+	Takes the place of the output while caching, passing every match on to
+	out, and keeping those of the log being scanned to be cached once it
+	has been scanned in full
+*/
+type result_cache struct {
+	dir     string
+	query   string
+	out     io.Writer
+	capture *bytes.Buffer
+	stored  bool
+}
+
+/*
+	Returns a cache for the results of this query, or nil if they aren't
+	to be cached: with --no-cache, with no home directory to keep the
+	cache in, or when the output is more than the matches of each log in
+	turn. Matches enriched by --enrich aren't cached, since what is looked
+	up for them can change
+*/
+func new_result_cache(filters []string, times *time.Location) *result_cache {
+	if *no_cache || *resume || *follow || *watch_dir != "" || *dedupe || len(enrichments) > 0 {
+		return nil
+	}
+	if *tui || *intel != "" || *bpf != "" || *report != "" || *preview > 0 || *quiet || *list_files || *count_per_file || *no_output {
+		return nil
+	}
+	if *show_progress || *show_timing || *alert_exec != "" || *alert_webhook != "" || *rate_limit != "" || *merge_sort_ts {
+		return nil
+	}
+
+	dir := cache_dir()
+	if dir == "" {
+		return nil
+	}
+
+	// anything that changes what is printed for a log makes a different
+	// query, including the config file, which may change what presets,
+	// predicates and derived fields mean
+	v, c, d := build_info()
+	config_stamp := ""
+	if info, err := os.Stat(*config_path); err == nil {
+		config_stamp = fmt.Sprintf("%s:%d:%d", *config_path, info.Size(), info.ModTime().UnixNano())
+	}
+	query := strings.Join([]string{
+		v, c, d, config_stamp,
+		strings.Join(filters, "\x01"),
+		*print_fields, *header_fields, *delimiter, *sanitize, *escapes,
+		fmt.Sprint(use_color(*color), *strict, *verify, *max_line),
+		timezone.String(), time_stamp(times),
+	}, "\x00")
+
+	return &result_cache{dir: dir, query: query, out: os.Stdout}
+}
+
+/*
+	Describes where times are written out as dates, if they are, for the
+	key of a query. The local timezone is named as it is now, so that the
+	machine's being set to another one is a different query
+*/
+func time_stamp(times *time.Location) string {
+	if times == nil {
+		return ""
+	}
+
+	zone, offset := time.Now().In(times).Zone()
+	return fmt.Sprintf("%s:%s:%d", times, zone, offset)
+}
+
+/*
+	Returns the directory the cache is kept in, under the XDG cache
+	directory, or "" if there is no home directory to find it in
+*/
+func cache_dir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(dir, "bro-awk", "results")
+}
+
+func (self *result_cache) Write(p []byte) (int, error) {
+	// the Qreader writes its matches from a single goroutine, so never
+	// two blocks of them at once
+	if self.capture != nil {
+		if self.capture.Len()+len(p) > cache_max {
+			self.capture = nil
+		} else {
+			self.capture.Write(p)
+		}
+	}
+
+	return self.out.Write(p)
+}
+
+/*
+	Returns the file the results of the query for this log are cached in,
+	or "" if they can't be. Only compressed logs on local storage are
+	cached, being archives that won't change; even so, the log's size and
+	modification time are part of the key, so one that does is scanned
+	afresh
+*/
+func (self *result_cache) path(log string) string {
+	if log == qreader.Stdin || remote.IsRemote(log) {
+		return ""
+	}
+	if !strings.HasSuffix(log, ".gz") && !strings.HasSuffix(log, ".tgz") && !strings.HasSuffix(log, ".tar") {
+		return ""
+	}
+
+	abs, err := filepath.Abs(log)
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d\x00%d", self.query, abs, info.Size(), info.ModTime().UnixNano()))
+	return filepath.Join(self.dir, hex.EncodeToString(sum[:]))
+}
+
+/*
+	Scans the log with the Qreader unless its results are in the cache,
+	in which case those are printed instead, and caches the results of a
+	scan that got through the whole log
+*/
+func (self *result_cache) parse(q *qreader.Qreader, log string) error {
+	path := self.path(log)
+	if path == "" || q.Stopped() {
+		return q.Parse(log)
+	}
+
+	if cached, err := os.Open(path); err == nil {
+		defer cached.Close()
+		q.Logger.Info("printing cached matches", "file", log, "cache", path)
+		_, err := io.Copy(self.out, cached)
+
+		// the cache is cleared of what hasn't been used in a while
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return err
+	}
+
+	self.capture = &bytes.Buffer{}
+	err := q.Parse(log)
+	capture := self.capture
+	self.capture = nil
+	if err != nil || q.Stopped() || capture == nil {
+		return err
+	}
+
+	if err := store_result(path, capture.Bytes()); err != nil {
+		q.Logger.Info("unable to cache the matches", "file", log, "cache", path, "err", err)
+	} else {
+		self.stored = true
+	}
+
+	return nil
+}
+
+/*
+	Writes the results to the cache, by way of a temporary file so that
+	a result is never read half written
+*/
+func store_result(path string, result []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".result-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(result); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+/*
+	Clears the results that haven't been used in a while out of the
+	cache. Only done after adding to it, since only that makes it grow
+*/
+func (self *result_cache) expire() {
+	if !self.stored {
+		return
+	}
+
+	filepath.WalkDir(self.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > cache_expiry {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
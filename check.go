@@ -0,0 +1,86 @@
+/*
+	Description:
+		Implements `--check`, a dry run that reads only the header of each
+		log and reports which column every filter and printed field
+		resolved to, so that typos are caught before a long scan
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+	Prints the field resolution report for each of the logs. Returns false
+	if any log couldn't be read or is missing a field
+*/
+func check(q *qreader.Qreader, logs []string) bool {
+	ok := true
+
+	for _, log := range logs {
+		fmt.Printf("%s:\n", log)
+
+		header, err := q.HeaderOf(log)
+		if err != nil {
+			fmt.Printf("\t[unreadable] %s\n", err)
+			ok = false
+			continue
+		}
+
+		rules := q.Filter.Rules()
+		for i, f := range q.Filter.Filters() {
+			name := rules[i]
+			if name == "" {
+				name = fmt.Sprintf("filter #%d", i+1)
+			}
+			ok = check_fields(name, filters.FieldsOf(f), header) && ok
+		}
+
+		if q.SelectivePrint {
+			ok = check_fields("-p "+strings.Join(q.PrintFields, ","), q.PrintFields, header) && ok
+		}
+
+		for _, e := range q.Enrichments {
+			ok = check_fields("--enrich "+e.Kind+":"+e.Field, []string{e.Field}, header) && ok
+		}
+	}
+
+	if ok {
+		fmt.Println("all filters and fields resolved")
+	}
+
+	return ok
+}
+
+/*
+	Prints one line of the report, showing the column each field resolved to
+*/
+func check_fields(name string, fields []string, header []string) bool {
+	status := "ok"
+	resolved := make([]string, len(fields))
+
+	for i, field := range fields {
+		idx := slices.Index(header, field)
+		d, derived := schema.LookupDerived(field)
+		if idx < 0 {
+			status = "missing"
+			resolved[i] = field + " (missing)"
+			if suggestions := schema.Suggest(field, header); len(suggestions) > 0 {
+				resolved[i] = fmt.Sprintf("%s (missing, did you mean %s?)", field, strings.Join(suggestions, " or "))
+			}
+		} else if derived {
+			resolved[i] = fmt.Sprintf("%s -> derived from %s", field, strings.Join(d.Inputs, ", "))
+		} else {
+			resolved[i] = fmt.Sprintf("%s -> column %d", field, idx+1)
+		}
+	}
+
+	fmt.Printf("\t[%s] %s\n\t\t%s\n", status, name, strings.Join(resolved, "\n\t\t"))
+	return status == "ok"
+}
@@ -0,0 +1,251 @@
+/*
+	Description:
+		Checkpoints long scans as they go, so that one interrupted partway
+		through a sweep of an archive can be taken up again with `--resume`
+		rather than started over: the logs scanned in full are passed over,
+		and the log the scan was in is taken up from where it had got: the
+		last line of an uncompressed file, the last gzip member of a
+		compressed one or the last log of a tar archive it had finished
+
+			bro-awk --resume 'id.resp_h=203.0.113.7' /archive/2024-*
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/remote"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var resume *bool = flagset.Bool("resume", false, "")
+
+/* how often a scan saves how far it has got */
+const checkpoint_every time.Duration = time.Minute
+
+/*
+	How far a scan has got: how many of its logs it has scanned in full,
+	and how far into the next it had got
+*/
+type checkpoint struct {
+	Query   string    `json:"query"`
+	Logs    int       `json:"logs"`
+	Done    int       `json:"done"`
+	Log     string    `json:"log,omitempty"`
+	Offset  int64     `json:"offset,omitempty"`
+	Members int       `json:"members,omitempty"`
+	Saved   time.Time `json:"saved"`
+}
+
+/*
+	Keeps the checkpoint of a scan up to date as the Qreader reports how
+	far it has got, saving it every so often, and when the scan is
+	interrupted, to a file named after the query
+*/
+type checkpointer struct {
+	path   string
+	logger *slog.Logger
+	lock   sync.Mutex
+	state  checkpoint
+	saved  time.Time
+	logs   []string
+}
+
+/*
+	Returns the checkpointer of a scan of the logs with these filters, or
+	nil if the scan isn't one that is checkpointed: only printing the
+	matches of logs that end is, and not when reading STDIN, which can't
+	be read again, or with no home directory to keep checkpoints in. Only
+	the same filters and printed fields over the same logs find the
+	checkpoint of a scan
+*/
+func new_checkpointer(filters []string, logs []string, logger *slog.Logger) *checkpointer {
+	if *follow || *watch_dir != "" || *tui || *intel != "" || *bpf != "" || *report != "" || *quiet || *list_files || *count_per_file || *merge_sort_ts {
+		return nil
+	}
+	if slices.Contains(logs, qreader.Stdin) {
+		return nil
+	}
+	dir := state_dir()
+	if dir == "" {
+		return nil
+	}
+
+	abs := make([]string, len(logs))
+	for i, log := range logs {
+		abs[i] = log
+		if path, err := filepath.Abs(log); err == nil && !remote.IsRemote(log) {
+			abs[i] = path
+		}
+	}
+	key := strings.Join([]string{
+		strings.Join(filters, "\x01"),
+		*print_fields, *header_fields, *delimiter,
+		strings.Join(abs, "\x01"),
+	}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+
+	return &checkpointer{
+		path:   filepath.Join(dir, "checkpoints", hex.EncodeToString(sum[:])+".json"),
+		logger: logger,
+		state:  checkpoint{Query: quote_args(filters), Logs: len(logs)},
+		saved:  time.Now(),
+		logs:   logs,
+	}
+}
+
+/*
+	Returns the logs an interrupted scan had yet to get through, and where
+	to take up the one it was in, from its checkpoint. Without one that
+	fits these logs, they are all scanned
+*/
+func (self *checkpointer) resume() ([]string, map[string]qreader.Checkpoint) {
+	data, err := os.ReadFile(self.path)
+	if os.IsNotExist(err) {
+		self.logger.Warn("no interrupted scan of these logs with this query to resume, scanning them all")
+		return self.logs, nil
+	} else if err != nil {
+		fail_with(err, "file", self.path)
+	}
+
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fail(ErrBadConfig, fmt.Sprintf("%s isn't a checkpoint bro-awk can read: %s", self.path, err), "file", self.path)
+	}
+	if saved.Logs != len(self.logs) || saved.Done > len(self.logs) || (saved.Log != "" && (saved.Done == len(self.logs) || self.logs[saved.Done] != saved.Log)) {
+		self.logger.Warn("the checkpoint doesn't fit these logs, scanning them all", "checkpoint", self.path)
+		return self.logs, nil
+	}
+
+	self.state = saved
+	self.logger.Info("resuming scan", "checkpoint", self.path, "saved", saved.Saved, "done", saved.Done, "logs", saved.Logs,
+		"file", saved.Log, "offset", saved.Offset, "members", saved.Members)
+	if saved.Log == "" {
+		return self.logs[saved.Done:], nil
+	}
+
+	return self.logs[saved.Done:], map[string]qreader.Checkpoint{saved.Log: {Offset: saved.Offset, Members: saved.Members}}
+}
+
+/*
+	Notes that the scan has started on a log, unless it is the one it is
+	taking up where it left off
+*/
+func (self *checkpointer) starting(log string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state.Log != log {
+		self.state.Log, self.state.Offset, self.state.Members = log, 0, 0
+	}
+}
+
+/*
+	Notes how far into a log the scan has got, as the Qreader reports it
+*/
+func (self *checkpointer) reached(log string, at qreader.Checkpoint) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if log != self.state.Log {
+		return
+	}
+	self.state.Offset, self.state.Members = at.Offset, at.Members
+	self.save_if_due()
+}
+
+/*
+	Notes that the scan is done with the log it was in
+*/
+func (self *checkpointer) finished() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.state.Done++
+	self.state.Log, self.state.Offset, self.state.Members = "", 0, 0
+	self.save_if_due()
+}
+
+/*
+	Saves how far the scan had got when it was interrupted, and says how
+	to carry on from there
+*/
+func (self *checkpointer) interrupted() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if err := self.save(); err != nil {
+		self.logger.Warn("unable to save how far the scan got", "checkpoint", self.path, "err", err)
+		return
+	}
+	self.logger.Warn("saved how far the scan got, run it again with --resume to carry on from there", "checkpoint", self.path)
+}
+
+/*
+	Removes the checkpoint once the scan is over, since there is nothing
+	left to resume
+*/
+func (self *checkpointer) clear() {
+	if err := os.Remove(self.path); err != nil && !os.IsNotExist(err) {
+		self.logger.Warn("unable to remove the checkpoint of a finished scan", "checkpoint", self.path, "err", err)
+	}
+}
+
+/* saves the checkpoint if it hasn't been for a while */
+func (self *checkpointer) save_if_due() {
+	if time.Since(self.saved) < checkpoint_every {
+		return
+	}
+	if err := self.save(); err != nil {
+		self.logger.Warn("unable to save how far the scan has got", "checkpoint", self.path, "err", err)
+	}
+}
+
+/*
+	Writes the checkpoint, by way of a temporary file so that one is never
+	read half written
+*/
+func (self *checkpointer) save() error {
+	self.saved = time.Now()
+	self.state.Saved = self.saved
+
+	// filters are kept as they were given, <, > and & and all
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(self.state); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(self.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data.Bytes()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), self.path)
+}
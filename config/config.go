@@ -0,0 +1,463 @@
+/*
+	Description:
+		Loads user defaults from ~/.config/bro-awk/config.toml so that a
+		team can standardize settings instead of repeating flags. Only the
+		small subset of TOML needed for these settings is understood:
+		[tables], key = value pairs, strings, integers, durations
+		and arrays of strings
+
+		BRO_AWK_* environment variables take precedence over the file, and
+		command-line flags take precedence over both
+*/
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	CONFIG
+//--------------------------------------------------------------------------------
+
+/*
+	Settings read from the config file. Zero values mean "not set", in
+	which case the program's own defaults apply
+
+		unzipper   = "/usr/bin/unpigz"
+		workers    = 8
+		blocksize  = 65536
+		max_line   = 1048576
+		max_memory = 512
+		color      = "auto"
+		sanitize   = "escape"
+		escapes    = "keep"
+		tz         = "UTC"
+
+		fingerprints = "/usr/share/ja3/sslbl.csv"
+		oui          = "/usr/share/ieee-data/oui.txt"
+
+		[presets]
+		ssh_in = ["local_orig=F", "id.resp_p=22"]
+
+		[geoip]
+		city = "/usr/share/GeoIP/GeoLite2-City.mmdb"
+
+		[severities]
+		"Scan::Port_Scan" = "high"
+		default = "low"
+
+		[formats.myapp]
+		delimiter = "|"
+		fields = ["ts", "user", "action"]
+
+		[queries.ssh_in]
+		filters = ["@ssh_in"]
+		dirs = ["/logs"]
+		log_types = ["conn"]
+		every = "5m"
+		webhook = "https://hooks.example.com/T000/B000/XXXX"
+*/
+type Config struct {
+	Unzipper     string
+	Workers      int
+	Blocksize    int
+	MaxLine      int
+	MaxMemory    int
+	Color        string
+	Sanitize     string
+	Escapes      string
+	Timezone     string
+	Fingerprints string
+	OUI          string
+	Presets      map[string][]string
+	GeoIP        map[string]string
+	Severities   map[string]string
+	Formats      map[string]*Format
+	Queries      map[string]*Query
+}
+
+/*
+	Layout of a delimited log that isn't Zeek's, used with --format
+*/
+type Format struct {
+	Delimiter string
+	Fields    []string
+}
+
+/*
+	Query run on a schedule by `bro-awk daemon` over the logs rotated into
+	its directories, and where its matches go: appended to Output, given
+	to the Exec command or POSTed to the Webhook
+*/
+type Query struct {
+	Filters  []string
+	Dirs     []string
+	LogTypes []string
+	Fields   []string
+	Every    time.Duration
+	Output   string
+	Exec     string
+	Webhook  string
+}
+
+/*
+	Returns the path of the user's config file, honoring BRO_AWK_CONFIG
+	and XDG_CONFIG_HOME
+*/
+func DefaultPath() string {
+	if path := os.Getenv("BRO_AWK_CONFIG"); path != "" {
+		return path
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "bro-awk", "config.toml")
+}
+
+/*
+	Reads the config file at the given path. A missing file is not an
+	error and yields an empty Config
+*/
+func Load(path string) (*Config, error) {
+	c := &Config{
+		Presets:    make(map[string][]string),
+		GeoIP:      make(map[string]string),
+		Severities: make(map[string]string),
+		Formats:    make(map[string]*Format),
+		Queries:    make(map[string]*Query),
+	}
+
+	if path == "" {
+		return c, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table := ""
+	pending := ""
+	lineno := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+
+		// arrays may be spread over several lines, so keep collecting
+		// until the brackets balance
+		if pending != "" {
+			pending += " " + line
+			if strings.Count(pending, "[") > strings.Count(pending, "]") {
+				continue
+			}
+			line, pending = pending, ""
+		}
+
+		if line == "" {
+			continue
+		}
+
+		// start of a new table
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") && !strings.Contains(line, "=") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineno)
+		}
+		key = unquote(strings.TrimSpace(key))
+		raw = strings.TrimSpace(raw)
+
+		if strings.HasPrefix(raw, "[") && strings.Count(raw, "[") > strings.Count(raw, "]") {
+			pending = line
+			continue
+		}
+
+		if err := c.set(table, key, raw); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		return nil, fmt.Errorf("%s: unterminated array", path)
+	}
+
+	return c, nil
+}
+
+/*
+	Stores a single parsed key/value into the matching setting
+*/
+func (self *Config) set(table string, key string, raw string) error {
+	var err error
+
+	switch table {
+	case "":
+		switch key {
+		case "unzipper":
+			self.Unzipper, err = parseString(raw)
+		case "workers":
+			self.Workers, err = strconv.Atoi(raw)
+		case "blocksize":
+			self.Blocksize, err = strconv.Atoi(raw)
+		case "max_line":
+			self.MaxLine, err = strconv.Atoi(raw)
+		case "max_memory":
+			self.MaxMemory, err = strconv.Atoi(raw)
+		case "color":
+			self.Color, err = parseString(raw)
+		case "sanitize":
+			self.Sanitize, err = parseString(raw)
+		case "escapes":
+			self.Escapes, err = parseString(raw)
+		case "tz":
+			self.Timezone, err = parseString(raw)
+		case "fingerprints":
+			self.Fingerprints, err = parseString(raw)
+		case "oui":
+			self.OUI, err = parseString(raw)
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	case "presets":
+		self.Presets[key], err = parseArray(raw)
+	case "geoip":
+		self.GeoIP[key], err = parseString(raw)
+	case "severities":
+		self.Severities[key], err = parseString(raw)
+	default:
+		if name, ok := strings.CutPrefix(table, "queries."); ok && name != "" {
+			return self.setQuery(name, key, raw)
+		}
+
+		name, ok := strings.CutPrefix(table, "formats.")
+		if !ok || name == "" {
+			return fmt.Errorf("unknown table [%s]", table)
+		}
+		if self.Formats[name] == nil {
+			self.Formats[name] = &Format{}
+		}
+		switch key {
+		case "delimiter":
+			self.Formats[name].Delimiter, err = parseString(raw)
+		case "fields":
+			self.Formats[name].Fields, err = parseArray(raw)
+		default:
+			return fmt.Errorf("unknown setting %q in [%s]", key, table)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("bad value for %s: %w", key, err)
+	}
+
+	return nil
+}
+
+/*
+	Stores a key/value of a [queries.NAME] table into that query
+*/
+func (self *Config) setQuery(name string, key string, raw string) error {
+	if self.Queries[name] == nil {
+		self.Queries[name] = &Query{}
+	}
+	query := self.Queries[name]
+
+	var err error
+	switch key {
+	case "filters":
+		query.Filters, err = parseArray(raw)
+	case "dirs":
+		query.Dirs, err = parseArray(raw)
+	case "log_types":
+		query.LogTypes, err = parseArray(raw)
+	case "fields":
+		query.Fields, err = parseArray(raw)
+	case "every":
+		query.Every, err = parseDuration(raw)
+	case "output":
+		query.Output, err = parseString(raw)
+	case "exec":
+		query.Exec, err = parseString(raw)
+	case "webhook":
+		query.Webhook, err = parseString(raw)
+	default:
+		return fmt.Errorf("unknown setting %q in [queries.%s]", key, name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("bad value for %s: %w", key, err)
+	}
+
+	return nil
+}
+
+//--------------------------------------------------------------------------------
+//	ENVIRONMENT
+//--------------------------------------------------------------------------------
+
+/*
+	Overrides settings with any of the following that are set:
+
+		BRO_AWK_UNZIPPER	unzipper
+		BRO_AWK_THREADS		workers
+		BRO_AWK_BLOCKSIZE	blocksize
+		BRO_AWK_COLOR		color
+*/
+func (self *Config) ApplyEnv() error {
+	if v := os.Getenv("BRO_AWK_UNZIPPER"); v != "" {
+		self.Unzipper = v
+	}
+
+	if v := os.Getenv("BRO_AWK_THREADS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("BRO_AWK_THREADS must be a number, not %q", v)
+		}
+		self.Workers = n
+	}
+
+	if v := os.Getenv("BRO_AWK_BLOCKSIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("BRO_AWK_BLOCKSIZE must be a number, not %q", v)
+		}
+		self.Blocksize = n
+	}
+
+	if v := os.Getenv("BRO_AWK_COLOR"); v != "" {
+		self.Color = v
+	}
+
+	return nil
+}
+
+//--------------------------------------------------------------------------------
+//	VALUE PARSING
+//--------------------------------------------------------------------------------
+
+/*
+	Removes a trailing # comment, ignoring any # inside quotes
+*/
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0 && line[i] == quote:
+			quote = 0
+		case quote == 0 && (line[i] == '"' || line[i] == '\''):
+			quote = line[i]
+		case quote == 0 && line[i] == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+/*
+	Removes the quotes from a quoted key, leaving bare keys untouched
+*/
+func unquote(s string) string {
+	if v, err := parseString(s); err == nil {
+		return v
+	}
+
+	return s
+}
+
+/*
+	Parses a basic "string" (with escapes) or a 'literal string'
+*/
+func parseString(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	if len(raw) >= 2 && raw[0] == '"' {
+		return strconv.Unquote(raw)
+	}
+
+	return "", fmt.Errorf("expected a quoted string, got %s", raw)
+}
+
+/*
+	Parses a duration given as a string, e.g. "5m" or "1h30m"
+*/
+func parseDuration(raw string) (time.Duration, error) {
+	s, err := parseString(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("expected a duration such as \"5m\", got %s", raw)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expected a duration of more than 0, got %s", raw)
+	}
+
+	return d, nil
+}
+
+/*
+	Parses an array of strings, e.g. ["a", 'b', ]
+*/
+func parseArray(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") || !strings.HasSuffix(raw, "]") {
+		return nil, fmt.Errorf("expected an array, got %s", raw)
+	}
+
+	values := make([]string, 0)
+	rest := strings.TrimSpace(raw[1 : len(raw)-1])
+
+	for rest != "" {
+		// find the end of the next quoted element
+		if rest[0] != '"' && rest[0] != '\'' {
+			return nil, fmt.Errorf("expected a quoted string in %s", raw)
+		}
+		end := 1
+		for end < len(rest) && (rest[end] != rest[0] || (rest[0] == '"' && rest[end-1] == '\\')) {
+			end++
+		}
+		if end == len(rest) {
+			return nil, fmt.Errorf("unterminated string in %s", raw)
+		}
+
+		v, err := parseString(rest[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		// skip past the separating comma
+		rest = strings.TrimSpace(rest[end+1:])
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+
+	return values, nil
+}
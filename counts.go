@@ -0,0 +1,63 @@
+/*
+	Description:
+		Implements grep's `-l`, listing only the logs with a match, and
+		`--count-per-file`, giving how many lines of each log matched out
+		of how many were scanned, for finding which hour's log holds the
+		activity before looking at the matches themselves
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+)
+
+var list_files *bool = flagset.Bool("l", false, "")
+var count_per_file *bool = flagset.Bool("count-per-file", false, "")
+
+/*
+	Scans each log in turn for its matches, which aren't printed. With -l
+	the names of the logs with a match are printed, and a log is only read
+	as far as its first. With --count-per-file, each log is printed with
+	how many of its lines matched and how many were read (its records,
+	not its header), separated by tabs -- only those with a match, if -l
+	is given too. Returns the logs that couldn't be scanned
+*/
+func count_logs(q *qreader.Qreader, logs []string) []failed_log {
+	failures := make([]failed_log, 0)
+	for i, log := range logs {
+		if q.Stopped() {
+			break
+		}
+
+		before := q.Totals()
+		matches := 0
+		var failed error
+		for _, err := range q.Scan(log).Seq() {
+			if err != nil {
+				failed = err
+				break
+			}
+			matches++
+			if !*count_per_file {
+				break
+			}
+		}
+
+		if failed != nil {
+			failures = append(failures, log_failed(log, failed, i < len(logs)-1))
+			continue
+		}
+		if *list_files && matches == 0 {
+			continue
+		}
+		if *count_per_file {
+			fmt.Printf("%s\t%d\t%d\n", log, matches, q.Totals().Lines-before.Lines)
+		} else {
+			fmt.Println(log)
+		}
+	}
+
+	return failures
+}
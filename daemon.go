@@ -0,0 +1,427 @@
+/*
+	Description:
+		Implements the `daemon` subcommand, which keeps running the queries
+		set out under [queries.NAME] in the config file on a schedule, each
+		over the logs rotated into its directories since it last ran, and
+		sends their matches wherever the query says:
+
+			bro-awk daemon
+			bro-awk daemon ssh_in dns_tunnels
+
+		Each query keeps a high-water mark of the newest log it has
+		scanned, along with the logs it has scanned near it, saved between
+		runs, so that every log is scanned once even across restarts
+*/
+
+package main
+
+import (
+	"bro-awk/config"
+	"bro-awk/qreader"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var daemon_state *string = flagset.String("state", "", "")
+
+/* time between runs of a query that doesn't give its own */
+const daemon_every time.Duration = 5 * time.Minute
+
+/*
+	how long a log must have gone unchanged before it is taken to be
+	completely written, rather than still being compressed or copied in
+*/
+const daemon_settle time.Duration = 30 * time.Second
+
+/*
+	how long before a query's mark a log may still turn up, such as a
+	large log that took longer to compress than the smaller ones rotated
+	with it, and so has an older modification time than they do. Logs
+	older than that are taken to have been scanned
+*/
+const daemon_grace time.Duration = time.Hour
+
+/*
+	A query from the config file, with its presets expanded, and when it
+	is next due to run
+*/
+type scheduled_query struct {
+	name    string
+	query   *config.Query
+	filters []string
+	due     time.Time
+}
+
+/*
+	How far a query has got through its logs: the modification time of
+	the newest log it has scanned, and the logs it has scanned within
+	daemon_grace of that, by their modification times, since more may
+	turn up that are older than the newest. Logs is how far a query had
+	got before those were kept, the logs scanned with the very time of
+	the mark
+*/
+type high_water struct {
+	Mark    time.Time            `json:"mark"`
+	Scanned map[string]time.Time `json:"scanned"`
+	Logs    []string             `json:"logs,omitempty"`
+}
+
+/*
+	Returns a mark at the given time for a query with no record of the
+	logs near it: those older count as scanned, along with the given logs
+	scanned with its very time. A query that hasn't run yet starts from
+	now, with the logs still to come
+*/
+func (self *scheduled_query) mark_at(at time.Time, scanned []string) *high_water {
+	mark := &high_water{Mark: at, Scanned: make(map[string]time.Time)}
+	for _, log := range self.pending(&high_water{Mark: at}, false) {
+		if log.mtime.Before(at) || slices.Contains(scanned, log.path) {
+			mark.Scanned[log.path] = log.mtime
+		}
+	}
+
+	return mark
+}
+
+/*
+	Notes that a log has been scanned, moving the mark on to it if it is
+	the newest yet
+*/
+func (self *high_water) scanned(log rotated_log) {
+	if log.mtime.After(self.Mark) {
+		self.Mark = log.mtime
+	}
+	self.Scanned[log.path] = log.mtime
+}
+
+/*
+	Forgets the logs too old to turn up again, which are passed over by
+	their modification times alone
+*/
+func (self *high_water) prune() {
+	for path, mtime := range self.Scanned {
+		if mtime.Before(self.Mark.Add(-daemon_grace)) {
+			delete(self.Scanned, path)
+		}
+	}
+}
+
+/*
+	A rotated log waiting to be scanned, with its modification time
+*/
+type rotated_log struct {
+	path  string
+	mtime time.Time
+}
+
+/*
+	Collects the matches of a run for its command or webhook, which get
+	them all at once when the run is over
+*/
+type match_list struct {
+	lines []string
+}
+
+func (self *match_list) Write(p []byte) (int, error) {
+	self.lines = append(self.lines, strings.Split(strings.TrimSuffix(string(p), "\n"), "\n")...)
+	return len(p), nil
+}
+
+/*
+	Runs the named queries, or every query in the config file if none are
+	named, each as often as it asks, until interrupted. A query run for
+	the first time starts from the logs still to come, as --watch does
+*/
+func daemon_command(opts []qreader.Option, args []string, cfg *config.Config, logger *slog.Logger) {
+	if len(cfg.Queries) == 0 {
+		fail(ErrBadConfig, fmt.Sprintf("no queries to run, set them out under [queries.NAME] in %s", *config_path), "file", *config_path)
+	}
+
+	names := args
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(cfg.Queries))
+	}
+	queries := make([]*scheduled_query, 0, len(names))
+	for _, name := range names {
+		query, ok := cfg.Queries[name]
+		if !ok {
+			fail(ErrUsage, fmt.Sprintf("no query named %s in %s", name, *config_path), "query", name)
+		}
+		queries = append(queries, schedule(name, query, cfg.Presets, opts))
+	}
+
+	path := daemon_state_path()
+	marks := read_marks(path)
+	for _, s := range queries {
+		if mark, ok := marks[s.name]; !ok {
+			marks[s.name] = s.mark_at(time.Now(), nil)
+		} else if mark.Scanned == nil {
+			// saved before the logs near the mark were kept
+			marks[s.name] = s.mark_at(mark.Mark, mark.Logs)
+		}
+	}
+	if err := write_marks(path, marks); err != nil {
+		fail_with(err, "file", path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("running scheduled queries", "queries", names, "state", path)
+	for {
+		next := slices.MinFunc(queries, func(a, b *scheduled_query) int {
+			return a.due.Compare(b.due)
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next.due)):
+		}
+
+		run_scheduled(ctx, next, marks[next.name], opts, logger)
+		if err := write_marks(path, marks); err != nil {
+			logger.Error("unable to save how far the queries have got", "file", path, "err", err)
+		}
+
+		// a run that took longer than the query's interval isn't made up
+		// for by running it again straight away
+		next.due = next.due.Add(cmp.Or(next.query.Every, daemon_every))
+		if next.due.Before(time.Now()) {
+			next.due = time.Now()
+		}
+	}
+}
+
+/*
+	Checks a query from the config file before the daemon starts, so that
+	a mistake in one is found then rather than at its first run
+*/
+func schedule(name string, query *config.Query, presets map[string][]string, opts []qreader.Option) *scheduled_query {
+	if len(query.Dirs) == 0 {
+		fail(ErrBadConfig, fmt.Sprintf("[queries.%s] in %s has no dirs to scan", name, *config_path), "query", name)
+	}
+	for _, dir := range query.Dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fail(ErrBadConfig, fmt.Sprintf("[queries.%s] in %s scans %s, which isn't a directory", name, *config_path, dir), "query", name, "file", dir)
+		}
+	}
+	if query.Webhook != "" {
+		if uri, err := url.Parse(query.Webhook); err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+			fail(ErrBadConfig, fmt.Sprintf("[queries.%s] in %s needs an http:// or https:// webhook, not %s", name, *config_path, query.Webhook), "query", name)
+		}
+	}
+
+	filters := make([]string, 0, len(query.Filters))
+	for _, rule := range query.Filters {
+		if !preset_re.MatchString(rule) {
+			filters = append(filters, rule)
+			continue
+		}
+		preset, ok := presets[rule[1:]]
+		if !ok {
+			fail(ErrUnknownPreset, fmt.Sprintf("no preset named %s in %s, for [queries.%s]", rule[1:], *config_path, name), "preset", rule[1:])
+		}
+		filters = append(filters, preset...)
+	}
+	if _, err := qreader.NewQreader(filters, opts...); err != nil {
+		fail_with(err, "query", name)
+	}
+
+	return &scheduled_query{name, query, filters, time.Now()}
+}
+
+/*
+	Returns the logs under the query's directories that it hasn't scanned
+	yet, oldest first: those it hasn't noted as scanned, unless they are
+	too far behind its mark to turn up now. With settled, only those that
+	have been completely written
+*/
+func (self *scheduled_query) pending(mark *high_water, settled bool) []rotated_log {
+	logs := make([]rotated_log, 0)
+	for _, dir := range self.query.Dirs {
+		for path, info := range archived_logs(dir, self.query.LogTypes) {
+			mtime := info.ModTime()
+			if mtime.Before(mark.Mark.Add(-daemon_grace)) {
+				continue
+			}
+			if _, ok := mark.Scanned[path]; ok {
+				continue
+			}
+			if settled && time.Since(mtime) < daemon_settle {
+				continue
+			}
+			logs = append(logs, rotated_log{path, mtime})
+		}
+	}
+
+	slices.SortFunc(logs, func(a, b rotated_log) int {
+		return cmp.Or(a.mtime.Compare(b.mtime), cmp.Compare(a.path, b.path))
+	})
+
+	return logs
+}
+
+/*
+	Scans the logs the query hasn't yet, moving its mark past each, then
+	sends the matches to the query's command and webhook. Matches are
+	appended to its output as they are found, or printed if it has nowhere
+	else to send them. A log that can't be scanned is reported and passed
+	over, since it won't be any better next time; one whose scan is
+	interrupted is scanned again when the daemon next starts
+*/
+func run_scheduled(ctx context.Context, s *scheduled_query, mark *high_water, opts []qreader.Option, logger *slog.Logger) {
+	logs := s.pending(mark, true)
+	if len(logs) == 0 {
+		return
+	}
+
+	matches := &match_list{}
+	writers := make([]io.Writer, 0)
+	if s.query.Output != "" {
+		output, err := os.OpenFile(s.query.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.Error("unable to open the output of a scheduled query", "query", s.name, "file", s.query.Output, "err", err)
+			return
+		}
+		defer output.Close()
+		writers = append(writers, output)
+	}
+	if s.query.Exec != "" || s.query.Webhook != "" {
+		writers = append(writers, matches)
+	}
+	if len(writers) == 0 {
+		writers = append(writers, os.Stdout)
+	}
+
+	run_opts := slices.Concat(opts, []qreader.Option{qreader.WithWriter(io.MultiWriter(writers...))})
+	if len(s.query.Fields) > 0 {
+		run_opts = append(run_opts, qreader.WithFields(s.query.Fields...))
+	}
+	q, err := qreader.NewQreader(s.filters, run_opts...)
+	if err != nil {
+		logger.Error("unable to run a scheduled query", "query", s.name, "err", err)
+		return
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.Stop()
+		case <-finished:
+		}
+	}()
+
+	for _, log := range logs {
+		err := q.Parse(log.path)
+		if q.Stopped() {
+			break
+		}
+		if err != nil {
+			logger.Error("unable to scan log", "query", s.name, "file", log.path, "err", err)
+		}
+
+		mark.scanned(log)
+	}
+	mark.prune()
+
+	totals := q.Totals()
+	logger.Info("ran scheduled query", "query", s.name, "logs", totals.Logs, "lines", totals.Lines, "matches", totals.Matches)
+	if len(matches.lines) == 0 {
+		return
+	}
+	if s.query.Exec != "" {
+		if err := alert_command(s.query.Exec, s.name, matches.lines); err != nil {
+			logger.Error("unable to run the exec of a scheduled query", "query", s.name, "command", s.query.Exec, "matches", len(matches.lines), "err", err)
+		}
+	}
+	if s.query.Webhook != "" {
+		client := &http.Client{Timeout: alert_timeout}
+		if err := alert_webhook_post(client, s.query.Webhook, s.name, matches.lines); err != nil {
+			logger.Error("unable to call the webhook of a scheduled query", "query", s.name, "url", s.query.Webhook, "matches", len(matches.lines), "err", err)
+		}
+	}
+}
+
+/*
+	Returns the path of the file the queries' marks are kept in, which is
+	--state if given
+*/
+func daemon_state_path() string {
+	if *daemon_state != "" {
+		return *daemon_state
+	}
+
+	dir := state_dir()
+	if dir == "" {
+		fail(ErrUsage, "no home directory to keep the daemon's state in, give --state")
+	}
+
+	return filepath.Join(dir, "daemon.json")
+}
+
+/*
+	Reads the marks of the queries from the state file. There are none
+	before the daemon has first run
+*/
+func read_marks(path string) map[string]*high_water {
+	marks := make(map[string]*high_water)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return marks
+	} else if err != nil {
+		fail_with(err, "file", path)
+	}
+	if err := json.Unmarshal(data, &marks); err != nil {
+		fail(ErrBadConfig, fmt.Sprintf("%s isn't a state file bro-awk can read: %s", path, err), "file", path)
+	}
+
+
+	return marks
+}
+
+/*
+	Saves the marks of the queries, by way of a temporary file so that a
+	daemon stopped partway through writing them doesn't lose them
+*/
+func write_marks(path string, marks map[string]*high_water) error {
+	data, err := json.MarshalIndent(marks, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".daemon-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(append(data, '\n')); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
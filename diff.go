@@ -0,0 +1,117 @@
+/*
+	Description:
+		Implements the `diff` subcommand, which runs the same filters over
+		two sets of logs and reports the values of a key field that turn
+		up in one but not the other, e.g. which external hosts a server
+		started talking to today that it didn't yesterday:
+
+			bro-awk diff --key id.resp_h id.orig_h=10.0.0.5 /logs/2024-05-01 /logs/2024-05-02
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+	"slices"
+)
+
+var diff_key *string = flagset.String("key", "", "")
+
+/* how diff is used, for its errors */
+const diff_usage string = "Usage: bro-awk diff --key <FIELD> [FILTERS...] <BEFORE> <AFTER>"
+
+/*
+	Prints each value of the --key field found among the matches of only
+	one of the two sets of logs, + for those only in the second (AFTER)
+	and - for those only in the first (BEFORE), followed by how many
+	matches had it. Each set is a log, a directory or a glob, and the
+	filters are given as for any query
+*/
+func diff_command(opts []qreader.Option, args []string, presets map[string][]string) {
+	if *diff_key == "" {
+		fail(ErrUsage, "diff needs --key, the field whose values to compare. "+diff_usage)
+	}
+
+	rules, sets := split_args(args, presets)
+	if len(sets) != 2 {
+		fail(ErrUsage, fmt.Sprintf("diff compares two sets of logs, not %d. %s", len(sets), diff_usage))
+	}
+
+	// the key has to be in every log, as a printed field does
+	opts = slices.Concat(opts, []qreader.Option{qreader.WithFields(*diff_key)})
+	before := diff_values(opts, rules, find_logs(sets[:1], diff_usage))
+	after := diff_values(opts, rules, find_logs(sets[1:], diff_usage))
+
+	for _, value := range only_in(after, before) {
+		fmt.Printf("+ %s\t%d\n", qreader.Sanitize(*sanitize, value, "\t"), after[value])
+	}
+	for _, value := range only_in(before, after) {
+		fmt.Printf("- %s\t%d\n", qreader.Sanitize(*sanitize, value, "\t"), before[value])
+	}
+}
+
+/*
+	Splits the arguments of a subcommand comparing sets of logs into the
+	filters, with presets expanded, and the sets
+*/
+func split_args(args []string, presets map[string][]string) ([]string, []string) {
+	rules := make([]string, 0)
+	sets := make([]string, 0)
+	for _, arg := range args {
+		if filter_re.MatchString(arg) {
+			rules = append(rules, arg)
+		} else if preset_re.MatchString(arg) {
+			preset, ok := presets[arg[1:]]
+			if !ok {
+				fail(ErrUnknownPreset, fmt.Sprintf("no preset named %s in %s", arg[1:], *config_path), "preset", arg[1:])
+			}
+			rules = append(rules, preset...)
+		} else {
+			sets = append(sets, arg)
+		}
+	}
+
+	return rules, sets
+}
+
+/*
+	Returns the values of a that b doesn't have, sorted
+*/
+func only_in(a map[string]int, b map[string]int) []string {
+	values := make([]string, 0)
+	for value := range a {
+		if _, ok := b[value]; !ok {
+			values = append(values, value)
+		}
+	}
+	slices.Sort(values)
+
+	return values
+}
+
+/*
+	Returns each value of the --key field among the matches of the logs,
+	with how many matches had it. Unset and empty values are left out,
+	and sets and vectors are taken whole
+*/
+func diff_values(opts []qreader.Option, rules []string, logs []string) map[string]int {
+	q, err := qreader.NewQreader(rules, opts...)
+	if err != nil {
+		fail_with(err)
+	}
+
+	values := make(map[string]int)
+	for record, err := range q.Scan(logs...).Seq() {
+		if err != nil {
+			fail_with(err)
+		}
+
+		value, _ := record.Get(*diff_key)
+		if value != "-" && value != "(empty)" {
+			values[value]++
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,105 @@
+package enrich
+
+import (
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	CONNECTION STATES
+//--------------------------------------------------------------------------------
+
+/* what each of conn.log's conn_state codes means, as Zeek documents them */
+var conn_states map[string]string = map[string]string{
+	"S0":     "attempt seen, no reply",
+	"S1":     "established, not terminated",
+	"SF":     "normal establishment and termination",
+	"REJ":    "attempt rejected",
+	"S2":     "established, close attempt by originator seen (no reply from responder)",
+	"S3":     "established, close attempt by responder seen (no reply from originator)",
+	"RSTO":   "established, originator aborted (sent a RST)",
+	"RSTR":   "responder sent a RST",
+	"RSTOS0": "originator sent a SYN then a RST, no SYN-ACK seen from the responder",
+	"RSTRH":  "responder sent a SYN-ACK then a RST, no SYN seen from the originator",
+	"SH":     "originator sent a SYN then a FIN, no SYN-ACK seen from the responder (half open)",
+	"SHR":    "responder sent a SYN-ACK then a FIN, no SYN seen from the originator",
+	"OTH":    "no SYN seen, only midstream traffic",
+}
+
+/*
+	what each letter of conn.log's history means. Upper case letters are
+	sent by the originator and lower case ones by the responder
+*/
+var history_events map[byte]string = map[byte]string{
+	's': "SYN",
+	'h': "SYN-ACK",
+	'a': "ACK",
+	'd': "data",
+	'f': "FIN",
+	'r': "RST",
+	'c': "bad checksum",
+	'g': "content gap",
+	't': "retransmitted data",
+	'w': "zero window",
+	'i': "inconsistent packet",
+	'q': "multi-flag packet",
+	'x': "analysis incomplete",
+}
+
+/*
+	Adds a readable description of conn.log's conn_state and history
+	codes, for those who don't know them by heart. Other fields are left
+	undescribed
+*/
+type describeEnricher struct{}
+
+func openDescribe() (Enricher, error) {
+	return describeEnricher{}, nil
+}
+
+func (self describeEnricher) Columns() []string {
+	return []string{"desc"}
+}
+
+func (self describeEnricher) Lookup(field string, value string) []string {
+	switch field {
+	case "conn_state":
+		return []string{conn_states[value]}
+	case "history":
+		return []string{describeHistory(value)}
+	}
+
+	return []string{""}
+}
+
+/*
+	Spells out a history string one event at a time, e.g. ShA as
+	"orig SYN; resp SYN-ACK; orig ACK"
+*/
+func describeHistory(history string) string {
+	events := make([]string, 0, len(history))
+
+	for i := 0; i < len(history); i++ {
+		c := history[i]
+		if c == '^' {
+			events = append(events, "direction flipped by Zeek")
+			continue
+		}
+
+		event, ok := history_events[c|0x20]
+		if !ok {
+			events = append(events, string(c)+"?")
+			continue
+		}
+		if c >= 'a' {
+			events = append(events, "resp "+event)
+		} else {
+			events = append(events, "orig "+event)
+		}
+	}
+
+	return strings.Join(events, "; ")
+}
+
+func init() {
+	Register("describe", openDescribe)
+}
@@ -0,0 +1,225 @@
+/*
+	Description:
+		Adds columns to printed matches that are worked out from the value
+		of one of their fields, such as the country an address is in, so
+		that results don't have to be joined against another source
+		afterwards. Each kind of enrichment registers an Opener under the
+		name used on the command line, e.g. --enrich geo:id.resp_h
+*/
+
+package enrich
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	ENRICHERS
+//--------------------------------------------------------------------------------
+
+/*
+	Works out extra columns from the value of a single field, which is
+	named for enrichers that read fields differently. Lookup is called
+	from several parsers at once, so it must be safe for concurrent use.
+	A column it has nothing for is left as ""
+*/
+type Enricher interface {
+	Columns() []string
+	Lookup(field string, value string) []string
+}
+
+/*
+	Sets up the enricher of one kind, e.g. by loading its database
+*/
+type Opener func() (Enricher, error)
+
+var openers map[string]Opener = make(map[string]Opener)
+var openers_lock sync.RWMutex
+
+/*
+	Makes the named kind of enrichment available to Parse
+*/
+func Register(kind string, open Opener) {
+	openers_lock.Lock()
+	defer openers_lock.Unlock()
+	openers[kind] = open
+}
+
+/*
+	Returns the names of every registered kind of enrichment, sorted
+*/
+func Kinds() []string {
+	openers_lock.RLock()
+	defer openers_lock.RUnlock()
+
+	kinds := make([]string, 0, len(openers))
+	for kind := range openers {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	return kinds
+}
+
+//--------------------------------------------------------------------------------
+//	ENRICHMENTS
+//--------------------------------------------------------------------------------
+
+/*
+	Columns of one kind added for one field of each match
+*/
+type Enrichment struct {
+	Kind     string
+	Field    string
+	Enricher Enricher
+}
+
+/*
+	Returns the columns for the value of the enrichment's field
+*/
+func (self *Enrichment) Lookup(value string) []string {
+	return self.Enricher.Lookup(self.Field, value)
+}
+
+/*
+	Returns the names of the added columns, e.g. id.resp_h.country
+*/
+func (self *Enrichment) Columns() []string {
+	columns := self.Enricher.Columns()
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = self.Field + "." + column
+	}
+
+	return names
+}
+
+/*
+	Turns specs of the form KIND:FIELD[,FIELD...] into enrichments. Each
+	kind is only opened once, so fields enriched the same way share its
+	database and cache
+*/
+func Parse(specs []string) ([]*Enrichment, error) {
+	opened := make(map[string]Enricher)
+	enrichments := make([]*Enrichment, 0, len(specs))
+
+	for _, spec := range specs {
+		kind, fields, ok := strings.Cut(spec, ":")
+		if !ok || kind == "" || fields == "" {
+			return nil, fmt.Errorf("enrichments should look like KIND:FIELD, e.g. geo:id.resp_h, not %s", spec)
+		}
+
+		enricher, ok := opened[kind]
+		if !ok {
+			openers_lock.RLock()
+			open, known := openers[kind]
+			openers_lock.RUnlock()
+			if !known {
+				return nil, fmt.Errorf("no enrichment named %s (known: %s)", kind, strings.Join(Kinds(), ", "))
+			}
+
+			var err error
+			enricher, err = open()
+			if err != nil {
+				return nil, &OpenError{kind, err}
+			}
+			opened[kind] = enricher
+		}
+
+		for _, field := range strings.Split(fields, ",") {
+			enrichments = append(enrichments, &Enrichment{kind, field, enricher})
+		}
+	}
+
+	return enrichments, nil
+}
+
+/*
+	Error returned when an enricher can't be set up, e.g. because its
+	database is missing
+*/
+type OpenError struct {
+	Kind string
+	Err  error
+}
+
+func (self *OpenError) Error() string {
+	return fmt.Sprintf("unable to set up %s enrichment: %s", self.Kind, self.Err)
+}
+
+func (self *OpenError) Unwrap() error {
+	return self.Err
+}
+
+//--------------------------------------------------------------------------------
+//	CACHE
+//--------------------------------------------------------------------------------
+
+/* number of values an enricher remembers the columns of */
+var CacheSize int = 65536
+
+/*
+	Least-recently-used cache of the columns looked up for each value,
+	since the same few addresses tend to turn up over and over
+*/
+type cache struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	lock    sync.Mutex
+}
+
+type cached struct {
+	value   string
+	columns []string
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+/*
+	Returns the columns remembered for the value, if there are any
+*/
+func (self *cache) get(value string) ([]string, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	elem, ok := self.entries[value]
+	if !ok {
+		return nil, false
+	}
+	self.order.MoveToFront(elem)
+
+	return elem.Value.(*cached).columns, true
+}
+
+/*
+	Remembers the columns of the value, forgetting the least recently
+	used value if the cache is full
+*/
+func (self *cache) put(value string, columns []string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if elem, ok := self.entries[value]; ok {
+		elem.Value.(*cached).columns = columns
+		self.order.MoveToFront(elem)
+		return
+	}
+
+	self.entries[value] = self.order.PushFront(&cached{value, columns})
+	if self.order.Len() > self.size {
+		oldest := self.order.Back()
+		self.order.Remove(oldest)
+		delete(self.entries, oldest.Value.(*cached).value)
+	}
+}
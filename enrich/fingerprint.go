@@ -0,0 +1,119 @@
+package enrich
+
+import (
+	"bro-awk/schema"
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	TLS FINGERPRINTS
+//--------------------------------------------------------------------------------
+
+/* fields of ssl.log (and http.log, for ja4h) that hold TLS client and server fingerprints */
+var fingerprint_fields []string = []string{"ja3", "ja3s", "ja4", "ja4s", "ja4h"}
+
+/*
+	Labels of known fingerprints, e.g. from abuse.ch's SSLBL, keyed by
+	fingerprint. Empty until LoadFingerprints is called
+*/
+var fingerprints map[string]string
+var fingerprints_lock sync.RWMutex
+
+/*
+	Reads a file of labelled fingerprints, one per line with the
+	fingerprint first and its label last, separated by commas or tabs:
+
+		# ja3_md5,Firstseen,Lastseen,Listingreason
+		72a589da586844d7f0818ce684948eea,2017-07-14 18:08:15,2019-07-27 20:42:54,Tofsee C&C
+
+	Lines starting with # are comments. Once loaded, each fingerprint
+	field gets a derived .label field, e.g. ja3.label
+*/
+func LoadFingerprints(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		sep := ","
+		if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		columns := strings.Split(line, sep)
+		if len(columns) < 2 {
+			return fmt.Errorf("%s:%d: expected a fingerprint and a label", path, lineno)
+		}
+		loaded[strings.ToLower(strings.TrimSpace(columns[0]))] = strings.TrimSpace(columns[len(columns)-1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fingerprints_lock.Lock()
+	fingerprints = loaded
+	fingerprints_lock.Unlock()
+
+	for _, field := range fingerprint_fields {
+		schema.RegisterDerived(schema.Derived{
+			Name:   field + ".label",
+			Type:   "string",
+			Inputs: []string{field},
+			Compute: func(values []string) string {
+				return fingerprintLabel(values[0])
+			},
+		})
+	}
+
+	return nil
+}
+
+/*
+	Returns the label of a fingerprint, or "" if it isn't a known one
+*/
+func fingerprintLabel(fingerprint string) string {
+	fingerprints_lock.RLock()
+	defer fingerprints_lock.RUnlock()
+	return fingerprints[strings.ToLower(fingerprint)]
+}
+
+/*
+	Adds the label of known TLS fingerprints, e.g. ja3.label, the same
+	as the derived field of that name
+*/
+type fingerprintEnricher struct{}
+
+func openFingerprint() (Enricher, error) {
+	fingerprints_lock.RLock()
+	defer fingerprints_lock.RUnlock()
+	if fingerprints == nil {
+		return nil, errors.New("no fingerprint database configured, set fingerprints in the config file")
+	}
+
+	return fingerprintEnricher{}, nil
+}
+
+func (self fingerprintEnricher) Columns() []string {
+	return []string{"label"}
+}
+
+func (self fingerprintEnricher) Lookup(field string, value string) []string {
+	return []string{fingerprintLabel(value)}
+}
+
+func init() {
+	Register("fingerprint", openFingerprint)
+}
@@ -0,0 +1,80 @@
+package enrich
+
+import (
+	"errors"
+	"net/netip"
+)
+
+//--------------------------------------------------------------------------------
+//	GEOIP
+//--------------------------------------------------------------------------------
+
+/*
+	MaxMind database used by geo enrichments, e.g. GeoLite2-City.mmdb. A
+	country database works too, leaving the city column empty
+*/
+var GeoIP string
+
+/*
+	Adds the country (as its ISO code, e.g. US) and city (in English) that
+	an address is in, according to the GeoIP database
+*/
+type geoEnricher struct {
+	db    *mmdb
+	cache *cache
+}
+
+func openGeo() (Enricher, error) {
+	if GeoIP == "" {
+		return nil, errors.New("no GeoIP database configured, set city under [geoip] in the config file")
+	}
+
+	db, err := openMMDB(GeoIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &geoEnricher{db, newCache(CacheSize)}, nil
+}
+
+func (self *geoEnricher) Columns() []string {
+	return []string{"country", "city"}
+}
+
+func (self *geoEnricher) Lookup(field string, value string) []string {
+	if columns, ok := self.cache.get(value); ok {
+		return columns
+	}
+
+	columns := []string{"", ""}
+	if addr, err := netip.ParseAddr(value); err == nil {
+		if record, err := self.db.lookup(addr); err == nil {
+			columns[0] = nested(record, "country", "iso_code")
+			columns[1] = nested(record, "city", "names", "en")
+		}
+	}
+
+	self.cache.put(value, columns)
+	return columns
+}
+
+/*
+	Returns the string found by following the keys down through nested
+	maps of a record, or "" if it isn't there
+*/
+func nested(record any, keys ...string) string {
+	for _, key := range keys {
+		m, ok := record.(map[string]any)
+		if !ok {
+			return ""
+		}
+		record = m[key]
+	}
+
+	s, _ := record.(string)
+	return s
+}
+
+func init() {
+	Register("geo", openGeo)
+}
@@ -0,0 +1,295 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+//--------------------------------------------------------------------------------
+//	MAXMIND DATABASES
+//--------------------------------------------------------------------------------
+
+/* marks the start of the metadata at the end of the file */
+var metadata_marker []byte = []byte("\xab\xcd\xefMaxMind.com")
+
+/* returned for a database whose layout doesn't make sense */
+var errCorrupt error = errors.New("corrupt MaxMind database")
+
+/*
+	Just enough of a reader for MaxMind's .mmdb format to look up the
+	record of an address. The whole file is read into memory, as MaxMind's
+	own readers do with mmap
+*/
+type mmdb struct {
+	buffer      []byte
+	data        []byte
+	node_count  uint
+	record_size uint
+	ip_version  uint
+	ipv4_start  uint
+}
+
+/*
+	Reads the database at the given path
+*/
+func openMMDB(path string) (*mmdb, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	at := bytes.LastIndex(buffer, metadata_marker)
+	if at < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind database", path)
+	}
+
+	meta_section := buffer[at+len(metadata_marker):]
+	raw, _, err := decoder{meta_section}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the metadata of %s: %w", path, err)
+	}
+	meta, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unable to read the metadata of %s: %w", path, errCorrupt)
+	}
+
+	db := &mmdb{buffer: buffer}
+	db.node_count = uint(asUint(meta["node_count"]))
+	db.record_size = uint(asUint(meta["record_size"]))
+	db.ip_version = uint(asUint(meta["ip_version"]))
+	if db.record_size != 24 && db.record_size != 28 && db.record_size != 32 {
+		return nil, fmt.Errorf("%s has unsupported record size %d", path, db.record_size)
+	}
+
+	// the search tree is followed by 16 zero bytes, then the data section
+	tree_size := db.record_size * 2 / 8 * db.node_count
+	if tree_size+16 > uint(at) {
+		return nil, fmt.Errorf("%s: %w", path, errCorrupt)
+	}
+	db.data = buffer[tree_size+16 : at]
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree
+	if db.ip_version == 6 {
+		for i := 0; i < 96 && db.ipv4_start < db.node_count; i++ {
+			db.ipv4_start, err = db.record(db.ipv4_start, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return db, nil
+}
+
+/*
+	Returns the left (0) or right (1) record of a node in the search tree
+*/
+func (self *mmdb) record(node uint, bit uint) (uint, error) {
+	size := self.record_size * 2 / 8
+	offset := node * size
+	if offset+size > uint(len(self.buffer)) {
+		return 0, errCorrupt
+	}
+	b := self.buffer[offset : offset+size]
+
+	switch self.record_size {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+/*
+	Returns the record for the address, or nil if the database has none
+*/
+func (self *mmdb) lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+
+	node := uint(0)
+	bits := addr.AsSlice()
+	if addr.Is4() {
+		if self.ip_version == 6 {
+			node = self.ipv4_start
+		}
+	} else if self.ip_version == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < self.node_count; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+
+		var err error
+		node, err = self.record(node, bit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node <= self.node_count {
+		return nil, nil
+	}
+
+	value, _, err := decoder{self.data}.decode(node - self.node_count - 16)
+	return value, err
+}
+
+//--------------------------------------------------------------------------------
+//	DATA SECTION
+//--------------------------------------------------------------------------------
+
+/*
+	Decodes the typed values of a data section, whose pointers are
+	offsets from its start
+*/
+type decoder struct {
+	section []byte
+}
+
+/*
+	Decodes the value at the given offset, returning it and the offset
+	just past it
+*/
+func (self decoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(self.section)) {
+		return nil, 0, errCorrupt
+	}
+	ctrl := self.section[offset]
+	offset++
+
+	kind := uint(ctrl >> 5)
+	if kind == 1 {
+		return self.pointer(ctrl, offset)
+	}
+	if kind == 0 {
+		if offset >= uint(len(self.section)) {
+			return nil, 0, errCorrupt
+		}
+		kind = 7 + uint(self.section[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(self.section)) {
+			return nil, 0, errCorrupt
+		}
+		extra := uint(0)
+		for _, b := range self.section[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+
+	switch kind {
+	case 7:
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := self.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, after, err := self.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			m[name] = value
+			offset = after
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := self.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(self.section)) {
+		return nil, 0, errCorrupt
+	}
+	b := self.section[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 9, 10:
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8:
+		n := int32(0)
+		for _, c := range b {
+			n = n<<8 | int32(c)
+		}
+		return int64(n), offset, nil
+	}
+
+	// bytes, and anything this reader has no use for
+	return b, offset, nil
+}
+
+/*
+	Follows a pointer, returning the value it points at and the offset
+	just past the pointer itself
+*/
+func (self decoder) pointer(ctrl byte, offset uint) (any, uint, error) {
+	n := uint(ctrl>>3&0x3) + 1
+	if offset+n > uint(len(self.section)) {
+		return nil, 0, errCorrupt
+	}
+
+	target := uint(0)
+	if n < 4 {
+		target = uint(ctrl & 0x7)
+	}
+	for _, b := range self.section[offset : offset+n] {
+		target = target<<8 | uint(b)
+	}
+	target += []uint{0, 2048, 526336, 0}[n-1]
+
+	value, _, err := self.decode(target)
+	return value, offset + n, err
+}
+
+/*
+	Returns a decoded unsigned integer, or 0 for anything else
+*/
+func asUint(value any) uint64 {
+	n, _ := value.(uint64)
+	return n
+}
@@ -0,0 +1,208 @@
+package enrich
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	MAC VENDORS
+//--------------------------------------------------------------------------------
+
+/*
+	Vendors of the MAC address blocks most often seen on a network that
+	matter when hunting for devices that shouldn't be there, used until
+	LoadOUI reads a full database. Keyed by the block's leading hex digits
+*/
+var bundled_ouis map[string]string = map[string]string{
+	"00000C": "Cisco Systems, Inc",
+	"000393": "Apple, Inc.",
+	"000569": "VMware, Inc.",
+	"000C29": "VMware, Inc.",
+	"001C14": "VMware, Inc.",
+	"005056": "VMware, Inc.",
+	"00155D": "Microsoft Corporation",
+	"00163E": "Xensource, Inc.",
+	"001A11": "Google, Inc.",
+	"001B21": "Intel Corporate",
+	"080027": "PCS Systemtechnik GmbH",
+	"0A0027": "VirtualBox host-only adapter",
+	"525400": "QEMU virtual NIC",
+	"0242AC": "Docker container",
+	"B827EB": "Raspberry Pi Foundation",
+	"28CDC1": "Raspberry Pi Trading Ltd",
+	"D83ADD": "Raspberry Pi Trading Ltd",
+	"DCA632": "Raspberry Pi Trading Ltd",
+	"E45F01": "Raspberry Pi Trading Ltd",
+}
+
+/*
+	Vendors keyed by the leading hex digits of the blocks assigned to
+	them: 6 for the usual 24-bit blocks, 7 or 9 for the smaller ones
+*/
+var ouis map[string]string = bundled_ouis
+var ouis_lock sync.RWMutex
+
+/* lengths of the blocks IEEE assigns, in hex digits, longest first */
+var oui_lengths []int = []int{9, 7, 6}
+
+/*
+	Reads a database of MAC address blocks, replacing the bundled one. Both
+	IEEE's own listings and Wireshark's manuf file are understood:
+
+		00-00-0C   (hex)		Cisco Systems, Inc
+		MA-L,00000C,"Cisco Systems, Inc","170 West Tasman Drive San Jose CA US 95134"
+		00:00:0C	Cisco	Cisco Systems, Inc
+		00:1B:C5:00:00:00/36	Converging	Converging Systems Inc.
+
+	Lines starting with # are comments, and other lines are skipped
+*/
+func LoadOUI(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		prefix, vendor, ok, err := parseOUI(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineno, err)
+		} else if ok {
+			loaded[prefix] = vendor
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(loaded) == 0 {
+		return fmt.Errorf("%s: no MAC address blocks found", path)
+	}
+
+	ouis_lock.Lock()
+	ouis = loaded
+	ouis_lock.Unlock()
+
+	return nil
+}
+
+/*
+	Returns the block and vendor on a line of any of the formats LoadOUI
+	reads, or false if the line has none
+*/
+func parseOUI(line string) (string, string, bool, error) {
+	// IEEE's oui.txt, which also repeats each block as (base 16)
+	if block, vendor, ok := strings.Cut(line, "(hex)"); ok {
+		return hexDigits(block), strings.TrimSpace(vendor), true, nil
+	} else if strings.Contains(line, "(base 16)") {
+		return "", "", false, nil
+	}
+
+	// IEEE's oui.csv and its MA-M and MA-S counterparts
+	if strings.HasPrefix(line, "MA-") {
+		record, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return "", "", false, err
+		}
+		if len(record) < 3 || record[0] == "Registry" {
+			return "", "", false, nil
+		}
+		return strings.ToUpper(record[1]), strings.TrimSpace(record[2]), true, nil
+	}
+
+	// Wireshark's manuf, with a long name after the short one when the
+	// short one is abbreviated
+	columns := strings.Split(line, "\t")
+	if len(columns) < 2 {
+		return "", "", false, nil
+	}
+	block, bits, masked := strings.Cut(columns[0], "/")
+	prefix := hexDigits(block)
+	if masked {
+		n, err := strconv.Atoi(bits)
+		if err != nil || n%4 != 0 || n/4 > len(prefix) {
+			return "", "", false, fmt.Errorf("bad block %s", columns[0])
+		}
+		prefix = prefix[:n/4]
+	}
+	if len(prefix) != 6 && len(prefix) != 7 && len(prefix) != 9 {
+		return "", "", false, nil
+	}
+
+	return prefix, strings.TrimSpace(columns[len(columns)-1]), true, nil
+}
+
+/*
+	Returns the hex digits of a MAC address or block, uppercased, with
+	its separators dropped
+*/
+func hexDigits(mac string) string {
+	var digits strings.Builder
+	for _, c := range strings.ToUpper(mac) {
+		if (c >= '0' && c <= '9') || (c >= 'A' && c <= 'F') {
+			digits.WriteRune(c)
+		}
+	}
+
+	return digits.String()
+}
+
+/*
+	Returns the vendor of a MAC address. Addresses with the locally
+	administered bit set, as phones randomizing their address and
+	virtual machines use, belong to no vendor and are said to be so
+*/
+func vendorOf(mac string) string {
+	digits := hexDigits(mac)
+	if len(digits) != 12 {
+		return ""
+	}
+
+	ouis_lock.RLock()
+	defer ouis_lock.RUnlock()
+	for _, n := range oui_lengths {
+		if vendor, ok := ouis[digits[:n]]; ok {
+			return vendor
+		}
+	}
+
+	if first, _ := strconv.ParseUint(digits[:2], 16, 8); first&0x02 != 0 {
+		return "(locally administered)"
+	}
+
+	return ""
+}
+
+/*
+	Adds the vendor of a MAC address, such as dhcp.log's mac or conn.log's
+	orig_l2_addr, from the block of addresses it was assigned
+*/
+type ouiEnricher struct{}
+
+func openOUI() (Enricher, error) {
+	return ouiEnricher{}, nil
+}
+
+func (self ouiEnricher) Columns() []string {
+	return []string{"vendor"}
+}
+
+func (self ouiEnricher) Lookup(field string, value string) []string {
+	return []string{vendorOf(value)}
+}
+
+func init() {
+	Register("oui", openOUI)
+}
@@ -0,0 +1,104 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	REVERSE DNS
+//--------------------------------------------------------------------------------
+
+/* longest a single PTR lookup may take before its column is left unset */
+var PTRTimeout time.Duration = 2 * time.Second
+
+/* number of PTR lookups made at the same time */
+var PTRConcurrency int = 16
+
+/*
+	Adds the PTR name of an address, as the system's resolver returns it.
+	Lookups are cached (including those that found nothing), bounded in
+	number and cut short by PTRTimeout, so that a slow resolver only holds
+	up the matches that are waiting on it
+*/
+type ptrEnricher struct {
+	cache   *cache
+	limiter chan struct{}
+	pending map[string]chan struct{}
+	lock    sync.Mutex
+}
+
+func openPTR() (Enricher, error) {
+	return &ptrEnricher{
+		cache:   newCache(CacheSize),
+		limiter: make(chan struct{}, max(PTRConcurrency, 1)),
+		pending: make(map[string]chan struct{}),
+	}, nil
+}
+
+func (self *ptrEnricher) Columns() []string {
+	return []string{"ptr"}
+}
+
+func (self *ptrEnricher) Lookup(field string, value string) []string {
+	for {
+		if columns, ok := self.cache.get(value); ok {
+			return columns
+		}
+
+		// parsers that turn up while the same address is being looked up
+		// wait for that lookup rather than making their own
+		self.lock.Lock()
+		wait, busy := self.pending[value]
+		if !busy {
+			self.pending[value] = make(chan struct{})
+		}
+		self.lock.Unlock()
+
+		if !busy {
+			break
+		}
+		<-wait
+	}
+
+	columns := []string{self.resolve(value)}
+	self.cache.put(value, columns)
+
+	self.lock.Lock()
+	close(self.pending[value])
+	delete(self.pending, value)
+	self.lock.Unlock()
+
+	return columns
+}
+
+/*
+	Returns the first PTR name of the address without its trailing dot,
+	or "" if it has none or the lookup fails
+*/
+func (self *ptrEnricher) resolve(value string) string {
+	if _, err := netip.ParseAddr(value); err != nil {
+		return ""
+	}
+
+	self.limiter <- struct{}{}
+	defer func() { <-self.limiter }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PTRTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, value)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+
+	return strings.TrimSuffix(names[0], ".")
+}
+
+func init() {
+	Register("ptr", openPTR)
+}
@@ -0,0 +1,32 @@
+package enrich
+
+import (
+	"bro-awk/schema"
+)
+
+//--------------------------------------------------------------------------------
+//	SERVICE NAMES
+//--------------------------------------------------------------------------------
+
+/*
+	Adds the IANA service name of a port, e.g. https for 443, as listed
+	in the system's services file
+*/
+type serviceEnricher struct{}
+
+func openService() (Enricher, error) {
+	return serviceEnricher{}, nil
+}
+
+func (self serviceEnricher) Columns() []string {
+	return []string{"service"}
+}
+
+func (self serviceEnricher) Lookup(field string, value string) []string {
+	name, _ := schema.ServiceName(value)
+	return []string{name}
+}
+
+func init() {
+	Register("service", openService)
+}
@@ -0,0 +1,232 @@
+/*
+	Description:
+		Fatal error reporting for the command-line interface. With
+		`--errors json` each failure is written to STDERR as a single JSON
+		object carrying a stable error code, so that wrapper scripts can
+		react to it without parsing free text
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"bro-awk/remote"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	Error codes reported in the "code" field of JSON errors
+*/
+const (
+	ErrUsage          = "usage"
+	ErrBadConfig      = "bad_config"
+	ErrBadFilter      = "bad_filter"
+	ErrUnknownPreset  = "unknown_preset"
+	ErrMissingField   = "missing_field"
+	ErrNoHeader       = "no_header"
+	ErrInvalidLine    = "invalid_line"
+	ErrCorruptArchive = "corrupt_archive"
+	ErrNoUnzipper     = "no_unzipper"
+	ErrUnreadableFile = "unreadable_file"
+	ErrInterrupted    = "interrupted"
+	ErrFailedLogs     = "failed_logs"
+	ErrInternal       = "internal"
+)
+
+var error_format *string = flagset.String("errors", "text", "")
+
+/*
+	Picks the error code that best describes an error returned by the library
+*/
+func error_code(err error) string {
+	var rule_err *filters.RuleError
+	var field_err *qreader.MissingFieldError
+	var header_err *qreader.NoHeaderError
+	var line_err *qreader.InvalidLineError
+	var archive_err *qreader.CorruptArchiveError
+	var path_err *fs.PathError
+	var status_err *remote.StatusError
+
+	switch {
+	case errors.As(err, &rule_err):
+		return ErrBadFilter
+	case errors.As(err, &field_err):
+		return ErrMissingField
+	case errors.As(err, &header_err):
+		return ErrNoHeader
+	case errors.As(err, &line_err):
+		return ErrInvalidLine
+	case errors.As(err, &archive_err):
+		return ErrCorruptArchive
+	case errors.Is(err, qreader.ErrNoUnzipper):
+		return ErrNoUnzipper
+	case errors.As(err, &path_err), errors.As(err, &status_err):
+		return ErrUnreadableFile
+	}
+
+	return ErrInternal
+}
+
+/*
+	Reports a fatal error and exits. `details` are extra key/value pairs
+	included in JSON output, e.g. "file", "conn.log"
+*/
+func fail(code string, message string, details ...string) {
+	report_error(code, message, details...)
+	os.Exit(error_status())
+}
+
+/*
+	Returns the status to exit with on an error, which with -q is 2 so that
+	it can be told apart from there being no match
+*/
+func error_status() int {
+	if *quiet {
+		return exit_quiet_error
+	}
+
+	return 1
+}
+
+/*
+	Writes an error to STDERR in the --errors format, without exiting
+*/
+func report_error(code string, message string, details ...string) {
+	if *error_format == "json" {
+		report := map[string]string{
+			"level":   "error",
+			"code":    code,
+			"message": message,
+		}
+		for i := 0; i+1 < len(details); i += 2 {
+			report[details[i]] = details[i+1]
+		}
+
+		encoded, _ := json.Marshal(report)
+		fmt.Fprintln(os.Stderr, string(encoded))
+	} else {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s\n", message)
+	}
+}
+
+/*
+	Reports an error returned by the library, pulling whatever details
+	the error carries into the report. Errors joined together, such as
+	one for each filter that couldn't be parsed, are each reported
+*/
+func fail_with(err error, details ...string) {
+	errs := split_errors(err)
+	for _, e := range errs[:len(errs)-1] {
+		message, e_details := describe_error(e, details...)
+		report_error(error_code(e), message, e_details...)
+	}
+
+	last := errs[len(errs)-1]
+	message, details := describe_error(last, details...)
+	fail(error_code(last), message, details...)
+}
+
+/*
+	Returns each of the errors joined into one, or just the one
+*/
+func split_errors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok && len(joined.Unwrap()) > 0 {
+		return joined.Unwrap()
+	}
+
+	return []error{err}
+}
+
+/*
+	Returns the message for an error returned by the library, along with
+	the given details and those the error carries
+*/
+func describe_error(err error, details ...string) (string, []string) {
+	var rule_err *filters.RuleError
+	var field_err *qreader.MissingFieldError
+	var header_err *qreader.NoHeaderError
+	var line_err *qreader.InvalidLineError
+	var archive_err *qreader.CorruptArchiveError
+	message := err.Error()
+
+	if errors.As(err, &rule_err) {
+		details = append(details, "rule", rule_err.Rule, "position", strconv.Itoa(rule_err.Pos))
+
+		// point out where in the rule it went wrong
+		if *error_format != "json" {
+			column := utf8.RuneCountInString(rule_err.Rule[:rule_err.Pos])
+			message += fmt.Sprintf("\n\t%s\n\t%s^", rule_err.Rule, strings.Repeat(" ", column))
+		}
+	}
+	if errors.As(err, &field_err) {
+		details = append(details, "file", field_err.File, "field", field_err.Field)
+		if len(field_err.Suggestions) > 0 {
+			details = append(details, "suggestions", strings.Join(field_err.Suggestions, ","))
+		}
+		if len(field_err.Fields) > 0 {
+			message += "; it has " + strings.Join(field_err.Fields, ", ")
+		}
+	}
+	if errors.As(err, &header_err) {
+		details = append(details, "file", header_err.File)
+
+		// its fields can still be given, or it can be filtered by position
+		given := "--fields <FIELDS>"
+		if header_err.Path != "" {
+			given = "--fields @" + header_err.Path
+		}
+		message += fmt.Sprintf("; give its fields with %s, or number its columns with --fields @N", given)
+	}
+	if errors.As(err, &line_err) {
+		details = append(details, "file", line_err.File, "line", strconv.Itoa(line_err.Line))
+		if line_err.Field != "" {
+			details = append(details, "field", line_err.Field)
+		}
+	}
+	if errors.As(err, &archive_err) {
+		details = append(details, "file", archive_err.File, "member", strconv.Itoa(archive_err.Member),
+			"offset", strconv.FormatInt(archive_err.Offset, 10))
+	}
+
+	return message, details
+}
+
+/*
+	A log that couldn't be scanned, reported once the rest have been
+*/
+type failed_log struct {
+	log string
+	err error
+}
+
+/*
+	Reports each log that couldn't be scanned, then how many there were
+	out of all those given, and exits
+*/
+func fail_logs(failures []failed_log, total int) {
+	for _, failure := range failures {
+		report_log(failure)
+	}
+
+	fail(ErrFailedLogs, fmt.Sprintf("%d of %d logs couldn't be scanned", len(failures), total),
+		"failed", strconv.Itoa(len(failures)), "logs", strconv.Itoa(total))
+}
+
+/*
+	Writes the errors of a log that couldn't be scanned to STDERR in the
+	--errors format, without exiting
+*/
+func report_log(failure failed_log) {
+	for _, err := range split_errors(failure.err) {
+		message, details := describe_error(err, "file", failure.log)
+		report_error(error_code(err), message, details...)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+	Description:
+		Implements `--explain`, which shows what a query would do without
+		running it: the filters as parsed, how each value is tested and
+		which get a fast path rather than the regex engine, the column each
+		field is at in each log, and which logs under the directories given
+		were left out by --log-type, --from or --to
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"fmt"
+	"strings"
+)
+
+var explain *bool = flagset.Bool("explain", false, "")
+
+/*
+	A log under a directory that was left out of the scan, and why
+*/
+type pruned_log struct {
+	path   string
+	reason string
+}
+
+/* logs left out by walk_dir, kept only for --explain */
+var pruned []pruned_log
+
+/*
+	Prints the plan of the query for each of the logs. Returns false if
+	any log couldn't be read or is missing a field, as the scan would fail
+*/
+func explain_query(q *qreader.Qreader, logs []string) bool {
+	ok := true
+
+	fmt.Println("filters, all of which a line has to pass:")
+	explained := q.Filter.Explain()
+	if len(explained) == 0 {
+		fmt.Println("\tnone, so every line matches")
+	}
+	for i, e := range explained {
+		explain_filter(e, fmt.Sprintf("[%d] ", i+1), 1)
+	}
+
+	fmt.Println("pruning:")
+	if *log_types == "" && from_time.IsZero() && to_time.IsZero() {
+		fmt.Println("\tno --log-type, --from or --to, so every log under the directories is kept")
+	}
+	for _, p := range pruned {
+		fmt.Printf("\tskipped %s: %s\n", p.path, p.reason)
+	}
+	fmt.Println("\tlogs are read whole: there is no index to skip parts of a log by")
+
+	for _, log := range logs {
+		fmt.Printf("%s:\n", log)
+
+		plan, err := q.Explain(log)
+		if err != nil {
+			for _, e := range split_errors(err) {
+				fmt.Printf("\t[fails] %s\n", e)
+			}
+			ok = false
+			continue
+		}
+
+		format := "Zeek"
+		if plan.JSON {
+			format = "JSON"
+		}
+		fmt.Printf("\t%s log with %d fields\n", format, len(plan.Fields))
+		for i, e := range plan.Filters {
+			explain_filter(e, fmt.Sprintf("[%d] ", i+1), 1)
+		}
+
+		if plan.Printed != nil {
+			printed := make([]string, len(plan.Printed))
+			for i, idx := range plan.Printed {
+				if idx < 0 {
+					printed[i] = "(not in this log, printed as unset)"
+				} else {
+					printed[i] = column_of(plan.Fields[idx], idx, "")
+				}
+			}
+			fmt.Printf("\tprinted:\n\t\t%s\n", strings.Join(printed, "\n\t\t"))
+		}
+	}
+
+	return ok
+}
+
+/*
+	Prints a filter, and any it is made of, indented by depth. Once bound
+	to a log, the column of each field it looks at is given too
+*/
+func explain_filter(e filters.Explanation, label string, depth int) {
+	indent := strings.Repeat("\t", depth)
+
+	switch e.Kind {
+	case "any", "all", "not":
+		fmt.Printf("%s%s%s of:\n", indent, label, e.Kind)
+		for _, child := range e.Children {
+			explain_filter(child, "", depth+1)
+		}
+		return
+	}
+
+	rule := e.Rule
+	if rule == "" {
+		rule = strings.Join(e.Fields, ",") + e.Op + strings.Join(e.Values, ",")
+	}
+	fmt.Printf("%s%s%s (%s)\n", indent, label, rule, e.Kind)
+
+	for i, field := range e.Fields {
+		if e.Columns != nil {
+			fmt.Printf("%s\t%s\n", indent, column_of(field, e.Columns[i], e.Types[i]))
+		}
+	}
+	for _, test := range e.Tests {
+		fmt.Printf("%s\t%s\n", indent, test)
+	}
+}
+
+/*
+	Describes where a field is in a log, as check does
+*/
+func column_of(field string, idx int, field_type string) string {
+	if idx < 0 {
+		return field + " (missing)"
+	}
+
+	where := fmt.Sprintf("column %d", idx+1)
+	if d, derived := schema.LookupDerived(field); derived {
+		where = "derived from " + strings.Join(d.Inputs, ", ")
+	}
+	if field_type != "" {
+		where += ", " + field_type
+	}
+
+	return fmt.Sprintf("%s -> %s", field, where)
+}
@@ -0,0 +1,81 @@
+/*
+	Description:
+		Implements the `fields` subcommand, which prints the header
+		metadata of each given log so that users can discover field names
+		without decompressing the log by hand:
+
+			bro-awk fields conn.log.gz
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+/*
+	Prints the header of each of the logs
+*/
+func fields_command(unzipper string, logs []string) {
+	if len(logs) == 0 {
+		fail(ErrUsage, "No logs specified. Usage: bro-awk fields [LOGS...]")
+	}
+
+	if unzipper == "" {
+		found, err := qreader.FindUnzipper()
+		if err != nil {
+			fail_with(err)
+		}
+		unzipper = found
+	}
+
+	for i, log := range logs {
+		header, err := qreader.ReadHeader(unzipper, log)
+		if err != nil {
+			fail_with(err, "file", log)
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n", log)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "\tpath\t%s\n", header.Path)
+		fmt.Fprintf(w, "\topen\t%s\n", header.Open)
+		fmt.Fprintf(w, "\tseparator\t%s\n", qreader.Escape(header.Separator))
+		fmt.Fprintf(w, "\tset_separator\t%s\n", qreader.Escape(header.SetSeparator))
+		fmt.Fprintf(w, "\tempty_field\t%s\n", header.EmptyField)
+		fmt.Fprintf(w, "\tunset_field\t%s\n", header.UnsetField)
+		fmt.Fprintln(w)
+
+		// fall back on the types of the known log layouts for logs
+		// written without #types
+		path := header.Path
+		if path == "" {
+			path = schema.PathOf(log)
+		}
+		log_type, known := schema.Lookup(path)
+
+		fmt.Fprintln(w, "\tFIELD\tTYPE")
+		for idx, field := range header.Fields {
+			field_type := "?"
+			if idx < len(header.Types) {
+				field_type = header.Types[idx]
+			} else if known {
+				if t, ok := log_type.Type(field); ok {
+					field_type = t + " (from schema)"
+				}
+			}
+			fmt.Fprintf(w, "\t%s\t%s\n", field, field_type)
+		}
+		for _, d := range schema.DerivedFrom(header.Fields) {
+			fmt.Fprintf(w, "\t%s\t%s (derived)\n", d.Name, d.Type)
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,180 @@
+package filters
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	Explaining filters
+//--------------------------------------------------------------------------------
+
+/*
+	Description of a filter, as --explain shows it: what kind of rule it
+	is, what it compares and how each value is tested. Once the filter is
+	bound, Columns holds the index of each field in the log (-1 for those
+	it doesn't have) and Types the Zeek type of each. Combined filters are
+	described by their Children
+*/
+type Explanation struct {
+	Rule     string
+	Kind     string
+	Fields   []string
+	Op       string
+	Values   []string
+	Negate   bool
+	Columns  []int
+	Types    []string
+	Tests    []string
+	Children []Explanation
+}
+
+/*
+	Describes each of the filters in the set, which a line has to pass all of
+*/
+func (self FilterSet) Explain() []Explanation {
+	explained := make([]Explanation, len(self.filters))
+	for i, f := range self.filters {
+		explained[i] = Explain(f)
+		explained[i].Rule = self.rules[i]
+	}
+
+	return explained
+}
+
+/*
+	Describes a single filter. Filters implemented outside of this package
+	are described only as custom
+*/
+func Explain(f BaseFilter) Explanation {
+	switch f := f.(type) {
+	case *Filter:
+		e := explained("literal", f.fields, "=", f.values, f.negate, f.binding)
+		for i, v := range f.values {
+			if prefix, err := netip.ParsePrefix(v); err == nil {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: exact match, or any address in the subnet %s", v, prefix.Masked()))
+			} else if f.given != nil && f.given[i] != v {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: exact match on %s, the port of the service", f.given[i], v))
+			} else {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: exact match", v))
+			}
+		}
+		return e
+
+	case *RegexFilter:
+		patterns := make([]string, len(f.values))
+		for i, re := range f.values {
+			patterns[i] = re.String()
+		}
+		e := explained("regex", f.fields, "~", patterns, f.negate, f.binding)
+		for _, re := range f.values {
+			if _, complete := re.LiteralPrefix(); complete && f.literals != nil {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: plain text, found in one pass over the value along with the other %d", re, len(f.values)-len(f.others)-1))
+				continue
+			}
+			e.Tests = append(e.Tests, explainRegex(re))
+		}
+		return e
+
+	case *PredicateFilter:
+		// filters built in Go code don't know what their predicates are called
+		names := f.names
+		if names == nil {
+			names = slices.Repeat([]string{"(unnamed)"}, len(f.predicates))
+		}
+		e := explained("predicate", f.fields, "|", names, f.negate, f.binding)
+		for _, name := range names {
+			e.Tests = append(e.Tests, name+": predicate called on the value")
+		}
+		return e
+
+	case *CompareFilter:
+		e := explained("compare", f.fields, f.op, []string{f.value}, false, f.binding)
+		if f.binding == nil {
+			e.Tests = append(e.Tests, "compared according to the type of the field, settled for each log")
+			return e
+		}
+		for i, field := range f.fields {
+			e.Tests = append(e.Tests, fmt.Sprintf("%s: %s", field, f.method(f.binding.ElementType(field))))
+			if f.containers[i] {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: each element of the set or vector is compared", field))
+			}
+		}
+		return e
+
+	case *WordFilter:
+		e := explained("words", f.fields, "%", f.values, f.negate, f.binding)
+		for i, v := range f.values {
+			if len(f.phrases[i]) == 1 {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: %s as a whole word, in any case", v, f.phrases[i][0]))
+			} else {
+				e.Tests = append(e.Tests, fmt.Sprintf("%s: %s as whole words, one after the other, in any case", v, strings.Join(f.phrases[i], " ")))
+			}
+		}
+		return e
+
+	case *AnyFilter:
+		return Explanation{Kind: "any", Children: explainAll(f.filters)}
+	case *AllFilter:
+		return Explanation{Kind: "all", Children: explainAll(f.filters)}
+	case *NotFilter:
+		return Explanation{Kind: "not", Children: []Explanation{Explain(f.filter)}}
+	}
+
+	return Explanation{Kind: "custom", Tests: []string{fmt.Sprintf("%T: Passes is called on each line", f)}}
+}
+
+/*
+	Fills in what every kind of rule has: its fields and values, and where
+	they are in the log once bound. Negated rules have ! before their
+	operator
+*/
+func explained(kind string, fields []string, op string, values []string, negate bool, binding *Binding) Explanation {
+	if negate {
+		op = "!" + op
+	}
+	e := Explanation{Kind: kind, Fields: fields, Op: op, Values: values, Negate: negate}
+
+	if binding != nil {
+		e.Columns = make([]int, len(fields))
+		e.Types = make([]string, len(fields))
+		for i, field := range fields {
+			e.Columns[i] = binding.Index(field)
+			e.Types[i] = binding.Types[field]
+		}
+	}
+
+	return e
+}
+
+func explainAll(filters []BaseFilter) []Explanation {
+	explained := make([]Explanation, len(filters))
+	for i, f := range filters {
+		explained[i] = Explain(f)
+	}
+
+	return explained
+}
+
+/*
+	Says how a regex is run, which is as a substring search if it is plain
+	text (see regexMatcher)
+*/
+func explainRegex(re *regexp.Regexp) string {
+	literal, complete := re.LiteralPrefix()
+	if complete {
+		return fmt.Sprintf("%s: plain text, found by substring search without the regex engine", re)
+	}
+	if literal != "" {
+		return fmt.Sprintf("%s: regex, which only runs where its literal prefix %s is found", re, strconv.Quote(literal))
+	}
+	if strings.HasPrefix(re.String(), "^") {
+		return fmt.Sprintf("%s: regex, anchored to the start of the value", re)
+	}
+
+	return fmt.Sprintf("%s: regex, run over the whole value", re)
+}
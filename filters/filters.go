@@ -1,20 +1,17 @@
 package filters
 
 import (
+	"bro-awk/schema"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"net/netip"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 )
 
-/*
-	global indexmap which allows mapping from field -> index in Linedata slice
-
-	this allows us to pass around []string instead of map[string]string and just
-	use this map to index into the given field
-*/
-var indexmap map[string]int
-
 //--------------------------------------------------------------------------------
 //	Linedata wrapper for []string
 //--------------------------------------------------------------------------------
@@ -23,30 +20,148 @@ var indexmap map[string]int
 type Linedata []string
 
 /*
-	helper function that allows for easy indexing into a Linedata struct
-	via the name of the field you're interested in
+	indexmap which allows mapping from field -> index in Linedata slice, built
+	from a single log's header
+
+	this allows us to pass around []string instead of map[string]string. Each
+	filter looks the indices of its fields up in the indexmap once, when it is
+	bound, so lines are indexed straight into and filters for different logs
+	never share one
 */
-func (self Linedata) get(field string) string {
-	idx, ok := indexmap[field]
-	if !ok {
-		fmt.Printf("[ERROR] unable to find index for field: %s\n", field)
-		fmt.Println("indexmap dump:")
-		fmt.Println(indexmap)
-		os.Exit(1)
+type Indexmap map[string]int
+
+/*
+	helper function that returns the value at one of the indices a filter
+	looked up when it was bound
+*/
+func (self Linedata) at(binding *Binding, idx int) string {
+	// a field the log doesn't have, or one missing from the end of a line
+	// cut short, counts as unset
+	if idx < 0 || idx >= len(self) {
+		return binding.UnsetField
 	}
 
 	return self[idx]
 }
 
+/*
+	Everything a filter needs to know about the log it is bound to: where
+	each of the fields is, how the log writes the elements of sets and
+	vectors and values that are empty or unset, and the Zeek type of each
+	field (taken from the log's #types, if it has them)
+*/
+type Binding struct {
+	Indexmap     Indexmap
+	SetSeparator string
+	EmptyField   string
+	UnsetField   string
+	Types        map[string]string
+}
+
+/*
+	Creates a binding for a log with the given fields that uses Zeek's
+	default markers
+*/
+func NewBinding(fields []string) *Binding {
+	b := &Binding{
+		Indexmap:     make(Indexmap),
+		SetSeparator: ",",
+		EmptyField:   "(empty)",
+		UnsetField:   "-",
+		Types:        make(map[string]string),
+	}
+
+	for idx, field := range fields {
+		b.Indexmap[field] = idx
+	}
+
+	return b
+}
+
+/*
+	Returns the index of the field in the log's lines, or -1 if it doesn't
+	have it
+*/
+func (self *Binding) Index(field string) int {
+	idx, ok := self.Indexmap[field]
+	if !ok {
+		return -1
+	}
+
+	return idx
+}
+
+/*
+	Splits the value of a set or vector field into its elements. Empty
+	and unset values have none
+*/
+func (self *Binding) Elements(value string) []string {
+	if value == self.EmptyField || value == self.UnsetField {
+		return nil
+	}
+
+	return strings.Split(value, self.SetSeparator)
+}
+
+/*
+	Reports whether the field holds a set or vector
+*/
+func (self *Binding) IsContainer(field string) bool {
+	t := self.Types[field]
+	return strings.HasPrefix(t, "set[") || strings.HasPrefix(t, "vector[")
+}
+
+/*
+	Returns the type of the elements of a set or vector field, or the type
+	of any other field. Fields of unknown type have none
+*/
+func (self *Binding) ElementType(field string) string {
+	t := self.Types[field]
+	if open := strings.IndexByte(t, '['); open >= 0 && strings.HasSuffix(t, "]") {
+		return t[open+1 : len(t)-1]
+	}
+
+	return t
+}
+
+/*
+	Tests a field's value with the comparison. The value of a set or
+	vector field is tested whole and then element by element, and passes
+	if any of those do -- or, for a negated rule, only if all of them do
+*/
+func (self *Binding) test(container bool, value string, negate bool, compare func(a string) bool) bool {
+	if !container {
+		return compare(value)
+	}
+
+	if compare(value) != negate {
+		return !negate
+	}
+	for _, element := range self.Elements(value) {
+		if compare(element) != negate {
+			return !negate
+		}
+	}
+
+	return negate
+}
+
 //--------------------------------------------------------------------------------
 //	Single Filter class
 //--------------------------------------------------------------------------------
 
 /*
 	Filter interface that allows agnostic treatment of string/regex based filters
+
+	Bind returns a copy of the filter that looks fields up through the given
+	binding, leaving the original untouched so that it can be bound to other
+	logs at the same time. Each field is looked up once, then, so fields the
+	log doesn't have read as unset on every line -- check for those first,
+	with FieldsOf
 */
 type BaseFilter interface {
 	Passes(data *Linedata) bool
+	Bind(binding *Binding) BaseFilter
 }
 
 /*
@@ -55,7 +170,12 @@ type BaseFilter interface {
 type Filter struct {
 	fields           []string
 	values           []string
+	given            []string
+	negate           bool
 	compare_function func(a string, b string) bool
+	binding          *Binding
+	indices          []int
+	containers       []bool
 }
 
 /*
@@ -64,50 +184,161 @@ type Filter struct {
 type RegexFilter struct {
 	fields           []string
 	values           []*regexp.Regexp
+	negate           bool
 	compare_function func(a string, re *regexp.Regexp) bool
+	literals         *automaton
+	others           []*regexp.Regexp
+	binding          *Binding
+	indices          []int
+	containers       []bool
 }
 
 /*
-	Constructor for single filter type
-	TODO check with regex or something to make sure it's a valid rule!
+	Error returned when a filter rule can't be turned into a filter. Pos
+	is the byte offset in the rule of what couldn't be parsed
 */
-func NewFilter(rule string) BaseFilter {
-	// set the appropriate comparison function based on which
-	// operator is given
+type RuleError struct {
+	Rule   string
+	Reason string
+	Err    error
+	Pos    int
+}
+
+func (self *RuleError) Error() string {
+	if self.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", self.Reason, self.Rule, self.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", self.Reason, self.Rule)
+}
+
+func (self *RuleError) Unwrap() error {
+	return self.Err
+}
+
+/*
+	Filter struct that represents a rule made up of named predicates
+	from the registry
+*/
+type PredicateFilter struct {
+	fields     []string
+	predicates []Predicate
+	markers    []markerPredicate
+	names      []string
+	negate     bool
+	binding    *Binding
+	indices    []int
+}
+
+/* operators a rule may use, longest first so that <= isn't taken for < */
+var rule_operators []string = []string{"!=", "!~", "!|", "!%", "<=", ">=", "=", "~", "|", "%", "<", ">"}
+
+/* characters that start an operator, and so can't be part of a field name */
+const operator_chars string = "=~!<>|%"
+
+/* names of predicates, as given after | */
+var predicate_name_re *regexp.Regexp = regexp.MustCompile(`^\w+$`)
+
+/*
+	Splits a rule into its fields, operator and value, checking that it
+	has one of each. The operator is the first one in the rule, so a regex
+	or value may contain operator characters of its own
+*/
+func splitRule(rule string) ([]string, string, string, error) {
+	operators := "one of " + strings.Join(slices.Sorted(slices.Values(rule_operators)), " ")
+
+	at := strings.IndexAny(rule, operator_chars)
+	if at < 0 {
+		return nil, "", "", &RuleError{rule, "rule has no operator, expected " + operators, nil, len(rule)}
+	}
+
+	// each of the fields, separated by commas, needs a name
+	fields := strings.Split(rule[:at], ",")
+	offset := 0
+	for _, field := range fields {
+		if strings.TrimSpace(field) == "" {
+			return nil, "", "", &RuleError{rule, "rule is missing a field name", nil, offset}
+		}
+		offset += len(field) + 1
+	}
+
 	var op string
-	var isregex, negate bool
-
-	if strings.Contains(rule, "!=") {
-		op = "!="
-		negate = true
-		isregex = false
-	} else if strings.Contains(rule, "=") {
-		op = "="
-		negate = false
-		isregex = false
-	} else if strings.Contains(rule, "!~") {
-		op = "!~"
-		negate = true
-		isregex = true
-	} else if strings.Contains(rule, "~") {
-		op = "~"
-		negate = false
-		isregex = true
-	} else {
-		fmt.Println("[ERROR] not sure how to parse rule: " + rule)
-		os.Exit(1)
+	for _, candidate := range rule_operators {
+		if strings.HasPrefix(rule[at:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, "", "", &RuleError{rule, fmt.Sprintf("unknown operator %q in rule, expected %s", rule[at:at+1], operators), nil, at}
+	}
+
+	value_at := at + len(op)
+	value := rule[value_at:]
+	if value == "" {
+		return nil, "", "", &RuleError{rule, fmt.Sprintf("rule has nothing after %s to compare with", op), nil, value_at}
+	}
+
+	// a value can't start with = or ~ straight after =, as in == or =~,
+	// which is nearly always a mistyped operator. Anything else may start
+	// a value, e.g. the %2e of an encoded URI or the < of a history
+	if (op == "=" || op == "!=") && strings.ContainsRune("=~", rune(value[0])) {
+		return nil, "", "", &RuleError{rule, fmt.Sprintf("unexpected %q after %s in rule, expected %s", value[:1], op, operators), nil, value_at}
+	}
+	if op == "=" || op == "!=" {
+		if extra := strings.Index(value, "="); extra >= 0 {
+			return nil, "", "", &RuleError{rule, "rule contains too many boolean operators", nil, value_at + extra}
+		}
 	}
 
-	// split the rule into fields/values and set the appropriate fields
-	// in the new filter
-	opsides := strings.Split(rule, op)
-	if len(opsides) != 2 {
-		fmt.Println("[ERROR] rule contains too many boolean operators: " + rule)
-		os.Exit(1)
+	return fields, op, value, nil
+}
+
+/*
+	Constructor for single filter type, returns an error if the rule
+	can't be parsed
+*/
+func NewFilter(rule string) (BaseFilter, error) {
+	fields, op, value, err := splitRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "|", "!|":
+		f := &PredicateFilter{}
+		f.fields = fields
+		f.negate = (op == "!|")
+
+		offset := len(rule) - len(value)
+		for _, name := range strings.Split(value, ",") {
+			if p, ok := LookupPredicate(name); ok {
+				f.predicates = append(f.predicates, p)
+			} else if p, ok := marker_predicates[name]; ok {
+				f.markers = append(f.markers, p)
+			} else if !predicate_name_re.MatchString(name) {
+				return nil, &RuleError{rule, fmt.Sprintf("bad predicate name %q in rule", name), nil, offset}
+			} else {
+				return nil, &RuleError{rule, fmt.Sprintf("unknown predicate %q in rule, expected one of %s", name, strings.Join(PredicateNames(), ", ")), nil, offset}
+			}
+			f.names = append(f.names, name)
+			offset += len(name) + 1
+		}
+
+		return BaseFilter(f), nil
+
+	case "<", "<=", ">", ">=":
+		return newCompareFilter(rule, fields, op, value)
+
+	case "%", "!%":
+		return newWordFilter(rule, fields, op == "!%", value)
 	}
 
-	fields := strings.Split(opsides[0], ",")
-	values := strings.Split(opsides[1], ",")
+	// set the appropriate comparison function based on which
+	// operator is given
+	isregex := (op == "~" || op == "!~")
+	negate := (op == "!=" || op == "!~")
+	values := strings.Split(value, ",")
 
 	// choose the comparison operator based on whether or not to negate
 	// the filter
@@ -116,51 +347,73 @@ func NewFilter(rule string) BaseFilter {
 
 		// compile the user-supplied regexes
 		regex_values := make([]*regexp.Regexp, len(values))
+		offset := len(rule) - len(value)
 		for i, v := range values {
 			my_regex, err := regexp.Compile(v)
 			if err != nil {
-				fmt.Println("[ERROR] unable to compile POSIX regex: " + v)
-				os.Exit(1)
+				return nil, &RuleError{rule, fmt.Sprintf("unable to compile regex %q in rule", v), err, offset}
 			} else {
 				regex_values[i] = my_regex
 			}
+			offset += len(v) + 1
 		}
 
 		// set the fields and values of the filter
 		f.fields = fields
 		f.values = regex_values
+		f.negate = negate
 
 		// set the compare function based on whether or not negation should be used
+		matches := regexMatcher(regex_values)
 		if negate {
 			f.compare_function = func(a string, re *regexp.Regexp) bool {
-				return !re.MatchString(a)
+				return !matches(a, re)
 			}
 		} else {
-			f.compare_function = func(a string, re *regexp.Regexp) bool {
-				return re.MatchString(a)
-			}
+			f.compare_function = matches
+			// a long list of plain text patterns is looked for in one pass
+			f.literals, f.others = newAutomaton(regex_values)
 		}
 
-		return BaseFilter(f)
+		return BaseFilter(f), nil
 	} else {
 		f := &Filter{}
 
 		// set the fields and values of the filter
 		f.fields = fields
 		f.values = values
+		f.negate = negate
+
+		// a value written as a subnet also matches each address in it,
+		// e.g. id.resp_h=10.0.0.0/8
+		prefixes := make(map[string]netip.Prefix)
+		for _, v := range values {
+			if prefix, err := netip.ParsePrefix(v); err == nil {
+				prefixes[v] = prefix.Masked()
+			}
+		}
+		equal := func(a string, b string) bool {
+			if a == b {
+				return true
+			}
+			prefix, ok := prefixes[b]
+			if !ok {
+				return false
+			}
+			addr, err := netip.ParseAddr(a)
+			return err == nil && prefix.Contains(addr.Unmap())
+		}
 
 		// set the compare function based on whether or not negation should be used
 		if negate {
 			f.compare_function = func(a string, b string) bool {
-				return (a != b)
+				return !equal(a, b)
 			}
 		} else {
-			f.compare_function = func(a string, b string) bool {
-				return (a == b)
-			}
+			f.compare_function = equal
 		}
 
-		return BaseFilter(f)
+		return BaseFilter(f), nil
 	}
 }
 
@@ -169,9 +422,12 @@ func NewFilter(rule string) BaseFilter {
 	TODO
 */
 func (self Filter) Passes(data *Linedata) bool {
-	for _, field := range self.fields {
+	for i, idx := range self.indices {
+		field_value := data.at(self.binding, idx)
 		for _, value := range self.values {
-			if self.compare_function(data.get(field), value) {
+			if self.binding.test(self.containers[i], field_value, self.negate, func(a string) bool {
+				return self.compare_function(a, value)
+			}) {
 				return true
 			}
 		}
@@ -185,9 +441,16 @@ func (self Filter) Passes(data *Linedata) bool {
 	TODO
 */
 func (self RegexFilter) Passes(data *Linedata) bool {
-	for _, field := range self.fields {
+	if self.literals != nil {
+		return self.passesAny(data)
+	}
+
+	for i, idx := range self.indices {
+		field_value := data.at(self.binding, idx)
 		for _, value := range self.values {
-			if self.compare_function(data.get(field), value) {
+			if self.binding.test(self.containers[i], field_value, self.negate, func(a string) bool {
+				return self.compare_function(a, value)
+			}) {
 				return true
 			}
 		}
@@ -196,6 +459,384 @@ func (self RegexFilter) Passes(data *Linedata) bool {
 	return false
 }
 
+/*
+	Passes the line if any of the patterns matches, when those that are
+	plain text are found together by an automaton, once for each field
+	rather than once for each pattern
+*/
+func (self RegexFilter) passesAny(data *Linedata) bool {
+	for i, idx := range self.indices {
+		if self.binding.test(self.containers[i], data.at(self.binding, idx), false, func(a string) bool {
+			if self.literals.contains(a) {
+				return true
+			}
+			for _, re := range self.others {
+				if re.MatchString(a) {
+					return true
+				}
+			}
+			return false
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Determines whether or not that line passes based off the given filter.
+	A negated filter passes only if no predicate matches any of its fields
+*/
+func (self PredicateFilter) Passes(data *Linedata) bool {
+	for _, idx := range self.indices {
+		value := data.at(self.binding, idx)
+		for _, p := range self.predicates {
+			if p(value) {
+				return !self.negate
+			}
+		}
+		for _, p := range self.markers {
+			if p(self.binding, value) {
+				return !self.negate
+			}
+		}
+	}
+
+	return self.negate
+}
+
+//--------------------------------------------------------------------------------
+//	Programmatic construction
+//--------------------------------------------------------------------------------
+
+/*
+	Filter that passes if any of its children pass
+*/
+type AnyFilter struct {
+	filters []BaseFilter
+}
+
+/*
+	Filter that passes only if all of its children pass
+*/
+type AllFilter struct {
+	filters []BaseFilter
+}
+
+/*
+	Filter that passes only if its child does not
+*/
+type NotFilter struct {
+	filter BaseFilter
+}
+
+func (self AnyFilter) Passes(data *Linedata) bool {
+	for _, f := range self.filters {
+		if f.Passes(data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self AllFilter) Passes(data *Linedata) bool {
+	for _, f := range self.filters {
+		if !f.Passes(data) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (self NotFilter) Passes(data *Linedata) bool {
+	return !self.filter.Passes(data)
+}
+
+func (self Filter) Bind(binding *Binding) BaseFilter {
+	self.binding = binding
+	self.indices = indicesOf(binding, self.fields)
+	self.containers = containersOf(binding, self.fields)
+	self.given = self.values
+	self.values = portsOf(binding, self.fields, self.values)
+	return &self
+}
+
+func (self RegexFilter) Bind(binding *Binding) BaseFilter {
+	self.binding = binding
+	self.indices = indicesOf(binding, self.fields)
+	self.containers = containersOf(binding, self.fields)
+	return &self
+}
+
+func (self PredicateFilter) Bind(binding *Binding) BaseFilter {
+	self.binding = binding
+	self.indices = indicesOf(binding, self.fields)
+	return &self
+}
+
+func (self AnyFilter) Bind(binding *Binding) BaseFilter {
+	return &AnyFilter{bindAll(self.filters, binding)}
+}
+
+func (self AllFilter) Bind(binding *Binding) BaseFilter {
+	return &AllFilter{bindAll(self.filters, binding)}
+}
+
+func (self NotFilter) Bind(binding *Binding) BaseFilter {
+	return &NotFilter{self.filter.Bind(binding)}
+}
+
+/*
+	Binds each of the filters, returning the bound copies
+*/
+func bindAll(filters []BaseFilter, binding *Binding) []BaseFilter {
+	bound := make([]BaseFilter, len(filters))
+	for i, f := range filters {
+		bound[i] = f.Bind(binding)
+	}
+
+	return bound
+}
+
+/*
+	Looks up where each of the fields is once, at bind time, rather than
+	for every line
+*/
+func indicesOf(binding *Binding, fields []string) []int {
+	indices := make([]int, len(fields))
+	for i, field := range fields {
+		indices[i] = binding.Index(field)
+	}
+
+	return indices
+}
+
+/*
+	Looks up which of the fields are sets or vectors once, at bind time,
+	rather than for every line
+*/
+func containersOf(binding *Binding, fields []string) []bool {
+	containers := make([]bool, len(fields))
+	for i, field := range fields {
+		containers[i] = binding.IsContainer(field)
+	}
+
+	return containers
+}
+
+/*
+	Swaps service names among the values for their port numbers when
+	every field is a port, so that id.resp_p=https matches 443
+*/
+func portsOf(binding *Binding, fields []string, values []string) []string {
+	for _, field := range fields {
+		if binding.Types[field] != "port" {
+			return values
+		}
+	}
+
+	translated := make([]string, len(values))
+	for i, v := range values {
+		translated[i] = v
+		if port, ok := schema.ServicePort(v); ok {
+			translated[i] = port
+		}
+	}
+
+	return translated
+}
+
+/*
+	Builds a filter that passes if the field equals any of the values,
+	the same as the rule <FIELD>=<VALUE>,<VALUE>...
+*/
+func Eq(field string, values ...string) BaseFilter {
+	return &Filter{
+		fields: []string{field},
+		values: values,
+		compare_function: func(a string, b string) bool {
+			return (a == b)
+		},
+	}
+}
+
+/*
+	Builds a filter that passes if the field equals none of the values
+*/
+func Ne(field string, values ...string) BaseFilter {
+	return Not(Eq(field, values...))
+}
+
+/*
+	Builds a filter that passes if the field matches any of the regexes,
+	the same as the rule <FIELD>~<REGEX>,<REGEX>...
+*/
+func Regex(field string, res ...*regexp.Regexp) BaseFilter {
+	literals, others := newAutomaton(res)
+	return &RegexFilter{
+		fields:           []string{field},
+		values:           res,
+		compare_function: regexMatcher(res),
+		literals:         literals,
+		others:           others,
+	}
+}
+
+/*
+	Returns a function that matches values against the regexes. Those
+	that are plain text, with nothing special in them, are looked for as
+	a substring rather than run through the regex engine
+*/
+func regexMatcher(res []*regexp.Regexp) func(a string, re *regexp.Regexp) bool {
+	literals := make(map[*regexp.Regexp]string)
+	for _, re := range res {
+		if literal, complete := re.LiteralPrefix(); complete {
+			literals[re] = literal
+		}
+	}
+
+	if len(literals) == 0 {
+		return func(a string, re *regexp.Regexp) bool {
+			return re.MatchString(a)
+		}
+	}
+
+	return func(a string, re *regexp.Regexp) bool {
+		if literal, ok := literals[re]; ok {
+			return strings.Contains(a, literal)
+		}
+		return re.MatchString(a)
+	}
+}
+
+/*
+	Builds a filter that passes if the field matches none of the regexes
+*/
+func NotRegex(field string, res ...*regexp.Regexp) BaseFilter {
+	return Not(Regex(field, res...))
+}
+
+/*
+	Builds a filter that passes if any of the predicates holds for the field
+*/
+func Is(field string, predicates ...Predicate) BaseFilter {
+	return &PredicateFilter{
+		fields:     []string{field},
+		predicates: predicates,
+	}
+}
+
+/*
+	Combines filters so that a line passes if any one of them does
+*/
+func Or(filters ...BaseFilter) BaseFilter {
+	return &AnyFilter{filters}
+}
+
+/*
+	Combines filters so that a line passes only if all of them do
+*/
+func And(filters ...BaseFilter) BaseFilter {
+	return &AllFilter{filters}
+}
+
+/*
+	Inverts a filter
+*/
+func Not(filter BaseFilter) BaseFilter {
+	return &NotFilter{filter}
+}
+
+//--------------------------------------------------------------------------------
+//	Predicate registry
+//--------------------------------------------------------------------------------
+
+/*
+	A named test against a single field value, usable in filter strings
+	as <FIELD>|<NAME>
+*/
+type Predicate func(value string) bool
+
+var predicates map[string]Predicate = map[string]Predicate{
+	"is_private": isPrivate,
+}
+var predicates_lock sync.RWMutex
+
+/*
+	Built-in predicates that depend on how the log writes missing values,
+	as declared by its #unset_field and #empty_field headers
+*/
+type markerPredicate func(binding *Binding, value string) bool
+
+var marker_predicates map[string]markerPredicate = map[string]markerPredicate{
+	"is_set": func(binding *Binding, value string) bool {
+		return value != binding.UnsetField
+	},
+	"is_unset": func(binding *Binding, value string) bool {
+		return value == binding.UnsetField
+	},
+	"is_empty": func(binding *Binding, value string) bool {
+		return value == binding.EmptyField
+	},
+}
+
+/*
+	Registers a predicate under the given name, replacing any predicate
+	already registered with that name. Must be called before the filters
+	that use it are constructed
+*/
+func RegisterPredicate(name string, p Predicate) {
+	predicates_lock.Lock()
+	defer predicates_lock.Unlock()
+
+	predicates[name] = p
+}
+
+/*
+	Returns the names of every predicate, registered or built in, sorted
+*/
+func PredicateNames() []string {
+	predicates_lock.RLock()
+	defer predicates_lock.RUnlock()
+
+	names := make([]string, 0, len(predicates)+len(marker_predicates))
+	for name := range predicates {
+		names = append(names, name)
+	}
+	for name := range marker_predicates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+/*
+	Returns the predicate registered under the given name, if any
+*/
+func LookupPredicate(name string) (Predicate, bool) {
+	predicates_lock.RLock()
+	defer predicates_lock.RUnlock()
+
+	p, ok := predicates[name]
+	return p, ok
+}
+
+/*
+	Built-in predicate: true for RFC1918 / RFC4193 / loopback / link-local addresses
+*/
+func isPrivate(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
+	}
+
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()
+}
+
 //--------------------------------------------------------------------------------
 //	Aggregate FilterSet class
 //--------------------------------------------------------------------------------
@@ -207,33 +848,139 @@ func (self RegexFilter) Passes(data *Linedata) bool {
 */
 type FilterSet struct {
 	filters []BaseFilter
+	rules   []string
 }
 
 /*
 	Helper function to set up the index map and turn string parameters
-	into Filter objects
+	into Filter objects. Compilation details are logged at debug level
+	to the given logger, which may be nil
 */
-func NewFilterSet(params []string) *FilterSet {
+func NewFilterSet(params []string, logger *slog.Logger) (*FilterSet, error) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
 	fs := FilterSet{}
 	fs.filters = make([]BaseFilter, len(params))
+	fs.rules = params
 
+	// every rule is compiled, so that all of those that can't be are
+	// reported at once
+	errs := make([]error, 0)
 	for i, param_string := range params {
-		fs.filters[i] = NewFilter(param_string)
+		f, err := NewFilter(param_string)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fs.filters[i] = f
+
+		switch f := f.(type) {
+		case *Filter:
+			logger.Debug("compiled literal filter", "rule", param_string, "fields", f.fields, "values", f.values)
+		case *RegexFilter:
+			logger.Debug("compiled regex filter", "rule", param_string, "fields", f.fields, "patterns", f.values)
+		case *PredicateFilter:
+			logger.Debug("compiled predicate filter", "rule", param_string, "fields", f.fields, "negate", f.negate)
+		case *CompareFilter:
+			logger.Debug("compiled comparison filter", "rule", param_string, "fields", f.fields, "op", f.op, "value", f.value)
+		case *WordFilter:
+			logger.Debug("compiled word filter", "rule", param_string, "fields", f.fields, "phrases", f.values, "negate", f.negate)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
-	return &fs
+	return &fs, nil
 }
 
 /*
-	Function that creates the indexmap for these filters using the Bro
-	header for a given file
+	Builds a FilterSet directly from filters constructed in Go code, for
+	callers who don't want to go through the string syntax:
+
+		fs := filters.Build(filters.Eq("proto", "tcp"), filters.Not(filters.Eq("id.resp_p", "443")))
+*/
+func Build(filters ...BaseFilter) *FilterSet {
+	return &FilterSet{filters, make([]string, len(filters))}
+}
+
+/*
+	Returns the individual filters in the set
+*/
+func (self FilterSet) Filters() []BaseFilter {
+	return self.filters
+}
+
+/*
+	Returns the rule string each filter in the set was compiled from, or
+	"" for filters that were built in Go code
+*/
+func (self FilterSet) Rules() []string {
+	return self.rules
+}
+
+/*
+	Returns the names of every field referenced by the filters in the set,
+	in order of first appearance
 */
-func (self FilterSet) ApplyHeader(header []string) {
-	indexmap = make(map[string]int)
+func (self FilterSet) Fields() []string {
+	fields := make([]string, 0)
 
-	for idx, field := range header {
-		indexmap[field] = idx
+	for _, f := range self.filters {
+		for _, name := range FieldsOf(f) {
+			if !slices.Contains(fields, name) {
+				fields = append(fields, name)
+			}
+		}
 	}
+
+	return fields
+}
+
+/*
+	Returns the names of the fields a single filter looks at. Filters
+	implemented outside of this package report none
+*/
+func FieldsOf(f BaseFilter) []string {
+	switch f := f.(type) {
+	case *Filter:
+		return f.fields
+	case *RegexFilter:
+		return f.fields
+	case *PredicateFilter:
+		return f.fields
+	case *CompareFilter:
+		return f.fields
+	case *WordFilter:
+		return f.fields
+	case *AnyFilter:
+		return Build(f.filters...).Fields()
+	case *AllFilter:
+		return Build(f.filters...).Fields()
+	case *NotFilter:
+		return FieldsOf(f.filter)
+	}
+
+	return nil
+}
+
+/*
+	Function that creates the indexmap for these filters using the Bro
+	header for a given file. Returns a copy of the set bound to that
+	header; the set itself is left unbound so that it can be applied to
+	any number of logs concurrently
+*/
+func (self FilterSet) ApplyHeader(header []string) *FilterSet {
+	return self.Bind(NewBinding(header))
+}
+
+/*
+	Like ApplyHeader, for logs that declare their own markers or types
+*/
+func (self FilterSet) Bind(binding *Binding) *FilterSet {
+	return &FilterSet{bindAll(self.filters, binding), self.rules}
 }
 
 /*
@@ -249,3 +996,19 @@ func (self FilterSet) Passes(data *Linedata) bool {
 
 	return true
 }
+
+/*
+	Like Passes, adding one to passed for each filter the line passes, so
+	that a caller can count how often each does. The filters are run in
+	order, and a line goes no further than the first it fails
+*/
+func (self FilterSet) Tally(data *Linedata, passed []int64) bool {
+	for i, f := range self.filters {
+		if !f.Passes(data) {
+			return false
+		}
+		passed[i]++
+	}
+
+	return true
+}
@@ -0,0 +1,68 @@
+package filters
+
+import (
+	"errors"
+	"testing"
+)
+
+/*
+	Values may start with any character an operator does, other than
+	the = or ~ of a mistyped == or =~
+*/
+func TestNewFilterValues(t *testing.T) {
+	binding := NewBinding([]string{"uri", "history", "msg"})
+
+	tests := []struct {
+		rule  string
+		line  Linedata
+		match bool
+	}{
+		{"uri=%2e%2e", Linedata{"%2e%2e", "-", "-"}, true},
+		{"uri=%2e%2e", Linedata{"/index.html", "-", "-"}, false},
+		{"uri!=%2e%2e", Linedata{"/index.html", "-", "-"}, true},
+		{"history=<x", Linedata{"-", "<x", "-"}, true},
+		{"history=>x", Linedata{"-", ">x", "-"}, true},
+		{"history=|x", Linedata{"-", "|x", "-"}, true},
+		{"history=!x", Linedata{"-", "!x", "-"}, true},
+		{"history!=<x", Linedata{"-", "ShADad", "-"}, true},
+		{"msg%%2e", Linedata{"-", "-", "saw %2e here"}, true},
+	}
+
+	for _, test := range tests {
+		f, err := NewFilter(test.rule)
+		if err != nil {
+			t.Errorf("NewFilter(%q): %s", test.rule, err)
+			continue
+		}
+		if got := f.Bind(binding).Passes(&test.line); got != test.match {
+			t.Errorf("NewFilter(%q) passes %q = %v, want %v", test.rule, test.line, got, test.match)
+		}
+	}
+}
+
+/*
+	Mistyped operators are reported at the character after the operator
+*/
+func TestNewFilterTypos(t *testing.T) {
+	tests := []struct {
+		rule string
+		pos  int
+	}{
+		{"uri==/index.html", 4},
+		{"uri=~index", 4},
+		{"uri!==/index.html", 5},
+		{"uri!=~index", 5},
+	}
+
+	for _, test := range tests {
+		_, err := NewFilter(test.rule)
+		var rule_err *RuleError
+		if !errors.As(err, &rule_err) {
+			t.Errorf("NewFilter(%q) = %v, want a RuleError", test.rule, err)
+			continue
+		}
+		if rule_err.Pos != test.pos {
+			t.Errorf("NewFilter(%q) error at %d, want %d", test.rule, rule_err.Pos, test.pos)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package filters
+
+import (
+	"regexp"
+)
+
+//--------------------------------------------------------------------------------
+//	Matching many literals at once
+//--------------------------------------------------------------------------------
+
+/*
+	Fewest plain text patterns in a regex rule worth building an automaton
+	for. Below this, looking for each as a substring is as fast
+*/
+const automatonMin int = 8
+
+/*
+	Largest transition table an automaton may have, in cells, beyond which
+	its patterns are looked for one by one instead (64MB)
+*/
+const automatonMaxCells int = 16 << 20
+
+/*
+	Aho-Corasick automaton finding whether any of a set of strings occurs
+	in a value, in a single pass over it however many strings there are,
+	as a rule with a long list of indicators (query~evil.com,bad.net,...)
+	would otherwise look for each of them in turn.
+
+	Its transitions are a dense table, a row for each state and a column
+	for each class of bytes: one for each byte found in the patterns, and
+	class 0 for every other, which always leads back to the start
+*/
+type automaton struct {
+	classes [256]int32
+	width   int
+	next    []int32
+	accepts []bool
+}
+
+/*
+	Builds an automaton for the patterns of a regex rule that are plain
+	text, returning it with the others, which still go through the regex
+	engine. Returns nil for the automaton if there are too few of them to
+	be worth it, or too many to fit
+*/
+func newAutomaton(res []*regexp.Regexp) (*automaton, []*regexp.Regexp) {
+	literals := make([]string, 0, len(res))
+	others := make([]*regexp.Regexp, 0)
+	for _, re := range res {
+		if literal, complete := re.LiteralPrefix(); complete {
+			literals = append(literals, literal)
+		} else {
+			others = append(others, re)
+		}
+	}
+	if len(literals) < automatonMin {
+		return nil, res
+	}
+
+	self := &automaton{width: 1}
+	states := 1
+	for _, literal := range literals {
+		for i := 0; i < len(literal); i++ {
+			if self.classes[literal[i]] == 0 {
+				self.classes[literal[i]] = int32(self.width)
+				self.width++
+			}
+		}
+		states += len(literal)
+	}
+	// states is only a bound, as patterns share their prefixes
+	if states*self.width > automatonMaxCells {
+		return nil, res
+	}
+
+	// the trie of the patterns, in which 0 is no edge, since none leads
+	// back to the start
+	self.next = make([]int32, self.width, states*self.width)
+	self.accepts = make([]bool, 1, states)
+	for _, literal := range literals {
+		state := 0
+		for i := 0; i < len(literal); i++ {
+			cell := state*self.width + int(self.classes[literal[i]])
+			if self.next[cell] == 0 {
+				self.next[cell] = int32(len(self.accepts))
+				self.next = append(self.next, make([]int32, self.width)...)
+				self.accepts = append(self.accepts, false)
+			}
+			state = int(self.next[cell])
+		}
+		self.accepts[state] = true
+	}
+
+	// breadth first, each state's missing edges are those of the longest
+	// suffix of it that is also a state, which is shallower and so done
+	// already, and it accepts if that suffix does
+	fail := make([]int32, len(self.accepts))
+	queue := make([]int32, 0, len(self.accepts))
+	for c := range self.width {
+		if child := self.next[c]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := int(queue[0])
+		queue = queue[1:]
+		suffix := int(fail[state])
+		self.accepts[state] = self.accepts[state] || self.accepts[suffix]
+
+		for c := range self.width {
+			cell := state*self.width + c
+			if child := self.next[cell]; child != 0 {
+				fail[child] = self.next[suffix*self.width+c]
+				queue = append(queue, child)
+			} else {
+				self.next[cell] = self.next[suffix*self.width+c]
+			}
+		}
+	}
+
+	return self, others
+}
+
+/*
+	Returns whether any of the patterns occurs in the value
+*/
+func (self *automaton) contains(value string) bool {
+	// an empty pattern is found in anything
+	if self.accepts[0] {
+		return true
+	}
+
+	state := 0
+	for i := 0; i < len(value); i++ {
+		state = int(self.next[state*self.width+int(self.classes[value[i]])])
+		if self.accepts[state] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,249 @@
+package filters
+
+import (
+	"bro-awk/schema"
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	Typed comparisons
+//--------------------------------------------------------------------------------
+
+/*
+	Filter struct that represents a rule ordering a field against a value,
+	e.g. orig_bytes>1000000 or ts>=2024-05-01T12:00:00. Whether values are
+	compared as numbers, times, addresses, ranks or strings depends on the
+	type of the field, so the comparison is only settled when the filter is bound
+*/
+type CompareFilter struct {
+	fields     []string
+	op         string
+	value      string
+	binding    *Binding
+	indices    []int
+	compares   []func(a string) bool
+	containers []bool
+}
+
+func newCompareFilter(rule string, fields []string, op string, value string) (BaseFilter, error) {
+	if comma := strings.Index(value, ","); comma >= 0 {
+		return nil, &RuleError{rule, "rule compares against more than one value", nil, len(rule) - len(value) + comma}
+	}
+	if extra := strings.IndexAny(value, "<>="); extra >= 0 {
+		return nil, &RuleError{rule, "rule contains too many boolean operators", nil, len(rule) - len(value) + extra}
+	}
+
+	return BaseFilter(&CompareFilter{fields: fields, op: op, value: value}), nil
+}
+
+/*
+	Passes if any of the fields compares as asked. For a set or vector
+	field, any one of its elements has to
+*/
+func (self CompareFilter) Passes(data *Linedata) bool {
+	for i, idx := range self.indices {
+		value := data.at(self.binding, idx)
+		if value == self.binding.UnsetField || value == self.binding.EmptyField {
+			continue
+		}
+
+		if !self.containers[i] {
+			if self.compares[i](value) {
+				return true
+			}
+			continue
+		}
+		for _, element := range self.binding.Elements(value) {
+			if self.compares[i](element) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (self CompareFilter) Bind(binding *Binding) BaseFilter {
+	self.binding = binding
+	self.indices = indicesOf(binding, self.fields)
+	self.containers = containersOf(binding, self.fields)
+	self.compares = make([]func(a string) bool, len(self.fields))
+	for i, field := range self.fields {
+		self.compares[i] = self.compile(binding.ElementType(field))
+	}
+
+	return &self
+}
+
+/*
+	Builds the comparison for a field of the given Zeek type. Fields of
+	unknown type, e.g. those of a JSON log with no schema, are compared
+	however the value in the rule reads
+*/
+func (self CompareFilter) compile(field_type string) func(a string) bool {
+	if field_type == "" {
+		field_type = guessType(self.value)
+	}
+
+	if names, ok := schema.Levels(field_type); ok {
+		b := slices.Index(names, strings.ToLower(self.value))
+		if b < 0 {
+			return never
+		}
+		return func(a string) bool {
+			n := slices.Index(names, strings.ToLower(a))
+			return n >= 0 && ordered(cmp.Compare(n, b), self.op)
+		}
+	}
+
+	switch field_type {
+	case "count", "int", "double", "interval", "port":
+		b, err := strconv.ParseFloat(self.value, 64)
+		if err != nil {
+			return never
+		}
+		return func(a string) bool {
+			n, err := strconv.ParseFloat(a, 64)
+			return err == nil && ordered(cmp.Compare(n, b), self.op)
+		}
+
+	case "time":
+		b, ok := ParseTime(self.value)
+		if !ok {
+			return never
+		}
+		return func(a string) bool {
+			t, ok := ParseTime(a)
+			return ok && ordered(cmp.Compare(t, b), self.op)
+		}
+
+	case "addr":
+		b, err := netip.ParseAddr(self.value)
+		if err != nil {
+			return never
+		}
+		b = b.Unmap()
+		return func(a string) bool {
+			addr, err := netip.ParseAddr(a)
+			return err == nil && ordered(addr.Unmap().Compare(b), self.op)
+		}
+	}
+
+	return func(a string) bool {
+		return ordered(strings.Compare(a, self.value), self.op)
+	}
+}
+
+/*
+	Says how compile compares a field of the given type, for --explain
+*/
+func (self CompareFilter) method(field_type string) string {
+	guessed := ""
+	if field_type == "" {
+		field_type = guessType(self.value)
+		guessed = ", guessed from the value since the log doesn't give the field's type"
+	}
+
+	if names, ok := schema.Levels(field_type); ok {
+		if !slices.Contains(names, strings.ToLower(self.value)) {
+			return fmt.Sprintf("never matches, since %s isn't one of the levels of %s", self.value, field_type)
+		}
+		return fmt.Sprintf("compared by rank as %s%s", field_type, guessed)
+	}
+
+	switch field_type {
+	case "count", "int", "double", "interval", "port":
+		if _, err := strconv.ParseFloat(self.value, 64); err != nil {
+			return fmt.Sprintf("never matches, since %s isn't a number", self.value)
+		}
+		return fmt.Sprintf("compared as numbers (%s)%s", field_type, guessed)
+	case "time":
+		if _, ok := ParseTime(self.value); !ok {
+			return fmt.Sprintf("never matches, since %s isn't a time", self.value)
+		}
+		return "compared as times" + guessed
+	case "addr":
+		if _, err := netip.ParseAddr(self.value); err != nil {
+			return fmt.Sprintf("never matches, since %s isn't an address", self.value)
+		}
+		return "compared as addresses" + guessed
+	}
+
+	return "compared as strings" + guessed
+}
+
+/*
+	Picks the type a value most likely has, for fields whose type the log
+	doesn't say
+*/
+func guessType(value string) string {
+	if _, err := netip.ParseAddr(value); err == nil {
+		return "addr"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "double"
+	}
+	if _, ok := ParseTime(value); ok {
+		return "time"
+	}
+
+	return "string"
+}
+
+/*
+	Applies the operator to the result of a three-way comparison
+*/
+func ordered(c int, op string) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+func never(a string) bool {
+	return false
+}
+
+/* timezone of the times in rules that don't give an offset */
+var Timezone *time.Location = time.UTC
+
+/* ways a time may be written in a rule, besides Zeek's epoch seconds */
+var time_layouts []string = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+/*
+	Reads a time as seconds since the epoch, the way Zeek writes it, or as
+	a date or date and time, taken in Timezone unless it has an offset
+*/
+func ParseTime(value string) (float64, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, true
+	}
+
+	for _, layout := range time_layouts {
+		if t, err := time.ParseInLocation(layout, value, Timezone); err == nil {
+			return float64(t.UnixNano()) / 1e9, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package filters
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+//--------------------------------------------------------------------------------
+//	Matching words
+//--------------------------------------------------------------------------------
+
+/*
+	Filter struct that represents a rule looking for words in free text,
+	such as the msg, sub and name fields of weird.log and notice.log, e.g.
+	msg%local issuer or name%unmatched. A value is split into words at
+	anything that isn't a letter or a digit, so dns_unmatched_msg and
+	SSL::Invalid_Server_Cert are words too, and a phrase is found if its
+	words come one after the other in it, in any case. Unlike a regex,
+	then, issuer doesn't find issuers, and neither cares how the words are
+	strung together
+*/
+type WordFilter struct {
+	fields  []string
+	values  []string
+	phrases [][]string
+	negate  bool
+	binding *Binding
+	indices []int
+}
+
+func newWordFilter(rule string, fields []string, negate bool, value string) (BaseFilter, error) {
+	f := &WordFilter{fields: fields, negate: negate}
+
+	offset := len(rule) - len(value)
+	for _, phrase := range strings.Split(value, ",") {
+		words := wordsOf(phrase)
+		if len(words) == 0 {
+			return nil, &RuleError{rule, fmt.Sprintf("no words to look for in %q", phrase), nil, offset}
+		}
+		f.values = append(f.values, phrase)
+		f.phrases = append(f.phrases, words)
+		offset += len(phrase) + 1
+	}
+
+	return BaseFilter(f), nil
+}
+
+/*
+	Passes if any of the phrases is found in any of the fields. A negated
+	filter passes only if none of them is found in any. Unset and empty
+	fields have no words
+*/
+func (self WordFilter) Passes(data *Linedata) bool {
+	for _, idx := range self.indices {
+		value := data.at(self.binding, idx)
+		if value == self.binding.UnsetField || value == self.binding.EmptyField {
+			continue
+		}
+
+		words := wordsOf(value)
+		for _, phrase := range self.phrases {
+			if hasPhrase(words, phrase) {
+				return !self.negate
+			}
+		}
+	}
+
+	return self.negate
+}
+
+func (self WordFilter) Bind(binding *Binding) BaseFilter {
+	self.binding = binding
+	self.indices = indicesOf(binding, self.fields)
+	return &self
+}
+
+/*
+	Builds a filter that passes if the field has any of the phrases in it
+	as whole words, in any case, the same as the rule
+	<FIELD>%<WORDS>,<WORDS>... A phrase with no words in it is found in
+	anything
+*/
+func Words(field string, phrases ...string) BaseFilter {
+	f := &WordFilter{fields: []string{field}, values: phrases}
+	for _, phrase := range phrases {
+		f.phrases = append(f.phrases, wordsOf(phrase))
+	}
+
+	return f
+}
+
+/*
+	Builds a filter that passes if the field has none of the phrases in it
+*/
+func NotWords(field string, phrases ...string) BaseFilter {
+	return Not(Words(field, phrases...))
+}
+
+/* splits text into its words, the runs of letters and digits in it */
+func wordsOf(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+/* reports whether the words of a phrase come one after the other among words */
+func hasPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.EqualFunc(words[i:i+len(phrase)], phrase, strings.EqualFold) {
+			return true
+		}
+	}
+
+	return false
+}
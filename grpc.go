@@ -0,0 +1,279 @@
+/*
+	Description:
+		Speaks just enough gRPC for `bro-awk serve --grpc`: the Query RPC of
+		the BroAwk service set out in bro-awk.proto, over HTTP/2 without TLS.
+		Its two messages are encoded and decoded here by hand, as the
+		protocol buffers wire format is simple enough not to need generated
+		code for so few fields
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/* the path of the Query RPC, as a client generated from bro-awk.proto calls it */
+const grpc_query_path string = "/broawk.BroAwk/Query"
+
+/* largest request a client may send */
+const grpc_max_request int = 4 << 20
+
+/* status codes of gRPC, those the server answers with */
+const (
+	grpc_ok                = 0
+	grpc_cancelled         = 1
+	grpc_invalid_argument  = 3
+	grpc_deadline_exceeded = 4
+	grpc_not_found         = 5
+	grpc_permission_denied = 7
+	grpc_unimplemented     = 12
+	grpc_internal          = 13
+)
+
+/*
+	Answers the Query RPC, streaming each match back as a Record message
+	until the query is done, its limit is reached, or the client hangs up
+	or runs out of time
+*/
+func (self *query_server) grpc_query(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "bro-awk serves gRPC over HTTP/2 here", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.URL.Path != grpc_query_path {
+		grpc_status(w, grpc_unimplemented, fmt.Sprintf("no method %s, only %s", r.URL.Path, grpc_query_path))
+		return
+	}
+
+	ctx := r.Context()
+	if timeout, ok := grpc_timeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	message, err := grpc_read(r.Body)
+	if err != nil {
+		grpc_status(w, grpc_invalid_argument, err.Error())
+		return
+	}
+	req, err := decode_query(message)
+	if err != nil {
+		grpc_status(w, grpc_invalid_argument, err.Error())
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	sent := 0
+	err = self.run(ctx, req, func(record query_record) error {
+		if _, err := w.Write(grpc_frame(encode_record(record))); err != nil {
+			return err
+		}
+		sent++
+		return controller.Flush()
+	})
+
+	var failed *query_error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		grpc_status(w, grpc_deadline_exceeded, fmt.Sprintf("ran out of time after %d records", sent))
+	case ctx.Err() != nil:
+		grpc_status(w, grpc_cancelled, "the client hung up")
+	case errors.As(err, &failed):
+		grpc_status(w, failed.grpc, failed.message)
+	case err != nil:
+		grpc_status(w, grpc_internal, err.Error())
+	default:
+		grpc_status(w, grpc_ok, "")
+	}
+}
+
+/*
+	Sets the status of the call in the trailers, with its message percent
+	encoded as gRPC has it
+*/
+func grpc_status(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", strings.ReplaceAll(url.PathEscape(message), "+", "%2B"))
+	}
+}
+
+/*
+	Reads the one message of a unary or server-streaming request: a flag
+	saying whether it is compressed, which the server doesn't support,
+	and its length, followed by the message itself
+*/
+func grpc_read(body io.Reader) ([]byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(body, prefix); err != nil {
+		return nil, fmt.Errorf("no request message: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed requests aren't supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > int64(grpc_max_request) {
+		return nil, fmt.Errorf("request of %d bytes is larger than the %d allowed", length, grpc_max_request)
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, fmt.Errorf("request message cut short: %w", err)
+	}
+
+	return message, nil
+}
+
+/* prefixes a message with its flag and length, to be sent */
+func grpc_frame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+/*
+	Reads the deadline a client gives in its grpc-timeout header: a count
+	of at most 8 digits followed by a unit, H, M, S, m(illiseconds), u(micro)
+	or n(ano)
+*/
+func grpc_timeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	count, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || count < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	return time.Duration(count) * unit, true
+}
+
+//--------------------------------------------------------------------------------
+//	PROTOCOL BUFFERS
+//--------------------------------------------------------------------------------
+
+/* wire types of the fields of a message */
+const (
+	proto_varint  = 0
+	proto_fixed64 = 1
+	proto_bytes   = 2
+	proto_fixed32 = 5
+)
+
+/*
+	Decodes a QueryRequest message. Fields it doesn't know of are passed
+	over, so that older servers can take requests from newer clients
+*/
+func decode_query(message []byte) (query_request, error) {
+	req := query_request{}
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return req, errors.New("malformed request message")
+		}
+		message = message[n:]
+
+		field, wire := tag>>3, tag&7
+		var value []byte
+		var number uint64
+		switch wire {
+		case proto_varint:
+			number, n = binary.Uvarint(message)
+			if n <= 0 {
+				return req, errors.New("malformed request message")
+			}
+			message = message[n:]
+		case proto_bytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return req, errors.New("malformed request message")
+			}
+			value, message = message[n:n+int(length)], message[n+int(length):]
+		case proto_fixed64, proto_fixed32:
+			size := 8
+			if wire == proto_fixed32 {
+				size = 4
+			}
+			if len(message) < size {
+				return req, errors.New("malformed request message")
+			}
+			message = message[size:]
+			continue
+		default:
+			return req, fmt.Errorf("malformed request message, with a field of wire type %d", wire)
+		}
+
+		if wire == proto_bytes && !utf8.Valid(value) {
+			return req, fmt.Errorf("field %d of the request isn't valid UTF-8", field)
+		}
+		switch {
+		case field == 1 && wire == proto_bytes:
+			req.Logs = append(req.Logs, string(value))
+		case field == 2 && wire == proto_bytes:
+			req.Filters = append(req.Filters, string(value))
+		case field == 3 && wire == proto_bytes:
+			req.Fields = append(req.Fields, string(value))
+		case field == 4 && wire == proto_bytes:
+			req.LogTypes = append(req.LogTypes, string(value))
+		case field == 5 && wire == proto_bytes:
+			req.From = string(value)
+		case field == 6 && wire == proto_bytes:
+			req.To = string(value)
+		case field == 7 && wire == proto_varint:
+			req.Limit = int64(min(number, math.MaxInt64))
+		}
+	}
+
+	return req, nil
+}
+
+/*
+	Encodes a Record message. Strings in protocol buffers must be UTF-8,
+	so any byte of a value that isn't is replaced with U+FFFD
+*/
+func encode_record(record query_record) []byte {
+	message := make([]byte, 0, 64+len(record.Line)*2)
+	message = proto_string(message, 1, record.Log)
+	for _, field := range record.Fields {
+		message = proto_string(message, 2, field)
+	}
+	for _, value := range record.Values {
+		message = proto_string(message, 3, value)
+	}
+	message = proto_string(message, 4, record.Line)
+
+	return message
+}
+
+/*
+	Appends a string field. A repeated field has one of these for each of
+	its values, empty ones included, so that values line up with fields
+*/
+func proto_string(message []byte, field int, value string) []byte {
+	message = binary.AppendUvarint(message, uint64(field)<<3|proto_bytes)
+	value = strings.ToValidUTF8(value, "�")
+	message = binary.AppendUvarint(message, uint64(len(value)))
+	return append(message, value...)
+}
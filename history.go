@@ -0,0 +1,258 @@
+/*
+	Description:
+		Keeps a history of the queries run, since most hunts are small
+		variations on earlier ones. `bro-awk history` lists them, and
+		`bro-awk !N` runs the Nth again, with any arguments given after
+		it added on:
+
+			bro-awk history
+			bro-awk '!12' /logs/2024-05-02
+			bro-awk '!!' id.resp_p=443
+
+		(quoted, since interactive shells expand ! themselves)
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* queries kept in the history, the oldest being dropped first */
+const history_size int = 1000
+
+/*
+	A query run, as the arguments it was run with
+*/
+type history_entry struct {
+	Time time.Time `json:"time"`
+	Args []string  `json:"args"`
+}
+
+/*
+	Returns the path of the history file, which is BRO_AWK_HISTORY if
+	set, or else kept in the XDG state directory. Returns "" if there is
+	to be no history, with BRO_AWK_HISTORY=off
+*/
+func history_path() string {
+	if path := os.Getenv("BRO_AWK_HISTORY"); path == "off" {
+		return ""
+	} else if path != "" {
+		return path
+	}
+
+	dir := state_dir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "history")
+}
+
+/*
+	Returns the directory bro-awk keeps its state in, under the XDG state
+	directory, or "" if there is no home directory to find it in
+*/
+func state_dir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(dir, "bro-awk")
+}
+
+/*
+	Reads the queries in the history, oldest first. Lines that can't be
+	read, e.g. from a write cut short, are passed over
+*/
+func read_history() []history_entry {
+	entries := make([]history_entry, 0)
+	path := history_path()
+	if path == "" {
+		return entries
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return entries
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry history_entry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && len(entry.Args) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+/*
+	Adds a query to the history. Extra --header values are left out,
+	since they tend to be credentials. The history is trimmed to its size
+	once it has grown a tenth past it, rather than on every query. Being
+	unable to keep a history is no reason to stop a query, so failing to
+	write it is only logged
+*/
+func record_history(args []string) error {
+	path := history_path()
+	if path == "" {
+		return nil
+	}
+
+	kept := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--header" || args[i] == "-header" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(args[i], "--header=") || strings.HasPrefix(args[i], "-header=") {
+			continue
+		}
+		kept = append(kept, args[i])
+	}
+
+	line, err := json.Marshal(history_entry{time.Now(), kept})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if entries := read_history(); len(entries) > history_size+history_size/10 {
+		return write_history(path, entries[len(entries)-history_size:])
+	}
+
+	return nil
+}
+
+/*
+	Replaces the history with the given queries, by way of a temporary
+	file so that it is never left half written
+*/
+func write_history(path string, entries []history_entry) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	w := bufio.NewWriter(temp)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			temp.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+/*
+	Lists the queries in the history, numbered for recall with !N. Only
+	the last N are listed if a number is given
+*/
+func history_command(args []string) {
+	entries := read_history()
+
+	first := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || len(args) > 1 {
+			fail(ErrUsage, "Usage: bro-awk history [N], to list the last N queries")
+		}
+		first = max(len(entries)-n, 0)
+	}
+
+	for i, entry := range entries[first:] {
+		fmt.Printf("%5d  %s  bro-awk %s\n", first+i+1, entry.Time.In(timezone).Format("2006-01-02 15:04"), quote_args(entry.Args))
+	}
+}
+
+/*
+	Expands a recalled query, !N for the Nth in the history, !-N for the
+	Nth from last or !! for the last, into the arguments it was run with
+	followed by the rest of the given arguments. Other arguments are
+	returned as they are. The query being run is written to STDERR, as
+	shells do
+*/
+func recall(args []string) []string {
+	if len(args) == 0 || len(args[0]) < 2 || args[0][0] != '!' {
+		return args
+	}
+
+	entries := read_history()
+	n := len(entries)
+	if args[0] != "!!" {
+		var err error
+		n, err = strconv.Atoi(args[0][1:])
+		if err != nil || n == 0 {
+			fail(ErrUsage, fmt.Sprintf("%s isn't a query to recall: use !N, !-N or !!", args[0]))
+		}
+		if n < 0 {
+			n += len(entries) + 1
+		}
+	}
+	if n < 1 || n > len(entries) {
+		fail(ErrUsage, fmt.Sprintf("%s: no such query in the history, which has %d. See `bro-awk history`", args[0], len(entries)))
+	}
+
+	recalled := slices.Concat(entries[n-1].Args, args[1:])
+	fmt.Fprintf(os.Stderr, "bro-awk %s\n", quote_args(recalled))
+	return recalled
+}
+
+/*
+	Writes arguments as they could be typed into a shell, quoting those
+	that need it
+*/
+func quote_args(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;~#") {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,147 @@
+/*
+	Description:
+		Implements `--intel`, which writes the distinct values of a field
+		across every match as a file for Zeek's Intelligence Framework,
+		ready to be loaded with `redef Intel::read_files`, so that what a
+		hunt turns up can be watched for from then on:
+
+			bro-awk 'id.resp_p=4444' --intel id.resp_h conn.log.gz > c2.intel
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+var intel *string = flagset.String("intel", "", "")
+var intel_source *string = flagset.String("intel-source", "bro-awk", "")
+
+/*
+	Intel::Type of the values of fields commonly worth exporting, for
+	those that aren't addresses or subnets
+*/
+var intel_types map[string]string = map[string]string{
+	"query":       "DOMAIN",
+	"host":        "DOMAIN",
+	"server_name": "DOMAIN",
+	"uri":         "URL",
+	"url":         "URL",
+	"user_agent":  "SOFTWARE",
+	"mailfrom":    "EMAIL",
+	"rcptto":      "EMAIL",
+	"from":        "EMAIL",
+	"to":          "EMAIL",
+	"username":    "USER_NAME",
+	"user":        "USER_NAME",
+	"md5":         "FILE_HASH",
+	"sha1":        "FILE_HASH",
+	"sha256":      "FILE_HASH",
+	"filename":    "FILE_NAME",
+}
+
+/*
+	Writes each distinct value of the --intel field among the matches in
+	the logs as a line of an intel file. The indicator type is the one
+	given as --intel FIELD:TYPE, or else worked out from the field
+*/
+func export_intel(q *qreader.Qreader, logs []string) {
+	field, given_type, _ := strings.Cut(*intel, ":")
+	if given_type != "" && !strings.HasPrefix(given_type, "Intel::") {
+		given_type = "Intel::" + strings.ToUpper(given_type)
+	}
+
+	// nothing is written until the end (or the buffer fills), so that a
+	// bad field doesn't leave half a file behind
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, "#fields\tindicator\tindicator_type\tmeta.source")
+
+	seen := make(map[string]bool)
+	for record, err := range q.Scan(logs...).Seq() {
+		if err != nil {
+			fail_with(err)
+		}
+
+		value, ok := record.Get(field)
+		if !ok {
+			fail(ErrMissingField, fmt.Sprintf("%s has no field named %s", record.Filename, field), "file", record.Filename, "field", field)
+		}
+
+		for _, indicator := range intel_values(record.Filename, field, value) {
+			indicator_type := given_type
+			if indicator_type == "" {
+				indicator_type = intel_type(field, indicator)
+			}
+			if indicator_type == "" {
+				fail(ErrUsage, fmt.Sprintf("no indicator type known for %s, give one as --intel %s:TYPE", field, field), "field", field)
+			}
+
+			// an intel file is TSV like a log, so a tab or newline in an
+			// indicator would break it
+			indicator = qreader.Sanitize(*sanitize, indicator, "")
+
+			// Zeek matches URLs without their scheme
+			if indicator_type == "Intel::URL" {
+				indicator = strings.TrimPrefix(strings.TrimPrefix(indicator, "http://"), "https://")
+			}
+
+			key := indicator + "\t" + indicator_type
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			fmt.Fprintf(w, "%s\t%s\t%s\n", indicator, indicator_type, *intel_source)
+		}
+	}
+}
+
+/*
+	Returns the values to export from a field, splitting sets and
+	vectors into their elements and leaving out missing values
+*/
+func intel_values(log string, field string, value string) []string {
+	values := []string{value}
+	if log_type, ok := schema.Lookup(schema.PathOf(log)); ok {
+		if t, ok := log_type.Type(field); ok && (strings.HasPrefix(t, "set[") || strings.HasPrefix(t, "vector[")) {
+			values = strings.Split(value, ",")
+		}
+	}
+
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" && v != "-" && v != "(empty)" {
+			kept = append(kept, v)
+		}
+	}
+
+	return kept
+}
+
+/*
+	Works out the Intel::Type of an indicator from what it looks like,
+	then from the name of its field. Returns "" if neither says
+*/
+func intel_type(field string, indicator string) string {
+	if _, err := netip.ParseAddr(indicator); err == nil {
+		return "Intel::ADDR"
+	}
+	if _, err := netip.ParsePrefix(indicator); err == nil {
+		return "Intel::SUBNET"
+	}
+
+	name := field[strings.LastIndex(field, ".")+1:]
+	if t, ok := intel_types[field]; ok {
+		return "Intel::" + t
+	} else if t, ok := intel_types[name]; ok {
+		return "Intel::" + t
+	}
+
+	return ""
+}
@@ -0,0 +1,62 @@
+/*
+	Description:
+		Stops a scan cleanly on Ctrl-C (or SIGTERM) rather than dying
+		mid-line with decompressors left running, and says how far it got
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+/* exit status after an interrupt, as shells report one */
+const exit_interrupted int = 130
+
+/*
+	Stops the Qreader on the first interrupt, leaving main to finish up.
+	A second interrupt exits at once
+*/
+func handle_interrupts(q *qreader.Qreader) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		q.Logger.Info("interrupted, stopping")
+		q.Stop()
+
+		<-signals
+		os.Exit(exit_interrupted)
+	}()
+}
+
+/*
+	If the Qreader was interrupted, reports how much was scanned before it
+	stopped and exits. Matches printed by then are complete lines
+*/
+func exit_if_interrupted(q *qreader.Qreader) {
+	if !q.Stopped() {
+		return
+	}
+
+	totals := q.Totals()
+	logs := "logs"
+	if totals.Logs == 1 {
+		logs = "log"
+	}
+	message := fmt.Sprintf("interrupted after scanning %d lines (%d bytes) in %d %s, %d of which matched",
+		totals.Lines, totals.Bytes, totals.Logs, logs, totals.Matches)
+	report_error(ErrInterrupted, message,
+		"logs", strconv.FormatInt(totals.Logs, 10),
+		"lines", strconv.FormatInt(totals.Lines, 10),
+		"bytes", strconv.FormatInt(totals.Bytes, 10),
+		"matches", strconv.FormatInt(totals.Matches, 10))
+
+	os.Exit(exit_interrupted)
+}
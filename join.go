@@ -0,0 +1,185 @@
+/*
+	Description:
+		Implements the `join` subcommand, which pairs up the matches of two
+		sets of logs that have the same value of a field, printing each pair
+		as one record, e.g. each connection with the DNS query it answered:
+
+			bro-awk join --on uid conn.log.gz dns.log.gz
+			bro-awk join --on id.resp_h:host 'method=POST' conn.log.gz http.log.gz
+
+		The smaller set is read into memory first and the other streamed
+		past it, as a hash join
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var join_on *string = flagset.String("on", "", "")
+
+/* how join is used, for its errors */
+const join_usage string = "Usage: bro-awk join --on <FIELD[:FIELD]> [FILTERS...] <LEFT> <RIGHT>"
+
+/*
+	One of the two sets of logs being joined, with the field its matches
+	are joined on and the filters that apply to it
+*/
+type join_side struct {
+	logs  []string
+	key   string
+	rules []string
+}
+
+/*
+	Prints each match of the first (LEFT) set of logs paired with each
+	match of the second (RIGHT) whose --on field has the same value,
+	the LEFT line first and then the RIGHT one, separated by a tab. --on
+	names the field of both, or LEFT's and RIGHT's separated by a colon.
+	Each filter applies to whichever sets have the fields it looks at
+*/
+func join_command(opts []qreader.Option, args []string, presets map[string][]string) {
+	if *join_on == "" {
+		fail(ErrUsage, "join needs --on, the field to join the logs on. "+join_usage)
+	}
+	left_key, right_key, paired := strings.Cut(*join_on, ":")
+	if !paired {
+		right_key = left_key
+	}
+	if left_key == "" || right_key == "" {
+		fail(ErrUsage, fmt.Sprintf("--on takes a field, or two separated by a colon, not %s. %s", *join_on, join_usage))
+	}
+
+	rules, sets := split_args(args, presets)
+	if len(sets) != 2 {
+		fail(ErrUsage, fmt.Sprintf("join pairs up two sets of logs, not %d. %s", len(sets), join_usage))
+	}
+
+	left := &join_side{logs: find_logs(sets[:1], join_usage), key: left_key}
+	right := &join_side{logs: find_logs(sets[1:], join_usage), key: right_key}
+	assign_rules(opts, rules, left, right)
+
+	// the smaller set is the one kept in memory. Logs whose size can't be
+	// told, such as remote ones, are taken to be the larger
+	build, probe := right, left
+	left_size, right_size := size_of(left.logs), size_of(right.logs)
+	if left_size > 0 && (right_size == 0 || left_size < right_size) {
+		build, probe = left, right
+	}
+
+	table := make(map[string][]qreader.Record)
+	for record := range join_scan(opts, build) {
+		value, _ := record.Get(build.key)
+		table[value] = append(table[value], record)
+	}
+
+	for record := range join_scan(opts, probe) {
+		value, _ := record.Get(probe.key)
+		for _, other := range table[value] {
+			if build == left {
+				print_joined(other, record)
+			} else {
+				print_joined(record, other)
+			}
+		}
+	}
+}
+
+/*
+	Hands each filter to the sides whose logs have all of the fields it
+	looks at, going by the first log of each. One that neither has is
+	given to both, to be reported as any filter on a missing field is
+*/
+func assign_rules(opts []qreader.Option, rules []string, sides ...*join_side) {
+	q, err := qreader.NewQreader(nil, opts...)
+	if err != nil {
+		fail_with(err)
+	}
+
+	headers := make([][]string, len(sides))
+	for i, side := range sides {
+		headers[i], err = q.HeaderOf(side.logs[0])
+		if err != nil {
+			fail_with(err, "file", side.logs[0])
+		}
+	}
+
+	for _, rule := range rules {
+		filter, err := filters.NewFilter(rule)
+		if err != nil {
+			fail_with(err)
+		}
+
+		taken := false
+		for i, side := range sides {
+			if !slices.ContainsFunc(filters.FieldsOf(filter), func(field string) bool { return !slices.Contains(headers[i], field) }) {
+				side.rules = append(side.rules, rule)
+				taken = true
+			}
+		}
+		if !taken {
+			for _, side := range sides {
+				side.rules = append(side.rules, rule)
+			}
+		}
+	}
+}
+
+/*
+	Returns the matches of one side that have a value to join on. Unset
+	and empty values are left out, and sets and vectors are taken whole
+*/
+func join_scan(opts []qreader.Option, side *join_side) func(yield func(qreader.Record) bool) {
+	return func(yield func(qreader.Record) bool) {
+		// the key has to be in every log, as a printed field does
+		q, err := qreader.NewQreader(side.rules, slices.Concat(opts, []qreader.Option{qreader.WithFields(side.key)})...)
+		if err != nil {
+			fail_with(err)
+		}
+
+		for record, err := range q.Scan(side.logs...).Seq() {
+			if err != nil {
+				fail_with(err)
+			}
+
+			value, _ := record.Get(side.key)
+			if value == "-" || value == "(empty)" {
+				continue
+			}
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
+
+/*
+	Prints a pair of matches as one record: with --print_fields, the
+	listed fields, each taken from the LEFT match if it has it and the
+	RIGHT one otherwise, or else both lines as they were logged
+*/
+func print_joined(left qreader.Record, right qreader.Record) {
+	if *print_fields == "" {
+		fmt.Printf("%s\t%s\n", qreader.Sanitize(*sanitize, left.Line, "\t"), qreader.Sanitize(*sanitize, right.Line, "\t"))
+		return
+	}
+
+	fields := strings.Split(*print_fields, ",")
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		value, ok := left.Get(field)
+		if !ok {
+			value, ok = right.Get(field)
+		}
+		if !ok {
+			value = "-"
+		}
+		values[i] = qreader.Sanitize(*sanitize, value, "")
+	}
+	fmt.Println(strings.Join(values, "\t"))
+}
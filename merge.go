@@ -0,0 +1,32 @@
+/*
+	Description:
+		Implements `--merge-sort-ts`, which scans the logs all at once and
+		prints their matches as one stream in order of their ts field,
+		rather than log by log, e.g. to read a day's conn, dns and http
+		logs as a single timeline:
+
+			bro-awk --merge-sort-ts 'id.orig_h=10.0.0.5' /logs/2024-05-01
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+)
+
+var merge_sort_ts *bool = flagset.Bool("merge-sort-ts", false, "")
+
+/*
+	Scans the logs together, printing their matches merged in time order.
+	Returns the logs that couldn't be scanned
+*/
+func merge_logs(q *qreader.Qreader, logs []string) []failed_log {
+	failures := make([]failed_log, 0)
+	for i, err := range q.ParseMerged(logs...) {
+		if err != nil {
+			failures = append(failures, log_failed(logs[i], err, false))
+		}
+	}
+
+	return failures
+}
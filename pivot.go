@@ -0,0 +1,151 @@
+/*
+	Description:
+		Implements the `pivot` subcommand, which finds every line in every
+		log that refers to the given connections, so that the whole story
+		of a connection can be read in one place:
+
+			bro-awk pivot --uid CHhAvVGS1DHFjwGM9 /logs/2024-05-01
+
+		Files seen on the connections are followed too: their fuids are
+		taken from files.log, and lines referring to those are included
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"bro-awk/remote"
+	"bro-awk/schema"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var pivot_uids *string = flagset.String("uid", "", "")
+
+/* fields that hold connection uids, in the logs that have them */
+var uid_fields []string = []string{"uid", "conn_uids"}
+
+/* fields that hold file ids */
+var fuid_fields []string = []string{"fuid", "orig_fuids", "resp_fuids"}
+
+/*
+	Prints each line of the logs that refers to the --uid connections or
+	files, prefixed with the log it came from, as grep does
+*/
+func pivot_command(opts []qreader.Option, args []string) {
+	if *pivot_uids == "" {
+		fail(ErrUsage, "pivot needs --uid, e.g. bro-awk pivot --uid CHhAvVGS1DHFjwGM9 /logs/2024-05-01")
+	}
+
+	// uids may be listed in a file, one per line. Zeek's file ids start
+	// with F where connection uids start with C
+	listed := strings.Split(*pivot_uids, ",")
+	if info, err := os.Stat(*pivot_uids); err == nil && info.Mode().IsRegular() {
+		listed = read_list(*pivot_uids)
+	}
+	uids := make([]string, 0)
+	fuids := make([]string, 0)
+	for _, id := range listed {
+		id = strings.TrimSpace(id)
+		if strings.HasPrefix(id, "F") {
+			fuids = append(fuids, id)
+		} else if id != "" {
+			uids = append(uids, id)
+		}
+	}
+
+	logs := find_logs(args, "Usage: bro-awk pivot --uid <UIDS> [LOGS...]")
+
+	// find the files seen on the connections first, so that lines about
+	// them can be picked up along with the rest
+	for _, log := range logs {
+		if schema.PathOf(log) != "files" {
+			continue
+		}
+		for record := range pivot_scan(opts, log, uids, nil) {
+			if fuid, ok := record.Get("fuid"); ok && !slices.Contains(fuids, fuid) {
+				fuids = append(fuids, fuid)
+			}
+		}
+	}
+
+	for _, log := range logs {
+		for record := range pivot_scan(opts, log, uids, fuids) {
+			fmt.Printf("%s:%s\n", record.Filename, qreader.Sanitize(*sanitize, record.Line, "\t"))
+		}
+	}
+}
+
+/*
+	Expands the arguments of a subcommand into the logs to search, which
+	are usually a directory of a day's logs. Usage is how the subcommand
+	is used, for its errors
+*/
+func find_logs(args []string, usage string) []string {
+	logs := make([]string, 0)
+
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			logs = append(logs, walk_dir(arg)...)
+		} else if strings.ContainsAny(arg, "*?[") {
+			logs = append(logs, expand_glob(arg)...)
+		} else if remote.IsRemote(arg) || log_re.MatchString(arg) {
+			logs = append(logs, arg)
+		} else {
+			fail(ErrUsage, fmt.Sprintf("%s is not a log or directory. %s", arg, usage))
+		}
+	}
+
+	if len(logs) == 0 {
+		fail(ErrUsage, "No logs specified. "+usage)
+	}
+
+	return logs
+}
+
+/*
+	Returns the lines of the log whose uid fields hold any of the uids or
+	whose fuid fields hold any of the fuids. Logs with neither kind of
+	field have nothing to give
+*/
+func pivot_scan(opts []qreader.Option, log string, uids []string, fuids []string) func(yield func(qreader.Record) bool) {
+	return func(yield func(qreader.Record) bool) {
+		q, err := qreader.NewQreader(nil, opts...)
+		if err != nil {
+			fail_with(err)
+		}
+
+		header, err := q.HeaderOf(log)
+		if err != nil {
+			fail_with(err, "file", log)
+		}
+
+		rules := make([]filters.BaseFilter, 0)
+		for _, field := range uid_fields {
+			if len(uids) > 0 && slices.Contains(header, field) {
+				rules = append(rules, filters.Eq(field, uids...))
+			}
+		}
+		for _, field := range fuid_fields {
+			if len(fuids) > 0 && slices.Contains(header, field) {
+				rules = append(rules, filters.Eq(field, fuids...))
+			}
+		}
+		if len(rules) == 0 {
+			return
+		}
+		q.Filter = filters.Build(filters.Or(rules...))
+
+		for record, err := range q.Scan(log).Seq() {
+			if err != nil {
+				fail_with(err, "file", log)
+			}
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+	Description:
+		Implements `--preview N`, which scans only until the first N
+		matches, shows them along with how big the whole scan is and how
+		long it should take, and asks before going on to sweep the lot.
+		Without a terminal to ask on, it stops there with a summary
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var preview *int = flagset.Int("preview", 0, "")
+
+/* shortest preview that the rest of the scan is estimated from */
+const preview_timed time.Duration = time.Second
+
+/*
+	Keeps the matches of the preview, writing each to STDERR, and stops
+	its Qreader once it has as many as were asked for
+*/
+type preview_writer struct {
+	q     *qreader.Qreader
+	want  int
+	lock  sync.Mutex
+	lines [][]byte
+}
+
+func (self *preview_writer) Write(p []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// the Qreader writes its matches many at a time, a line each, and
+	// those the parsers had in hand when the scan was stopped are dropped
+	shown := 0
+	for shown < len(p) && len(self.lines) < self.want {
+		end := len(p)
+		if i := bytes.IndexByte(p[shown:], '\n'); i >= 0 {
+			end = shown + i + 1
+		}
+		self.lines = append(self.lines, slices.Clone(p[shown:end]))
+		shown = end
+	}
+	if len(self.lines) == self.want {
+		self.q.Stop()
+	}
+
+	if _, err := os.Stderr.Write(p[:shown]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+/*
+	Scans the logs until the first N matches, which are written to STDERR
+	so that STDOUT gets the full scan alone, then estimates the rest of the
+	scan from how far the preview got and asks whether to go on with it.
+	Returns only if it is to be gone on with. A preview that got through
+	every log has found every match, so it writes them to STDOUT as well
+	(unless that is the terminal they were shown on) and exits
+*/
+func run_preview(filters []string, opts []qreader.Option, logs []string) {
+	w := &preview_writer{want: *preview}
+	q, err := qreader.NewQreader(filters, slices.Concat(opts, []qreader.Option{qreader.WithWriter(w), qreader.WithProgress(true)})...)
+	if err != nil {
+		fail_with(err)
+	}
+	w.q = q
+	handle_interrupts(q)
+
+	start := time.Now()
+	scanned := 0
+	failures := make([]failed_log, 0)
+	for i, log := range logs {
+		if q.Stopped() {
+			break
+		}
+		if err := q.Parse(log); err != nil && !q.Stopped() {
+			failures = append(failures, log_failed(log, err, i < len(logs)-1))
+		}
+		scanned++
+	}
+	elapsed := time.Since(start)
+
+	// the preview stops its Qreader itself once it has found enough, so
+	// it was only interrupted if it hadn't
+	found := len(w.lines)
+	if found < w.want {
+		exit_if_interrupted(q)
+
+		fmt.Fprintf(os.Stderr, "preview: scanned every log, finding %d matches\n", found)
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+			for _, line := range w.lines {
+				os.Stdout.Write(line)
+			}
+		}
+		if len(failures) > 0 {
+			fail_logs(failures, len(logs))
+		}
+		os.Exit(0)
+	}
+
+	fmt.Fprintf(os.Stderr, "preview: %s\n", describe_preview(found, q.Totals().Read, size_of(logs), elapsed, scanned, len(logs)))
+	if !confirm("scan every log?") {
+		fmt.Fprintln(os.Stderr, "preview: stopping here, run again without --preview to scan every log")
+		os.Exit(0)
+	}
+}
+
+/*
+	Describes the scan as the preview saw it: how much it read of how
+	much there is, and how long reading it all should take at the rate
+	the preview read. A preview over in less than preview_timed reads
+	little more than what the first reads buffer, which says nothing of
+	the rate, so isn't used to estimate one
+*/
+func describe_preview(found int, read int64, total int64, elapsed time.Duration, scanned int, logs int) string {
+	preview := fmt.Sprintf("first %d matches found in %s, in %d of %d logs", found, elapsed.Round(time.Millisecond), scanned, logs)
+	if total <= 0 {
+		return fmt.Sprintf("%s, reading %s. The size of the logs isn't known", preview, format_bytes(read))
+	}
+
+	preview = fmt.Sprintf("%s, reading %s of %s (%d%%)", preview, format_bytes(read), format_bytes(total), percent(read, total))
+	if elapsed < preview_timed {
+		return preview + ". The preview was too quick to tell how long the rest will take"
+	}
+
+	return fmt.Sprintf("%s. The rest should take about %s", preview, eta(read, total, rate(read, elapsed)))
+}
+
+/*
+	Asks a yes or no question on the terminal, which STDIN and STDERR may
+	not be. With no terminal to ask on, the answer is no
+*/
+func confirm(question string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,174 @@
+/*
+	Description:
+		Shows how far a scan has got on STDERR with --progress: how much
+		of the logs has been read against their size, how fast, and how
+		long the rest should take, overall and for each log being
+		scanned. Compressed logs are measured by how much of the archive
+		has been read, so that the estimate holds whatever they
+		decompress to
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var show_progress *bool = flagset.Bool("progress", false, "")
+
+/* how often progress is redrawn on a terminal, and written out otherwise */
+const progress_redraw time.Duration = 500 * time.Millisecond
+const progress_interval time.Duration = 10 * time.Second
+
+/*
+	Starts reporting the progress of the scan of the logs, until the
+	returned function is called, which reports how it went in the end.
+	The logs can only be estimated as a whole if each of their sizes is
+	known, which those on STDIN or elsewhere on the network aren't
+*/
+func report_progress(q *qreader.Qreader, logs []string) func() {
+	if !*show_progress {
+		return func() {}
+	}
+
+	total := size_of(logs)
+
+	// a terminal gets a line that is redrawn in place, anything else a
+	// line every so often
+	info, err := os.Stderr.Stat()
+	terminal := err == nil && info.Mode()&os.ModeCharDevice != 0
+	interval := progress_interval
+	if terminal {
+		interval = progress_redraw
+	}
+
+	start := time.Now()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			line := describe_progress(q.Totals().Read, total, time.Since(start), q.Progress())
+			if terminal {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+			} else {
+				fmt.Fprintln(os.Stderr, line)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+
+		elapsed := time.Since(start)
+		read := q.Totals().Read
+		if terminal {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+		fmt.Fprintf(os.Stderr, "read %s in %s, %s/s\n", format_bytes(read), elapsed.Round(time.Second), format_bytes(rate(read, elapsed)))
+	}
+}
+
+/*
+	Returns the size of all the logs together, as they are stored, or 0
+	if the size of any of them isn't known
+*/
+func size_of(logs []string) int64 {
+	var total int64
+	for _, log := range logs {
+		info, err := os.Stat(log)
+		if log == qreader.Stdin || err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		total += info.Size()
+	}
+
+	return total
+}
+
+/*
+	Describes how far the scan has got overall, having read so much of the
+	total in the time elapsed, followed by each of the logs being scanned
+*/
+func describe_progress(read int64, total int64, elapsed time.Duration, logs []qreader.LogProgress) string {
+	per_second := rate(read, elapsed)
+	parts := []string{}
+	if total > 0 {
+		parts = append(parts, fmt.Sprintf("%s of %s (%d%%), %s/s, ETA %s", format_bytes(read), format_bytes(total),
+			percent(read, total), format_bytes(per_second), eta(read, total, per_second)))
+	} else {
+		parts = append(parts, fmt.Sprintf("%s, %s/s", format_bytes(read), format_bytes(per_second)))
+	}
+
+	for _, log := range logs {
+		name := filepath.Base(log.File)
+		if log.Size > 0 {
+			log_rate := rate(log.Read, time.Since(log.Started))
+			parts = append(parts, fmt.Sprintf("%s %d%% of %s, ETA %s", name, percent(log.Read, log.Size),
+				format_bytes(log.Size), eta(log.Read, log.Size, log_rate)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %s", name, format_bytes(log.Read)))
+		}
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+/* bytes read per second */
+func rate(read int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return int64(float64(read) / elapsed.Seconds())
+}
+
+/* how much of the total has been read, which may pass 100 for a log that grew */
+func percent(read int64, total int64) int64 {
+	return read * 100 / total
+}
+
+/*
+	How long the rest of the total should take to read at the given rate
+*/
+func eta(read int64, total int64, per_second int64) string {
+	if per_second <= 0 {
+		return "unknown"
+	}
+
+	left := time.Duration(float64(max(total-read, 0)) / float64(per_second) * float64(time.Second))
+	return left.Round(time.Second).String()
+}
+
+/*
+	Writes a number of bytes the way people read them, e.g. 1.5 GB
+*/
+func format_bytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
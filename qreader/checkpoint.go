@@ -0,0 +1,126 @@
+package qreader
+
+import (
+	"bro-awk/remote"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	CHECKPOINTS
+//--------------------------------------------------------------------------------
+
+/*
+	How far the scan of a log has got, such that every match before it
+	has been written out and the scan can be taken up again from there:
+	the bytes of an uncompressed log, or the members of a tar archive,
+	scanned in full. A compressed log is taken up at the start of one of
+	its gzip members, so has both: the members scanned in full, and the
+	byte of the compressed log the next starts at
+*/
+type Checkpoint struct {
+	Offset  int64
+	Members int
+}
+
+/*
+	Works out how far through an uncompressed log its matches have all
+	been written out. The parsers finish its chunks in any order, so this
+	is the end of the last chunk that every chunk before has been parsed
+	up to, as the reader numbered them
+*/
+type chunkOffsets struct {
+	lock   sync.Mutex
+	ends   []int64
+	parsed []bool
+	first  int
+	report func(offset int64)
+}
+
+/*
+	Notes where the next chunk ends, before it is handed to the parsers.
+	A chunk that can't be resumed after, as at the start of another log
+	concatenated onto this one, ends at -1
+*/
+func (self *chunkOffsets) read(end int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.ends = append(self.ends, end)
+	self.parsed = append(self.parsed, false)
+}
+
+/*
+	Notes that the chunk with this number, counting from 0 in the order
+	they were read, has been parsed, reporting how far the log has now
+	been written out up to if that has moved on
+*/
+func (self *chunkOffsets) done(chunk int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.parsed[chunk-self.first] = true
+	offset := int64(-1)
+	for len(self.parsed) > 0 && self.parsed[0] {
+		if self.ends[0] >= 0 {
+			offset = self.ends[0]
+		}
+		self.ends, self.parsed = self.ends[1:], self.parsed[1:]
+		self.first++
+	}
+
+	if offset >= 0 {
+		self.report(offset)
+	}
+}
+
+/*
+	The ends of the gzip members of a compressed log decompressed so far,
+	by how far into the decompressed log each ends, so that it can be
+	checkpointed at the last member whose matches have all been written
+	out. Only members that end a line are noted, since the next has to
+	start one for the log to be taken up there
+*/
+type gzipMembers struct {
+	lock sync.Mutex
+	ends []int64
+	at   []Checkpoint
+}
+
+/*
+	Notes that a member ended this far into the decompressed log, after
+	which the log can be taken up again from the given checkpoint
+*/
+func (self *gzipMembers) ended(end int64, at Checkpoint) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.ends = append(self.ends, end)
+	self.at = append(self.at, at)
+}
+
+/*
+	Returns the checkpoint of the last member to end by this far into the
+	decompressed log, if one has since the last call
+*/
+func (self *gzipMembers) before(offset int64) (Checkpoint, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var at Checkpoint
+	found := false
+	for len(self.ends) > 0 && self.ends[0] <= offset {
+		at, found = self.at[0], true
+		self.ends, self.at = self.ends[1:], self.at[1:]
+	}
+
+	return at, found
+}
+
+/*
+	Reports whether a log can be taken up partway through: only one read
+	straight from a local file, and neither followed nor checked strictly,
+	since that numbers its lines from the start
+*/
+func (self *Qreader) resumable(file *fileScan, stream bool) bool {
+	return !stream && file.source == nil && !remote.IsRemote(file.filename) && !self.Follow && !self.Strict
+}
@@ -0,0 +1,60 @@
+package qreader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+/*
+	A compressed log is checkpointed at the start of the last gzip member
+	whose matches were all written out, and taken up again from there
+*/
+func TestResumeGzipMembers(t *testing.T) {
+	dir := t.TempDir()
+	plain, err := os.ReadFile(writeLog(t, dir, "conn",
+		[]string{"ts", "uid", "proto"},
+		[]string{"time", "string", "enum"},
+		"1.0\tC1\ttcp\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header and first line, then one line in each of two members
+	var archive bytes.Buffer
+	starts := make([]int64, 0)
+	for _, member := range []string{string(plain), "2.0\tC2\ttcp\n", "3.0\tC3\ttcp\n"} {
+		starts = append(starts, int64(archive.Len()))
+		gz := gzip.NewWriter(&archive)
+		gz.Write([]byte(member))
+		gz.Close()
+	}
+	fn := filepath.Join(dir, "conn.log.gz")
+	if err := os.WriteFile(fn, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var reached []Checkpoint
+	got := parseAll(t, nil, []Option{WithFields("uid"), WithCheckpoints(func(log string, at Checkpoint) {
+		lock.Lock()
+		defer lock.Unlock()
+		reached = append(reached, at)
+	})}, fn)
+	if want := []string{"C1", "C2", "C3"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if want := (Checkpoint{Offset: starts[2], Members: 2}); len(reached) == 0 || reached[len(reached)-1] != want {
+		t.Errorf("checkpoints %v, want the last to be %v", reached, want)
+	}
+
+	// taken up after the first member, only the lines of the others are read
+	got = parseAll(t, nil, []Option{WithFields("uid"), WithResume(map[string]Checkpoint{fn: {Offset: starts[1], Members: 1}})}, fn)
+	if want := []string{"C2", "C3"}; !slices.Equal(got, want) {
+		t.Errorf("resumed, got %q, want %q", got, want)
+	}
+}
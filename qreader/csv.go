@@ -0,0 +1,96 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	CSV LOGS
+//--------------------------------------------------------------------------------
+
+/* names of comma-separated exports, compressed or not */
+var csv_re *regexp.Regexp = regexp.MustCompile(`\.csv(?:\.gz)?$`)
+
+/*
+	Reports whether the log is a comma-separated export, which is known
+	by its name since its header row looks like any other line
+*/
+func isCSV(fn string) bool {
+	return csv_re.MatchString(fn)
+}
+
+/*
+	Reads the header row of a CSV log, whose columns are its fields. A
+	cell left empty is how CSV writes a missing value, so it stands for
+	both the empty and unset markers
+*/
+func parseCSVHeader(reader *bufio.Reader) (*Header, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	fields, err := csvRecord(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header row: %w", err)
+	}
+
+	h := NewHeader(fields)
+	h.Format = FormatCSV
+	h.Separator = ","
+	h.EmptyField = ""
+	h.UnsetField = ""
+	return h, nil
+}
+
+/*
+	Returns a function that splits a CSV line into its fields, honoring
+	quoted cells. Cells can't span lines, since logs are split into
+	lines before they are parsed
+*/
+func csvSplitter() func(line string) filters.Linedata {
+	return func(line string) filters.Linedata {
+		line = strings.TrimSuffix(line, "\r")
+		record, err := csvRecord(line)
+		if err != nil {
+			return strings.Split(line, ",")
+		}
+
+		return record
+	}
+}
+
+/*
+	Parses a single line of CSV
+*/
+func csvRecord(line string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	record, err := r.Read()
+	if err == io.EOF {
+		return []string{}, nil
+	}
+
+	return record, err
+}
+
+/*
+	Joins printed fields back into a line of CSV, quoting those that need it
+*/
+func csvJoin(fields []string) string {
+	var b strings.Builder
+
+	w := csv.NewWriter(&b)
+	w.Write(fields)
+	w.Flush()
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,166 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	DEDUPLICATION
+//--------------------------------------------------------------------------------
+
+/* how far behind the latest record WithDedupe remembers records, unless set */
+const DefaultDedupeWindow time.Duration = time.Hour
+
+/* seconds of ts the records remembered are grouped by, to be forgotten together */
+const dedupe_bucket float64 = 60
+
+/* bytes a record remembered takes up besides its key, for the map holding it */
+const dedupe_overhead int64 = 64
+
+/* the group of records without a ts, which are only forgotten to save memory */
+const dedupe_untimed int64 = math.MinInt64
+
+/*
+	The records WithDedupe has let through, so that the same record met
+	again in another log is dropped. Records are grouped by the minute of
+	their ts, and a minute is forgotten once it falls further behind the
+	latest record than the window, so that following logs or watching a
+	directory doesn't remember every record ever matched. The overlap of
+	rotated copies of a log is well within it. The keys count against the
+	Qreader's memory, and while they take up more than half of it the
+	oldest minutes are forgotten early
+*/
+type dedupeSet struct {
+	lock     sync.Mutex
+	window   float64
+	watchdog *watchdog
+	logger   *slog.Logger
+	latest   float64
+	buckets  map[int64]*dedupeBucket
+	size     int64
+	early    bool
+}
+
+/* the records of a minute, and the bytes they take up */
+type dedupeBucket struct {
+	keys map[string]struct{}
+	size int64
+}
+
+func newDedupeSet(window time.Duration, watchdog *watchdog, logger *slog.Logger) *dedupeSet {
+	return &dedupeSet{
+		window:   window.Seconds(),
+		watchdog: watchdog,
+		logger:   logger,
+		buckets:  make(map[int64]*dedupeBucket),
+	}
+}
+
+/*
+	Returns whether the record with the given key and ts was let through
+	before, remembering it if not. A record from before the window can't
+	be told apart from those forgotten, so it is let through again
+*/
+func (self *dedupeSet) seen(key string, ts float64, timed bool) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	at := dedupe_untimed
+	if timed {
+		if ts > self.latest {
+			self.latest = ts
+			self.forget(int64(math.Floor((ts - self.window) / dedupe_bucket)))
+		}
+		at = int64(math.Floor(ts / dedupe_bucket))
+		if float64(at+1)*dedupe_bucket <= self.latest-self.window {
+			return false
+		}
+	}
+
+	bucket := self.buckets[at]
+	if bucket == nil {
+		bucket = &dedupeBucket{keys: make(map[string]struct{})}
+		self.buckets[at] = bucket
+	} else if _, dupe := bucket.keys[key]; dupe {
+		return true
+	}
+
+	size := int64(len(key)) + dedupe_overhead
+	bucket.keys[key] = struct{}{}
+	bucket.size += size
+	self.size += size
+	self.watchdog.held.Add(size)
+
+	// keep to half the memory, forgetting the oldest minutes first and
+	// those without a ts last
+	for self.size > self.watchdog.limit/2 && len(self.buckets) > 1 {
+		oldest := int64(math.MaxInt64)
+		for other := range self.buckets {
+			if other != at && other != dedupe_untimed {
+				oldest = min(oldest, other)
+			}
+		}
+		if oldest == int64(math.MaxInt64) {
+			oldest = dedupe_untimed
+		}
+		self.drop(oldest)
+
+		if !self.early {
+			self.early = true
+			self.logger.Warn("forgetting records matched to stay within the memory limit, some duplicates may be printed",
+				"max_memory", self.watchdog.limit)
+		}
+	}
+
+	return false
+}
+
+/*
+	Forgets the minutes of records before the given one, other than the
+	records without a ts
+*/
+func (self *dedupeSet) forget(before int64) {
+	for at := range self.buckets {
+		if at != dedupe_untimed && at < before {
+			self.drop(at)
+		}
+	}
+}
+
+func (self *dedupeSet) drop(at int64) {
+	if bucket, ok := self.buckets[at]; ok {
+		delete(self.buckets, at)
+		self.size -= bucket.size
+		self.watchdog.held.Add(-bucket.size)
+	}
+}
+
+/*
+	Returns the function that identifies a record of the given log for
+	WithDedupe, by its log type, ts and uid, along with its ts. Records of
+	logs without those fields are identified by the whole line
+*/
+func dedupeKey(h *Header) func(line string, ld filters.Linedata) (string, float64, bool) {
+	ts := slices.Index(h.Fields, "ts")
+	uid := slices.Index(h.Fields, "uid")
+	path := h.Path
+
+	if ts < 0 || uid < 0 {
+		return func(line string, ld filters.Linedata) (string, float64, bool) {
+			return path + "\x00" + line, 0, false
+		}
+	}
+
+	return func(line string, ld filters.Linedata) (string, float64, bool) {
+		if ts >= len(ld) || uid >= len(ld) {
+			return path + "\x00" + line, 0, false
+		}
+		at, timed := filters.ParseTime(ld[ts])
+		return path + "\x00" + ld[ts] + "\x00" + ld[uid], at, timed
+	}
+}
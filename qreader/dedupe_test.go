@@ -0,0 +1,64 @@
+package qreader
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+/*
+	Records are dropped when met again within the window, and forgotten
+	once the latest record is further ahead than it, along with the
+	memory they were counted as holding
+*/
+func TestDedupeWindow(t *testing.T) {
+	watchdog := newWatchdog(DefaultMaxMemory)
+	set := newDedupeSet(time.Hour, watchdog, slog.New(slog.DiscardHandler))
+
+	if set.seen("conn\x001000\x00C1", 1000, true) {
+		t.Error("first record reported as seen")
+	}
+	if !set.seen("conn\x001000\x00C1", 1000, true) {
+		t.Error("record within the window not reported as seen")
+	}
+	if set.seen("conn\x00line", 0, false) || !set.seen("conn\x00line", 0, false) {
+		t.Error("record without a ts not remembered")
+	}
+
+	// two hours on, the first record is forgotten, though not the one
+	// without a ts
+	if set.seen("conn\x008200\x00C2", 8200, true) {
+		t.Error("later record reported as seen")
+	}
+	if set.seen("conn\x001000\x00C1", 1000, true) {
+		t.Error("record behind the window reported as seen")
+	}
+	if !set.seen("conn\x00line", 0, false) {
+		t.Error("record without a ts forgotten by the window")
+	}
+	if held := watchdog.held.Load(); held != set.size || len(set.buckets) != 2 {
+		t.Errorf("holding %d bytes in %d groups, want %d in 2", held, len(set.buckets), set.size)
+	}
+}
+
+/*
+	The oldest records are forgotten early rather than let the records
+	remembered take up more than half the memory
+*/
+func TestDedupeMemory(t *testing.T) {
+	watchdog := newWatchdog(10 * dedupe_overhead)
+	set := newDedupeSet(time.Hour, watchdog, slog.New(slog.DiscardHandler))
+
+	for ts := 0.0; ts < 600; ts += 60 {
+		set.seen(time.Duration(ts).String(), ts, true)
+	}
+	if held := watchdog.held.Load(); held > watchdog.limit/2 {
+		t.Errorf("holding %d bytes, want at most %d", held, watchdog.limit/2)
+	}
+	if !set.seen(time.Duration(540).String(), 540, true) {
+		t.Error("latest record forgotten")
+	}
+	if set.seen(time.Duration(0).String(), 0, true) {
+		t.Error("oldest record still remembered")
+	}
+}
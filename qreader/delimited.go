@@ -0,0 +1,63 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	DELIMITED LOGS
+//--------------------------------------------------------------------------------
+
+/*
+	Layout of a delimited log that isn't Zeek's, such as squid's
+	access.log: what separates its fields and what they are called. A
+	delimiter of a single space stands for any run of spaces and tabs,
+	as in awk, since such logs pad their columns to line them up
+*/
+type Profile struct {
+	Delimiter string
+	Fields    []string
+}
+
+var profiles map[string]Profile = map[string]Profile{
+	"squid": {
+		Delimiter: " ",
+		Fields:    []string{"ts", "elapsed", "client", "result", "bytes", "method", "url", "user", "hierarchy", "content_type"},
+	},
+}
+var profiles_lock sync.RWMutex
+
+/*
+	Adds a named profile for --format, replacing any with the same name
+*/
+func RegisterProfile(name string, p Profile) {
+	profiles_lock.Lock()
+	defer profiles_lock.Unlock()
+	profiles[name] = p
+}
+
+/*
+	Returns the profile registered under the given name
+*/
+func LookupProfile(name string) (Profile, bool) {
+	profiles_lock.RLock()
+	defer profiles_lock.RUnlock()
+	p, ok := profiles[name]
+	return p, ok
+}
+
+/*
+	Returns a function that splits a line of a delimited log on its
+	delimiter, or on runs of whitespace if that is a single space
+*/
+func delimitedSplitter(delimiter string) func(line string) filters.Linedata {
+	if delimiter == " " {
+		return func(line string) filters.Linedata {
+			return strings.Fields(line)
+		}
+	}
+
+	return splitter(delimiter)
+}
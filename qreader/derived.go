@@ -0,0 +1,91 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"bro-awk/schema"
+	"slices"
+)
+
+//--------------------------------------------------------------------------------
+//	DERIVED FIELDS
+//--------------------------------------------------------------------------------
+
+/*
+	Returns the derived fields among those asked for that the header
+	doesn't have itself but has the inputs of, in the order asked for
+*/
+func derivedFields(header []string, requested []string) []schema.Derived {
+	found := make([]schema.Derived, 0)
+
+	for _, field := range requested {
+		d, ok := schema.LookupDerived(field)
+		if !ok || slices.Contains(header, field) || !containsAll(header, d.Inputs) {
+			continue
+		}
+		if !slices.ContainsFunc(found, func(f schema.Derived) bool { return f.Name == field }) {
+			found = append(found, d)
+		}
+	}
+
+	return found
+}
+
+func containsAll(fields []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(fields, w) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+	Wraps a splitter so that each line also gets the values of the
+	derived fields, after the fields of the log itself. A derived field
+	is unset where any of its inputs is unset or empty
+*/
+func derivedSplitter(split func(line string) filters.Linedata, h *Header, derived []schema.Derived) func(line string) filters.Linedata {
+	width := len(h.Fields)
+	unset := h.UnsetField
+	empty := h.EmptyField
+
+	inputs := make([][]int, len(derived))
+	for i, d := range derived {
+		inputs[i] = make([]int, len(d.Inputs))
+		for j, input := range d.Inputs {
+			inputs[i][j] = slices.Index(h.Fields, input)
+		}
+	}
+
+	return func(line string) filters.Linedata {
+		ld := split(line)
+
+		// short lines are missing their last fields, which count as unset
+		for len(ld) < width {
+			ld = append(ld, unset)
+		}
+		ld = ld[:width]
+
+		for i, d := range derived {
+			values := make([]string, len(inputs[i]))
+			value := ""
+			for j, idx := range inputs[i] {
+				values[j] = ld[idx]
+				if values[j] == unset || values[j] == empty {
+					values = nil
+					break
+				}
+			}
+			if values != nil {
+				value = d.Compute(values)
+			}
+			if value == "" {
+				value = unset
+			}
+			ld = append(ld, value)
+		}
+
+		return ld
+	}
+}
@@ -0,0 +1,64 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"slices"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	ESCAPED VALUES
+//--------------------------------------------------------------------------------
+
+/*
+	Ways of printing values that Zeek escaped: the separator, backslashes,
+	and bytes that aren't printable ASCII, such as a tab in a URI or the
+	UTF-8 of a user agent, are written to TSV logs as \x09 and the like.
+	Filters always see the decoded value, but printed fields keep Zeek's
+	escapes unless they are to be decoded too
+*/
+const (
+	EscapesKeep   string = "keep"
+	EscapesDecode string = "decode"
+)
+
+/* what every escape Zeek writes starts with */
+const escape_prefix string = `\x`
+
+/*
+	Returns a function that splits a line of a Zeek log and decodes the
+	escapes in each of its values, so that the filters and derived fields
+	see the values Zeek logged rather than how it wrote them
+*/
+func decodingSplitter(split func(line string) filters.Linedata) func(line string) filters.Linedata {
+	return func(line string) filters.Linedata {
+		ld := split(line)
+		if !strings.Contains(line, escape_prefix) {
+			return ld
+		}
+
+		for idx, value := range ld {
+			if strings.Contains(value, escape_prefix) {
+				ld[idx] = unescape(value)
+			}
+		}
+
+		return ld
+	}
+}
+
+/*
+	Returns the values of a line with Zeek's escapes as the log wrote
+	them, in place of the decoded ones the filters saw. Derived fields
+	are worked out from the decoded values, and are left as they are
+*/
+func (self *fileScan) escaped(line string, ld filters.Linedata) filters.Linedata {
+	if self.raw_split == nil || !strings.Contains(line, escape_prefix) {
+		return ld
+	}
+
+	escaped := slices.Clone(ld)
+	copy(escaped[:min(len(escaped), self.width)], self.raw_split(line))
+
+	return escaped
+}
@@ -0,0 +1,41 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"io"
+)
+
+//--------------------------------------------------------------------------------
+//	EXPLAIN
+//--------------------------------------------------------------------------------
+
+/*
+	What scanning a log would do, as --explain shows it. Fields are those
+	the log has, derived fields asked for included, Filters the filters as
+	bound to them, and Printed the index in Fields of each field printed
+	(-1 for those the log doesn't have), if only some fields are
+*/
+type Plan struct {
+	File    string
+	JSON    bool
+	Fields  []string
+	Filters []filters.Explanation
+	Printed []int
+}
+
+/*
+	Reads the header of the given log and works out what scanning it
+	would do, without scanning it. Fails just as the scan would if the
+	log is missing a field
+*/
+func (self *Qreader) Explain(fn string) (*Plan, error) {
+	file, err := self.openFile(fn, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := file.source.(io.Closer); ok {
+		closer.Close()
+	}
+
+	return &Plan{fn, file.json, file.header, file.filter.Explain(), file.print_indices}, nil
+}
@@ -0,0 +1,271 @@
+package qreader
+
+import (
+	"bro-awk/schema"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	HEADER
+//--------------------------------------------------------------------------------
+
+/*
+	Everything declared in the `#` lines at the top of a Bro log:
+
+		#separator \x09
+		#set_separator	,
+		#empty_field	(empty)
+		#unset_field	-
+		#path	conn
+		#open	2015-02-26-00-00-00
+		#fields	ts	uid	...
+		#types	time	string	...
+
+	along with the number of lines it took up
+*/
+type Header struct {
+	Format       string
+	Separator    string
+	SetSeparator string
+	EmptyField   string
+	UnsetField   string
+	Path         string
+	Open         string
+	Fields       []string
+	Types        []string
+	Lines        int
+}
+
+/* formats a log can be written in */
+const (
+	FormatTSV       string = "tsv"
+	FormatJSON      string = "json"
+	FormatCSV       string = "csv"
+	FormatDelimited string = "delimited"
+)
+
+/*
+	Creates the header Zeek writes by default for a TSV log with the given
+	fields, for logs that have lost their own
+*/
+func NewHeader(fields []string) *Header {
+	return &Header{
+		Format:       FormatTSV,
+		Separator:    "\t",
+		SetSeparator: ",",
+		EmptyField:   "(empty)",
+		UnsetField:   "-",
+		Fields:       fields,
+	}
+}
+
+/*
+	Reads the `#` lines at the top of the given log, stopping at the first
+	line of data. Logs written as JSON lines have no such header, so their
+	fields are taken from the first record and the schema registry. Those
+	of CSV logs are the columns of their header row
+*/
+func ReadHeader(unzipper string, fn string) (*Header, error) {
+	return Reader{filename: fn, unzipper: unzipper}.readHeader()
+}
+
+/*
+	Like ReadHeader, opening the log as the reader would to scan it
+*/
+func (self Reader) readHeader() (*Header, error) {
+	reader, err := self.GetReader()
+	if err != nil {
+		return nil, err
+	}
+
+	// only the top of the file is needed, so hang up on the rest of it
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	h, _, err := ReadHeaderFrom(reader, self.filename)
+	return h, err
+}
+
+/*
+	Reads the header from a stream that can't be opened a second time,
+	such as STDIN. Returns the header along with a reader positioned at
+	the first line of data. The name is only used to guess the log type
+*/
+func ReadHeaderFrom(reader io.Reader, fn string) (*Header, io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+
+	// the header row of a CSV log is consumed, leaving only data
+	if isCSV(fn) {
+		h, err := parseCSVHeader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Path = schema.PathOf(fn)
+		h.Lines = 1
+		return h, buffered, nil
+	}
+
+	h, first, err := parseHeader(buffered)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// fill in the layout of a JSON log that Zeek would have declared
+	// in the header of a TSV one
+	if h.Format == FormatJSON {
+		if h.Path == "" {
+			h.Path = schema.PathOf(fn)
+		}
+		if log_type, ok := schema.Lookup(h.Path); ok {
+			for _, field := range log_type.Names() {
+				if !slices.Contains(h.Fields, field) {
+					h.Fields = append(h.Fields, field)
+				}
+			}
+		}
+		h.Types = nil
+	}
+
+	return h, io.MultiReader(bytes.NewReader(first), buffered), nil
+}
+
+/*
+	Parses header lines from the reader until the first line that doesn't
+	start with `#`. The first record of a JSON log has to be read to find
+	its fields, so it is returned for the caller to put back
+*/
+func parseHeader(reader *bufio.Reader) (*Header, []byte, error) {
+	h := NewHeader(nil)
+	seen_header := false
+
+	for {
+		// peek so that the first line of data is left unread
+		next, err := reader.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if err != nil || next[0] != '#' {
+			break
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		seen_header = true
+		h.Lines++
+
+		// #separator is always followed by a space, since it is what
+		// declares the separator for every other line
+		if value, ok := strings.CutPrefix(line, "#separator "); ok {
+			if separator := unescape(value); separator != "" {
+				h.Separator = separator
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(line[1:], h.Separator)
+		switch key {
+		case "set_separator":
+			h.SetSeparator = unescape(value)
+		case "empty_field":
+			h.EmptyField = value
+		case "unset_field":
+			h.UnsetField = value
+		case "path":
+			h.Path = value
+		case "open":
+			h.Open = value
+		case "fields":
+			h.Fields = strings.Split(value, h.Separator)
+		case "types":
+			h.Types = strings.Split(value, h.Separator)
+		}
+	}
+
+	// a log without a header that starts with an object is a JSON log, and
+	// the keys of that first object are its fields
+	if next, err := reader.Peek(1); !seen_header && err == nil && next[0] == '{' {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+
+		keys, err := jsonKeys(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse JSON log: %w", err)
+		}
+
+		h.Format = FormatJSON
+		h.Fields = keys
+		return h, line, nil
+	}
+
+	return h, nil, nil
+}
+
+/*
+	Returns the Zeek type of each field, as declared by #types. Logs
+	written without #types, such as JSON ones, get the types of their
+	fields from the schema registry instead, where it knows them
+*/
+func (self *Header) FieldTypes() map[string]string {
+	types := make(map[string]string, len(self.Fields))
+	log_type, known := schema.Lookup(self.Path)
+
+	for idx, field := range self.Fields {
+		if idx < len(self.Types) {
+			types[field] = self.Types[idx]
+		} else if known {
+			types[field], _ = log_type.Type(field)
+		}
+	}
+
+	return types
+}
+
+/*
+	Decodes the \xNN escapes Bro uses when declaring separators, and in
+	values that contain them
+*/
+func unescape(value string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if n, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+/*
+	Renders a separator the way Bro writes it, e.g. a tab as \x09
+*/
+func Escape(value string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] >= 0x7f {
+			fmt.Fprintf(&b, "\\x%02x", value[i])
+		} else {
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
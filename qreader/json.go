@@ -0,0 +1,110 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	JSON LOGS
+//--------------------------------------------------------------------------------
+
+/*
+	Returns the keys of a single JSON object in the order they appear,
+	which is the order Zeek wrote the fields in
+*/
+func jsonKeys(line []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+
+	// opening brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.(string))
+
+		// skip over the value, whatever shape it is
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+/*
+	Returns a function that turns a JSON log line into Linedata laid out
+	according to the header, so that it can go through the same filters
+	as a TSV line. Values are rendered the way Zeek writes them in TSV logs:
+	booleans as T/F, containers joined with the set separator and missing
+	or null fields as the unset marker
+*/
+func jsonSplitter(header *Header) func(line string) filters.Linedata {
+	indices := make(map[string]int, len(header.Fields))
+	for idx, field := range header.Fields {
+		indices[field] = idx
+	}
+
+	unset := header.UnsetField
+	set_separator := header.SetSeparator
+
+	return func(line string) filters.Linedata {
+		ld := make(filters.Linedata, len(header.Fields))
+		for i := range ld {
+			ld[i] = unset
+		}
+
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return ld
+		}
+
+		for key, raw := range record {
+			if idx, ok := indices[key]; ok {
+				ld[idx] = jsonValue(raw, unset, set_separator)
+			}
+		}
+
+		return ld
+	}
+}
+
+/*
+	Renders a single JSON value as it would appear in a TSV log
+*/
+func jsonValue(raw json.RawMessage, unset string, set_separator string) string {
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return unset
+	case string(raw) == "true":
+		return "T"
+	case string(raw) == "false":
+		return "F"
+	case raw[0] == '"':
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+	case raw[0] == '[':
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) == nil {
+			values := make([]string, len(items))
+			for i, item := range items {
+				values[i] = jsonValue(item, unset, set_separator)
+			}
+			return strings.Join(values, set_separator)
+		}
+	}
+
+	// numbers and nested objects are kept as written
+	return string(raw)
+}
@@ -0,0 +1,93 @@
+package qreader
+
+import (
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	MEMORY WATCHDOG
+//--------------------------------------------------------------------------------
+
+/* memory a Qreader holds before its readers wait for the parsers, unless set */
+const DefaultMaxMemory int64 = 512 << 20
+
+/* how often the heap is measured, and readers that are waiting look again */
+var heap_interval time.Duration = 100 * time.Millisecond
+var throttle_interval time.Duration = 10 * time.Millisecond
+
+/* metric of the memory held by live objects, and any not yet collected */
+const heap_metric string = "/memory/classes/heap/objects:bytes"
+
+/*
+	Keeps the readers of a Qreader from reading further ahead of the
+	parsers than memory allows. Logs are read at disk speed, but matches
+	are only parsed as fast as they can be written out, so a slow pipe or
+	remote sink would otherwise leave the chunks read piling up until the
+	process is killed. Shared by every log the Qreader scans, and counts
+	what is held onto across them, as the records WithDedupe remembers
+*/
+type watchdog struct {
+	limit    int64
+	buffered atomic.Int64
+	held     atomic.Int64
+	heap     atomic.Int64
+	sampled  atomic.Int64
+}
+
+func newWatchdog(limit int64) *watchdog {
+	return &watchdog{limit: limit}
+}
+
+/*
+	Waits until a chunk of the given size can be handed to the parsers:
+	until the chunks they have yet to get through, along with what is
+	held, and the heap both fit the limit, or nothing is waiting to be
+	parsed at all. Returns false
+	if done is closed first, and counts each wait in the stats
+*/
+func (self *watchdog) reserve(size int, done <-chan struct{}, stats *scanStats) bool {
+	for waited := false; ; waited = true {
+		buffered := self.buffered.Load()
+		if buffered == 0 || (buffered+self.held.Load()+int64(size) <= self.limit && self.heapInUse() <= self.limit) {
+			break
+		}
+		if !waited {
+			stats.throttled.Add(1)
+		}
+
+		select {
+		case <-done:
+			return false
+		case <-time.After(throttle_interval):
+		}
+	}
+
+	self.buffered.Add(int64(size))
+	return true
+}
+
+/*
+	Gives back what reserve took once a chunk has been parsed, or dropped
+*/
+func (self *watchdog) release(size int) {
+	self.buffered.Add(-int64(size))
+}
+
+/*
+	Returns the size of the heap, measuring it again only if the last
+	measurement is out of date since every chunk read asks
+*/
+func (self *watchdog) heapInUse() int64 {
+	now := time.Now().UnixNano()
+	if last := self.sampled.Load(); now-last >= int64(heap_interval) && self.sampled.CompareAndSwap(last, now) {
+		sample := []metrics.Sample{{Name: heap_metric}}
+		metrics.Read(sample)
+		if sample[0].Value.Kind() == metrics.KindUint64 {
+			self.heap.Store(int64(sample[0].Value.Uint64()))
+		}
+	}
+
+	return self.heap.Load()
+}
@@ -0,0 +1,134 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"container/heap"
+	"slices"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	MERGING BY TIME
+//--------------------------------------------------------------------------------
+
+/*
+	A match waiting to be printed by a merged scan, with the time it is
+	ordered by and the log it came from
+*/
+type mergedLine struct {
+	ts   float64
+	line string
+	log  int
+}
+
+/*
+	The next match of each log still being scanned, earliest first. Matches
+	with the same time are printed in the order the logs were given
+*/
+type mergeQueue []mergedLine
+
+func (self mergeQueue) Len() int {
+	return len(self)
+}
+
+func (self mergeQueue) Less(i, j int) bool {
+	if self[i].ts != self[j].ts {
+		return self[i].ts < self[j].ts
+	}
+	return self[i].log < self[j].log
+}
+
+func (self mergeQueue) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (self *mergeQueue) Push(x any) {
+	*self = append(*self, x.(mergedLine))
+}
+
+func (self *mergeQueue) Pop() any {
+	old := *self
+	last := old[len(old)-1]
+	*self = old[:len(old)-1]
+	return last
+}
+
+/*
+	Like Parse, for all of the given logs at once, printing their matches
+	merged into one stream in order of their ts field rather than log by
+	log. Each log is scanned by a single parser of its own so its matches
+	come out in the order it has them, and as Zeek writes each log close
+	enough to in order, the merge needs only the next match of each log
+	to hand. A match without a ts keeps its place after the one before it
+	in its log. Returns the error each log failed with, if any, in the
+	order they were given
+*/
+func (self *Qreader) ParseMerged(fns ...string) []error {
+	errs := make([]error, len(fns))
+	sources := make([]chan mergedLine, len(fns))
+
+	// the logs are all read at once, and none can get further ahead than
+	// the merge lets it, so each gets its share of the memory rather than
+	// waiting on the others' to be parsed
+	limit := max(self.watchdog.limit/int64(max(len(fns), 1)), int64(self.Blocksize))
+
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		sources[i] = make(chan mergedLine, chansize)
+
+		ordered := *self
+		ordered.ParserPool = 1
+		ordered.watchdog = newWatchdog(limit)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(sources[i])
+
+			last := 0.0
+			errs[i] = ordered.scan(fn, self.stopped, func(file *fileScan, _ *[]byte, line string, ld filters.Linedata) {
+				if idx := slices.Index(file.header, "ts"); idx >= 0 && idx < len(ld) {
+					if ts, ok := filters.ParseTime(ld[idx]); ok {
+						last = ts
+					}
+				}
+
+				select {
+				case sources[i] <- mergedLine{last, self.format(file, line, ld), i}:
+				case <-self.stopped:
+				}
+			}, nil)
+		}()
+	}
+
+	// the merged matches go out through the same queue as Parse's, so
+	// they don't interleave with those of any Parse running alongside
+	blocks := self.openOutput()
+	defer self.closeOutput(blocks)
+
+	queue := make(mergeQueue, 0, len(fns))
+	for _, source := range sources {
+		if next, ok := <-source; ok {
+			queue = append(queue, next)
+		}
+	}
+	heap.Init(&queue)
+
+	for queue.Len() > 0 && !self.Stopped() {
+		next := heap.Pop(&queue).(mergedLine)
+		blocks <- outputBlock{data: append([]byte(next.line), '\n')}
+
+		if more, ok := <-sources[next.log]; ok {
+			heap.Push(&queue, more)
+		}
+	}
+
+	// logs stopped partway through have to be let go of before returning
+	for _, source := range sources {
+		for range source {
+		}
+	}
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,100 @@
+package qreader
+
+import (
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	OUTPUT
+//--------------------------------------------------------------------------------
+
+/* matches queued to be written before the parser workers wait on the Writer */
+const output_queue int = 256
+
+/* bytes of matches gathered up into a single write, at most */
+const output_block int = 256 << 10
+
+/*
+	Writes out the matches Parse prints from a single goroutine. Each
+	parser worker gathers the matches of the chunk it parsed into a buffer
+	of its own and queues it whole, so that the workers don't take turns
+	at the Writer line by line. The writer joins together whatever is
+	queued into blocks of up to output_block bytes, writing each as soon
+	as nothing more is waiting so that matches still come out promptly
+	when they are few. ParseMerged queues its matches one by one, in the
+	order it merged them. It runs as long as any Parse or ParseMerged does
+*/
+type output struct {
+	lock   sync.Mutex
+	users  int
+	blocks chan outputBlock
+	done   chan struct{}
+}
+
+/*
+	Matches queued to be written, or with synced set, a request to be told
+	once everything queued before it has been
+*/
+type outputBlock struct {
+	data   []byte
+	synced chan struct{}
+}
+
+/*
+	Starts writing out matches if nothing else is, returning the queue to
+	send them to
+*/
+func (self *Qreader) openOutput() chan outputBlock {
+	self.output.lock.Lock()
+	defer self.output.lock.Unlock()
+
+	if self.output.users == 0 {
+		self.output.blocks = make(chan outputBlock, output_queue)
+		self.output.done = make(chan struct{})
+		go self.writeOutput(self.output.blocks, self.output.done)
+	}
+	self.output.users++
+
+	return self.output.blocks
+}
+
+/*
+	Waits for everything sent to the queue to be written, then stops
+	writing out matches if nothing else is using the queue
+*/
+func (self *Qreader) closeOutput(blocks chan outputBlock) {
+	synced := make(chan struct{})
+	blocks <- outputBlock{synced: synced}
+	<-synced
+
+	self.output.lock.Lock()
+	defer self.output.lock.Unlock()
+
+	self.output.users--
+	if self.output.users == 0 {
+		close(blocks)
+		<-self.output.done
+	}
+}
+
+func (self *Qreader) writeOutput(blocks chan outputBlock, done chan struct{}) {
+	defer close(done)
+
+	buffer := make([]byte, 0, output_block)
+	for block := range blocks {
+		buffer = append(buffer, block.data...)
+		if block.synced == nil && len(buffer) < output_block && len(blocks) > 0 {
+			continue
+		}
+
+		if len(buffer) > 0 {
+			self.write_lock.Lock()
+			self.Writer.Write(buffer)
+			self.write_lock.Unlock()
+			buffer = buffer[:0]
+		}
+		if block.synced != nil {
+			close(block.synced)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package qreader
+
+import (
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	PROGRESS
+//--------------------------------------------------------------------------------
+
+/*
+	How far the scan of a log has got, in bytes of the log as it is
+	stored: a compressed log is counted by how much of the archive has
+	been read, not by what it decompresses to, so that Read can be set
+	against Size. Size is 0 when it isn't known, e.g. for STDIN or remote
+	logs
+*/
+type LogProgress struct {
+	File    string
+	Size    int64
+	Read    int64
+	Started time.Time
+}
+
+/*
+	Progress of a log being scanned, counted as it is read, along with its
+	timings if it is being timed
+*/
+type logProgress struct {
+	file    string
+	size    int64
+	read    atomic.Int64
+	started time.Time
+	totals  *scanStats
+	timing  *logTiming
+}
+
+/*
+	Reader of a log that counts what is read from it, towards the log's
+	progress and the Qreader's totals. It can be closed as the log it
+	reads could be
+*/
+type progressReader struct {
+	io.Reader
+	progress *logProgress
+}
+
+func (self *progressReader) Read(p []byte) (int, error) {
+	if self.progress.timing != nil {
+		defer lap(&self.progress.timing.storage, time.Now())
+	}
+
+	n, err := self.Reader.Read(p)
+	self.progress.read.Add(int64(n))
+	self.progress.totals.read.Add(int64(n))
+	return n, err
+}
+
+func (self *progressReader) Close() error {
+	if closer, ok := self.Reader.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+/*
+	Starts counting the progress of a log, if progress is being tracked or
+	the log timed, returning nil otherwise. Logs being followed never end,
+	so are neither
+*/
+func (self *Qreader) track(fn string) *logProgress {
+	if (!self.TrackProgress && !self.Timing) || self.Follow {
+		return nil
+	}
+
+	progress := &logProgress{file: fn, started: time.Now(), totals: self.totals}
+	if self.Timing {
+		progress.timing = &logTiming{}
+	}
+	if info, err := os.Stat(fn); fn != Stdin && err == nil && info.Mode().IsRegular() {
+		progress.size = info.Size()
+	} else if info, err := os.Stdin.Stat(); fn == Stdin && err == nil && info.Mode().IsRegular() {
+		progress.size = info.Size()
+	}
+
+	if self.TrackProgress {
+		self.scanning.Store(progress, struct{}{})
+	}
+	return progress
+}
+
+/*
+	Stops counting the progress of a log once it has been scanned, and
+	records its timings
+*/
+func (self *Qreader) untrack(progress *logProgress) {
+	if progress != nil {
+		self.scanning.Delete(progress)
+		self.recordTiming(progress)
+	}
+}
+
+/*
+	Returns how far each of the logs being scanned has got, oldest first.
+	Nothing is returned unless WithProgress was given
+*/
+func (self *Qreader) Progress() []LogProgress {
+	logs := make([]LogProgress, 0)
+	self.scanning.Range(func(key any, _ any) bool {
+		p := key.(*logProgress)
+		logs = append(logs, LogProgress{p.file, p.size, p.read.Load(), p.started})
+		return true
+	})
+
+	slices.SortFunc(logs, func(a LogProgress, b LogProgress) int {
+		if c := a.Started.Compare(b.Started); c != 0 {
+			return c
+		}
+		return strings.Compare(a.File, b.File)
+	})
+
+	return logs
+}
+
+/*
+	Wraps what a log is read from so that it is counted, if the log's
+	progress is being tracked
+*/
+func (self Reader) counted(source io.Reader) io.Reader {
+	if self.progress == nil {
+		return source
+	}
+
+	return &progressReader{source, self.progress}
+}
@@ -10,14 +10,28 @@
 package qreader
 
 import (
+	"bro-awk/enrich"
 	"bro-awk/filters"
+	"bro-awk/remote"
+	"bro-awk/schema"
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"iter"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +41,134 @@ import (
 
 var chansize int = 10000
 
+/* log name that stands for STDIN */
+const Stdin string = "-"
+
+/* how often a followed log is checked for new lines */
+var follow_interval time.Duration = 250 * time.Millisecond
+
+/* longest line scanned unless told otherwise, in bytes */
+const DefaultMaxLine int = 1 << 20
+
+/* the footer Zeek writes when it is done with a log, just before rotating it */
+var close_footer []byte = []byte("#close")
+
+/* lines that start a Zeek header, which may follow another log's lines when logs are concatenated */
+var header_starts [][]byte = [][]byte{[]byte("#separator "), []byte("#fields")}
+
+/* returned when the program given to decompress .gz logs isn't installed */
+var ErrNoUnzipper error = errors.New("could not find a program for gz decompression")
+
+/*
+	Unzipper that decompresses .gz logs in process, which is used when none
+	of the known programs are installed, e.g. on Windows or in a minimal
+	container, or when asked for by name
+*/
+const UnzipperBuiltin string = "builtin"
+
+/*
+	Error returned when a filter or printed field refers to a field that
+	a log's header doesn't have. Suggestions are the fields it has that
+	were probably meant, closest first, Elsewhere the known log types that
+	do have the field, and Fields the ones it does have
+*/
+type MissingFieldError struct {
+	File        string
+	Field       string
+	Suggestions []string
+	Elsewhere   []string
+	Fields      []string
+}
+
+/*
+	Returns the error for a field the log with the given header doesn't have
+*/
+func missingField(fn string, field string, header []string) *MissingFieldError {
+	return &MissingFieldError{fn, field, schema.Suggest(field, header), schema.TypesWith(field), nil}
+}
+
+func (self *MissingFieldError) Error() string {
+	if len(self.Suggestions) > 0 {
+		return fmt.Sprintf("%s has no field named %s (did you mean %s?)", self.File, self.Field, listed(self.Suggestions, "or"))
+	}
+	if len(self.Elsewhere) > 0 {
+		return fmt.Sprintf("%s has no field named %s (it is a field of %s logs)", self.File, self.Field, listed(self.Elsewhere, "and"))
+	}
+
+	return fmt.Sprintf("%s has no field named %s", self.File, self.Field)
+}
+
+/*
+	Lists the words the way they'd be written in a sentence, e.g. "a, b
+	or c"
+*/
+func listed(words []string, conjunction string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+
+	return strings.Join(words[:len(words)-1], ", ") + " " + conjunction + " " + words[len(words)-1]
+}
+
+/*
+	Error returned when a log has no #fields line to name its columns,
+	e.g. because its header was stripped. Path is the known log type it
+	seems to be, going by its #path or its name, if any
+*/
+type NoHeaderError struct {
+	File string
+	Path string
+}
+
+func (self *NoHeaderError) Error() string {
+	return fmt.Sprintf("%s has no #fields header", self.File)
+}
+
+/*
+	Returns the error for a log whose header has no fields
+*/
+func noHeader(fn string, h *Header) error {
+	path := cmp.Or(h.Path, schema.PathOf(fn))
+	if _, known := schema.Lookup(path); !known {
+		path = ""
+	}
+
+	return &NoHeaderError{fn, path}
+}
+
+/*
+	Print field list that stands for the default fields of each log's type,
+	as listed in the schema registry
+*/
+const DefaultFields string = "@default"
+
+/* how WithTimes writes times, as zeek-cut's -d and -u do */
+const TimeLayout string = "2006-01-02T15:04:05-0700"
+
+/* ANSI escapes used to highlight the filtered fields when Color is set */
+var highlight_start string = "\x1b[1;31m"
+var highlight_end string = "\x1b[0m"
+
+/*
+	Counters kept while scanning a single file, reported in the debug log,
+	and added up over every file a Qreader scans
+*/
+type scanStats struct {
+	logs       atomic.Int64
+	chunks     atomic.Int64
+	bytes      atomic.Int64
+	lines      atomic.Int64
+	matches    atomic.Int64
+	peak       atomic.Int64
+	rotations  atomic.Int64
+	partial    atomic.Int64
+	oversized  atomic.Int64
+	duplicates atomic.Int64
+	throttled  atomic.Int64
+	read       atomic.Int64
+	filters    filterCounts
+}
+
 //--------------------------------------------------------------------------------
 //	READER
 //--------------------------------------------------------------------------------
@@ -37,92 +179,541 @@ var chansize int = 10000
 type Reader struct {
 	filename string
 	unzipper string
+	verify   bool
+	progress *logProgress
 	bsize    int
+	maxline  int
 	outq     chan []byte
+	done     <-chan struct{}
+	stats    *scanStats
+	watchdog *watchdog
+	source   io.Reader
+	follow   bool
+	complete func(line []byte) bool
+	strict   *strictScan
+	start    int64
+	member   int
+	members  *gzipMembers
+	offsets  *chunkOffsets
+	err      error
+	rest     io.ReadCloser
+}
+
+/*
+	Deals with whatever followed the last newline of the log. That is
+	usually nothing, but a log that is still being written or was cut
+	short by a crash ends partway through a line. The line is passed on
+	only if it looks whole (the log may simply not end in a newline), and
+	is otherwise skipped rather than parsed into a garbled record. Logs
+	being followed never get here: their last line is held until the
+	rest of it is written. The log ends at the given offset
+*/
+func (self *Reader) finish(leftovers []byte, end int64) {
+	// a #close footer without its newline has nothing to scan either way
+	if len(leftovers) == 0 || leftovers[0] == '#' {
+		return
+	}
+
+	if self.complete == nil || !self.complete(leftovers) {
+		self.stats.partial.Add(1)
+		if self.strict != nil {
+			self.strict.fail(self.strict.read+1, "", "is cut short")
+		}
+		return
+	}
+
+	self.send(leftovers, end)
+}
+
+/*
+	Hands a chunk to the parsers once the watchdog lets it, returning
+	false if the scan is stopped first. The chunk ends at the given offset
+	of the log, for its checkpoints
+*/
+func (self *Reader) send(chunk []byte, end int64) bool {
+	if !self.watchdog.reserve(len(chunk), self.done, self.stats) {
+		return false
+	}
+	if self.offsets != nil {
+		self.offsets.read(end)
+	}
+
+	self.stats.chunks.Add(1)
+	self.stats.bytes.Add(int64(len(chunk)))
+	select {
+	case self.outq <- chunk:
+		return true
+	case <-self.done:
+		self.watchdog.release(len(chunk))
+		return false
+	}
 }
 
 /*
 	Returns an appropriate io.Reader object based on whether or not
 	the file is gzipped. Uses the `Unzipper` variable to determine
-	which program to use in the case of a gzipped file
+	which program to use in the case of a gzipped file, if any
 */
-func (self Reader) GetReader() io.Reader {
-	if strings.HasSuffix(self.filename, ".gz") {
+func (self Reader) GetReader() (io.Reader, error) {
+	if self.filename == Stdin {
 
-		// init a subprocess using the Unzipper command
-		// TODO -- let the -c be an option
-		c := exec.Command(self.unzipper, "-c", self.filename)
-		pipe, err := c.StdoutPipe()
+		// there's no name to go by, so look for the gzip magic number
+		buffered := bufio.NewReader(self.counted(os.Stdin))
+		if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+			return self.unzip(buffered)
+		}
+
+		return buffered, nil
+
+	} else if remote.IsRemote(self.filename) {
+
+		// stream the object, decompressing it on the way if needed
+		body, err := remote.Open(self.filename)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+
+		// make sure the object can be read at all before handing it over,
+		// since the Unzipper would only see it end early. URLs don't always
+		// end in the file name, so go by the gzip magic number
+		buffered := bufio.NewReader(self.counted(body))
+		magic, err := buffered.Peek(2)
+		if err != nil && err != io.EOF {
+			body.Close()
+			return nil, err
+		}
+		if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+			return struct {
+				io.Reader
+				io.Closer
+			}{buffered, body}, nil
+		}
+
+		pipe, err := self.unzip(buffered)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return unzipped{pipe, body}, nil
+
+	} else if strings.HasSuffix(self.filename, ".gz") {
+
+		// decompress it here instead if there's no program to do it, if
+		// it's to be verified as it streams, or if its members are to be
+		// checkpointed. The Unzipper is fed the log instead of opening it
+		// when how much it has read is counted
+		if self.verify || self.unzipper == UnzipperBuiltin || self.progress != nil || self.members != nil {
+			file, err := os.Open(self.filename)
+			if err != nil {
+				return nil, err
+			}
+
+			// a resumed scan starts from the member it left off at
+			if self.start > 0 {
+				if _, err := file.Seek(self.start, io.SeekStart); err != nil {
+					file.Close()
+					return nil, err
+				}
+				if self.progress != nil {
+					self.progress.read.Add(self.start)
+					self.progress.totals.read.Add(self.start)
+				}
+			}
+			pipe, err := self.unzip(self.counted(file))
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return unzipped{pipe, file}, nil
 		}
 
-		// start the subprocess and return a reader connected to
-		// its STDOUT
-		c.Start()
-		return pipe
+		// init a subprocess using the Unzipper command
+		// TODO -- let the -c be an option
+		return startUnzipper(exec.Command(self.unzipper, "-c", self.filename))
 
 	} else {
 
 		// otherwise just open a file as normal and return
-		// an io.Reader object for it
+		// an io.Reader object for it, from where a resumed scan left off
 		file, err := os.Open(self.filename)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if self.start > 0 {
+			if _, err := file.Seek(self.start, io.SeekStart); err != nil {
+				file.Close()
+				return nil, err
+			}
+			if self.progress != nil {
+				self.progress.read.Add(self.start)
+				self.progress.totals.read.Add(self.start)
+			}
+		}
+
+		return self.counted(file), nil
+
+	}
+}
+
+/*
+	Decompresses a stream that isn't a file by feeding it to the Unzipper,
+	or in process if there is none, it's to be verified or its members
+	are to be checkpointed. Members are checked as they stream either way,
+	so verifying costs nothing here
+*/
+func (self Reader) unzip(source io.Reader) (io.ReadCloser, error) {
+	if self.verify || self.unzipper == UnzipperBuiltin || self.members != nil {
+		verified, err := resumeGzip(self.filename, source, Checkpoint{self.start, self.member}, self.members)
+		if err != nil {
+			return nil, err
+		}
+		return verified, nil
+	}
+
+	c := exec.Command(self.unzipper, "-c")
+	c.Stdin = source
+	return startUnzipper(c)
+}
+
+/*
+	Output of a running Unzipper. Once all of it has been read the
+	Unzipper is waited on, and if it failed, e.g. because the log is
+	missing or corrupt, the read fails with what it wrote to STDERR
+	rather than ending as if the log were simply empty
+*/
+type unzipperOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	waited bool
+	err    error
+}
+
+func startUnzipper(c *exec.Cmd) (*unzipperOutput, error) {
+	stderr := &bytes.Buffer{}
+	c.Stderr = stderr
+	pipe, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("unable to run %s: %w", c.Path, err)
+	}
+
+	return &unzipperOutput{ReadCloser: pipe, cmd: c, stderr: stderr}, nil
+}
+
+func (self *unzipperOutput) Read(p []byte) (int, error) {
+	n, err := self.ReadCloser.Read(p)
+	if err == io.EOF && !self.waited {
+		self.waited = true
+		if wait_err := self.cmd.Wait(); wait_err != nil {
+			self.err = fmt.Errorf("%s failed (%w)", filepath.Base(self.cmd.Path), wait_err)
+			if message := strings.TrimSpace(self.stderr.String()); message != "" {
+				self.err = fmt.Errorf("%s failed (%w): %s", filepath.Base(self.cmd.Path), wait_err, message)
+			}
 		}
+	}
+	if err == io.EOF && self.err != nil {
+		return n, self.err
+	}
 
-		return io.Reader(file)
+	return n, err
+}
 
+/*
+	Stops the Unzipper if it hasn't finished, e.g. when only the header
+	was wanted, and reaps it
+*/
+func (self *unzipperOutput) Close() error {
+	if self.waited {
+		return nil
 	}
+
+	self.waited = true
+	self.cmd.Process.Kill()
+	self.ReadCloser.Close()
+	self.cmd.Wait()
+	return nil
+}
+
+/*
+	Decompressed output of the Unzipper, which also hangs up on the stream
+	it decompresses when closed
+*/
+type unzipped struct {
+	io.ReadCloser
+	source io.Closer
+}
+
+func (self unzipped) Close() error {
+	self.source.Close()
+	return self.ReadCloser.Close()
 }
 
 /*
 	Begins to read from the given file and pushes data
-	into a channel. Closes the channel upon EOF
+	into a channel. Closes the channel upon EOF or error, in which
+	case the error is left in self.err
 */
-func (self Reader) Start() {
-	// get an appropriate reader
-	reader := self.GetReader()
+func (self *Reader) Start() {
+	// close channel to let next worker know that you're done
+	defer close(self.outq)
+
+	// get an appropriate reader, unless the stream was already opened
+	// to read its header
+	reader := self.source
+	if reader == nil {
+		var err error
+		reader, err = self.GetReader()
+		if err != nil {
+			self.err = err
+			return
+		}
+
+		// hang up on the log once done with it, which stops the Unzipper
+		// if the scan was stopped before the end. If another log follows
+		// this one in the stream, it is left open for that log's scan
+		defer func() {
+			if closer, ok := reader.(io.Closer); ok && self.rest == nil {
+				closer.Close()
+			}
+		}()
+	}
 
 	// initialize a byteslice for the partial lines
 	// to be added to the following read chunk
 	var leftovers []byte
 
-	// loop until EOF
+	// when following, the log that replaced this one after rotation, and
+	// whether Zeek has written this one's #close footer yet
+	var next *os.File
+	closed := false
+
+	// whether any lines of data have been read, after which a header
+	// starts another log. A resumed scan is already past some
+	data := self.start > 0
+
+	// how far into the log has been read, for its checkpoints. Those of
+	// a compressed log go by how far into it decompressed, from where
+	// the scan started, which its members are noted by
+	pos := self.start
+	if self.members != nil {
+		pos = 0
+	}
+
+	// whether the rest of a line too long to scan is being thrown away
+	skipping := false
+
+	// loop until EOF, or until the scan is stopped
 	for {
+		select {
+		case <-self.done:
+			return
+		default:
+		}
+
 		// read in the next chunk
 		buffer := make([]byte, self.bsize)
+		started := time.Now()
 		length, err := reader.Read(buffer)
+		if self.progress != nil && self.progress.timing != nil {
+			lap(&self.progress.timing.reading, started)
+		}
 		if err != nil && err != io.EOF {
-			panic(err)
+			self.err = err
+			return
 		}
 
-		// break if reading is done
+		// stop once reading is done, unless waiting for the log to grow
+		// (a pipe that has been closed never will)
+		pos += int64(length)
 		if length == 0 {
-			break
+			if !self.follow || self.filename == Stdin {
+				self.finish(leftovers, pos)
+				return
+			}
+
+			// once everything written before the rotation has been read,
+			// carry on with the log that took this one's place
+			if next != nil {
+				if closer, ok := reader.(io.Closer); ok {
+					closer.Close()
+				}
+				self.stats.rotations.Add(1)
+				reader, next, closed, leftovers, skipping = next, nil, false, nil, false
+				continue
+			}
+
+			if file, ok := reader.(*os.File); ok {
+				next = self.rotated(file, closed)
+				if next == file {
+					self.stats.rotations.Add(1)
+					next, closed, leftovers, skipping = nil, false, nil, false
+				}
+				if next != nil {
+					continue
+				}
+			}
+
+			select {
+			case <-self.done:
+				return
+			case <-time.After(follow_interval):
+			}
+			continue
 		}
 
 		// add partial line from previous chunk to beginning of this chunk
 		// add partial line from this chunk to leftovers variable for next
-		end_it := length - 1
-		for {
-			if end_it == 0 {
-				leftovers = append(leftovers, buffer...)
-				break
+		// (pipes often return less than a full buffer, so only look at what was read)
+		buffer = buffer[:length]
+		if bytes.Contains(buffer, close_footer) {
+			closed = true
+		}
+
+		// the rest of a line too long to scan runs up to the next newline
+		if skipping {
+			first := bytes.IndexByte(buffer, '\n')
+			if first < 0 {
+				continue
 			}
-			if buffer[end_it] == '\n' {
-				leftovers = append(leftovers, buffer[:end_it]...)
-				self.outq <- leftovers
-				leftovers = buffer[end_it+1:]
-				break
-			} else {
-				end_it -= 1
+			buffer, skipping = buffer[first+1:], false
+		}
+
+		// a chunk in the middle of a line longer than the blocksize has
+		// no newline at all, so keep collecting until one turns up -- unless
+		// the line is so long that it must be junk, such as a binary file
+		// named like a log, which would otherwise be held in memory whole
+		end := bytes.LastIndexByte(buffer, '\n')
+		if end < 0 {
+			leftovers = append(leftovers, buffer...)
+			if len(leftovers) > self.maxline {
+				self.stats.oversized.Add(1)
+				if self.strict != nil {
+					self.strict.fail(self.strict.read+1, "", fmt.Sprintf("is longer than the maximum line length of %d", self.maxline))
+					return
+				}
+				leftovers, skipping = nil, true
+			}
+			continue
+		}
+
+		chunk := append(leftovers, buffer[:end]...)
+		leftovers = buffer[end+1:]
+		chunk_end := pos - int64(len(leftovers))
+
+		// logs that were concatenated, e.g. by `cat`ing rotated logs
+		// together, have another header partway through. What comes
+		// after it is left to be scanned as a log of its own, since its
+		// fields may be different. Followed logs only get a new header
+		// when rewritten, which rotated deals with
+		var at int
+		if at, data = nextHeader(chunk, data); at >= 0 && !self.follow {
+			rest := slices.Concat(chunk[at:], []byte("\n"), leftovers)
+			var closer io.Closer = io.NopCloser(nil)
+			if c, ok := reader.(io.Closer); ok {
+				closer = c
+			}
+			self.rest = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(rest), reader), closer}
+			chunk = chunk[:max(at-1, 0)]
+			chunk_end = -1
+		}
+
+		// a strict scan numbers the lines, so even a chunk that is only a
+		// blank line is passed on, unless the next header starts it
+		if len(chunk) > 0 || (self.strict != nil && at != 0) {
+			if self.strict != nil {
+				self.strict.read += linesIn(chunk)
 			}
+			if !self.send(chunk, chunk_end) {
+				return
+			}
+		}
+		if self.rest != nil {
+			return
 		}
 	}
+}
 
-	// close channel to let next worker know that you're done
-	close(self.outq)
+/*
+	Returns where a header starts in a chunk of whole lines, as happens
+	partway through logs that were concatenated, or -1 if none does. Only
+	a header after lines of data counts, since a log's own is read before
+	it is scanned. Also returns whether there have been lines of data by
+	the end of the chunk, given whether there had been before it
+*/
+func nextHeader(chunk []byte, data bool) (int, bool) {
+	// most chunks have no header lines at all
+	if !bytes.Contains(chunk, []byte("\n#")) && (len(chunk) == 0 || chunk[0] != '#') {
+		return -1, data || len(bytes.TrimSpace(chunk)) > 0
+	}
+
+	for start := 0; start < len(chunk); {
+		end := bytes.IndexByte(chunk[start:], '\n')
+		if end < 0 {
+			end = len(chunk)
+		} else {
+			end += start
+		}
+
+		line := chunk[start:end]
+		if len(line) > 0 && line[0] == '#' {
+			for _, header_start := range header_starts {
+				if data && bytes.HasPrefix(line, header_start) {
+					return start, data
+				}
+			}
+		} else if len(bytes.TrimSpace(line)) > 0 {
+			data = true
+		}
+		start = end + 1
+	}
+
+	return -1, data
+}
+
+/*
+	Checks whether a followed log has been rotated out from under the open
+	file. If it was renamed or deleted, the new log at its path is opened
+	and returned. If it was truncated, or rewritten after its #close
+	footer, the open file is rewound and returned itself. Otherwise
+	returns nil
+*/
+func (self *Reader) rotated(file *os.File, closed bool) *os.File {
+	current, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	latest, err := os.Stat(self.filename)
+	if err != nil {
+		// nothing has taken its place yet
+		return nil
+	}
+
+	if !os.SameFile(current, latest) {
+		next, err := os.Open(self.filename)
+		if err != nil {
+			return nil
+		}
+		return next
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	if latest.Size() < offset || (closed && latest.Size() != offset) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil
+		}
+		return file
+	}
+
+	return nil
 }
 
 //--------------------------------------------------------------------------------
@@ -131,50 +722,140 @@ func (self Reader) Start() {
 
 /*
 	Parser class which handles splitting data at newlines and separating
-	out relevant data
+	out relevant data. Every line that passes the filter is handed to emit,
+	along with the output of its chunk, and the output to flush once the
+	whole chunk has been parsed
 */
 type Parser struct {
-	filter          *filters.FilterSet
-	limiter         chan int
-	inq             chan []byte
-	print_indices   []int
-	selective_print bool
+	filter   *filters.FilterSet
+	limiter  chan int
+	inq      chan []byte
+	done     <-chan struct{}
+	maxline  int
+	strict   *strictScan
+	stats    *scanStats
+	watchdog *watchdog
+	timing   *logTiming
+	split    func(line string) filters.Linedata
+	offsets  *chunkOffsets
+	emit     func(out *[]byte, line string, ld filters.Linedata)
+	flush    func(out []byte)
 }
 
-func (self Parser) Parse(fileslice []byte) {
+func (self Parser) Parse(fileslice []byte, first int, chunk int) {
 	// split incoming byteslice @ newlines
 	raw_lines := strings.Split(string(fileslice), "\n")
 
-	for _, line := range raw_lines {
-		// skip commented lines
-		if line[0] == '#' {
+	// count the lines each filter passes over the chunk, rather than
+	// line by line, which the workers would contend over
+	filtered := int64(0)
+	passed := make([]int64, len(self.filter.Filters()))
+
+	// and gather up what the matches print, to be written out at once
+	var out []byte
+
+	for i, line := range raw_lines {
+		// skip blank lines, such as a trailing newline or one left between
+		// concatenated logs, and commented lines
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
 			continue
 		}
 
-		// split on tabs to create Linedata object
-		var ld filters.Linedata = strings.Split(line, "\t")
-		if self.filter.Passes(&ld) {
-			// print the specified fields, or the whole line if none were specifically asked for
-			if self.selective_print {
-				to_print := make([]string, len(self.print_indices))
-				for i, idx := range self.print_indices {
-					to_print[i] = ld[idx]
-				}
+		// and lines too long to be anything but junk
+		if len(line) > self.maxline {
+			self.stats.oversized.Add(1)
+			if self.strict != nil {
+				self.strict.fail(first+i, "", fmt.Sprintf("is longer than the maximum line length of %d", self.maxline))
+				break
+			}
+			continue
+		}
 
-				fmt.Println(strings.Join(to_print, "\t"))
-			} else {
-				fmt.Println(line)
+		// a strict scan stops at the first line that doesn't fit the header,
+		// rather than making what it can of it
+		if self.strict != nil {
+			if field, reason := self.strict.check(line); reason != "" {
+				self.strict.fail(first+i, field, reason)
+				break
 			}
 		}
+
+		// split on tabs (or decode JSON) to create Linedata object
+		filtered++
+		if self.timing != nil {
+			self.timed(line, passed, &out)
+			continue
+		}
+		ld := self.split(line)
+		if self.filter.Tally(&ld, passed) {
+			self.stats.matches.Add(1)
+			self.emit(&out, line, ld)
+		}
+	}
+
+	if len(out) > 0 {
+		start := time.Now()
+		self.flush(out)
+		if self.timing != nil {
+			lap(&self.timing.writing, start)
+		}
+	}
+
+	// only lines of data count as scanned, not the header, footer or
+	// blank lines around them
+	self.stats.lines.Add(filtered)
+	self.stats.filters.add(filtered, passed)
+	self.watchdog.release(len(fileslice))
+	if self.offsets != nil {
+		self.offsets.done(chunk)
 	}
 
 	<-self.limiter
 }
 
+/*
+	Splits, filters and emits a line as Parse does, timing each step
+*/
+func (self Parser) timed(line string, passed []int64, out *[]byte) {
+	start := time.Now()
+	ld := self.split(line)
+	start = lap(&self.timing.splitting, start)
+	passes := self.filter.Tally(&ld, passed)
+	start = lap(&self.timing.filtering, start)
+	if passes {
+		self.stats.matches.Add(1)
+		self.emit(out, line, ld)
+		lap(&self.timing.writing, start)
+	}
+}
+
 func (self Parser) Start() {
+	// chunks are numbered as they arrive, which is the order they were
+	// read in
+	chunk := -1
 	for fileslice := range self.inq {
+		chunk++
+
+		// once the scan is stopped, let whatever the reader had already
+		// queued go unparsed
+		select {
+		case <-self.done:
+			self.watchdog.release(len(fileslice))
+			continue
+		default:
+		}
+
 		self.limiter <- 1
-		go self.Parse(fileslice)
+		if busy := int64(len(self.limiter)); busy > self.stats.peak.Load() {
+			self.stats.peak.Store(busy)
+		}
+		// a strict scan numbers the lines of each chunk, which arrive in order
+		first := 0
+		if self.strict != nil {
+			first = self.strict.parsed + 1
+			self.strict.parsed += linesIn(fileslice)
+		}
+		go self.Parse(fileslice, first, chunk)
 	}
 
 	for {
@@ -190,128 +871,1016 @@ func (self Parser) Start() {
 //	MAIN QREADER CLASS
 //--------------------------------------------------------------------------------
 
+/*
+	Main engine object, created with NewQreader. Its settings are only
+	read once it has been constructed, so a single Qreader may run any
+	number of Parse/Scan calls concurrently. Nothing about any one log is
+	kept here: each log's header is resolved into its own fileScan, so
+	logs of different types can be given together
+*/
 type Qreader struct {
-	Filename       string
 	Unzipper       string
 	ParserPool     int
 	Blocksize      int
+	MaxLine        int
+	MaxMemory      int64
 	Filter         *filters.FilterSet
 	PrintFields    []string
-	PrintIndices   []int
 	SelectivePrint bool
+	HeaderFields   []string
+	Delimiter      string
+	Dedupe         bool
+	DedupeWindow   time.Duration
+	Enrichments    []*enrich.Enrichment
+	Color          bool
+	Sanitize       string
+	Escapes        string
+	TimeLocation   *time.Location
+	Verify         bool
+	Follow         bool
+	Strict         bool
+	TrackProgress  bool
+	Timing         bool
+	Checkpoints    func(fn string, at Checkpoint)
+	Resume         map[string]Checkpoint
+	Writer         io.Writer
+	Logger         *slog.Logger
+	write_lock     *sync.Mutex
+	seen           *dedupeSet
+	totals         *scanStats
+	watchdog       *watchdog
+	scanning       *sync.Map
+	timings        *timings
+	scanned        *scanned
+	output         *output
+	stopped        chan struct{}
+	stop_once      *sync.Once
 }
 
 /*
-	Struct initializer for QREADER
+	Optional setting for NewQreader
 */
-func NewQreader(Unzipper string, filter_strings []string, ParserPool int, Blocksize int, my_print_fields string) *Qreader {
-	// initialize a new, empty Qreader
-	q := Qreader{}
+type Option func(q *Qreader)
 
-	// set the unzipper, find one if not given
-	if Unzipper == "" {
-		Unzipper = FindUnzipper()
+/*
+	program used to decompress .gz logs, found on the PATH if not given,
+	or UnzipperBuiltin to decompress them in process
+*/
+func WithUnzipper(unzipper string) Option {
+	return func(q *Qreader) {
+		q.Unzipper = unzipper
 	}
-	q.Unzipper = Unzipper
+}
 
-	// set the number of workers in the parser pool, use default if not given
-	if ParserPool <= 0 {
-		ParserPool = runtime.NumCPU() - 1
+/* number of parser workers, defaults to one less than the number of CPUs */
+func WithWorkers(n int) Option {
+	return func(q *Qreader) {
+		q.ParserPool = n
 	}
-	q.ParserPool = ParserPool
+}
 
-	// set the reading blocksize, use default if not given
-	if Blocksize <= 0 {
-		Blocksize = 8192
+/* size of each read from a log in bytes, defaults to 8192 */
+func WithBlockSize(n int) Option {
+	return func(q *Qreader) {
+		q.Blocksize = n
 	}
-	q.Blocksize = Blocksize
-
-	// set the number of max concurrent goroutines
-	runtime.GOMAXPROCS(runtime.NumCPU() - 1)
+}
 
-	// set up the filters
-	q.Filter = filters.NewFilterSet(filter_strings)
+/*
+	length in bytes past which a line is skipped as junk rather than
+	scanned, defaults to DefaultMaxLine
+*/
+func WithMaxLine(n int) Option {
+	return func(q *Qreader) {
+		q.MaxLine = n
+	}
+}
 
-	// if print_fields is given, set that global variable
-	if my_print_fields == "" {
-		q.PrintFields = nil
-		q.SelectivePrint = false
-	} else {
-		q.PrintFields = strings.Split(my_print_fields, ",")
-		q.SelectivePrint = true
+/*
+	bytes of memory past which the logs being read wait for the matches
+	already read to be parsed and written out, defaults to DefaultMaxMemory.
+	Counts the chunks waiting to be parsed, the records WithDedupe
+	remembers and the heap as a whole
+*/
+func WithMaxMemory(n int64) Option {
+	return func(q *Qreader) {
+		q.MaxMemory = n
 	}
+}
 
-	return &q
+/* only print the named fields rather than whole lines */
+func WithFields(fields ...string) Option {
+	return func(q *Qreader) {
+		q.PrintFields = fields
+		q.SelectivePrint = len(fields) > 0
+	}
 }
 
 /*
-	Function to find a program for gz decompression
+	read every log as headerless TSV with these fields, rather than
+	looking for a header. Any `#` lines the logs do have are skipped
 */
-func FindUnzipper() string {
-	possibilities := []string{"gzcat", "unpigz", "zcat"}
+func WithHeaderFields(fields ...string) Option {
+	return func(q *Qreader) {
+		q.HeaderFields = fields
+	}
+}
+
+/*
+	with WithHeaderFields, split lines on this delimiter rather than on
+	tabs. A single space splits them on any run of whitespace
+*/
+func WithDelimiter(delimiter string) Option {
+	return func(q *Qreader) {
+		q.Delimiter = delimiter
+	}
+}
+
+/*
+	drop records already matched in another log (or earlier in the same
+	one), as happens when overlapping rotated copies of a log are given.
+	Records are the same if they have the same log type, ts and uid
+*/
+func WithDedupe(dedupe bool) Option {
+	return func(q *Qreader) {
+		q.Dedupe = dedupe
+	}
+}
+
+/*
+	how far behind the latest record matched WithDedupe remembers the
+	records before it, by their ts, defaults to DefaultDedupeWindow
+*/
+func WithDedupeWindow(window time.Duration) Option {
+	return func(q *Qreader) {
+		q.DedupeWindow = window
+	}
+}
+
+/*
+	append the columns of these enrichments to every printed match, after
+	the line or the fields printed. The fields they enrich must be in
+	every log, like those the filters look at
+*/
+func WithEnrichments(enrichments ...*enrich.Enrichment) Option {
+	return func(q *Qreader) {
+		q.Enrichments = enrichments
+	}
+}
+
+/*
+	where Parse prints matches, defaults to STDOUT. They are written from a
+	single goroutine, many at a time, each on a line of its own
+*/
+func WithWriter(w io.Writer) Option {
+	return func(q *Qreader) {
+		q.Writer = w
+	}
+}
+
+/* where diagnostics go, defaults to nowhere */
+func WithLogger(logger *slog.Logger) Option {
+	return func(q *Qreader) {
+		q.Logger = logger
+	}
+}
+
+/* highlight the filtered fields in printed matches */
+func WithColor(color bool) Option {
+	return func(q *Qreader) {
+		q.Color = color
+	}
+}
+
+/*
+	how control characters in printed matches are dealt with, one of
+	SanitizeEscape (the default), SanitizeStrip or SanitizeNone
+*/
+func WithSanitize(mode string) Option {
+	return func(q *Qreader) {
+		q.Sanitize = mode
+	}
+}
+
+/*
+	whether values Zeek escaped are printed as the log wrote them,
+	EscapesKeep (the default), or decoded, EscapesDecode. Filters always
+	see them decoded
+*/
+func WithEscapes(mode string) Option {
+	return func(q *Qreader) {
+		q.Escapes = mode
+	}
+}
+
+/*
+	print the values of time fields as dates and times in this location,
+	as zeek-cut -d (local) and -u (UTC) do, rather than as the seconds
+	since the epoch the log wrote. Nil, the default, leaves them be
+*/
+func WithTimes(loc *time.Location) Option {
+	return func(q *Qreader) {
+		q.TimeLocation = loc
+	}
+}
+
+/*
+	decompress .gz logs in process rather than with the Unzipper, checking
+	the CRC-32 of each gzip member as it streams, so that a damaged archive
+	fails with a CorruptArchiveError instead of looking shorter than it is
+*/
+func WithVerify(verify bool) Option {
+	return func(q *Qreader) {
+		q.Verify = verify
+	}
+}
+
+/*
+	count how much of each log has been read as it is scanned, for
+	Progress and Totals. Compressed logs are then fed to the Unzipper
+	rather than opened by it
+*/
+func WithProgress(track bool) Option {
+	return func(q *Qreader) {
+		q.TrackProgress = track
+	}
+}
+
+/*
+	time where scanning each log goes, for Timings: reading the log,
+	decompressing it, splitting its lines, filtering them and writing out
+	the matches. Like WithProgress, compressed logs are then fed to the
+	Unzipper
+*/
+func WithTiming(timing bool) Option {
+	return func(q *Qreader) {
+		q.Timing = timing
+	}
+}
+
+/*
+	keep reading each log as it grows, like `tail -f`, rather than
+	stopping at its end. Parse and Scan then only return if stopped
+*/
+func WithFollow(follow bool) Option {
+	return func(q *Qreader) {
+		q.Follow = follow
+	}
+}
+
+/*
+	treat lines that don't fit their log's header, by their number of
+	fields or the types of their values, as errors, rather than making
+	what can be made of them. Fields asked for that a log doesn't have
+	are errors even with DefaultFields
+*/
+func WithStrict(strict bool) Option {
+	return func(q *Qreader) {
+		q.Strict = strict
+	}
+}
+
+/*
+	call report with each log's Checkpoint as its scan gets further, from
+	the parsers' goroutines. Only logs that can be taken up partway
+	through have them: uncompressed local files, by bytes, and tar
+	archives, by members
+*/
+func WithCheckpoints(report func(fn string, at Checkpoint)) Option {
+	return func(q *Qreader) {
+		q.Checkpoints = report
+	}
+}
+
+/*
+	take the scans of these logs up from where their checkpoints say,
+	rather than from the start, as after an interrupted scan. Logs that
+	can't be taken up partway through are scanned from the start
+*/
+func WithResume(at map[string]Checkpoint) Option {
+	return func(q *Qreader) {
+		q.Resume = at
+	}
+}
+
+/*
+	use a FilterSet built in Go code (see filters.Build) in place of
+	the filter strings given to NewQreader
+*/
+func WithFilterSet(fs *filters.FilterSet) Option {
+	return func(q *Qreader) {
+		q.Filter = fs
+	}
+}
+
+/*
+	Struct initializer for QREADER. The filter strings are compiled into
+	the Qreader's FilterSet unless WithFilterSet is given:
+
+		q, err := qreader.NewQreader([]string{"proto=tcp"}, qreader.WithWorkers(4), qreader.WithFields("uid"))
+*/
+func NewQreader(filter_strings []string, opts ...Option) (*Qreader, error) {
+	// initialize a new, empty Qreader and apply the caller's settings
+	q := Qreader{}
+	for _, opt := range opts {
+		opt(&q)
+	}
+
+	// set the logger, dropping everything if not given
+	if q.Logger == nil {
+		q.Logger = slog.New(slog.DiscardHandler)
+	}
+
+	// print to STDOUT if not given anywhere else
+	if q.Writer == nil {
+		q.Writer = os.Stdout
+	}
+	q.write_lock = &sync.Mutex{}
+	q.totals = &scanStats{}
+	q.scanning = &sync.Map{}
+	q.timings = &timings{}
+	q.scanned = &scanned{}
+	q.output = &output{}
+	q.stopped = make(chan struct{})
+	q.stop_once = &sync.Once{}
+
+	// set the unzipper, find one if not given, and make sure one that
+	// was can be run before any log needs it
+	if q.Unzipper == "" {
+		u, err := FindUnzipper()
+		if err != nil {
+			return nil, err
+		}
+		q.Unzipper = u
+	} else if q.Unzipper != UnzipperBuiltin {
+		if _, err := exec.LookPath(q.Unzipper); err != nil {
+			return nil, fmt.Errorf("%w: %s isn't installed (%s decompresses logs in process)", ErrNoUnzipper, q.Unzipper, UnzipperBuiltin)
+		}
+	}
+
+	// set the number of workers in the parser pool, use default if not given
+	if q.ParserPool <= 0 {
+		q.ParserPool = max(runtime.NumCPU()-1, 1)
+	}
+
+	// set the reading blocksize, use default if not given
+	if q.Blocksize <= 0 {
+		q.Blocksize = 8192
+	}
+
+	// set the longest line to scan, use default if not given
+	if q.MaxLine <= 0 {
+		q.MaxLine = DefaultMaxLine
+	}
+
+	// bound how far ahead of the parsers the readers get, use default if
+	// not given
+	if q.MaxMemory <= 0 {
+		q.MaxMemory = DefaultMaxMemory
+	}
+	q.watchdog = newWatchdog(q.MaxMemory)
+
+	// remember the records let through for an hour of ts, unless told otherwise
+	if q.DedupeWindow <= 0 {
+		q.DedupeWindow = DefaultDedupeWindow
+	}
+	q.seen = newDedupeSet(q.DedupeWindow, q.watchdog, q.Logger)
+
+	// escape control characters in output unless told otherwise
+	if q.Sanitize == "" {
+		q.Sanitize = SanitizeEscape
+	}
+
+	// print values as the log wrote them unless told otherwise
+	if q.Escapes == "" {
+		q.Escapes = EscapesKeep
+	}
+
+	// set the number of max concurrent goroutines
+	runtime.GOMAXPROCS(max(runtime.NumCPU()-1, 1))
+
+	// set up the filters, unless they were already built
+	if q.Filter == nil {
+		fs, err := filters.NewFilterSet(filter_strings, q.Logger)
+		if err != nil {
+			return nil, err
+		}
+		q.Filter = fs
+	}
+
+	q.Logger.Info("qreader configured", "unzipper", q.Unzipper, "workers", q.ParserPool, "blocksize", q.Blocksize)
+
+	return &q, nil
+}
+
+/*
+	Stops every scan the Qreader is running, e.g. on Ctrl-C. Each log being
+	read is hung up on, its Unzipper killed, and the Parse, Scan or follow
+	reading it returns as soon as the lines already parsed are printed. No
+	more logs are scanned after
+*/
+func (self *Qreader) Stop() {
+	self.stop_once.Do(func() {
+		close(self.stopped)
+	})
+}
+
+/*
+	Reports whether Stop has been called
+*/
+func (self *Qreader) Stopped() bool {
+	select {
+	case <-self.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+	How much a Qreader has scanned so far, over every log. Logs, Lines,
+	Bytes and Matches count the logs that have been scanned through, Lines
+	being their records rather than their header lines, while Read counts
+	the bytes read of the logs as they are stored as it goes, with
+	WithProgress
+*/
+type Totals struct {
+	Logs    int64
+	Lines   int64
+	Bytes   int64
+	Matches int64
+	Read    int64
+}
+
+func (self *Qreader) Totals() Totals {
+	return Totals{self.totals.logs.Load(), self.totals.lines.Load(), self.totals.bytes.Load(), self.totals.matches.Load(), self.totals.read.Load()}
+}
+
+/*
+	Function to find a program for gz decompression, falling back on
+	decompressing in process when none is installed
+*/
+func FindUnzipper() (string, error) {
+	possibilities := []string{"gzcat", "unpigz", "zcat"}
 
 	for _, p := range possibilities {
 		cmd, err := exec.LookPath(p)
 		if err == nil {
-			return cmd
+			return cmd, nil
 		}
 	}
 
-	fmt.Println("[ERROR] could not find a program for gz decompression")
-	os.Exit(1)
-	return ""
+	return UnzipperBuiltin, nil
 }
 
 /*
 	Read in the bro log file up to the `#fields` line and find the names of the various fields
 */
-func GetHeader(unzipper string, fn string) []string {
-	cmdstring := fmt.Sprintf("%s -c %s | grep -m1 fields", unzipper, fn)
-	cmd := exec.Command("bash", "-c", cmdstring)
-
-	stdout, err := cmd.StdoutPipe()
+func GetHeader(unzipper string, fn string) ([]string, error) {
+	header, err := ReadHeader(unzipper, fn)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if len(header.Fields) == 0 {
+		return nil, noHeader(fn, header)
 	}
 
-	cmd.Start()
+	return header.Fields, nil
+}
 
-	field_string, err := ioutil.ReadAll(stdout)
+/*
+	State for a single file being scanned. It is kept apart from the Qreader
+	so that one Qreader can scan any number of files at the same time
+*/
+type fileScan struct {
+	filename      string
+	header        []string
+	filter        *filters.FilterSet
+	separator     string
+	unset         string
+	json          bool
+	width         int
+	split         func(line string) filters.Linedata
+	raw_split     func(line string) filters.Linedata
+	join          func(fields []string) string
+	complete      func(line []byte) bool
+	key           func(line string, ld filters.Linedata) (string, float64, bool)
+	source        io.Reader
+	skipped       int
+	check         func(line string) (string, string)
+	print_indices []int
+	enriched      []int
+	highlight     map[int]bool
+	times         map[int]bool
+}
+
+/*
+	Returns a function that splits a line of a log into its fields on the
+	separator declared in its header
+*/
+func splitter(separator string) func(line string) filters.Linedata {
+	return func(line string) filters.Linedata {
+		return strings.Split(line, separator)
+	}
+}
+
+/*
+	Returns the fields of the given log: those given by WithHeaderFields,
+	or else those in its header, followed by the derived fields that can
+	be worked out from them
+*/
+func (self *Qreader) HeaderOf(fn string) ([]string, error) {
+	var fields []string
+	if len(self.HeaderFields) > 0 {
+		h, err := self.givenHeader(fn)
+		if err != nil {
+			return nil, err
+		}
+		fields = h.Fields
+	} else {
+		var err error
+		fields, err = GetHeader(self.Unzipper, fn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range schema.DerivedFrom(fields) {
+		fields = append(fields, d.Name)
+	}
+
+	return fields, nil
+}
+
+/*
+	Builds the header for a log read with WithHeaderFields. A single
+	@TYPE stands for all the fields of that log type, in the order Zeek
+	writes them
+*/
+func (self *Qreader) givenHeader(fn string) (*Header, error) {
+	h := NewHeader(self.HeaderFields)
+	h.Path = schema.PathOf(fn)
+	if self.Delimiter != "" {
+		h.Format = FormatDelimited
+		h.Separator = self.Delimiter
+	}
+
+	// @N names the columns by position, 1 to N, for logs whose fields
+	// aren't known at all
+	if len(h.Fields) == 1 && strings.HasPrefix(h.Fields[0], "@") {
+		if n, err := strconv.Atoi(h.Fields[0][1:]); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("logs must have at least 1 column, not %d", n)
+			}
+			h.Fields = make([]string, n)
+			for i := range h.Fields {
+				h.Fields[i] = strconv.Itoa(i + 1)
+			}
+			return h, nil
+		}
+
+		h.Path = strings.TrimPrefix(h.Fields[0], "@")
+		log_type, ok := schema.Lookup(h.Path)
+		if !ok {
+			return nil, fmt.Errorf("no fields known for %q logs", h.Path)
+		}
+		h.Fields = log_type.Names()
+	}
+
+	return h, nil
+}
+
+/*
+	Set up the workers and read through a given file, printing every
+	matching line (or only the requested fields) to the Qreader's Writer.
+	Safe to call from several goroutines at once. Every match has been
+	written by the time it returns
+*/
+func (self *Qreader) Parse(fn string) error {
+	blocks := self.openOutput()
+	defer self.closeOutput(blocks)
+
+	return self.scan(fn, self.stopped, func(file *fileScan, out *[]byte, line string, ld filters.Linedata) {
+		*out = append(append(*out, self.format(file, line, ld)...), '\n')
+	}, func(out []byte) {
+		blocks <- outputBlock{data: out}
+	})
+}
+
+/*
+	Returns a matching line as it is printed: only the requested fields,
+	or the whole line, highlighted, sanitized and enriched as asked
+*/
+func (self *Qreader) format(file *fileScan, line string, ld filters.Linedata) string {
+	// the filters saw the decoded values, but they are printed as the
+	// log wrote them unless asked otherwise
+	values := ld
+	if self.Escapes != EscapesDecode {
+		values = file.escaped(line, ld)
+	}
+
+	// print the specified fields, or the whole line if none were specifically asked for
+	if self.SelectivePrint {
+		to_print := make([]string, len(file.print_indices))
+		for i, idx := range file.print_indices {
+			value := file.unset
+			if idx >= 0 && idx < len(values) {
+				value = values[idx]
+			}
+			to_print[i] = self.colorize(file, idx, Sanitize(self.Sanitize, self.showTime(file, idx, value), ""))
+		}
+		line = file.join(to_print)
+	} else if self.Color || file.times != nil {
+		// derived fields aren't part of the line
+		values := values[:min(len(values), file.width)]
+		to_print := make([]string, len(values))
+		for idx, value := range values {
+			to_print[idx] = self.colorize(file, idx, Sanitize(self.Sanitize, self.showTime(file, idx, value), ""))
+		}
+		line = file.join(to_print)
+	} else if self.Escapes == EscapesDecode && file.raw_split != nil && strings.Contains(line, escape_prefix) {
+		// a decoded separator is escaped again like any other control
+		// character, so the line keeps its columns
+		values := ld[:min(len(ld), file.width)]
+		to_print := make([]string, len(values))
+		for idx, value := range values {
+			to_print[idx] = Sanitize(self.Sanitize, value, "")
+		}
+		line = file.join(to_print)
+	} else {
+		line = Sanitize(self.Sanitize, line, file.separator)
+	}
+
+	if len(self.Enrichments) > 0 {
+		line = self.enrich(file, line, ld)
+	}
+
+	return line
+}
+
+/*
+	Wraps the value in highlighting escapes if color is turned on and
+	the column is one that the filters look at
+*/
+func (self *Qreader) colorize(file *fileScan, idx int, value string) string {
+	if !self.Color || !file.highlight[idx] {
+		return value
+	}
+
+	return highlight_start + value + highlight_end
+}
+
+/*
+	Writes the value as a date and time in the location asked for with
+	WithTimes if it is of a time field, in zeek-cut's format. Unset
+	values, and those that aren't seconds since the epoch (as JSON logs
+	may have already), are left as they are
+*/
+func (self *Qreader) showTime(file *fileScan, idx int, value string) string {
+	if !file.times[idx] {
+		return value
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		panic(err)
+		return value
+	}
+
+	return time.Unix(int64(seconds), 0).In(self.TimeLocation).Format(TimeLayout)
+}
+
+/*
+	Appends the columns of each enrichment to the printed line, as more
+	fields of the same kind. Whole JSON records get them as more keys,
+	which are left out where unset as Zeek does
+*/
+func (self *Qreader) enrich(file *fileScan, line string, ld filters.Linedata) string {
+	names := make([]string, 0)
+	values := make([]string, 0)
+
+	for i, e := range self.Enrichments {
+		value := file.unset
+		if idx := file.enriched[i]; idx < len(ld) {
+			value = ld[idx]
+		}
+
+		var columns []string
+		if value != file.unset {
+			columns = e.Lookup(value)
+		}
+		for j, name := range e.Columns() {
+			column := file.unset
+			if j < len(columns) && columns[j] != "" {
+				column = Sanitize(self.Sanitize, columns[j], "")
+			}
+			names = append(names, name)
+			values = append(values, column)
+		}
+	}
+
+	if !file.json || self.SelectivePrint {
+		return line + file.separator + file.join(values)
 	}
 
-	// to from 0:end-1 of the field string (to ignore newline)
-	// then take 1:end to get everything except the '#fields' string
-	return strings.Split(string(field_string[:len(field_string)-1]), "\t")[1:]
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(strings.TrimRight(line, " \r"), "}"))
+	for i, name := range names {
+		if values[i] == file.unset {
+			continue
+		}
+		key, _ := json.Marshal(name)
+		value, _ := json.Marshal(values[i])
+		b.WriteString("," + string(key) + ":" + string(value))
+	}
+	b.WriteString("}")
+
+	return b.String()
 }
 
 /*
-	Set up the workers and read through a given file
+	Reads the header of the given file and works out everything that
+	depends on it: the bound filters and the columns to print/highlight.
+	The file is read from stream instead of being opened, if it is given,
+	and otherwise counts towards the given progress where the rest of it
+	is read on from its header
 */
-func (self Qreader) Parse(fn string) {
-	// find the header for the bro file
-	header := GetHeader(self.Unzipper, fn)
+func (self *Qreader) openFile(fn string, stream io.Reader, progress *logProgress) (*fileScan, error) {
+	// find the header for the bro file. STDIN and other streams can only
+	// be read once, so the rest of them is kept to be scanned after the header
+	var full_header *Header
+	var source io.Reader
+	var err error
+	if len(self.HeaderFields) > 0 {
+		// the fields were given, so there is no header to look for
+		full_header, err = self.givenHeader(fn)
+		if err == nil && stream != nil {
+			source = stream
+		} else if err == nil && (fn == Stdin || remote.IsStream(fn)) {
+			source, err = Reader{filename: fn, unzipper: self.Unzipper, verify: self.Verify, progress: progress}.GetReader()
+		}
+	} else if stream != nil {
+		full_header, source, err = ReadHeaderFrom(stream, fn)
+	} else if fn == Stdin || remote.IsStream(fn) || isCSV(fn) {
+		// the header row of a CSV log isn't marked with `#`, so the
+		// rest of the log is read on from the same place too
+		var reader io.Reader
+		reader, err = Reader{filename: fn, unzipper: self.Unzipper, verify: self.Verify, progress: progress}.GetReader()
+		if err == nil {
+			full_header, source, err = ReadHeaderFrom(reader, fn)
+		}
+	} else {
+		full_header, err = Reader{filename: fn, unzipper: self.Unzipper, verify: self.Verify}.readHeader()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(full_header.Fields) == 0 {
+		return nil, noHeader(fn, full_header)
+	}
+	header := full_header.Fields
+	requested := slices.Concat(self.Filter.Fields(), self.PrintFields, self.enrichedFields())
+
+	// Zeek leaves unset fields out of JSON records entirely, so the first
+	// record may not have shown every field -- any field asked for (or
+	// needed to work out a derived field asked for) is assumed to exist
+	// and is treated as unset where it's missing
+	if full_header.Format == FormatJSON {
+		for _, field := range requested {
+			wanted := []string{field}
+			if d, ok := schema.LookupDerived(field); ok && !slices.Contains(header, field) {
+				wanted = d.Inputs
+			}
+			for _, w := range wanted {
+				if w != DefaultFields && !slices.Contains(header, w) {
+					header = append(header, w)
+				}
+			}
+		}
+		full_header.Fields = header
+	}
+
+	// derived fields asked for are worked out from the others, and come
+	// after them
+	derived := derivedFields(header, requested)
+	if len(derived) > 0 {
+		header = slices.Clone(header)
+		for _, d := range derived {
+			header = append(header, d.Name)
+		}
+	}
+	self.Logger.Debug("read header", "file", fn, "format", full_header.Format, "fields", header)
+
+	// make sure every field the filters look at is actually in this log,
+	// reporting all of those that aren't. Only the last lists the fields
+	// the log does have, since they're the same for each
+	missing := make([]*MissingFieldError, 0)
+	for _, field := range self.Filter.Fields() {
+		if !slices.Contains(header, field) {
+			missing = append(missing, missingField(fn, field, header))
+		}
+	}
+	if len(missing) > 0 {
+		missing[len(missing)-1].Fields = header
+		errs := make([]error, len(missing))
+		for i, err := range missing {
+			errs[i] = err
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	file := &fileScan{filename: fn, header: header, separator: full_header.Separator, unset: full_header.UnsetField, source: source}
+	file.json = full_header.Format == FormatJSON
+	switch full_header.Format {
+	case FormatJSON:
+		file.split = jsonSplitter(full_header)
+	case FormatCSV:
+		file.split = csvSplitter()
+	case FormatDelimited:
+		file.split = delimitedSplitter(full_header.Separator)
+	default:
+		// the values of Zeek's own logs are escaped
+		file.raw_split = splitter(full_header.Separator)
+		file.split = decodingSplitter(file.raw_split)
+	}
+	file.join = func(fields []string) string {
+		return strings.Join(fields, file.separator)
+	}
+	if full_header.Format == FormatCSV {
+		file.join = csvJoin
+	}
+
+	// a last line without a newline is only taken to be whole if it has
+	// every field (or, for JSON, is a whole object)
+	split := file.split
+	file.complete = func(line []byte) bool {
+		return len(split(string(line))) >= len(full_header.Fields)
+	}
+	if full_header.Format == FormatJSON {
+		file.complete = json.Valid
+	}
+
+	// a strict scan checks each line against the header before the derived
+	// fields are added, numbering them from the start of the log even if
+	// the header has already been read off the stream
+	if self.Strict {
+		file.check = strictChecker(full_header, split)
+		if source != nil {
+			file.skipped = full_header.Lines
+		}
+	}
+
+	file.width = len(full_header.Fields)
+	if len(derived) > 0 {
+		file.split = derivedSplitter(split, full_header, derived)
+	}
+
+	if self.Dedupe {
+		if full_header.Path == "" {
+			full_header.Path = schema.PathOf(fn)
+		}
+		file.key = dedupeKey(full_header)
+	}
+
+	// swap in the default fields for this type of log if they were asked for
+	print_fields := self.PrintFields
+	defaults := len(print_fields) == 1 && print_fields[0] == DefaultFields
+	if defaults {
+		path := full_header.Path
+		if path == "" {
+			path = schema.PathOf(fn)
+		}
+		log_type, ok := schema.Lookup(path)
+		if !ok {
+			return nil, fmt.Errorf("%s: no default fields known for %q logs", fn, path)
+		}
+		print_fields = log_type.Defaults
+	}
 
 	// if only certain fields are to be printed, use the new header to determine
 	// the indices of those fields
 	if self.SelectivePrint {
-		self.PrintIndices = make([]int, len(self.PrintFields))
+		file.print_indices = make([]int, len(print_fields))
+
+		for i, field := range print_fields {
+			// logs from older versions of Zeek may lack some of the usual
+			// fields of their type, which print as unset, but any field
+			// asked for by name has to be there
+			file.print_indices[i] = slices.Index(header, field)
+			if file.print_indices[i] < 0 && (!defaults || self.Strict) {
+				err := missingField(fn, field, header)
+				err.Fields = header
+				return nil, err
+			}
+		}
+	}
+
+	// find the columns to enrich, which have to be there like those the
+	// filters look at
+	file.enriched = make([]int, len(self.Enrichments))
+	for i, field := range self.enrichedFields() {
+		file.enriched[i] = slices.Index(header, field)
+		if file.enriched[i] < 0 {
+			err := missingField(fn, field, header)
+			err.Fields = header
+			return nil, err
+		}
+	}
+
+	// find the columns of times to write out as dates
+	if self.TimeLocation != nil {
+		file.times = make(map[int]bool)
+		types := full_header.FieldTypes()
+		for _, d := range derived {
+			types[d.Name] = d.Type
+		}
+		for idx, field := range header {
+			if types[field] == "time" {
+				file.times[idx] = true
+			}
+		}
+	}
 
-		for i1, field := range self.PrintFields {
-			for i2, header_field := range header {
+	// find the columns to highlight when printing in color
+	if self.Color {
+		file.highlight = make(map[int]bool)
+		for _, field := range self.Filter.Fields() {
+			for idx, header_field := range header {
 				if field == header_field {
-					self.PrintIndices[i1] = i2
+					file.highlight[idx] = true
 				}
 			}
 		}
-	} else {
-		self.PrintIndices = nil
 	}
 
-	// use the header and the filter strings to generate a FilterSet
-	// TODO find a more elegant way of doing this??
-	self.Filter.ApplyHeader(header)
+	// bind a copy of the filters to this file's header, along with the
+	// markers it uses for sets and missing values and the type of each
+	// field
+	binding := filters.NewBinding(header)
+	binding.SetSeparator = full_header.SetSeparator
+	binding.EmptyField = full_header.EmptyField
+	binding.UnsetField = full_header.UnsetField
+	binding.Types = full_header.FieldTypes()
+	for _, d := range derived {
+		binding.Types[d.Name] = d.Type
+	}
+	file.filter = self.Filter.Bind(binding)
+
+	return file, nil
+}
+
+/*
+	Returns the field of each enrichment, in order
+*/
+func (self *Qreader) enrichedFields() []string {
+	fields := make([]string, len(self.Enrichments))
+	for i, e := range self.Enrichments {
+		fields[i] = e.Field
+	}
+
+	return fields
+}
+
+/*
+	Set up the workers and read through a given file, handing every matching
+	line to the given emit function along with the state of the file it came
+	from and the output of the chunk it is in, which emit may add to. The
+	output of each chunk is handed to flush once it is parsed, unless there
+	is none. Reading stops early if done is closed
+*/
+func (self *Qreader) scan(fn string, done <-chan struct{}, emit func(file *fileScan, out *[]byte, line string, ld filters.Linedata), flush func(out []byte)) error {
+	if self.Stopped() {
+		return nil
+	}
+
+	progress := self.track(fn)
+	defer self.untrack(progress)
+
+	if isTar(fn) {
+		return self.scanTar(fn, progress, done, emit, flush)
+	}
+
+	log := &LogStats{File: fn}
+	defer self.recordLog(log, time.Now())
+
+	return self.scanFrom(fn, nil, 0, progress, log, done, emit, flush)
+}
+
+/*
+	Like scan, for a single log that is read from stream rather than being
+	opened, if it is given. Such a log can't be followed. A stream that
+	comes after the given number of lines of the same file is numbered on
+	from them. What is read and how long it takes count towards the given
+	progress, which may be that of the archive or file the log is part of,
+	and what is scanned towards the given stats of the log
+*/
+func (self *Qreader) scanFrom(fn string, stream io.Reader, lineno int, progress *logProgress, log *LogStats, done <-chan struct{}, emit func(file *fileScan, out *[]byte, line string, ld filters.Linedata), flush func(out []byte)) error {
+	start := time.Now()
+	self.Logger.Info("scanning log", "file", fn)
+
+	file, err := self.openFile(fn, stream, progress)
+	if err != nil {
+		return err
+	}
 
 	// create the necessary channels
 	chan1 := make(chan []byte, chansize)
@@ -320,11 +1889,248 @@ func (self Qreader) Parse(fn string) {
 	// to limit overall throughput
 	limiter1 := make(chan int, self.ParserPool)
 
+	// a strict scan also stops at the first invalid line
+	var strict *strictScan
+	scan_done := done
+	if self.Strict {
+		strict = newStrictScan(fn, file.check, lineno+file.skipped)
+		var release func()
+		scan_done, release = either(done, strict.found)
+		defer release()
+	}
+
+	// a log read straight from a file can be taken up where a scan left
+	// off, and says how far it has got. A compressed log can only be
+	// taken up at the start of one of its gzip members, so those are
+	// noted as it is decompressed
+	var resume_at Checkpoint
+	var offsets *chunkOffsets
+	var members *gzipMembers
+	if self.resumable(file, stream != nil) {
+		resume_at = self.Resume[fn]
+		if strings.HasSuffix(fn, ".gz") && (self.Checkpoints != nil || resume_at.Offset > 0) {
+			members = &gzipMembers{}
+		}
+		if self.Checkpoints != nil {
+			offsets = &chunkOffsets{report: func(offset int64) {
+				if members == nil {
+					self.Checkpoints(fn, Checkpoint{Offset: offset})
+				} else if at, ok := members.before(offset); ok {
+					self.Checkpoints(fn, at)
+				}
+			}}
+		}
+	}
+
 	// intialize the various worker objects
-	r := Reader{fn, self.Unzipper, self.Blocksize, chan1}
-	p := Parser{self.Filter, limiter1, chan1, self.PrintIndices, self.SelectivePrint}
+	stats := &scanStats{}
+	r := &Reader{fn, self.Unzipper, self.Verify, progress, self.Blocksize, self.MaxLine, chan1, scan_done, stats, self.watchdog, file.source, self.Follow && stream == nil, file.complete, strict, resume_at.Offset, resume_at.Members, members, offsets, nil, nil}
+	var timing *logTiming
+	if progress != nil {
+		timing = progress.timing
+	}
+	p := Parser{file.filter, limiter1, chan1, scan_done, self.MaxLine, strict, stats, self.watchdog, timing, file.split, offsets, func(out *[]byte, line string, ld filters.Linedata) {
+		if file.key != nil {
+			if self.seen.seen(file.key(line, ld)) {
+				stats.duplicates.Add(1)
+				return
+			}
+		}
+		emit(file, out, line, ld)
+	}, flush}
 
 	// start each of the worker functions on its own goroutine
 	go r.Start()
 	p.Start()
+
+	if stats.partial.Load() > 0 {
+		self.Logger.Warn("skipped incomplete last line", "file", fn)
+	}
+	if stats.oversized.Load() > 0 {
+		self.Logger.Warn("skipped lines longer than the maximum line length", "file", fn,
+			"lines", stats.oversized.Load(), "max_line", self.MaxLine)
+	}
+	self.Logger.Info("finished log", "file", fn, "elapsed", time.Since(start),
+		"lines", stats.lines.Load(), "matches", stats.matches.Load())
+	self.Logger.Debug("pipeline stats", "file", fn, "chunks", stats.chunks.Load(), "bytes", stats.bytes.Load(),
+		"workers", self.ParserPool, "peak_busy_workers", stats.peak.Load(), "rotations", stats.rotations.Load(),
+		"duplicates", stats.duplicates.Load(), "throttled", stats.throttled.Load())
+
+	self.totals.logs.Add(1)
+	self.totals.lines.Add(stats.lines.Load())
+	self.totals.bytes.Add(stats.bytes.Load())
+	self.totals.matches.Add(stats.matches.Load())
+	self.totals.filters.merge(&stats.filters)
+	log.Bytes += stats.bytes.Load()
+	log.Lines += stats.lines.Load()
+	log.Matches += stats.matches.Load()
+	if timing != nil {
+		timing.lines.Add(stats.lines.Load())
+		timing.matches.Add(stats.matches.Load())
+	}
+
+	if strict != nil {
+		if err := strict.err(); err != nil {
+			if r.rest != nil {
+				r.rest.Close()
+			}
+			return err
+		}
+		lineno = strict.read
+	}
+
+	// carry on with the log concatenated onto this one, from its header
+	if r.err == nil && r.rest != nil {
+		defer r.rest.Close()
+		return self.scanFrom(fn, r.rest, lineno, progress, log, done, emit, flush)
+	}
+
+	return r.err
+}
+
+//--------------------------------------------------------------------------------
+//	STREAMING RESULTS
+//--------------------------------------------------------------------------------
+
+/*
+	A single line that passed the filters, along with the names of the
+	fields it was split into and their values, with Zeek's escapes decoded
+*/
+type Record struct {
+	Filename string
+	Fields   []string
+	Values   []string
+	Line     string
+}
+
+/*
+	Returns the value of the named field, and whether or not the
+	record's header contained it
+*/
+func (self Record) Get(field string) (string, bool) {
+	for i, f := range self.Fields {
+		if f == field && i < len(self.Values) {
+			return self.Values[i], true
+		}
+	}
+
+	return "", false
+}
+
+/*
+	A single run of a Qreader over a set of logs whose matches are
+	delivered over a channel rather than printed
+*/
+type Scanner struct {
+	qreader  *Qreader
+	logs     []string
+	results  chan Record
+	done     chan struct{}
+	err      error
+	once     sync.Once
+	stopOnce sync.Once
+}
+
+/*
+	Prepares a scan of the given logs. Nothing is read until Results
+	is called
+*/
+func (self *Qreader) Scan(logs ...string) *Scanner {
+	return &Scanner{
+		qreader: self,
+		logs:    logs,
+		results: make(chan Record, chansize),
+		done:    make(chan struct{}),
+	}
+}
+
+/*
+	Starts the scan (on the first call) and returns a buffered channel of
+	matching records. The channel is closed once every log has been read
+	or an error stops the scan -- check Err after it is drained
+*/
+func (self *Scanner) Results() <-chan Record {
+	self.once.Do(func() {
+		go self.run()
+	})
+
+	return self.results
+}
+
+/*
+	Returns an iterator over the matching records, for use with range:
+
+		for rec, err := range scanner.Seq() { ... }
+
+	An error that stops the scan is yielded last with an empty Record.
+	Breaking out of the loop early stops the scan
+*/
+func (self *Scanner) Seq() iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		for rec := range self.Results() {
+			if !yield(rec, nil) {
+				self.Stop()
+				return
+			}
+		}
+
+		if err := self.Err(); err != nil {
+			yield(Record{}, err)
+		}
+	}
+}
+
+/*
+	Stops the scan early. The Results channel is closed shortly after,
+	possibly before every buffered match has been delivered
+*/
+func (self *Scanner) Stop() {
+	self.stopOnce.Do(func() {
+		close(self.done)
+	})
+}
+
+/*
+	Returns the error that stopped the scan, if any. Only meaningful once
+	the Results channel has been closed
+*/
+func (self *Scanner) Err() error {
+	return self.err
+}
+
+/*
+	Reads through each of the logs in turn, pushing matches onto the
+	results channel
+*/
+func (self *Scanner) run() {
+	defer close(self.results)
+
+	// stopping the Qreader stops its scans too
+	defer self.Stop()
+	go func() {
+		select {
+		case <-self.qreader.stopped:
+			self.Stop()
+		case <-self.done:
+		}
+	}()
+
+	for _, fn := range self.logs {
+		select {
+		case <-self.done:
+			return
+		default:
+		}
+
+		err := self.qreader.scan(fn, self.done, func(file *fileScan, _ *[]byte, line string, ld filters.Linedata) {
+			select {
+			case self.results <- Record{fn, file.header, ld, line}:
+			case <-self.done:
+			}
+		}, nil)
+		if err != nil {
+			self.err = err
+			return
+		}
+	}
 }
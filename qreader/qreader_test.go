@@ -0,0 +1,215 @@
+package qreader
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+	Writes a TSV log with the given path, fields and lines of data into
+	dir, with the header Zeek writes, and returns its name
+*/
+func writeLog(t *testing.T, dir string, path string, fields []string, types []string, data string) string {
+	t.Helper()
+
+	header := "#separator \\x09\n#set_separator\t,\n#empty_field\t(empty)\n#unset_field\t-\n" +
+		"#path\t" + path + "\n#open\t2024-05-01-00-00-00\n" +
+		"#fields\t" + strings.Join(fields, "\t") + "\n#types\t" + strings.Join(types, "\t") + "\n"
+
+	fn := filepath.Join(dir, path+".log")
+	if err := os.WriteFile(fn, []byte(header+data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn
+}
+
+/*
+	Runs the filters over the logs with one Qreader, returning the lines
+	it printed in order
+*/
+func parseAll(t *testing.T, filters []string, opts []Option, logs ...string) []string {
+	t.Helper()
+
+	var out bytes.Buffer
+	q, err := NewQreader(filters, append([]Option{WithUnzipper(UnzipperBuiltin), WithWriter(&out)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range logs {
+		if err := q.Parse(fn); err != nil {
+			t.Fatalf("Parse(%s): %s", fn, err)
+		}
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	return lines[:len(lines)-1]
+}
+
+/*
+	Each log is scanned by its own header, so filters and printed fields
+	find their columns in conn.log and dns.log alike, wherever they are
+*/
+func TestParseMixedLogTypes(t *testing.T) {
+	dir := t.TempDir()
+	conn := writeLog(t, dir, "conn",
+		[]string{"ts", "uid", "id.orig_h", "id.resp_h", "id.resp_p", "proto", "duration"},
+		[]string{"time", "string", "addr", "addr", "port", "enum", "interval"},
+		"1714521600.000000\tCconn1\t10.0.0.1\t10.0.0.53\t53\tudp\t0.1\n"+
+			"1714521601.000000\tCconn2\t10.0.0.1\t10.0.0.80\t80\ttcp\t2.5\n")
+	dns := writeLog(t, dir, "dns",
+		[]string{"ts", "proto", "id.resp_p", "query", "uid", "id.orig_h", "id.resp_h"},
+		[]string{"time", "enum", "port", "string", "string", "addr", "addr"},
+		"1714521600.000000\tudp\t53\texample.com\tCdns1\t10.0.0.1\t10.0.0.53\n"+
+			"1714521602.000000\ttcp\t53\texample.org\tCdns2\t10.0.0.1\t10.0.0.53\n")
+
+	got := parseAll(t, []string{"proto=udp"}, []Option{WithFields("uid", "id.resp_p")}, conn, dns)
+	want := []string{"Cconn1\t53", "Cdns1\t53"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+/*
+	Blank lines, including the one after a log's last newline, are
+	skipped rather than scanned as records
+*/
+func TestParseBlankLines(t *testing.T) {
+	fields := []string{"ts", "uid", "proto"}
+	types := []string{"time", "string", "enum"}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"trailing newline", "1.0\tC1\ttcp\n2.0\tC2\tudp\n"},
+		{"no trailing newline", "1.0\tC1\ttcp\n2.0\tC2\tudp"},
+		{"blank lines between", "1.0\tC1\ttcp\n\n\n2.0\tC2\tudp\n"},
+		{"blank lines at the end", "1.0\tC1\ttcp\n2.0\tC2\tudp\n\n\n"},
+		{"whitespace lines", "1.0\tC1\ttcp\n \t\n2.0\tC2\tudp\n\r\n"},
+		{"before the footer", "1.0\tC1\ttcp\n2.0\tC2\tudp\n\n#close\t2024-05-01-01-00-00\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := writeLog(t, t.TempDir(), "conn", fields, types, test.data)
+
+			got := parseAll(t, nil, []Option{WithFields("uid")}, fn)
+			if want := []string{"C1", "C2"}; !slices.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	// a log with nothing after its header has nothing to print
+	fn := writeLog(t, t.TempDir(), "conn", fields, types, "\n")
+	if got := parseAll(t, nil, nil, fn); len(got) != 0 {
+		t.Errorf("empty log printed %q", got)
+	}
+}
+
+/*
+	Lines several times longer than the blocksize are put back together
+	from the blocks they were read in, including one whose newline is the
+	last byte of a block and one whose newline is the first of the next
+*/
+func TestParseLongLines(t *testing.T) {
+	const bsize = 16
+	fields := []string{"ts", "uid", "query"}
+	types := []string{"time", "string", "string"}
+
+	// the log is read from its start, header and all, so the lines are
+	// laid out from where the header leaves off
+	dir := t.TempDir()
+	header, err := os.ReadFile(writeLog(t, dir, "dns", fields, types, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := len(header)
+
+	// the lines' newlines go anywhere in a block, at its end, then at
+	// the start of the next
+	tests := []struct {
+		uid string
+		at  int
+	}{
+		{"C1", -1},
+		{"C2", bsize - 1},
+		{"C3", 0},
+		{"C4", -1},
+	}
+
+	var data strings.Builder
+	var want []string
+	for i, test := range tests {
+		query := strings.Repeat("x", 3*bsize+i)
+		for test.at >= 0 && (offset+len("1.0\t"+test.uid+"\t"+query))%bsize != test.at {
+			query += "x"
+		}
+
+		line := "1.0\t" + test.uid + "\t" + query + "\n"
+		data.WriteString(line)
+		offset += len(line)
+		want = append(want, test.uid+"\t"+query)
+	}
+
+	fn := writeLog(t, dir, "dns", fields, types, data.String())
+	got := parseAll(t, nil, []Option{WithFields("uid", "query"), WithBlockSize(bsize)}, fn)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+/*
+	Only records count as lines scanned, not the header and footer
+*/
+func TestTotalsLines(t *testing.T) {
+	fn := writeLog(t, t.TempDir(), "conn",
+		[]string{"ts", "uid", "proto"},
+		[]string{"time", "string", "enum"},
+		"1.0\tC1\ttcp\n2.0\tC2\tudp\n\n3.0\tC3\ttcp\n#close\t2024-05-01-01-00-00\n")
+
+	q, err := NewQreader([]string{"proto=tcp"}, WithUnzipper(UnzipperBuiltin), WithWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Parse(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	if totals := q.Totals(); totals.Lines != 3 || totals.Matches != 2 {
+		t.Errorf("got %d lines and %d matches, want 3 and 2", totals.Lines, totals.Matches)
+	}
+}
+
+/*
+	Merged matches are written through the output queue in order of their
+	ts, across the logs, all by the time ParseMerged returns
+*/
+func TestParseMerged(t *testing.T) {
+	dir := t.TempDir()
+	fields := []string{"ts", "uid", "proto"}
+	types := []string{"time", "string", "enum"}
+	conn := writeLog(t, dir, "conn", fields, types, "1.0\tC1\ttcp\n3.0\tC3\ttcp\n5.0\tC5\ttcp\n")
+	dns := writeLog(t, dir, "dns", fields, types, "2.0\tD2\tudp\n4.0\tD4\tudp\n")
+
+	var out bytes.Buffer
+	q, err := NewQreader(nil, WithUnzipper(UnzipperBuiltin), WithWriter(&out), WithFields("uid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range q.ParseMerged(conn, dns) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := out.String(), "C1\nD2\nC3\nD4\nC5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package qreader
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//--------------------------------------------------------------------------------
+//	SANITIZING OUTPUT
+//--------------------------------------------------------------------------------
+
+/*
+	Ways of printing the control characters and stray bytes that fields
+	can carry, e.g. in a user agent or DNS query crafted to send escape
+	sequences to the analyst's terminal. Zeek escapes them in its TSV
+	logs, but JSON logs, CSV exports and other tools' logs may not
+*/
+const (
+	SanitizeEscape string = "escape"
+	SanitizeStrip  string = "strip"
+	SanitizeNone   string = "none"
+)
+
+/*
+	Returns the value with control characters, including carriage returns
+	and the C1 controls, and bytes that aren't valid UTF-8 escaped as Zeek
+	escapes them (\x1b) or stripped, depending on the mode. Characters in
+	keep, such as the separator of a whole line, are left alone
+*/
+func Sanitize(mode string, value string, keep string) string {
+	if mode == SanitizeNone || safe(value, keep) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if (r == utf8.RuneError && size == 1) || (unsafe(r) && !strings.ContainsRune(keep, r)) {
+			if mode == SanitizeEscape {
+				for _, c := range []byte(value[i : i+size]) {
+					fmt.Fprintf(&b, "\\x%02x", c)
+				}
+			}
+		} else {
+			b.WriteString(value[i : i+size])
+		}
+		i += size
+	}
+
+	return b.String()
+}
+
+/*
+	Reports whether a value has nothing to sanitize, checking printable
+	ASCII quickly since nearly every value is
+*/
+func safe(value string, keep string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= utf8.RuneSelf {
+			return utf8.ValidString(value[i:]) && !strings.ContainsFunc(value[i:], func(r rune) bool {
+				return unsafe(r) && !strings.ContainsRune(keep, r)
+			})
+		}
+		if unsafe(rune(c)) && strings.IndexByte(keep, c) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* control characters, which terminals act on rather than print */
+func unsafe(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
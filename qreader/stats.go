@@ -0,0 +1,127 @@
+package qreader
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	STATS
+//--------------------------------------------------------------------------------
+
+/*
+	What a Qreader has scanned so far, for programs embedding it to keep
+	as metrics. Bytes, Lines and Matches are added up over the logs as
+	Totals has them. Filters has the hits of each filter, in the order
+	they were given, and Logs what was scanned of each log, in the order
+	they finished
+*/
+type Stats struct {
+	Bytes   int64
+	Lines   int64
+	Matches int64
+	Filters []FilterStats
+	Logs    []LogStats
+}
+
+/*
+	How many lines a filter was run on, and how many of those it passed.
+	The filters are run on each line in order until one fails it, so
+	each is run only on the lines that passed the one before. Rule is ""
+	for filters built in Go code
+*/
+type FilterStats struct {
+	Rule   string
+	Tested int64
+	Hits   int64
+}
+
+/*
+	What was scanned of a single log, and how long it took from opening
+	it to the last of its matches being written out. The members of a
+	tar archive are each a log of their own
+*/
+type LogStats struct {
+	File    string
+	Elapsed time.Duration
+	Bytes   int64
+	Lines   int64
+	Matches int64
+}
+
+/*
+	Lines run through the filters, and how many passed each of them.
+	Parser workers add theirs once for each chunk
+*/
+type filterCounts struct {
+	lock     sync.Mutex
+	filtered int64
+	passed   []int64
+}
+
+func (self *filterCounts) add(filtered int64, passed []int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.passed == nil {
+		self.passed = make([]int64, len(passed))
+	}
+	self.filtered += filtered
+	for i, n := range passed {
+		self.passed[i] += n
+	}
+}
+
+func (self *filterCounts) merge(other *filterCounts) {
+	other.lock.Lock()
+	defer other.lock.Unlock()
+
+	self.add(other.filtered, other.passed)
+}
+
+/*
+	Stats of every log scanned, in the order they finished
+*/
+type scanned struct {
+	lock sync.Mutex
+	logs []LogStats
+}
+
+/*
+	Records the stats of a log once it has been scanned, whether or not
+	it could be scanned through
+*/
+func (self *Qreader) recordLog(log *LogStats, started time.Time) {
+	log.Elapsed = time.Since(started)
+
+	self.scanned.lock.Lock()
+	defer self.scanned.lock.Unlock()
+	self.scanned.logs = append(self.scanned.logs, *log)
+}
+
+/*
+	Returns what has been scanned so far, over every log and of each.
+	Logs still being scanned count towards none of it until they finish
+*/
+func (self *Qreader) Stats() Stats {
+	totals := self.Totals()
+	stats := Stats{Bytes: totals.Bytes, Lines: totals.Lines, Matches: totals.Matches}
+
+	self.totals.filters.lock.Lock()
+	tested := self.totals.filters.filtered
+	for i, rule := range self.Filter.Rules() {
+		hits := int64(0)
+		if i < len(self.totals.filters.passed) {
+			hits = self.totals.filters.passed[i]
+		}
+		stats.Filters = append(stats.Filters, FilterStats{rule, tested, hits})
+		tested = hits
+	}
+	self.totals.filters.lock.Unlock()
+
+	self.scanned.lock.Lock()
+	defer self.scanned.lock.Unlock()
+	stats.Logs = append([]LogStats{}, self.scanned.logs...)
+
+	return stats
+}
@@ -0,0 +1,198 @@
+package qreader
+
+import (
+	"bro-awk/filters"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	STRICT SCANS
+//--------------------------------------------------------------------------------
+
+/*
+	A line that doesn't fit its log's header, which ends a strict scan.
+	Field is empty when the line as a whole is malformed
+*/
+type InvalidLineError struct {
+	File   string
+	Line   int
+	Field  string
+	Reason string
+}
+
+func (self *InvalidLineError) Error() string {
+	if self.Field == "" {
+		return fmt.Sprintf("%s:%d: %s", self.File, self.Line, self.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s %s", self.File, self.Line, self.Field, self.Reason)
+}
+
+/*
+	How far the reader and parsers of a strict scan of one log have got,
+	in lines, and the first invalid line they found. Closing found stops
+	the scan
+*/
+type strictScan struct {
+	filename string
+	check    func(line string) (string, string)
+	read     int
+	parsed   int
+	found    chan struct{}
+	once     sync.Once
+	lock     sync.Mutex
+	invalid  *InvalidLineError
+}
+
+/*
+	Starts a strict scan of a log whose lines check validates, numbering
+	them on from the given line
+*/
+func newStrictScan(fn string, check func(line string) (string, string), line int) *strictScan {
+	return &strictScan{filename: fn, check: check, read: line, parsed: line, found: make(chan struct{})}
+}
+
+/*
+	Records an invalid line, keeping the earliest since chunks are parsed
+	out of order, and stops the scan
+*/
+func (self *strictScan) fail(line int, field string, reason string) {
+	self.lock.Lock()
+	if self.invalid == nil || line < self.invalid.Line {
+		self.invalid = &InvalidLineError{self.filename, line, field, reason}
+	}
+	self.lock.Unlock()
+
+	self.once.Do(func() {
+		close(self.found)
+	})
+}
+
+/*
+	Returns the first invalid line found, if any
+*/
+func (self *strictScan) err() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.invalid == nil {
+		return nil
+	}
+
+	return self.invalid
+}
+
+/* number of lines in a chunk, which never ends in the newline of its last line */
+func linesIn(chunk []byte) int {
+	return bytes.Count(chunk, []byte("\n")) + 1
+}
+
+/*
+	Returns a channel closed once either of the given ones is, and a
+	function that lets go of both once the channel is no longer needed
+*/
+func either(a <-chan struct{}, b <-chan struct{}) (<-chan struct{}, func()) {
+	both := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-a:
+			close(both)
+		case <-b:
+			close(both)
+		case <-finished:
+		}
+	}()
+
+	return both, func() {
+		close(finished)
+	}
+}
+
+/*
+	Returns the check a strict scan makes of each line of the log: that it
+	has as many fields as the header declares (JSON records need only be
+	whole objects, since Zeek leaves unset fields out), and that each
+	value is of the field's type. Returns the offending field, if there is
+	one, and why the line is invalid
+*/
+func strictChecker(header *Header, split func(line string) filters.Linedata) func(line string) (string, string) {
+	fields := header.Fields
+	types := header.FieldTypes()
+
+	return func(line string) (string, string) {
+		if header.Format == FormatJSON {
+			if !json.Valid([]byte(line)) || line[0] != '{' {
+				return "", "isn't a JSON object"
+			}
+		}
+
+		values := split(line)
+		if header.Format != FormatJSON && len(values) != len(fields) {
+			return "", fmt.Sprintf("has %d fields where the header declares %d", len(values), len(fields))
+		}
+
+		for idx, value := range values[:min(len(values), len(fields))] {
+			if value == header.UnsetField {
+				continue
+			}
+			if t := types[fields[idx]]; !validValue(t, value, header) {
+				return fields[idx], fmt.Sprintf("%q isn't a valid %s", value, t)
+			}
+		}
+
+		return "", ""
+	}
+}
+
+/*
+	Reports whether a value is one Zeek could have written for a field of
+	the given type. Types it can't check, such as string, always pass
+*/
+func validValue(type_name string, value string, header *Header) bool {
+	// sets and vectors are checked element by element
+	for _, container := range []string{"set[", "vector["} {
+		if element, ok := strings.CutPrefix(type_name, container); ok {
+			if value == header.EmptyField {
+				return true
+			}
+			element = strings.TrimSuffix(element, "]")
+			for _, item := range strings.Split(value, header.SetSeparator) {
+				if !validValue(element, item, header) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	var err error
+	switch type_name {
+	case "bool":
+		return value == "T" || value == "F"
+	case "count":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "int":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "port":
+		_, err = strconv.ParseUint(value, 10, 16)
+	case "double", "interval":
+		_, err = strconv.ParseFloat(value, 64)
+	case "time":
+		// JSON logs may be written with ISO 8601 timestamps
+		if _, err = strconv.ParseFloat(value, 64); err != nil {
+			_, err = time.Parse(time.RFC3339Nano, value)
+		}
+	case "addr":
+		_, err = netip.ParseAddr(value)
+	case "subnet":
+		_, err = netip.ParsePrefix(value)
+	}
+
+	return err == nil
+}
@@ -0,0 +1,109 @@
+package qreader
+
+import (
+	"archive/tar"
+	"bro-awk/filters"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	TAR ARCHIVES
+//--------------------------------------------------------------------------------
+
+/* names of tar archives, compressed or not */
+var tar_re *regexp.Regexp = regexp.MustCompile(`\.(?:tar|tar\.gz|tgz)$`)
+
+/* names of the archive members that are scanned */
+var member_re *regexp.Regexp = regexp.MustCompile(`\.(?:log|json|ndjson|csv)(?:\.gz)?$`)
+
+/*
+	Reports whether the log is a tar archive of logs, such as a bundle
+	exported from a sensor
+*/
+func isTar(fn string) bool {
+	return tar_re.MatchString(fn)
+}
+
+/*
+	Scans each log in a tar archive in turn as the archive is read, without
+	unpacking it. Each member is named after the archive and its path in it,
+	e.g. bundle.tar.gz/2024-05-01/conn.00:00:00-01:00:00.log.gz, and is
+	read with its own header. The archive, and the time spent on each log in
+	it, count towards the given progress. A resumed scan passes over the
+	members its checkpoint says were scanned in full
+*/
+func (self *Qreader) scanTar(fn string, progress *logProgress, done <-chan struct{}, emit func(file *fileScan, out *[]byte, line string, ld filters.Linedata), flush func(out []byte)) error {
+	opener := Reader{filename: fn, unzipper: self.Unzipper, verify: self.Verify, progress: progress}
+	reader, err := opener.GetReader()
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// .tgz and remote archives aren't decompressed by name, so go by the
+	// gzip magic number
+	buffered := bufio.NewReader(reader)
+	var archive_reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		pipe, err := opener.unzip(buffered)
+		if err != nil {
+			return err
+		}
+		defer pipe.Close()
+		archive_reader = pipe
+	}
+
+	archive := tar.NewReader(archive_reader)
+	for index := 0; ; index++ {
+		member, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read %s: %w", fn, err)
+		}
+
+		if member.Typeflag != tar.TypeReg || !member_re.MatchString(member.Name) || index < self.Resume[fn].Members {
+			continue
+		}
+
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		name := fn + "/" + strings.TrimPrefix(member.Name, "./")
+		var source io.Reader = archive
+		if strings.HasSuffix(member.Name, ".gz") {
+			pipe, err := Reader{filename: name, unzipper: self.Unzipper, verify: self.Verify}.unzip(archive)
+			if err != nil {
+				return err
+			}
+			source = pipe
+		}
+		log := &LogStats{File: name}
+		started := time.Now()
+		err = self.scanFrom(name, source, 0, progress, log, done, emit, flush)
+		self.recordLog(log, started)
+		if err != nil {
+			return err
+		}
+
+		// a member the scan was stopped partway through is scanned again
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if self.Checkpoints != nil {
+			self.Checkpoints(fn, Checkpoint{Members: index + 1})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package qreader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	TIMING
+//--------------------------------------------------------------------------------
+
+/*
+	Where the time went in scanning a log, with WithTiming. Wall is how
+	long it took from start to finish. Reading is how long was spent
+	waiting on the log for data, decompressed if need be, and Storage how
+	much of that went on reading the log as it is stored, so that whatever
+	is left went on decompressing it. An Unzipper is fed the log while it
+	decompresses, so the two can overlap. Splitting, Filtering and Writing
+	are the time the parser workers spent splitting lines into fields,
+	running the filters over them, and writing out the matches, added up
+	over the workers, which run at the same time
+*/
+type LogTiming struct {
+	File      string
+	Wall      time.Duration
+	Reading   time.Duration
+	Storage   time.Duration
+	Splitting time.Duration
+	Filtering time.Duration
+	Writing   time.Duration
+	Lines     int64
+	Matches   int64
+}
+
+/*
+	Timings of a log being scanned, added to as it goes
+*/
+type logTiming struct {
+	reading   atomic.Int64
+	storage   atomic.Int64
+	splitting atomic.Int64
+	filtering atomic.Int64
+	writing   atomic.Int64
+	lines     atomic.Int64
+	matches   atomic.Int64
+}
+
+/*
+	Adds the time since start to the given timing, returning now so that
+	the next step can be timed on from it
+*/
+func lap(timing *atomic.Int64, start time.Time) time.Time {
+	now := time.Now()
+	timing.Add(int64(now.Sub(start)))
+	return now
+}
+
+/*
+	Timings of every log scanned, in the order they finished
+*/
+type timings struct {
+	lock sync.Mutex
+	logs []LogTiming
+}
+
+/*
+	Records the timings of a log once it has been scanned
+*/
+func (self *Qreader) recordTiming(progress *logProgress) {
+	if progress == nil || progress.timing == nil {
+		return
+	}
+
+	t := progress.timing
+	self.timings.lock.Lock()
+	defer self.timings.lock.Unlock()
+	self.timings.logs = append(self.timings.logs, LogTiming{
+		File:      progress.file,
+		Wall:      time.Since(progress.started),
+		Reading:   time.Duration(t.reading.Load()),
+		Storage:   time.Duration(t.storage.Load()),
+		Splitting: time.Duration(t.splitting.Load()),
+		Filtering: time.Duration(t.filtering.Load()),
+		Writing:   time.Duration(t.writing.Load()),
+		Lines:     t.lines.Load(),
+		Matches:   t.matches.Load(),
+	})
+}
+
+/*
+	Returns the timings of each log scanned so far, whether or not it
+	could be scanned through. Nothing is returned unless WithTiming was
+	given
+*/
+func (self *Qreader) Timings() []LogTiming {
+	self.timings.lock.Lock()
+	defer self.timings.lock.Unlock()
+
+	return append([]LogTiming{}, self.timings.logs...)
+}
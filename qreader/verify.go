@@ -0,0 +1,177 @@
+package qreader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//--------------------------------------------------------------------------------
+//	VERIFIED ARCHIVES
+//--------------------------------------------------------------------------------
+
+/*
+	A compressed log that failed verification, along with the member of
+	it that was damaged, counting from 1, and where that member starts
+*/
+type CorruptArchiveError struct {
+	File   string
+	Member int
+	Offset int64
+	Err    error
+}
+
+func (self *CorruptArchiveError) Error() string {
+	return fmt.Sprintf("%s is corrupt: member %d (at byte %d) %s", self.File, self.Member, self.Offset, corruption(self.Err))
+}
+
+func (self *CorruptArchiveError) Unwrap() error {
+	return self.Err
+}
+
+/* describes what is wrong with a damaged member */
+func corruption(err error) string {
+	var flate_err flate.CorruptInputError
+
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "is cut short"
+	case errors.Is(err, gzip.ErrChecksum):
+		return "doesn't match its checksum"
+	case errors.Is(err, gzip.ErrHeader):
+		return "has no gzip header"
+	case errors.As(err, &flate_err):
+		return "has corrupt compressed data"
+	}
+
+	return err.Error()
+}
+
+/*
+	A reader that keeps count of how far into the stream it has read, so
+	that a damaged member can be found. Being an io.ByteReader, the gzip
+	reader reads from it directly rather than reading ahead
+*/
+type countingReader struct {
+	*bufio.Reader
+	offset int64
+}
+
+func (self *countingReader) Read(p []byte) (int, error) {
+	n, err := self.Reader.Read(p)
+	self.offset += int64(n)
+	return n, err
+}
+
+func (self *countingReader) ReadByte() (byte, error) {
+	b, err := self.Reader.ReadByte()
+	if err == nil {
+		self.offset++
+	}
+	return b, err
+}
+
+/*
+	A gzip stream decompressed in process rather than by the Unzipper, so
+	that the CRC-32 and length ending each of its members are checked as
+	it streams. The Unzipper may stop early at damage in an archive and
+	still exit cleanly (or be one that never checks), leaving a log that
+	simply looks shorter than it was
+*/
+type verifiedGzip struct {
+	filename string
+	source   *countingReader
+	closer   io.Closer
+	gz       *gzip.Reader
+	member   int
+	start    int64
+	err      error
+	members  *gzipMembers
+	out      int64
+	last     byte
+}
+
+/*
+	Starts decompressing a gzip stream, failing at once if it doesn't
+	start with a gzip member
+*/
+func verifyGzip(fn string, source io.Reader) (*verifiedGzip, error) {
+	return resumeGzip(fn, source, Checkpoint{}, nil)
+}
+
+/*
+	Like verifyGzip, for a stream that starts with the member after those
+	the checkpoint passed over, at the byte of the log it gives. The end
+	of each member is noted in members, if given
+*/
+func resumeGzip(fn string, source io.Reader, at Checkpoint, members *gzipMembers) (*verifiedGzip, error) {
+	self := &verifiedGzip{filename: fn, source: &countingReader{Reader: bufio.NewReader(source), offset: at.Offset}, member: at.Members + 1, start: at.Offset, members: members}
+	if closer, ok := source.(io.Closer); ok {
+		self.closer = closer
+	}
+
+	gz, err := gzip.NewReader(self.source)
+	if err != nil {
+		self.Close()
+		return nil, &CorruptArchiveError{fn, self.member, self.start, err}
+	}
+	gz.Multistream(false)
+	self.gz = gz
+
+	return self, nil
+}
+
+func (self *verifiedGzip) Read(p []byte) (int, error) {
+	if self.err != nil {
+		return 0, self.err
+	}
+
+	for {
+		n, err := self.gz.Read(p)
+		if n > 0 {
+			self.out += int64(n)
+			self.last = p[n-1]
+		}
+		if err == nil {
+			return n, nil
+		} else if err != io.EOF {
+			self.err = &CorruptArchiveError{self.filename, self.member, self.start, err}
+			return n, self.err
+		}
+
+		// the member checked out, so carry on with the next one, if any.
+		// Logs are often concatenated archives, e.g. from rotation
+		if _, err := self.source.Peek(1); err == io.EOF {
+			self.err = io.EOF
+			return n, io.EOF
+		}
+		self.member++
+		self.start = self.source.offset
+		if self.members != nil && self.last == '\n' {
+			self.members.ended(self.out, Checkpoint{Offset: self.start, Members: self.member - 1})
+		}
+		if err := self.gz.Reset(self.source); err != nil {
+			self.err = &CorruptArchiveError{self.filename, self.member, self.start, err}
+			return n, self.err
+		}
+		self.gz.Multistream(false)
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+/*
+	Hangs up on the stream being decompressed, if it can be
+*/
+func (self *verifiedGzip) Close() error {
+	if self.closer == nil {
+		return nil
+	}
+
+	return self.closer.Close()
+}
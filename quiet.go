@@ -0,0 +1,77 @@
+/*
+	Description:
+		Implements grep's `-q`, for scripts that only need to know whether
+		anything matches at all, e.g. whether an indicator has ever been
+		seen. Nothing is printed, and the scan stops at the first match
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"os"
+	"slices"
+	"sync/atomic"
+)
+
+var quiet *bool = flagset.Bool("q", false, "")
+
+/* exit statuses with -q, as grep's: 0 for a match, 1 for none and 2 for an error */
+const exit_no_match int = 1
+const exit_quiet_error int = 2
+
+/*
+	Takes the place of the output with -q, stopping the scan as soon as
+	anything is written to it
+*/
+type first_match struct {
+	q     *qreader.Qreader
+	found atomic.Bool
+}
+
+func (self *first_match) Write(p []byte) (int, error) {
+	if !self.found.Swap(true) {
+		self.q.Stop()
+	}
+
+	return len(p), nil
+}
+
+/*
+	Scans the logs, or follows them with --follow, until the first match,
+	and exits with whether there was one. Stopping the Qreader stops every
+	log being read and any decompressors reading them. Logs that couldn't
+	be scanned only count against a scan that found nothing, as with grep
+*/
+func scan_quietly(filters []string, opts []qreader.Option, logs []string) {
+	w := &first_match{}
+	q, err := qreader.NewQreader(filters, slices.Concat(opts, []qreader.Option{qreader.WithWriter(w)})...)
+	if err != nil {
+		fail_with(err)
+	}
+	w.q = q
+	handle_interrupts(q)
+
+	var failures []failed_log
+	if *follow {
+		failures = follow_logs(q, logs)
+	} else {
+		for i, log := range logs {
+			if q.Stopped() {
+				break
+			}
+			if err := q.Parse(log); err != nil && !q.Stopped() {
+				failures = append(failures, log_failed(log, err, i < len(logs)-1))
+			}
+		}
+	}
+
+	if w.found.Load() {
+		os.Exit(0)
+	}
+	exit_if_interrupted(q)
+	if len(failures) > 0 {
+		fail_logs(failures, len(logs))
+	}
+	os.Exit(exit_no_match)
+}
@@ -0,0 +1,164 @@
+/*
+	Description:
+		Implements `--rate-limit`, which keeps a query run with --follow or
+		--watch, or over streamed logs such as kafka:// topics, from
+		printing (and alerting on) more than so many matches a second,
+		minute or whatever is given, so that whatever reads them isn't
+		overwhelmed by a storm of matches. Matches over the limit wait
+		their turn, or with `--rate-limit-policy drop` are thrown away:
+
+			bro-awk --follow --rate-limit 1000/s 'query~evil' /var/log/zeek/current/dns.log | logger
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rate_limit *string = flagset.String("rate-limit", "", "")
+var rate_policy *string = flagset.String("rate-limit-policy", "block", "")
+
+/* how often matches being dropped over the limit is warned of */
+const rate_warn_every time.Duration = 10 * time.Second
+
+/* longest a match waiting its turn goes without seeing whether the scan was stopped */
+const rate_poll time.Duration = 100 * time.Millisecond
+
+/*
+	Takes the place of the output when rate limiting, passing matches on
+	to out no faster than the limit. The limit is a bucket of as many
+	matches as it allows at a time, refilled evenly as time goes by, so a
+	quiet spell lets a burst of that many straight through. Once stopped
+	reports the scan was stopped, matches no longer wait but are dropped
+*/
+type rate_limiter struct {
+	out     io.Writer
+	limit   int
+	per     time.Duration
+	drop    bool
+	logger  *slog.Logger
+	stopped func() bool
+	lock    sync.Mutex
+	tokens  float64
+	last    time.Time
+	dropped int
+	warned  time.Time
+}
+
+func new_rate_limiter(out io.Writer, limit int, per time.Duration, drop bool, logger *slog.Logger) *rate_limiter {
+	now := time.Now()
+	return &rate_limiter{out: out, limit: limit, per: per, drop: drop, logger: logger, tokens: float64(limit), last: now, warned: now}
+}
+
+func (self *rate_limiter) Write(p []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// the Qreader writes its matches many at a time, a line each, passing
+	// on those that are let through together
+	written := len(p)
+	allowed := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+
+		self.refill()
+		if self.tokens < 1 {
+			if self.drop {
+				self.dropped++
+				if time.Since(self.warned) >= rate_warn_every {
+					self.warn()
+				}
+				continue
+			}
+
+			// waiting here holds up the scan until the match can go, unless
+			// it is stopped meanwhile, so those already let through go first
+			if len(allowed) > 0 {
+				if _, err := self.out.Write(allowed); err != nil {
+					return 0, err
+				}
+				allowed = allowed[:0]
+			}
+			for self.tokens < 1 {
+				if self.stopped != nil && self.stopped() {
+					return written, nil
+				}
+				time.Sleep(min(time.Duration((1-self.tokens)*float64(self.per)/float64(self.limit)), rate_poll))
+				self.refill()
+			}
+		}
+		self.tokens = max(self.tokens-1, 0)
+		allowed = append(allowed, line...)
+	}
+
+	if len(allowed) > 0 {
+		if _, err := self.out.Write(allowed); err != nil {
+			return 0, err
+		}
+	}
+	return written, nil
+}
+
+/* adds the matches allowed since the bucket was last refilled */
+func (self *rate_limiter) refill() {
+	now := time.Now()
+	self.tokens = min(self.tokens+float64(now.Sub(self.last))*float64(self.limit)/float64(self.per), float64(self.limit))
+	self.last = now
+}
+
+/* warns of the matches dropped since the last warning */
+func (self *rate_limiter) warn() {
+	self.logger.Warn("dropped matches over --rate-limit", "dropped", self.dropped, "since", self.warned.Format(time.TimeOnly))
+	self.dropped = 0
+	self.warned = time.Now()
+}
+
+/*
+	Warns of any matches dropped since the last warning, once the scan is
+	over
+*/
+func (self *rate_limiter) close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.dropped > 0 {
+		self.warn()
+	}
+}
+
+/*
+	Reads a rate limit such as 1000/s: so many matches per second (s),
+	minute (m) or hour (h), or per any duration, e.g. 500/10s
+*/
+func parse_rate(rate string) (int, time.Duration, error) {
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("--rate-limit takes a number of matches and a unit of time, such as 1000/s, not %s", rate)
+	}
+
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 1 {
+		return 0, 0, fmt.Errorf("--rate-limit must allow at least 1 match, not %s", count)
+	}
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+		unit = "1" + unit
+	}
+	per, err := time.ParseDuration(unit)
+	if err != nil || per <= 0 {
+		return 0, 0, fmt.Errorf("--rate-limit needs a unit of time such as s, m, h or 10s, not %s", rate)
+	}
+
+	return limit, per, nil
+}
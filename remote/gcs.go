@@ -0,0 +1,202 @@
+package remote
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	GOOGLE CLOUD STORAGE
+//--------------------------------------------------------------------------------
+
+/*
+	Opens gs://bucket/object URIs. Requests are authorized with the first
+	of these that is set:
+
+		GOOGLE_OAUTH_ACCESS_TOKEN	an access token, e.g. from `gcloud auth print-access-token`
+		GOOGLE_APPLICATION_CREDENTIALS	a service account key file
+
+	Without either, objects are requested anonymously. STORAGE_EMULATOR_HOST
+	points at a local emulator instead of Google
+*/
+func openGCS(uri *url.URL) (io.ReadCloser, error) {
+	bucket := uri.Host
+	object := strings.TrimPrefix(uri.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("%s should look like gs://bucket/object", uri)
+	}
+
+	base := "https://storage.googleapis.com"
+	if emulator := os.Getenv("STORAGE_EMULATOR_HOST"); emulator != "" {
+		base = strings.TrimSuffix(emulator, "/")
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+	}
+
+	// the object name is a single path segment, slashes and all
+	location := base + "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object) + "?alt=media"
+
+	return newRangedReader(uri.String(), func(ctx context.Context, start int64, end int64) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+		token, err := gcs_tokens.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+	}), nil
+}
+
+func init() {
+	Register("gs", openGCS)
+}
+
+//--------------------------------------------------------------------------------
+//	GOOGLE ACCESS TOKENS
+//--------------------------------------------------------------------------------
+
+/*
+	Hands out access tokens, fetching a new one from Google with the
+	service account key whenever the last has expired
+*/
+type tokenSource struct {
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var gcs_tokens *tokenSource = &tokenSource{}
+
+/* the fields of a service account key file that are needed */
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func (self *tokenSource) get(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	key_file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if key_file == "" {
+		return "", nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// leave a minute to spare so that a token doesn't expire mid-request
+	if self.token != "" && time.Now().Add(time.Minute).Before(self.expires) {
+		return self.token, nil
+	}
+
+	raw, err := os.ReadFile(key_file)
+	if err != nil {
+		return "", err
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(raw, &account); err != nil {
+		return "", fmt.Errorf("bad service account key %s: %w", key_file, err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	assertion, err := account.assertion(time.Now())
+	if err != nil {
+		return "", fmt.Errorf("bad service account key %s: %w", key_file, err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{account.TokenURI, resp.Status, resp.StatusCode}
+	}
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return "", err
+	}
+
+	self.token = grant.AccessToken
+	self.expires = time.Now().Add(time.Duration(grant.ExpiresIn) * time.Second)
+	return self.token, nil
+}
+
+/*
+	Builds the signed JWT that is traded for an access token
+*/
+func (self serviceAccount) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(self.PrivateKey))
+	if block == nil {
+		return "", errors.New("no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("private key is not an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   self.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/devstorage.read_only",
+		"aud":   self.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
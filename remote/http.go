@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+//--------------------------------------------------------------------------------
+//	HTTP(S)
+//--------------------------------------------------------------------------------
+
+/* extra headers sent with every http:// and https:// request, e.g. Authorization */
+var Headers http.Header = make(http.Header)
+
+/*
+	Opens http:// and https:// URLs, such as logs served by an artifact
+	store. Servers that don't support ranged requests are read in one go
+*/
+func openHTTP(uri *url.URL) (io.ReadCloser, error) {
+	location := uri.String()
+
+	return newRangedReader(location, func(ctx context.Context, start int64, end int64) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = Headers.Clone()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		return req, nil
+	}), nil
+}
+
+func init() {
+	Register("http", openHTTP)
+	Register("https", openHTTP)
+}
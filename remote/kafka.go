@@ -0,0 +1,87 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	KAFKA
+//--------------------------------------------------------------------------------
+
+/*
+	Program used to consume Kafka topics, found on the PATH as kcat (or its
+	old name, kafkacat) if not set
+*/
+var Kcat string = ""
+
+/*
+	Consumes kafka://broker1:9092,broker2:9092/topic URIs through kcat,
+	one log line per message, for as long as the topic is read. Options
+	are given as query parameters:
+
+		group=NAME	consume as part of a consumer group, committing offsets
+		offset=WHERE	where to start: beginning, end, stored or an offset
+		path=TYPE	log type of TSV messages, when it isn't the topic name
+
+	Messages that are Zeek JSON need nothing more. TSV messages don't carry
+	a header, so the fields of their log type are taken from the schema
+	registry
+*/
+func openKafka(uri *url.URL) (io.ReadCloser, error) {
+	brokers := uri.Host
+	topic := strings.TrimPrefix(uri.Path, "/")
+	if brokers == "" || topic == "" {
+		return nil, fmt.Errorf("%s should look like kafka://broker:9092/topic", uri)
+	}
+
+	program := Kcat
+	if program == "" {
+		for _, name := range []string{"kcat", "kafkacat"} {
+			if found, err := exec.LookPath(name); err == nil {
+				program = found
+				break
+			}
+		}
+	}
+	if program == "" {
+		return nil, fmt.Errorf("reading %s needs kcat, which isn't installed", uri)
+	}
+
+	query := uri.Query()
+	args := []string{"-b", brokers, "-q", "-u", "-f", "%s\n"}
+	if group := query.Get("group"); group != "" {
+		args = append(args, "-G", group, topic)
+	} else {
+		args = append(args, "-C", "-t", topic)
+		if offset := query.Get("offset"); offset != "" {
+			args = append(args, "-o", offset)
+		}
+	}
+
+	c := exec.Command(program, args...)
+	c.Stderr = os.Stderr
+	pipe, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	consumer := &command{pipe, c}
+
+	// messages with no header of their own get the one for their log type
+	path := query.Get("path")
+	if path == "" {
+		path = topic
+	}
+	return withHeader(uri, path, consumer)
+}
+
+func init() {
+	RegisterStream("kafka", openKafka)
+}
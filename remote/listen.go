@@ -0,0 +1,159 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	LISTENERS
+//--------------------------------------------------------------------------------
+
+/*
+	Listens on tcp://HOST:PORT or udp://HOST:PORT for Zeek log lines sent
+	by rsyslog, syslog-ng, Vector and the like, until stopped. Over TCP
+	each line is a message, over UDP each datagram holds one or more
+	lines. The syslog header that forwarders put in front of each line is
+	removed. As with Kafka, ?path=TYPE gives the log type of TSV lines
+*/
+func openListener(uri *url.URL) (io.ReadCloser, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("%s should look like %s://:5514", uri, uri.Scheme)
+	}
+
+	reader, writer := io.Pipe()
+	l := &listener{reader: reader, writer: writer}
+
+	switch uri.Scheme {
+	case "tcp":
+		ln, err := net.Listen("tcp", uri.Host)
+		if err != nil {
+			return nil, err
+		}
+		l.socket = ln
+		go l.acceptTCP(ln)
+	case "udp":
+		conn, err := net.ListenPacket("udp", uri.Host)
+		if err != nil {
+			return nil, err
+		}
+		l.socket = conn
+		go l.readUDP(conn)
+	}
+
+	return withHeader(uri, uri.Query().Get("path"), l)
+}
+
+func init() {
+	RegisterStream("tcp", openListener)
+	RegisterStream("udp", openListener)
+}
+
+/*
+	Lines received from every sender, merged into a single stream
+*/
+type listener struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	socket io.Closer
+	lock   sync.Mutex
+}
+
+func (self *listener) Read(p []byte) (int, error) {
+	return self.reader.Read(p)
+}
+
+func (self *listener) Close() error {
+	self.socket.Close()
+	self.writer.Close()
+	return self.reader.Close()
+}
+
+/*
+	Passes on a single line, whole, so that lines from different senders
+	never interleave
+*/
+func (self *listener) write(line []byte) {
+	line = stripSyslog(line)
+	if len(line) == 0 {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.writer.Write(append(line, '\n'))
+}
+
+func (self *listener) acceptTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			self.writer.CloseWithError(err)
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				self.write(scanner.Bytes())
+			}
+		}()
+	}
+}
+
+func (self *listener) readUDP(conn net.PacketConn) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			self.writer.CloseWithError(err)
+			return
+		}
+
+		for line := range bytes.SplitSeq(buffer[:n], []byte("\n")) {
+			self.write(line)
+		}
+	}
+}
+
+/* the <PRI> that starts every syslog message */
+var syslog_re *regexp.Regexp = regexp.MustCompile(`^<\d{1,3}>`)
+
+/*
+	Removes the syslog header (priority, timestamp, host, tag...) from a
+	forwarded line, leaving the Zeek log line. Lines that aren't syslog
+	messages are left as they are
+*/
+func stripSyslog(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r\n")
+	if !syslog_re.Match(line) {
+		return line
+	}
+
+	// rsyslog escapes tabs as #011 unless told not to
+	if bytes.IndexByte(line, '\t') < 0 {
+		line = bytes.ReplaceAll(line, []byte("#011"), []byte("\t"))
+	}
+
+	// the header has spaces but no tabs, so a TSV line starts after the
+	// last space before its first tab. A JSON line starts at its brace
+	tab := bytes.IndexByte(line, '\t')
+	brace := bytes.IndexByte(line, '{')
+	switch {
+	case brace >= 0 && (tab < 0 || brace < tab):
+		return line[brace:]
+	case tab >= 0:
+		return line[bytes.LastIndexByte(line[:tab], ' ')+1:]
+	}
+
+	// nothing that looks like a log line
+	return nil
+}
@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	RANGED READS
+//--------------------------------------------------------------------------------
+
+/*
+	Builds the request for bytes start through end (inclusive) of an
+	object, signed or authorized however its storage needs
+*/
+type rangeRequest func(ctx context.Context, start int64, end int64) (*http.Request, error)
+
+/*
+	One fetched piece of an object, and the size of the whole object. A
+	server that doesn't support ranges sends the whole body instead
+*/
+type part struct {
+	data []byte
+	size int64
+	body io.ReadCloser
+	err  error
+}
+
+/*
+	Reads an object from start to finish as a series of ranged requests,
+	keeping up to Concurrency of them in flight ahead of the reader
+*/
+type rangedReader struct {
+	uri     string
+	request rangeRequest
+	ctx     context.Context
+	cancel  context.CancelFunc
+	size    int64
+	next    int64
+	window  int
+	pending []chan part
+	current []byte
+	stream  io.ReadCloser
+	err     error
+}
+
+func newRangedReader(uri string, request rangeRequest) *rangedReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rangedReader{uri: uri, request: request, ctx: ctx, cancel: cancel, size: -1, window: 1}
+}
+
+func (self *rangedReader) Read(p []byte) (int, error) {
+	if self.stream != nil {
+		return self.stream.Read(p)
+	}
+
+	for len(self.current) == 0 {
+		if self.err != nil {
+			return 0, self.err
+		}
+
+		// keep up to window parts in flight. Until the first part says how
+		// big the object is, it's the only one that can be asked for
+		for len(self.pending) < self.window && (self.next == 0 || self.next < self.size) {
+			self.pending = append(self.pending, self.fetch(self.next))
+			self.next += PartSize
+		}
+		if len(self.pending) == 0 {
+			self.err = io.EOF
+			continue
+		}
+
+		result := <-self.pending[0]
+		self.pending = self.pending[1:]
+		if result.err != nil {
+			self.err = result.err
+			self.cancel()
+			continue
+		}
+		if result.body != nil {
+			self.stream = result.body
+			return self.stream.Read(p)
+		}
+		self.size = result.size
+		self.current = result.data
+
+		// widen the readahead as the object keeps being read, so that
+		// reading only its header costs a single request
+		self.window = min(self.window+1, max(Concurrency, 1))
+	}
+
+	n := copy(p, self.current)
+	self.current = self.current[n:]
+	return n, nil
+}
+
+/*
+	Stops any requests still in flight
+*/
+func (self *rangedReader) Close() error {
+	self.cancel()
+	if self.stream != nil {
+		return self.stream.Close()
+	}
+	return nil
+}
+
+/*
+	Starts fetching the part beginning at start in the background
+*/
+func (self *rangedReader) fetch(start int64) chan part {
+	result := make(chan part, 1)
+
+	go func() {
+		result <- self.get(start, start+PartSize-1)
+	}()
+
+	return result
+}
+
+/*
+	Fetches a single part, along with the size of the whole object
+*/
+func (self *rangedReader) get(start int64, end int64) part {
+	req, err := self.request(self.ctx, start, end)
+	if err != nil {
+		return part{err: err}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return part{err: err}
+	}
+
+	// the server ignored the range and is sending everything, which can
+	// only happen for the first part. Stream it rather than holding it all
+	if resp.StatusCode == http.StatusOK {
+		return part{body: resp.Body}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return part{err: err}
+		}
+
+		// Content-Range: bytes 0-4194303/73014444
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		size, err := strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return part{err: fmt.Errorf("unable to read %s: bad Content-Range %q", self.uri, resp.Header.Get("Content-Range"))}
+		}
+		return part{data: data, size: size}
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// an empty object has no bytes to ask for
+		return part{}
+	}
+
+	return part{err: &StatusError{self.uri, resp.Status, resp.StatusCode}}
+}
@@ -0,0 +1,135 @@
+/*
+	Description:
+		Streams logs that live somewhere other than the local disk, named
+		by a URI such as s3://bucket/2024-05-01/conn.00:00:00-01:00:00.log.gz.
+		Each kind of storage registers an Opener for its URI scheme, and
+		objects are read as a series of ranged requests made several at a
+		time, so that large archives stream at the speed of the network
+		rather than that of a single connection
+*/
+
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	SETTINGS
+//--------------------------------------------------------------------------------
+
+/* number of ranged requests made at the same time for a single object */
+var Concurrency int = 4
+
+/* size of each ranged request, in bytes */
+var PartSize int64 = 4 << 20
+
+/* client used for every request */
+var client *http.Client = &http.Client{}
+
+//--------------------------------------------------------------------------------
+//	OPENERS
+//--------------------------------------------------------------------------------
+
+/*
+	Opens the object named by the URI for reading
+*/
+type Opener func(uri *url.URL) (io.ReadCloser, error)
+
+var openers map[string]Opener = make(map[string]Opener)
+var streams map[string]bool = make(map[string]bool)
+var openers_lock sync.RWMutex
+
+/*
+	Makes URIs with the given scheme (e.g. "s3") readable
+*/
+func Register(scheme string, open Opener) {
+	openers_lock.Lock()
+	defer openers_lock.Unlock()
+	openers[scheme] = open
+}
+
+/*
+	Like Register, for sources such as a message queue that can only be
+	read once and may never end. Their headers have to be read from the
+	same stream as the rest of their lines
+*/
+func RegisterStream(scheme string, open Opener) {
+	Register(scheme, open)
+
+	openers_lock.Lock()
+	defer openers_lock.Unlock()
+	streams[scheme] = true
+}
+
+/*
+	Reports whether the name is a URI for a source registered with
+	RegisterStream
+*/
+func IsStream(name string) bool {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok {
+		return false
+	}
+
+	openers_lock.RLock()
+	defer openers_lock.RUnlock()
+	return streams[scheme]
+}
+
+/*
+	Reports whether the name is a URI with a registered scheme
+*/
+func IsRemote(name string) bool {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok {
+		return false
+	}
+
+	openers_lock.RLock()
+	defer openers_lock.RUnlock()
+	_, ok = openers[scheme]
+	return ok
+}
+
+/*
+	Opens the object named by the URI, which must have a registered scheme
+*/
+func Open(name string) (io.ReadCloser, error) {
+	uri, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	openers_lock.RLock()
+	open, ok := openers[uri.Scheme]
+	openers_lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("don't know how to read %s:// URIs", uri.Scheme)
+	}
+
+	return open(uri)
+}
+
+//--------------------------------------------------------------------------------
+//	ERRORS
+//--------------------------------------------------------------------------------
+
+/*
+	Error returned when the storage refuses a request, e.g. with
+	403 Forbidden or 404 Not Found
+*/
+type StatusError struct {
+	URI    string
+	Status string
+	Code   int
+}
+
+func (self *StatusError) Error() string {
+	return fmt.Sprintf("unable to read %s: %s", self.URI, self.Status)
+}
@@ -0,0 +1,186 @@
+package remote
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------------------------
+//	S3
+//--------------------------------------------------------------------------------
+
+/*
+	Opens s3://bucket/key URIs. Credentials and region are taken from the
+	standard AWS environment variables:
+
+		AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+		AWS_REGION (or AWS_DEFAULT_REGION), defaults to us-east-1
+		AWS_ENDPOINT_URL, for S3-compatible storage such as MinIO
+
+	Without credentials, objects are requested anonymously
+*/
+func openS3(uri *url.URL) (io.ReadCloser, error) {
+	bucket := uri.Host
+	key := strings.TrimPrefix(uri.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%s should look like s3://bucket/key", uri)
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// virtual-hosted style for AWS itself, path style for anything else
+	var object *url.URL
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		base, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("bad AWS_ENDPOINT_URL: %w", err)
+		}
+		object = base.JoinPath(bucket, key)
+	} else {
+		object = &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com", Path: "/" + key}
+	}
+	object.RawPath = s3Escape(object.Path)
+
+	signer := &sigV4{
+		access_key: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret_key: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:      os.Getenv("AWS_SESSION_TOKEN"),
+		region:     region,
+		service:    "s3",
+	}
+
+	return newRangedReader(uri.String(), func(ctx context.Context, start int64, end int64) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, object.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		signer.sign(req, time.Now())
+		return req, nil
+	}), nil
+}
+
+func init() {
+	Register("s3", openS3)
+}
+
+/*
+	Escapes an object key the way AWS expects it in a signed request:
+	everything but unreserved characters and slashes
+*/
+func s3Escape(path string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+//--------------------------------------------------------------------------------
+//	AWS SIGNATURE VERSION 4
+//--------------------------------------------------------------------------------
+
+/*
+	Signs requests with AWS Signature Version 4. Requests go unsigned if
+	there is no access key
+*/
+type sigV4 struct {
+	access_key string
+	secret_key string
+	token      string
+	region     string
+	service    string
+}
+
+/* hash of the empty body of a GET request */
+const empty_sha256 string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (self *sigV4) sign(req *http.Request, now time.Time) {
+	if self.access_key == "" {
+		return
+	}
+
+	now = now.UTC()
+	amz_date := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amz_date)
+	req.Header.Set("X-Amz-Content-Sha256", empty_sha256)
+	if self.token != "" {
+		req.Header.Set("X-Amz-Security-Token", self.token)
+	}
+
+	// the headers that are signed, already in sorted order
+	signed := []string{"host"}
+	if req.Header.Get("Range") != "" {
+		signed = append(signed, "range")
+	}
+	signed = append(signed, "x-amz-content-sha256", "x-amz-date")
+	if self.token != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		headers.String(),
+		strings.Join(signed, ";"),
+		empty_sha256,
+	}, "\n")
+
+	scope := day + "/" + self.region + "/" + self.service + "/aws4_request"
+	to_sign := "AWS4-HMAC-SHA256\n" + amz_date + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+self.secret_key), day)
+	key = hmacSHA256(key, self.region)
+	key = hmacSHA256(key, self.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, to_sign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		self.access_key, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
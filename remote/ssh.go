@@ -0,0 +1,59 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	SSH
+//--------------------------------------------------------------------------------
+
+/* program used to reach ssh:// hosts */
+var SSH string = "ssh"
+
+/*
+	Streams ssh://[user@]host[:port]/path URIs, such as
+	ssh://sensor1:/data/logs/conn.log.gz, by running cat on the host. The
+	log is sent as it is stored and decompressed locally, so compressed
+	logs cross the network compressed. Hosts, users, keys and the like
+	come from the usual ssh configuration
+*/
+func openSSH(uri *url.URL) (io.ReadCloser, error) {
+	host := uri.Hostname()
+	if host == "" || !strings.HasPrefix(uri.Path, "/") || len(uri.Path) < 2 {
+		return nil, fmt.Errorf("%s should look like ssh://host:/path/to/conn.log.gz", uri)
+	}
+
+	args := make([]string, 0)
+	if port := uri.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	if uri.User != nil {
+		host = uri.User.Username() + "@" + host
+	}
+
+	// the path goes through the remote shell, so quote it
+	quoted := "'" + strings.ReplaceAll(uri.Path, "'", `'\''`) + "'"
+	args = append(args, host, "cat -- "+quoted)
+
+	c := exec.Command(SSH, args...)
+	c.Stderr = os.Stderr
+	pipe, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("reading %s needs %s: %w", uri, SSH, err)
+	}
+
+	return &command{pipe, c}, nil
+}
+
+func init() {
+	Register("ssh", openSSH)
+}
@@ -0,0 +1,61 @@
+package remote
+
+import (
+	"bro-awk/schema"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	STREAMS
+//--------------------------------------------------------------------------------
+
+/*
+	Output of a subprocess that is stopped when closed
+*/
+type command struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (self *command) Close() error {
+	self.cmd.Process.Kill()
+	self.ReadCloser.Close()
+	self.cmd.Wait()
+	return nil
+}
+
+/*
+	Streams of single log lines, e.g. messages from a queue, carry no
+	header. Unless the stream starts with a header or a JSON record, this
+	puts the header of the given log type from the schema registry in
+	front of it
+*/
+func withHeader(uri *url.URL, path string, stream io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(stream)
+	first, err := buffered.Peek(1)
+	if err != nil || first[0] == '#' || first[0] == '{' {
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, stream}, nil
+	}
+
+	log_type, ok := schema.Lookup(path)
+	if !ok {
+		stream.Close()
+		return nil, fmt.Errorf("lines from %s have no header and %q isn't a known log type, give it with ?path=", uri, path)
+	}
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "#path\t%s\n#fields\t%s\n", log_type.Path, strings.Join(log_type.Names(), "\t"))
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&header, buffered), stream}, nil
+}
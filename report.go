@@ -0,0 +1,354 @@
+/*
+	Description:
+		Implements `--report`, which writes the results of a query as a
+		self-contained HTML page rather than printing the matches: the
+		query, how much was scanned and matched, tables of the commonest
+		values of telling fields and a sample of the matches, ready to be
+		attached to a ticket or mailed on without copying a terminal:
+
+			bro-awk --report c2.html 'id.resp_h=203.0.113.7' /logs/2024-05-01
+*/
+
+package main
+
+import (
+	"bro-awk/filters"
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var report *string = flagset.String("report", "", "")
+
+/* rows in each table of commonest values */
+const report_top int = 10
+
+/* matches shown in the sample, in all */
+const report_sampled int = 100
+
+/*
+	Fields whose commonest values are tabled, when the matches have them
+	and no fields were given to --print_fields
+*/
+var report_fields []string = []string{
+	"id.orig_h", "id.resp_h", "id.resp_p", "proto", "service", "conn_state",
+	"query", "qtype_name", "host", "server_name", "user_agent", "mime_type",
+	"note", "name",
+}
+
+/* a value and how many matches had it */
+type report_row struct {
+	Value string
+	Count int
+	Share float64
+}
+
+/* the commonest values of a field among the matches */
+type report_table struct {
+	Field string
+	Rows  []report_row
+}
+
+/* the first matches of a log type, in its own columns */
+type report_sample struct {
+	Type   string
+	Fields []string
+	Rows   [][]string
+}
+
+/* everything the page is made from */
+type report_data struct {
+	Query     string
+	Generated string
+	Version   string
+	Logs      int64
+	Lines     int64
+	Bytes     string
+	Matches   int64
+	Share     float64
+	Elapsed   string
+	First     string
+	Last      string
+	Types     []report_row
+	Tops      []report_table
+	Samples   []*report_sample
+	Sampled   int
+	Failures  []report_failure
+}
+
+/* a log that couldn't be scanned, and why */
+type report_failure struct {
+	Log   string
+	Error string
+}
+
+/*
+	Scans the logs, gathering what the report needs from the matches
+	rather than printing them, then writes the report to the --report
+	file. Logs that couldn't be scanned are listed in the report as well
+	as returned
+*/
+func write_report(q *qreader.Qreader, logs []string, argv []string) []failed_log {
+	fields := report_fields
+	if *print_fields != "" {
+		fields = strings.Split(*print_fields, ",")
+	}
+
+	counts := make(map[string]map[string]int)
+	types := make(map[string]int)
+	samples := make(map[string]*report_sample)
+	order := make([]string, 0)
+	sampled := 0
+	var first, last time.Time
+
+	start := time.Now()
+	failures := make([]failed_log, 0)
+	for i, log := range logs {
+		if q.Stopped() {
+			break
+		}
+
+		for record, err := range q.Scan(log).Seq() {
+			if err != nil {
+				failures = append(failures, log_failed(log, err, i < len(logs)-1))
+				break
+			}
+
+			log_type := cmp.Or(schema.PathOf(record.Filename), "unknown")
+			types[log_type]++
+
+			for _, field := range fields {
+				value, ok := record.Get(field)
+				if !ok {
+					continue
+				}
+				if counts[field] == nil {
+					counts[field] = make(map[string]int)
+				}
+				for _, v := range intel_values(record.Filename, field, value) {
+					counts[field][v]++
+				}
+			}
+
+			if ts, ok := record.Get("ts"); ok {
+				if seconds, ok := filters.ParseTime(ts); ok {
+					t := time.Unix(0, int64(seconds*float64(time.Second)))
+					if first.IsZero() || t.Before(first) {
+						first = t
+					}
+					if t.After(last) {
+						last = t
+					}
+				}
+			}
+
+			if sampled < report_sampled {
+				sample := samples[log_type]
+				if sample == nil {
+					sample = &report_sample{Type: log_type, Fields: sample_fields(record)}
+					samples[log_type] = sample
+					order = append(order, log_type)
+				}
+				row := make([]string, len(sample.Fields))
+				for i, field := range sample.Fields {
+					value, _ := record.Get(field)
+					row[i] = qreader.Sanitize(*sanitize, value, "")
+				}
+				sample.Rows = append(sample.Rows, row)
+				sampled++
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	totals := q.Totals()
+	v, _, _ := build_info()
+	data := report_data{
+		Query:     "bro-awk " + quote_args(argv),
+		Generated: time.Now().In(timezone).Format(time.RFC1123),
+		Version:   cmp.Or(v, "(devel)"),
+		Logs:      totals.Logs,
+		Lines:     totals.Lines,
+		Bytes:     format_bytes(totals.Bytes),
+		Matches:   totals.Matches,
+		Elapsed:   round(elapsed).String(),
+		Types:     top_values(types, len(types), int(totals.Matches)),
+		Sampled:   sampled,
+	}
+	for _, failure := range failures {
+		message, _ := describe_error(failure.err)
+		data.Failures = append(data.Failures, report_failure{failure.log, message})
+	}
+	if totals.Lines > 0 {
+		data.Share = float64(totals.Matches) * 100 / float64(totals.Lines)
+	}
+	if !first.IsZero() {
+		data.First = first.In(timezone).Format(time.DateTime + " MST")
+		data.Last = last.In(timezone).Format(time.DateTime + " MST")
+	}
+	for _, field := range fields {
+		if len(counts[field]) > 0 {
+			data.Tops = append(data.Tops, report_table{field, top_values(counts[field], report_top, int(totals.Matches))})
+		}
+	}
+	for _, log_type := range order {
+		data.Samples = append(data.Samples, samples[log_type])
+	}
+
+	// the report is only written once it is complete, so that an
+	// interrupted scan doesn't leave a misleading one behind
+	exit_if_interrupted(q)
+	var page bytes.Buffer
+	if err := report_template.Execute(&page, data); err != nil {
+		fail_with(err)
+	}
+	if err := os.WriteFile(*report, page.Bytes(), 0644); err != nil {
+		fail_with(err, "file", *report)
+	}
+	q.Logger.Info("wrote report", "file", *report, "matches", totals.Matches)
+
+	return failures
+}
+
+/*
+	Returns the fields of a match shown in the sample: those printed with
+	--print_fields, or else all of them
+*/
+func sample_fields(record qreader.Record) []string {
+	if *print_fields == "" {
+		return record.Fields
+	}
+
+	fields := make([]string, 0)
+	for _, field := range strings.Split(*print_fields, ",") {
+		if _, ok := record.Get(field); ok {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+/*
+	Returns the n commonest values, most common first and then in order,
+	with their share of the matches
+*/
+func top_values(counts map[string]int, n int, matches int) []report_row {
+	values := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+
+	rows := make([]report_row, 0, min(n, len(values)))
+	for _, value := range values[:min(n, len(values))] {
+		share := 0.0
+		if matches > 0 {
+			share = float64(counts[value]) * 100 / float64(matches)
+		}
+		rows = append(rows, report_row{qreader.Sanitize(*sanitize, value, ""), counts[value], share})
+	}
+
+	return rows
+}
+
+var report_template *template.Template = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(share float64) string { return fmt.Sprintf("%.1f%%", share) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>bro-awk report</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 75em; padding: 0 1em; color: #222; }
+	h1 { font-size: 1.5em; margin-bottom: 0.2em; }
+	h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: 0.2em; }
+	h3 { font-size: 1em; margin-bottom: 0.4em; }
+	.meta { color: #666; font-size: 0.9em; }
+	pre, td.value { font-family: SFMono-Regular, Consolas, "Liberation Mono", monospace; font-size: 0.85em; }
+	pre { background: #f5f5f5; padding: 0.8em; overflow-x: auto; white-space: pre-wrap; word-break: break-all; }
+	table { border-collapse: collapse; margin-bottom: 1em; }
+	th, td { text-align: left; padding: 0.25em 0.8em 0.25em 0; border-bottom: 1px solid #eee; vertical-align: top; }
+	td.number { text-align: right; }
+	.tops { display: flex; flex-wrap: wrap; gap: 0 2.5em; }
+	.sample { overflow-x: auto; }
+	.sample td, .sample th { font-family: SFMono-Regular, Consolas, "Liberation Mono", monospace; font-size: 0.8em; white-space: nowrap; }
+	.failed { color: #a00; }
+</style>
+</head>
+<body>
+<h1>bro-awk report</h1>
+<div class="meta">Generated {{.Generated}} by bro-awk {{.Version}}</div>
+
+<h2>Query</h2>
+<pre>{{.Query}}</pre>
+
+<h2>Summary</h2>
+<table>
+	<tr><th>Logs scanned</th><td class="number">{{.Logs}}</td></tr>
+	<tr><th>Lines scanned</th><td class="number">{{.Lines}}</td></tr>
+	<tr><th>Data scanned</th><td class="number">{{.Bytes}}</td></tr>
+	<tr><th>Matches</th><td class="number">{{.Matches}} ({{percent .Share}})</td></tr>
+	{{- if .First}}
+	<tr><th>First match</th><td class="number">{{.First}}</td></tr>
+	<tr><th>Last match</th><td class="number">{{.Last}}</td></tr>
+	{{- end}}
+	<tr><th>Time taken</th><td class="number">{{.Elapsed}}</td></tr>
+</table>
+{{- if .Types}}
+<h3>Matches by log type</h3>
+<table>
+	{{- range .Types}}
+	<tr><td class="value">{{.Value}}</td><td class="number">{{.Count}}</td><td class="number">{{percent .Share}}</td></tr>
+	{{- end}}
+</table>
+{{- end}}
+{{- if .Failures}}
+<h3 class="failed">Logs that couldn't be scanned</h3>
+<table>
+	{{- range .Failures}}
+	<tr><td class="value">{{.Log}}</td><td>{{.Error}}</td></tr>
+	{{- end}}
+</table>
+{{- end}}
+{{- if .Tops}}
+
+<h2>Commonest values</h2>
+<div class="tops">
+{{- range .Tops}}
+<div>
+<h3>{{.Field}}</h3>
+<table>
+	{{- range .Rows}}
+	<tr><td class="value">{{.Value}}</td><td class="number">{{.Count}}</td><td class="number">{{percent .Share}}</td></tr>
+	{{- end}}
+</table>
+</div>
+{{- end}}
+</div>
+{{- end}}
+{{- if .Samples}}
+
+<h2>Sample of the matches</h2>
+<div class="meta">The first {{.Sampled}} of {{.Matches}}</div>
+{{- range .Samples}}
+<h3>{{.Type}}</h3>
+<div class="sample">
+<table>
+	<tr>{{range .Fields}}<th>{{.}}</th>{{end}}</tr>
+	{{- range .Rows}}
+	<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+	{{- end}}
+</table>
+</div>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
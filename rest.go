@@ -0,0 +1,529 @@
+/*
+	Description:
+		Serves queries over plain HTTP with `bro-awk serve --http`, as jobs
+		that are submitted, polled and have their results downloaded once
+		they are done, making bro-awk a small log search service:
+
+			curl -d '{"logs": ["2024-05-01"], "filters": ["id.resp_p=22"]}' localhost:8080/queries
+			curl localhost:8080/queries/5f0c3a9e1b2d4c68
+			curl localhost:8080/queries/5f0c3a9e1b2d4c68/results?format=csv
+
+		Queries wait their turn among those running, which the gRPC ones
+		share, and the matches of each are kept in a file until the server
+		stops or they have been kept an hour
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var serve_http *string = flagset.String("http", "", "")
+
+/* how long the matches of a finished query are kept to be downloaded */
+const rest_keep time.Duration = time.Hour
+
+/* largest query that can be submitted */
+const rest_max_request int64 = 1 << 20
+
+/* states of a query submitted over HTTP */
+const (
+	rest_queued    = "queued"
+	rest_running   = "running"
+	rest_done      = "done"
+	rest_failed    = "failed"
+	rest_cancelled = "cancelled"
+)
+
+/*
+	A query submitted over HTTP, with the file its matches are written to
+	and how far it has got
+*/
+type rest_query struct {
+	id       string
+	req      query_request
+	q        *qreader.Qreader
+	logs     []string
+	size     int64
+	path     string
+	cancel   func()
+	lock     sync.Mutex
+	state    string
+	err      *query_error
+	records  int64
+	deleted  bool
+	created  time.Time
+	started  time.Time
+	finished time.Time
+}
+
+/*
+	What a query submitted over HTTP is doing, as it is polled. The size
+	of its logs, and so how much of them has been scanned, is only given
+	when it is known
+*/
+type rest_status struct {
+	ID       string        `json:"id"`
+	State    string        `json:"state"`
+	Query    query_request `json:"query"`
+	Logs     int           `json:"logs"`
+	Size     int64         `json:"size,omitempty"`
+	Read     int64         `json:"read"`
+	Percent  int           `json:"percent,omitempty"`
+	Records  int64         `json:"records"`
+	Error    *rest_error   `json:"error,omitempty"`
+	Created  time.Time     `json:"created"`
+	Started  time.Time     `json:"started,omitzero"`
+	Finished time.Time     `json:"finished,omitzero"`
+}
+
+/* an error, with its code as --errors json would report it */
+type rest_error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/*
+	Runs the queries submitted over HTTP, keeping their matches under a
+	directory of its own until they are downloaded, deleted or expire
+*/
+type rest_server struct {
+	server  *query_server
+	dir     string
+	ctx     context.Context
+	stop    func()
+	lock    sync.Mutex
+	queries map[string]*rest_query
+	running sync.WaitGroup
+}
+
+func new_rest_server(server *query_server) *rest_server {
+	dir, err := os.MkdirTemp("", "bro-awk-results-")
+	if err != nil {
+		fail_with(err)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	return &rest_server{server: server, dir: dir, ctx: ctx, stop: stop, queries: make(map[string]*rest_query)}
+}
+
+/*
+	Returns the handler of the API:
+
+		POST   /queries                 submit a query, answered with its status
+		GET    /queries                 the status of every query
+		GET    /queries/{id}            the status of a query
+		GET    /queries/{id}/results    its matches, as NDJSON or with ?format=csv
+		DELETE /queries/{id}            cancel a query and throw its matches away
+*/
+func (self *rest_server) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/queries" {
+			switch r.Method {
+			case http.MethodPost:
+				self.submit(w, r)
+			case http.MethodGet:
+				self.list(w, r)
+			default:
+				rest_method(w, "GET, POST")
+			}
+			return
+		}
+
+		id, results, _ := strings.Cut(strings.TrimPrefix(path, "/queries/"), "/")
+		switch {
+		case !strings.HasPrefix(path, "/queries/") || id == "" || (results != "" && results != "results"):
+			rest_fail(w, http.StatusNotFound, ErrUsage, fmt.Sprintf("no endpoint %s, see the README for those there are", r.URL.Path))
+		case results != "" && r.Method == http.MethodGet:
+			self.results(w, r, id)
+		case results != "":
+			rest_method(w, "GET")
+		case r.Method == http.MethodGet:
+			self.status(w, r, id)
+		case r.Method == http.MethodDelete:
+			self.delete(w, r, id)
+		default:
+			rest_method(w, "GET, DELETE")
+		}
+	})
+}
+
+/*
+	Cancels the queries still queued or running, waits for them to stop,
+	and removes every query's matches
+*/
+func (self *rest_server) close() {
+	self.stop()
+	self.running.Wait()
+	if err := os.RemoveAll(self.dir); err != nil {
+		self.server.logger.Warn("unable to remove the matches of the queries", "dir", self.dir, "err", err)
+	}
+}
+
+/*
+	Submits a query, which is checked straight away and then queued to
+	run once there is room for it
+*/
+func (self *rest_server) submit(w http.ResponseWriter, r *http.Request) {
+	self.expire()
+
+	// fields that aren't known are more likely a mistake than not
+	var req query_request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, rest_max_request))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		rest_fail(w, http.StatusBadRequest, ErrUsage, fmt.Sprintf("the query isn't JSON bro-awk can read: %s", err))
+		return
+	}
+
+	q, logs, err := self.server.prepare(req, qreader.WithProgress(true))
+	var failed *query_error
+	if errors.As(err, &failed) {
+		rest_fail(w, http_status(failed.grpc), failed.code, failed.message)
+		return
+	} else if err != nil {
+		rest_fail(w, http.StatusInternalServerError, ErrInternal, err.Error())
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	query := &rest_query{
+		id:      hex.EncodeToString(id),
+		req:     req,
+		q:       q,
+		logs:    logs,
+		size:    size_of(logs),
+		state:   rest_queued,
+		created: time.Now(),
+	}
+	query.path = filepath.Join(self.dir, query.id+".ndjson")
+
+	ctx, cancel := context.WithCancel(self.ctx)
+	query.cancel = cancel
+	self.lock.Lock()
+	self.queries[query.id] = query
+	self.lock.Unlock()
+
+	self.running.Add(1)
+	go self.execute(ctx, query)
+
+	w.Header().Set("Location", "/queries/"+query.id)
+	rest_reply(w, http.StatusAccepted, query.status())
+}
+
+/*
+	Runs a query once there is room for it, writing its matches out as
+	NDJSON as they are found
+*/
+func (self *rest_server) execute(ctx context.Context, query *rest_query) {
+	defer self.running.Done()
+	defer query.cancel()
+
+	if !self.server.acquire(ctx) {
+		query.finish(rest_cancelled, nil)
+		return
+	}
+	defer self.server.release()
+
+	query.lock.Lock()
+	query.state, query.started = rest_running, time.Now()
+	query.lock.Unlock()
+
+	file, err := os.Create(query.path)
+	if err != nil {
+		query.finish(rest_failed, &query_error{ErrInternal, grpc_internal, fmt.Sprintf("unable to keep the matches: %s", err)})
+		return
+	}
+	out := bufio.NewWriter(file)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	err = self.server.scan(ctx, query.q, query.logs, query.req, func(record query_record) error {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		query.lock.Lock()
+		query.records++
+		query.lock.Unlock()
+		return nil
+	})
+	if flushed := out.Flush(); err == nil {
+		err = flushed
+	}
+	if closed := file.Close(); err == nil {
+		err = closed
+	}
+
+	var failed *query_error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		query.finish(rest_failed, &query_error{ErrInterrupted, grpc_deadline_exceeded, fmt.Sprintf("ran out of time after %s, the --query-timeout", self.server.timeout)})
+	case ctx.Err() != nil:
+		query.finish(rest_cancelled, nil)
+	case errors.As(err, &failed):
+		query.finish(rest_failed, failed)
+	case err != nil:
+		query.finish(rest_failed, &query_error{ErrInternal, grpc_internal, err.Error()})
+	default:
+		query.finish(rest_done, nil)
+	}
+
+	// a query deleted while it ran has no one to download its matches
+	query.lock.Lock()
+	deleted := query.deleted
+	query.lock.Unlock()
+	if deleted {
+		os.Remove(query.path)
+	}
+}
+
+/* the status of every query, oldest first */
+func (self *rest_server) list(w http.ResponseWriter, r *http.Request) {
+	self.expire()
+
+	self.lock.Lock()
+	statuses := make([]rest_status, 0, len(self.queries))
+	for _, query := range self.queries {
+		statuses = append(statuses, query.status())
+	}
+	self.lock.Unlock()
+
+	slices.SortFunc(statuses, func(a, b rest_status) int {
+		return cmp.Or(a.Created.Compare(b.Created), cmp.Compare(a.ID, b.ID))
+	})
+	rest_reply(w, http.StatusOK, statuses)
+}
+
+/* the status of a query */
+func (self *rest_server) status(w http.ResponseWriter, r *http.Request, id string) {
+	query := self.find(w, id)
+	if query == nil {
+		return
+	}
+
+	rest_reply(w, http.StatusOK, query.status())
+}
+
+/*
+	Downloads the matches of a query once it is over, as NDJSON, one
+	record a line, or as CSV with ?format=csv, with a column for the log
+	and then for each field: those asked for, or else those of the first
+	match. A query that failed or was cancelled has whatever matches it
+	found first
+*/
+func (self *rest_server) results(w http.ResponseWriter, r *http.Request, id string) {
+	query := self.find(w, id)
+	if query == nil {
+		return
+	}
+
+	format := cmp.Or(r.URL.Query().Get("format"), "ndjson")
+	if format != "ndjson" && format != "csv" {
+		rest_fail(w, http.StatusBadRequest, ErrUsage, fmt.Sprintf("results are given as ndjson or csv, not %s", format))
+		return
+	}
+
+	status := query.status()
+	if status.State == rest_queued || status.State == rest_running {
+		rest_fail(w, http.StatusConflict, ErrUsage, fmt.Sprintf("the query is still %s, poll /queries/%s until it is over", status.State, query.id))
+		return
+	}
+
+	file, err := os.Open(query.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// a query cancelled before it ran has no matches
+		file, err = os.Open(os.DevNull)
+	}
+	if err != nil {
+		rest_fail(w, http.StatusInternalServerError, ErrInternal, err.Error())
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", query.id, format))
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.Copy(w, file)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w)
+	fields := query.req.Fields
+	header := false
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var record query_record
+		if err := decoder.Decode(&record); err != nil {
+			break
+		}
+
+		if !header {
+			if len(fields) == 0 {
+				fields = record.Fields
+			}
+			out.Write(append([]string{"log"}, fields...))
+			header = true
+		}
+		row := []string{record.Log}
+		for _, field := range fields {
+			value, ok := qreader.Record{Fields: record.Fields, Values: record.Values}.Get(field)
+			if !ok {
+				value = "-"
+			}
+			row = append(row, value)
+		}
+		out.Write(row)
+	}
+	out.Flush()
+}
+
+/*
+	Cancels a query if it is queued or running, and throws it and its
+	matches away
+*/
+func (self *rest_server) delete(w http.ResponseWriter, r *http.Request, id string) {
+	query := self.find(w, id)
+	if query == nil {
+		return
+	}
+
+	self.lock.Lock()
+	delete(self.queries, query.id)
+	self.lock.Unlock()
+
+	query.lock.Lock()
+	query.deleted = true
+	over := !query.finished.IsZero()
+	query.lock.Unlock()
+	query.cancel()
+	if over {
+		os.Remove(query.path)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+	Returns the query with this id, or answers that there is no such
+	query and returns nil
+*/
+func (self *rest_server) find(w http.ResponseWriter, id string) *rest_query {
+	self.expire()
+
+	self.lock.Lock()
+	query, ok := self.queries[id]
+	self.lock.Unlock()
+	if !ok {
+		rest_fail(w, http.StatusNotFound, ErrUsage, fmt.Sprintf("no query %s, or its matches have expired", id))
+		return nil
+	}
+
+	return query
+}
+
+/* throws away the queries that have been over for longer than they are kept */
+func (self *rest_server) expire() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for id, query := range self.queries {
+		query.lock.Lock()
+		expired := !query.finished.IsZero() && time.Since(query.finished) > rest_keep
+		query.lock.Unlock()
+		if expired {
+			delete(self.queries, id)
+			os.Remove(query.path)
+		}
+	}
+}
+
+/* notes that a query is over, and how it went */
+func (self *rest_query) finish(state string, err *query_error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.state, self.err, self.finished = state, err, time.Now()
+}
+
+func (self *rest_query) status() rest_status {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	status := rest_status{
+		ID:       self.id,
+		State:    self.state,
+		Query:    self.req,
+		Logs:     len(self.logs),
+		Size:     self.size,
+		Read:     self.q.Totals().Read,
+		Records:  self.records,
+		Created:  self.created,
+		Started:  self.started,
+		Finished: self.finished,
+	}
+	if self.size > 0 {
+		status.Percent = int(min(status.Read*100/self.size, 100))
+	}
+	if self.err != nil {
+		status.Error = &rest_error{self.err.code, self.err.message}
+	}
+
+	return status
+}
+
+/* answers with a value as JSON */
+func rest_reply(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+}
+
+/* answers with an error, as JSON */
+func rest_fail(w http.ResponseWriter, code int, error_code string, message string) {
+	rest_reply(w, code, rest_error{error_code, message})
+}
+
+/* answers that the endpoint doesn't take the request's method, but these */
+func rest_method(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	rest_fail(w, http.StatusMethodNotAllowed, ErrUsage, "the endpoint only takes "+allowed)
+}
+
+/* returns the HTTP status that goes with a gRPC one */
+func http_status(grpc int) int {
+	switch grpc {
+	case grpc_invalid_argument:
+		return http.StatusBadRequest
+	case grpc_permission_denied:
+		return http.StatusForbidden
+	case grpc_not_found:
+		return http.StatusNotFound
+	case grpc_deadline_exceeded:
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusInternalServerError
+}
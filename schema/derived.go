@@ -0,0 +1,163 @@
+package schema
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	DERIVED FIELDS
+//--------------------------------------------------------------------------------
+
+/*
+	A field that isn't written to the log but is worked out from fields
+	that are, e.g. total_bytes from orig_bytes and resp_bytes. It can be
+	filtered on and printed like any other field of the logs that have
+	its inputs. Compute is only called when none of the inputs is unset
+	or empty, and returns "" if there is no value to give
+*/
+type Derived struct {
+	Name    string
+	Type    string
+	Inputs  []string
+	Compute func(values []string) string
+}
+
+var derived map[string]Derived = make(map[string]Derived)
+var derived_lock sync.RWMutex
+
+/*
+	Adds a derived field, replacing any with the same name
+*/
+func RegisterDerived(d Derived) {
+	derived_lock.Lock()
+	defer derived_lock.Unlock()
+	derived[d.Name] = d
+}
+
+/*
+	Returns the derived field with the given name
+*/
+func LookupDerived(name string) (Derived, bool) {
+	derived_lock.RLock()
+	defer derived_lock.RUnlock()
+	d, ok := derived[name]
+	return d, ok
+}
+
+/*
+	Returns the derived fields that can be worked out from the given
+	fields, sorted by name
+*/
+func DerivedFrom(fields []string) []Derived {
+	derived_lock.RLock()
+	defer derived_lock.RUnlock()
+
+	available := make([]Derived, 0)
+	for _, d := range derived {
+		if !slices.Contains(fields, d.Name) && hasAll(fields, d.Inputs) {
+			available = append(available, d)
+		}
+	}
+	slices.SortFunc(available, func(a, b Derived) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return available
+}
+
+func hasAll(fields []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(fields, w) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//--------------------------------------------------------------------------------
+//	TRAFFIC MEASURES
+//--------------------------------------------------------------------------------
+
+/*
+	Parses each of the values as a number, reporting false if any isn't
+*/
+func numbers(values []string) ([]float64, bool) {
+	n := make([]float64, len(values))
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, false
+		}
+		n[i] = f
+	}
+
+	return n, true
+}
+
+/* formats a double the way Zeek writes them */
+func double(f float64) string {
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
+
+/* formats a count the way Zeek writes them */
+func count(f float64) string {
+	return strconv.FormatFloat(f, 'f', 0, 64)
+}
+
+/*
+	Returns a derived field that adds up the inputs
+*/
+func sum(name string, inputs ...string) Derived {
+	return Derived{name, "count", inputs, func(values []string) string {
+		n, ok := numbers(values)
+		if !ok {
+			return ""
+		}
+		total := 0.0
+		for _, f := range n {
+			total += f
+		}
+		return count(total)
+	}}
+}
+
+/*
+	Returns a derived field that divides the sum of the inputs but the
+	last by the last, the number of seconds the connection lasted. Zero
+	second connections have no rate
+*/
+func rate(name string, inputs ...string) Derived {
+	return Derived{name, "double", inputs, func(values []string) string {
+		n, ok := numbers(values)
+		if !ok || n[len(n)-1] <= 0 {
+			return ""
+		}
+		total := 0.0
+		for _, f := range n[:len(n)-1] {
+			total += f
+		}
+		return double(total / n[len(n)-1])
+	}}
+}
+
+func init() {
+	RegisterDerived(sum("total_bytes", "orig_bytes", "resp_bytes"))
+	RegisterDerived(sum("total_pkts", "orig_pkts", "resp_pkts"))
+	RegisterDerived(rate("bytes_per_second", "orig_bytes", "resp_bytes", "duration"))
+	RegisterDerived(rate("pkts_per_second", "orig_pkts", "resp_pkts", "duration"))
+
+	// how lopsided the transfer was, from the originator's side. A
+	// connection that got nothing back counts its bytes as the ratio
+	// rather than having none
+	RegisterDerived(Derived{"bytes_ratio", "double", []string{"orig_bytes", "resp_bytes"}, func(values []string) string {
+		n, ok := numbers(values)
+		if !ok {
+			return ""
+		}
+		return double(n[0] / max(n[1], 1))
+	}})
+}
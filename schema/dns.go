@@ -0,0 +1,107 @@
+package schema
+
+//--------------------------------------------------------------------------------
+//	DNS CODES
+//--------------------------------------------------------------------------------
+
+/* names of DNS query types, as Zeek writes them in qtype_name */
+var dns_qtypes map[string]string = map[string]string{
+	"1":     "A",
+	"2":     "NS",
+	"5":     "CNAME",
+	"6":     "SOA",
+	"10":    "NULL",
+	"12":    "PTR",
+	"13":    "HINFO",
+	"15":    "MX",
+	"16":    "TXT",
+	"17":    "RP",
+	"24":    "SIG",
+	"25":    "KEY",
+	"28":    "AAAA",
+	"29":    "LOC",
+	"33":    "SRV",
+	"35":    "NAPTR",
+	"37":    "CERT",
+	"39":    "DNAME",
+	"41":    "OPT",
+	"43":    "DS",
+	"44":    "SSHFP",
+	"45":    "IPSECKEY",
+	"46":    "RRSIG",
+	"47":    "NSEC",
+	"48":    "DNSKEY",
+	"49":    "DHCID",
+	"50":    "NSEC3",
+	"51":    "NSEC3PARAM",
+	"52":    "TLSA",
+	"59":    "CDS",
+	"60":    "CDNSKEY",
+	"61":    "OPENPGPKEY",
+	"64":    "SVCB",
+	"65":    "HTTPS",
+	"99":    "SPF",
+	"249":   "TKEY",
+	"250":   "TSIG",
+	"251":   "IXFR",
+	"252":   "AXFR",
+	"255":   "*",
+	"256":   "URI",
+	"257":   "CAA",
+	"32769": "DLV",
+}
+
+/* names of DNS response codes, as Zeek writes them in rcode_name */
+var dns_rcodes map[string]string = map[string]string{
+	"0":  "NOERROR",
+	"1":  "FORMERR",
+	"2":  "SERVFAIL",
+	"3":  "NXDOMAIN",
+	"4":  "NOTIMP",
+	"5":  "REFUSED",
+	"6":  "YXDOMAIN",
+	"7":  "YXRRSET",
+	"8":  "NXRRSET",
+	"9":  "NOTAUTH",
+	"10": "NOTZONE",
+	"16": "BADVERS",
+	"17": "BADKEY",
+	"18": "BADTIME",
+	"19": "BADMODE",
+	"20": "BADNAME",
+	"21": "BADALG",
+	"22": "BADTRUNC",
+	"23": "BADCOOKIE",
+}
+
+/* names of DNS query classes, as Zeek writes them in qclass_name */
+var dns_qclasses map[string]string = map[string]string{
+	"1":   "C_INTERNET",
+	"3":   "C_CHAOS",
+	"4":   "C_HESIOD",
+	"254": "C_NONE",
+	"255": "C_ANY",
+}
+
+/*
+	Returns a derived field that names the code in its input, falling
+	back on prefix-N for codes without a name
+*/
+func codeName(name string, input string, names map[string]string, prefix string) Derived {
+	return Derived{name, "string", []string{input}, func(values []string) string {
+		if n, ok := names[values[0]]; ok {
+			return n
+		}
+		return prefix + values[0]
+	}}
+}
+
+/*
+	Logs written without the *_name fields, such as some JSON exports,
+	only have the numbers, so the names are derived from them
+*/
+func init() {
+	RegisterDerived(codeName("qtype_name", "qtype", dns_qtypes, "query-"))
+	RegisterDerived(codeName("rcode_name", "rcode", dns_rcodes, "rcode-"))
+	RegisterDerived(codeName("qclass_name", "qclass", dns_qclasses, "class-"))
+}
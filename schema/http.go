@@ -0,0 +1,145 @@
+package schema
+
+import (
+	"net/netip"
+	"path"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	HTTP
+//--------------------------------------------------------------------------------
+
+/*
+	Returns the class of an HTTP status code, e.g. 4xx for 404
+*/
+func statusClass(values []string) string {
+	code := values[0]
+	if len(code) != 3 || code[0] < '1' || code[0] > '5' {
+		return ""
+	}
+
+	return code[:1] + "xx"
+}
+
+//--------------------------------------------------------------------------------
+//	URL COMPONENTS
+//--------------------------------------------------------------------------------
+
+/*
+	Suffixes of more than one label that are common enough to be worth
+	knowing, under which names are registered a level further down, e.g.
+	example.co.uk rather than co.uk. Any other name is taken to be
+	registered directly under its top-level domain. Only a handful of the
+	Public Suffix List, so host.base is a rough grouping of hosts, not the
+	registrable domain
+*/
+var multi_label_suffixes map[string]bool = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true, "me.uk": true, "net.uk": true,
+	"com.au": true, "net.au": true, "org.au": true, "edu.au": true, "gov.au": true,
+	"co.nz": true, "org.nz": true, "govt.nz": true,
+	"co.jp": true, "ne.jp": true, "or.jp": true, "ac.jp": true, "go.jp": true,
+	"co.kr": true, "or.kr": true,
+	"com.br": true, "net.br": true, "org.br": true, "gov.br": true,
+	"com.cn": true, "net.cn": true, "org.cn": true, "gov.cn": true, "edu.cn": true,
+	"com.hk": true, "com.tw": true, "com.sg": true, "com.my": true,
+	"co.in": true, "net.in": true, "org.in": true, "gov.in": true,
+	"co.za": true, "org.za": true,
+	"com.mx": true, "com.ar": true, "com.tr": true, "com.ua": true, "com.ru": true,
+	"co.il": true, "co.id": true, "co.th": true,
+	"com.pl": true, "com.es": true,
+	"github.io": true, "herokuapp.com": true, "appspot.com": true, "blogspot.com": true,
+	"cloudfront.net": true, "azurewebsites.net": true, "pages.dev": true, "workers.dev": true,
+	"s3.amazonaws.com": true, "duckdns.org": true, "ngrok.io": true, "ngrok-free.app": true,
+}
+
+/*
+	Returns the base of a host name: its last two labels, or three under
+	one of the multi_label_suffixes, e.g. example.co.uk for
+	www.example.co.uk. Addresses, bare suffixes and names of one label
+	have none
+*/
+func baseDomain(values []string) string {
+	host := strings.TrimSuffix(strings.ToLower(hostName(values[0])), ".")
+	if _, err := netip.ParseAddr(host); err == nil {
+		return ""
+	}
+
+	// the longest suffix known, or else the top-level domain
+	labels := strings.Split(host, ".")
+	suffix := 1
+	for n := len(labels); n > 1; n-- {
+		if multi_label_suffixes[strings.Join(labels[len(labels)-n:], ".")] {
+			suffix = n
+			break
+		}
+	}
+	if suffix >= len(labels) || labels[len(labels)-suffix-1] == "" {
+		return ""
+	}
+
+	return strings.Join(labels[len(labels)-suffix-1:], ".")
+}
+
+/*
+	Returns the host of an HTTP Host header, without its port
+*/
+func hostName(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.Index(host, "]"); end > 0 {
+			return host[1:end]
+		}
+	} else if strings.Count(host, ":") == 1 {
+		host, _, _ = strings.Cut(host, ":")
+	}
+
+	return host
+}
+
+/*
+	Splits a request's URI into its path and query. URIs sent to proxies
+	name the server as well, which is dropped
+*/
+func splitURI(uri string) (string, string) {
+	if _, rest, ok := strings.Cut(uri, "://"); ok {
+		uri = "/"
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			uri = rest[i:]
+		}
+	}
+	uri, _, _ = strings.Cut(uri, "#")
+	uri_path, query, _ := strings.Cut(uri, "?")
+
+	return uri_path, query
+}
+
+func uriPath(values []string) string {
+	uri_path, _ := splitURI(values[0])
+	return uri_path
+}
+
+func uriQuery(values []string) string {
+	_, query := splitURI(values[0])
+	return query
+}
+
+/*
+	Returns the extension of the file a request's path names, lowercased
+	and without its dot, e.g. exe for /dl/Setup.EXE?x=1
+*/
+func uriExt(values []string) string {
+	uri_path, _ := splitURI(values[0])
+	if strings.HasSuffix(uri_path, "/") {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimPrefix(path.Ext(uri_path), "."))
+}
+
+func init() {
+	RegisterDerived(Derived{"status_class", "string", []string{"status_code"}, statusClass})
+	RegisterDerived(Derived{"host.base", "string", []string{"host"}, baseDomain})
+	RegisterDerived(Derived{"uri.path", "string", []string{"uri"}, uriPath})
+	RegisterDerived(Derived{"uri.query", "string", []string{"uri"}, uriQuery})
+	RegisterDerived(Derived{"uri.ext", "string", []string{"uri"}, uriExt})
+}
@@ -0,0 +1,62 @@
+package schema
+
+import (
+	"testing"
+)
+
+/*
+	Hosts are cut down to their last two labels, or three under a known
+	multi-label suffix, whatever port or trailing dot they are given with
+*/
+func TestBaseDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.example.com", "example.com"},
+		{"example.com", "example.com"},
+		{"WWW.Example.COM", "example.com"},
+		{"a.b.c.example.org", "example.org"},
+
+		// multi-label suffixes
+		{"www.example.co.uk", "example.co.uk"},
+		{"example.co.uk", "example.co.uk"},
+		{"co.uk", ""},
+		{"user.github.io", "user.github.io"},
+		{"cdn.user.github.io", "user.github.io"},
+		{"bucket.s3.amazonaws.com", "bucket.s3.amazonaws.com"},
+		{"x.bucket.s3.amazonaws.com", "bucket.s3.amazonaws.com"},
+		{"s3.amazonaws.com", ""},
+		{"amazonaws.com", "amazonaws.com"},
+
+		// addresses
+		{"10.0.0.1", ""},
+		{"10.0.0.1:8080", ""},
+		{"[2001:db8::1]:443", ""},
+		{"[2001:db8::1]", ""},
+		{"2001:db8::1", ""},
+
+		// ports
+		{"www.example.com:8080", "example.com"},
+		{"www.example.co.uk:443", "example.co.uk"},
+
+		// trailing dots
+		{"www.example.com.", "example.com"},
+		{"www.example.com.:8080", "example.com"},
+		{"co.uk.", ""},
+
+		// names of one label, and malformed ones
+		{"localhost", ""},
+		{"localhost:8080", ""},
+		{"", ""},
+		{"-", ""},
+		{".com", ""},
+		{"..example.com", "example.com"},
+	}
+
+	for _, test := range tests {
+		if got := baseDomain([]string{test.host}); got != test.want {
+			t.Errorf("baseDomain(%q) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
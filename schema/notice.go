@@ -0,0 +1,122 @@
+package schema
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	RANKED TYPES
+//--------------------------------------------------------------------------------
+
+/*
+	Types whose values are names ranked in order, lowest first, such as
+	severity. Comparisons on them go by rank rather than alphabetically
+*/
+var levels map[string][]string = make(map[string][]string)
+var levels_lock sync.RWMutex
+
+/*
+	Makes the type ranked, with the given names from lowest to highest
+*/
+func RegisterLevels(type_name string, names ...string) {
+	levels_lock.Lock()
+	defer levels_lock.Unlock()
+	levels[type_name] = names
+}
+
+/*
+	Returns the names of a ranked type from lowest to highest
+*/
+func Levels(type_name string) ([]string, bool) {
+	levels_lock.RLock()
+	defer levels_lock.RUnlock()
+	names, ok := levels[type_name]
+	return names, ok
+}
+
+//--------------------------------------------------------------------------------
+//	NOTICE SEVERITY
+//--------------------------------------------------------------------------------
+
+/* severities, from lowest to highest */
+var severity_levels []string = []string{"info", "low", "medium", "high", "critical"}
+
+/* severity of notice types that aren't given one */
+var DefaultSeverity string = "medium"
+
+/*
+	How much the notices that Zeek's own scripts raise usually matter.
+	Sites can override any of them with SetSeverity
+*/
+var severities map[string]string = map[string]string{
+	"CaptureLoss::Too_Much_Loss":               "info",
+	"Conn::Retransmission_Inconsistency":       "info",
+	"PacketFilter::Dropped_Packets":            "info",
+	"Weird::Activity":                          "info",
+	"SSL::Certificate_Expires_Soon":            "info",
+	"DNS::External_Name":                       "low",
+	"SSL::Certificate_Expired":                 "low",
+	"SSL::Certificate_Not_Valid_Yet":           "low",
+	"SSL::Invalid_Server_Cert":                 "low",
+	"Traceroute::Detected":                     "low",
+	"Scan::Address_Scan":                       "medium",
+	"Scan::Port_Scan":                          "medium",
+	"SSL::Old_Version":                         "medium",
+	"SSL::Weak_Cipher":                         "medium",
+	"SSL::Weak_Key":                            "medium",
+	"FTP::Bruteforcing":                        "high",
+	"Heartbleed::SSL_Heartbeat_Attack":         "high",
+	"HTTP::SQL_Injection_Attacker":             "high",
+	"HTTP::SQL_Injection_Victim":               "high",
+	"Intel::Notice":                            "high",
+	"Signatures::Sensitive_Signature":          "high",
+	"Software::Vulnerable_Version":             "high",
+	"SSH::Password_Guessing":                   "high",
+	"Heartbleed::SSL_Heartbeat_Attack_Success": "critical",
+	"SSH::Login_By_Password_Guesser":           "critical",
+	"TeamCymruMalwareHashRegistry::Match":      "critical",
+}
+var severities_lock sync.RWMutex
+
+/*
+	Sets the severity of a notice type, e.g. Scan::Port_Scan, or of every
+	type not otherwise given one if the type is "default"
+*/
+func SetSeverity(note string, severity string) error {
+	severity = strings.ToLower(severity)
+	if !slices.Contains(severity_levels, severity) {
+		return fmt.Errorf("severity of %s must be one of %s, not %q", note, strings.Join(severity_levels, ", "), severity)
+	}
+
+	severities_lock.Lock()
+	defer severities_lock.Unlock()
+	if note == "default" {
+		DefaultSeverity = severity
+	} else {
+		severities[note] = severity
+	}
+
+	return nil
+}
+
+/*
+	Returns the severity of a notice type
+*/
+func severityOf(values []string) string {
+	severities_lock.RLock()
+	defer severities_lock.RUnlock()
+
+	if severity, ok := severities[values[0]]; ok {
+		return severity
+	}
+
+	return DefaultSeverity
+}
+
+func init() {
+	RegisterLevels("severity", severity_levels...)
+	RegisterDerived(Derived{"severity", "severity", []string{"note"}, severityOf})
+}
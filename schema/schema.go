@@ -0,0 +1,470 @@
+/*
+	Description:
+		Built-in knowledge of the standard Zeek/Bro logs: the fields each
+		log type has, their types, the fields worth printing by default and
+		the other names people commonly use for them. Used to give better
+		error messages and to fill in field types when a log has no #types
+*/
+
+package schema
+
+import (
+	"cmp"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	LOG TYPES
+//--------------------------------------------------------------------------------
+
+/*
+	A single field of a log type
+*/
+type Field struct {
+	Name string
+	Type string
+}
+
+/*
+	Layout of one kind of log, e.g. conn.log
+*/
+type LogType struct {
+	Path     string
+	Fields   []Field
+	Defaults []string
+}
+
+/*
+	Returns the Zeek type of the named field, if the log type has it
+*/
+func (self LogType) Type(field string) (string, bool) {
+	for _, f := range self.Fields {
+		if f.Name == field {
+			return f.Type, true
+		}
+	}
+
+	return "", false
+}
+
+/*
+	Returns the names of all the log type's fields, in order
+*/
+func (self LogType) Names() []string {
+	names := make([]string, len(self.Fields))
+	for i, f := range self.Fields {
+		names[i] = f.Name
+	}
+
+	return names
+}
+
+/*
+	Helper to build a field list from alternating name, type pairs
+*/
+func fields(pairs ...string) []Field {
+	f := make([]Field, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		f = append(f, Field{pairs[i], pairs[i+1]})
+	}
+
+	return f
+}
+
+/* the connection 4-tuple that starts most logs */
+var conn_id []string = []string{
+	"id.orig_h", "addr",
+	"id.orig_p", "port",
+	"id.resp_h", "addr",
+	"id.resp_p", "port",
+}
+
+func with_id(pairs ...string) []string {
+	return slices.Concat([]string{"ts", "time", "uid", "string"}, conn_id, pairs)
+}
+
+var registry map[string]*LogType = map[string]*LogType{
+	"conn": {
+		Path: "conn",
+		Fields: fields(with_id(
+			"proto", "enum",
+			"service", "string",
+			"duration", "interval",
+			"orig_bytes", "count",
+			"resp_bytes", "count",
+			"conn_state", "string",
+			"local_orig", "bool",
+			"local_resp", "bool",
+			"missed_bytes", "count",
+			"history", "string",
+			"orig_pkts", "count",
+			"orig_ip_bytes", "count",
+			"resp_pkts", "count",
+			"resp_ip_bytes", "count",
+			"tunnel_parents", "set[string]",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.orig_p", "id.resp_h", "id.resp_p", "proto", "service", "conn_state", "orig_bytes", "resp_bytes"},
+	},
+	"dns": {
+		Path: "dns",
+		Fields: fields(with_id(
+			"proto", "enum",
+			"trans_id", "count",
+			"rtt", "interval",
+			"query", "string",
+			"qclass", "count",
+			"qclass_name", "string",
+			"qtype", "count",
+			"qtype_name", "string",
+			"rcode", "count",
+			"rcode_name", "string",
+			"AA", "bool",
+			"TC", "bool",
+			"RD", "bool",
+			"RA", "bool",
+			"Z", "count",
+			"answers", "vector[string]",
+			"TTLs", "vector[interval]",
+			"rejected", "bool",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.resp_h", "query", "qtype_name", "rcode_name", "answers"},
+	},
+	"http": {
+		Path: "http",
+		Fields: fields(with_id(
+			"trans_depth", "count",
+			"method", "string",
+			"host", "string",
+			"uri", "string",
+			"referrer", "string",
+			"version", "string",
+			"user_agent", "string",
+			"origin", "string",
+			"request_body_len", "count",
+			"response_body_len", "count",
+			"status_code", "count",
+			"status_msg", "string",
+			"info_code", "count",
+			"info_msg", "string",
+			"tags", "set[enum]",
+			"username", "string",
+			"password", "string",
+			"proxied", "set[string]",
+			"orig_fuids", "vector[string]",
+			"orig_filenames", "vector[string]",
+			"orig_mime_types", "vector[string]",
+			"resp_fuids", "vector[string]",
+			"resp_filenames", "vector[string]",
+			"resp_mime_types", "vector[string]",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.resp_h", "method", "host", "uri", "status_code", "user_agent"},
+	},
+	"ssl": {
+		Path: "ssl",
+		Fields: fields(with_id(
+			"version", "string",
+			"cipher", "string",
+			"curve", "string",
+			"server_name", "string",
+			"resumed", "bool",
+			"last_alert", "string",
+			"next_protocol", "string",
+			"established", "bool",
+			"ssl_history", "string",
+			"cert_chain_fps", "vector[string]",
+			"client_cert_chain_fps", "vector[string]",
+			"subject", "string",
+			"issuer", "string",
+			"client_subject", "string",
+			"client_issuer", "string",
+			"sni_matches_cert", "bool",
+			"validation_status", "string",
+			"ja3", "string",
+			"ja3s", "string",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.resp_h", "version", "server_name", "established", "validation_status"},
+	},
+	"files": {
+		Path: "files",
+		Fields: fields(
+			"ts", "time",
+			"fuid", "string",
+			"uid", "string",
+			"id.orig_h", "addr",
+			"id.orig_p", "port",
+			"id.resp_h", "addr",
+			"id.resp_p", "port",
+			"source", "string",
+			"depth", "count",
+			"analyzers", "set[string]",
+			"mime_type", "string",
+			"filename", "string",
+			"duration", "interval",
+			"local_orig", "bool",
+			"is_orig", "bool",
+			"seen_bytes", "count",
+			"total_bytes", "count",
+			"missing_bytes", "count",
+			"overflow_bytes", "count",
+			"timedout", "bool",
+			"parent_fuid", "string",
+			"md5", "string",
+			"sha1", "string",
+			"sha256", "string",
+			"extracted", "string",
+			"extracted_cutoff", "bool",
+			"extracted_size", "count",
+		),
+		Defaults: []string{"ts", "fuid", "uid", "source", "mime_type", "filename", "total_bytes", "sha256"},
+	},
+	"notice": {
+		Path: "notice",
+		Fields: fields(with_id(
+			"fuid", "string",
+			"file_mime_type", "string",
+			"file_desc", "string",
+			"proto", "enum",
+			"note", "enum",
+			"msg", "string",
+			"sub", "string",
+			"src", "addr",
+			"dst", "addr",
+			"p", "port",
+			"n", "count",
+			"peer_descr", "string",
+			"actions", "set[enum]",
+			"email_dest", "set[string]",
+			"suppress_for", "interval",
+			"remote_location.country_code", "string",
+			"remote_location.region", "string",
+			"remote_location.city", "string",
+			"remote_location.latitude", "double",
+			"remote_location.longitude", "double",
+		)...),
+		Defaults: []string{"ts", "uid", "note", "msg", "sub", "src", "dst", "p"},
+	},
+	"weird": {
+		Path: "weird",
+		Fields: fields(with_id(
+			"name", "string",
+			"addl", "string",
+			"notice", "bool",
+			"peer", "string",
+			"source", "string",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.resp_h", "name", "addl"},
+	},
+	"dhcp": {
+		Path: "dhcp",
+		Fields: fields(
+			"ts", "time",
+			"uids", "set[string]",
+			"client_addr", "addr",
+			"server_addr", "addr",
+			"mac", "string",
+			"host_name", "string",
+			"client_fqdn", "string",
+			"domain", "string",
+			"requested_addr", "addr",
+			"assigned_addr", "addr",
+			"lease_time", "interval",
+			"client_message", "string",
+			"server_message", "string",
+			"msg_types", "vector[string]",
+			"duration", "interval",
+		),
+		Defaults: []string{"ts", "client_addr", "assigned_addr", "mac", "host_name"},
+	},
+	"ssh": {
+		Path: "ssh",
+		Fields: fields(with_id(
+			"version", "count",
+			"auth_success", "bool",
+			"auth_attempts", "count",
+			"direction", "enum",
+			"client", "string",
+			"server", "string",
+			"cipher_alg", "string",
+			"mac_alg", "string",
+			"compression_alg", "string",
+			"kex_alg", "string",
+			"host_key_alg", "string",
+			"host_key", "string",
+		)...),
+		Defaults: []string{"ts", "uid", "id.orig_h", "id.resp_h", "auth_success", "auth_attempts", "client", "server"},
+	},
+}
+
+/*
+	Returns the layout of the named log type (the value of its #path), if known
+*/
+func Lookup(path string) (*LogType, bool) {
+	lt, ok := registry[path]
+	return lt, ok
+}
+
+/*
+	Returns the names of all the known log types, sorted
+*/
+func Paths() []string {
+	paths := make([]string, 0, len(registry))
+	for path := range registry {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	return paths
+}
+
+/* matches both conn.log.gz and rotated names like conn.00:00:00-01:00:00.log.gz */
+var filename_re *regexp.Regexp = regexp.MustCompile(`^([a-z0-9_]+)\.`)
+
+/*
+	Guesses the log type from a file name, for logs whose header has no #path
+*/
+func PathOf(filename string) string {
+	m := filename_re.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+//--------------------------------------------------------------------------------
+//	ALIASES
+//--------------------------------------------------------------------------------
+
+/*
+	Other names people reach for, mapped to the Zeek field they mean
+*/
+var aliases map[string]string = map[string]string{
+	"src":        "id.orig_h",
+	"src_ip":     "id.orig_h",
+	"srcip":      "id.orig_h",
+	"source_ip":  "id.orig_h",
+	"orig_h":     "id.orig_h",
+	"sport":      "id.orig_p",
+	"src_port":   "id.orig_p",
+	"srcport":    "id.orig_p",
+	"orig_p":     "id.orig_p",
+	"dst":        "id.resp_h",
+	"dst_ip":     "id.resp_h",
+	"dstip":      "id.resp_h",
+	"dest_ip":    "id.resp_h",
+	"resp_h":     "id.resp_h",
+	"dport":      "id.resp_p",
+	"dst_port":   "id.resp_p",
+	"dstport":    "id.resp_p",
+	"dest_port":  "id.resp_p",
+	"resp_p":     "id.resp_p",
+	"port":       "id.resp_p",
+	"protocol":   "proto",
+	"state":      "conn_state",
+	"bytes_out":  "orig_bytes",
+	"bytes_in":   "resp_bytes",
+	"sni":        "server_name",
+	"useragent":  "user_agent",
+	"ua":         "user_agent",
+	"status":     "status_code",
+	"domain":     "query",
+	"hostname":   "host",
+	"mime":       "mime_type",
+	"notice":     "note",
+	"weird_name": "name",
+}
+
+/* most fields suggested for one that isn't in a header */
+const suggestions int = 3
+
+/*
+	Suggests the fields the user probably meant when asking for one that
+	isn't in the header, closest first: an alias that resolves to a field
+	the header has, a field that differs only in case, and then the near
+	misses -- fields a few typos away, as id.resp_h is from id.resph, or
+	that start with the field or that the field starts with, as service
+	does with servic. Returns nil if nothing fits
+*/
+func Suggest(field string, header []string) []string {
+	if canonical, ok := aliases[strings.ToLower(field)]; ok && slices.Contains(header, canonical) {
+		return []string{canonical}
+	}
+
+	for _, h := range header {
+		if strings.EqualFold(h, field) {
+			return []string{h}
+		}
+	}
+
+	// a typo for every few characters is allowed, and at least one
+	lower := strings.ToLower(field)
+	limit := max(len(field)/3, 1)
+	distances := make(map[string]int)
+	for _, h := range header {
+		l := strings.ToLower(h)
+		d := distance(lower, l)
+		if d <= limit || strings.HasPrefix(l, lower) || strings.HasPrefix(lower, l) {
+			distances[h] = d
+		}
+	}
+
+	near := slices.Collect(maps.Keys(distances))
+	slices.SortFunc(near, func(a string, b string) int {
+		return cmp.Or(cmp.Compare(distances[a], distances[b]), cmp.Compare(a, b))
+	})
+
+	return near[:min(len(near), suggestions)]
+}
+
+/*
+	Returns the number of characters that have to be inserted, deleted,
+	changed or swapped with the next to turn a into b (their optimal string
+	alignment distance)
+*/
+func distance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+
+	// rows i-2, i-1 and i of the table of distances between prefixes
+	before := make([]int, len(t)+1)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				current[j] = min(current[j], before[j-2]+1)
+			}
+		}
+		before, previous, current = previous, current, before
+	}
+
+	return previous[len(t)]
+}
+
+/*
+	Returns the known log types that have the named field, sorted, for
+	a field asked of a log that may have been meant for another type
+*/
+func TypesWith(field string) []string {
+	paths := make([]string, 0)
+	for _, path := range Paths() {
+		if _, ok := registry[path].Type(field); ok {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
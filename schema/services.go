@@ -0,0 +1,138 @@
+package schema
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------------------------
+//	SERVICE NAMES
+//--------------------------------------------------------------------------------
+
+/* the system's table of service names, as in getservbyname(3) */
+var ServicesFile string = "/etc/services"
+
+/*
+	IANA names of the ports seen most often, for systems without a
+	services file (or with a sparse one)
+*/
+var well_known_ports map[string]string = map[string]string{
+	"20":    "ftp-data",
+	"21":    "ftp",
+	"22":    "ssh",
+	"23":    "telnet",
+	"25":    "smtp",
+	"53":    "domain",
+	"67":    "bootps",
+	"68":    "bootpc",
+	"69":    "tftp",
+	"80":    "http",
+	"88":    "kerberos",
+	"110":   "pop3",
+	"123":   "ntp",
+	"135":   "epmap",
+	"137":   "netbios-ns",
+	"138":   "netbios-dgm",
+	"139":   "netbios-ssn",
+	"143":   "imap",
+	"161":   "snmp",
+	"162":   "snmptrap",
+	"179":   "bgp",
+	"389":   "ldap",
+	"443":   "https",
+	"445":   "microsoft-ds",
+	"465":   "submissions",
+	"500":   "isakmp",
+	"514":   "syslog",
+	"587":   "submission",
+	"636":   "ldaps",
+	"853":   "domain-s",
+	"873":   "rsync",
+	"993":   "imaps",
+	"995":   "pop3s",
+	"1194":  "openvpn",
+	"1433":  "ms-sql-s",
+	"1883":  "mqtt",
+	"3306":  "mysql",
+	"3389":  "ms-wbt-server",
+	"5060":  "sip",
+	"5432":  "postgresql",
+	"5900":  "rfb",
+	"6379":  "redis",
+	"8080":  "http-alt",
+	"27017": "mongodb",
+}
+
+var service_names map[string]string
+var service_ports map[string]string
+var services_once sync.Once
+
+/*
+	Fills in the tables from the built-in ports and then the services
+	file, whose names win where they differ
+*/
+func loadServices() {
+	service_names = make(map[string]string)
+	service_ports = make(map[string]string)
+	for port, name := range well_known_ports {
+		service_names[port] = name
+		service_ports[name] = port
+	}
+
+	file, err := os.Open(ServicesFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// lines look like `https   443/tcp   # http protocol over TLS/SSL`,
+	// with any aliases after the port
+	named := make(map[string]bool)
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		words := strings.Fields(line)
+		if len(words) < 2 {
+			continue
+		}
+		port, _, _ := strings.Cut(words[1], "/")
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			continue
+		}
+
+		// the first name listed for a port is its own, whatever the protocol
+		if !named[port] {
+			service_names[port] = words[0]
+			named[port] = true
+		}
+		for _, name := range append([]string{words[0]}, words[2:]...) {
+			name = strings.ToLower(name)
+			if !listed[name] {
+				service_ports[name] = port
+				listed[name] = true
+			}
+		}
+	}
+}
+
+/*
+	Returns the service name of a port number, e.g. https for 443
+*/
+func ServiceName(port string) (string, bool) {
+	services_once.Do(loadServices)
+	name, ok := service_names[port]
+	return name, ok
+}
+
+/*
+	Returns the port number of a service name, e.g. 443 for https
+*/
+func ServicePort(name string) (string, bool) {
+	services_once.Do(loadServices)
+	port, ok := service_ports[strings.ToLower(name)]
+	return port, ok
+}
@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"slices"
+	"strings"
+)
+
+//--------------------------------------------------------------------------------
+//	TLS CATEGORIES
+//--------------------------------------------------------------------------------
+
+/*
+	OpenSSL's validation errors, as ssl.log's validation_status gives
+	them, grouped into categories. Checked in order, so the more specific
+	messages come first
+*/
+var validation_classes [][2]string = [][2]string{
+	{"self signed", "self-signed"},
+	{"has expired", "expired"},
+	{"not yet valid", "not-yet-valid"},
+	{"revoked", "revoked"},
+	{"unable to get", "untrusted"},
+	{"unable to verify", "untrusted"},
+	{"untrusted", "untrusted"},
+}
+
+/* pieces of cipher suite names that mark them as weak */
+var weak_ciphers []string = []string{"_NULL_", "_EXPORT", "_anon_", "_RC4_", "_DES_", "_3DES_", "_MD5"}
+
+/* protocol versions that are no longer considered safe */
+var weak_versions []string = []string{"SSLv2", "SSLv3", "TLSv10", "TLSv11"}
+
+/*
+	Returns the category of a validation_status, e.g. self-signed for
+	"self signed certificate in certificate chain"
+*/
+func validationClass(values []string) string {
+	status := strings.ToLower(values[0])
+	if status == "ok" {
+		return "ok"
+	}
+
+	for _, class := range validation_classes {
+		if strings.Contains(status, class[0]) {
+			return class[1]
+		}
+	}
+
+	return "invalid"
+}
+
+/*
+	Returns weak-cipher for cipher suites without encryption, with
+	export-grade or broken ciphers, or with MD5 MACs, and ok otherwise
+*/
+func cipherClass(values []string) string {
+	for _, weak := range weak_ciphers {
+		if strings.Contains(values[0]+"_", weak) {
+			return "weak-cipher"
+		}
+	}
+
+	return "ok"
+}
+
+/*
+	Returns weak-version for SSL and TLS versions before 1.2, and ok
+	otherwise. Other logs' version fields, such as ssh.log's, have none
+*/
+func versionClass(values []string) string {
+	if slices.Contains(weak_versions, values[0]) {
+		return "weak-version"
+	} else if strings.HasPrefix(values[0], "TLS") || strings.HasPrefix(values[0], "DTLS") {
+		return "ok"
+	}
+
+	return ""
+}
+
+func init() {
+	RegisterDerived(Derived{"validation_class", "string", []string{"validation_status"}, validationClass})
+	RegisterDerived(Derived{"cipher_class", "string", []string{"cipher"}, cipherClass})
+	RegisterDerived(Derived{"version_class", "string", []string{"version"}, versionClass})
+}
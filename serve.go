@@ -0,0 +1,495 @@
+/*
+	Description:
+		Implements the `serve` subcommand, which answers queries over the
+		network, so that tools on other hosts can query the logs of this
+		one without shelling out to bro-awk and parsing what it prints. With
+		--grpc, it serves the Query RPC set out in bro-awk.proto, streaming
+		back each match as a record:
+
+			bro-awk serve --grpc :9000 /var/log/zeek
+
+		Only logs under the directories it is given can be queried
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/remote"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var serve_grpc *string = flagset.String("grpc", "", "")
+var max_queries *int = flagset.Int("max-queries", 2, "")
+var query_timeout *time.Duration = flagset.Duration("query-timeout", time.Hour, "")
+var query_max_results *int64 = flagset.Int64("query-max-results", 1000000, "")
+
+/* how serve is used, for its errors */
+const serve_usage string = "Usage: bro-awk serve [--grpc <ADDR>] [--http <ADDR>] <DIRS...>"
+
+/* how long queries still running are given to finish once the server is stopped */
+const serve_grace time.Duration = 10 * time.Second
+
+/*
+	A query sent to the server: the logs to scan, as paths under its
+	directories, globs or directories, and the filters, fields and limit
+	to scan them with, as given on the command line. Directories are
+	walked for the log types and span of time asked for, and no logs at
+	all means every directory the server has
+*/
+type query_request struct {
+	Logs     []string `json:"logs,omitempty"`
+	Filters  []string `json:"filters"`
+	Fields   []string `json:"fields,omitempty"`
+	LogTypes []string `json:"log_types,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Limit    int64    `json:"limit,omitempty"`
+}
+
+/*
+	A match sent back, with the log it came from, its fields (those asked
+	for, or else all of them) and their values, and the line itself
+*/
+type query_record struct {
+	Log    string   `json:"log"`
+	Fields []string `json:"fields"`
+	Values []string `json:"values"`
+	Line   string   `json:"line"`
+}
+
+/*
+	Error with which a query is answered, with the code of the error as
+	--errors json would report it and the gRPC status it is sent with
+*/
+type query_error struct {
+	code    string
+	grpc    int
+	message string
+}
+
+func (self *query_error) Error() string {
+	return self.message
+}
+
+/*
+	Answers queries over the logs under its directories, each with a
+	Qreader of its own, running so many at a time. Each may only run for
+	so long and send back so many matches, where those are limited
+*/
+type query_server struct {
+	opts        []qreader.Option
+	roots       []string
+	presets     map[string][]string
+	logger      *slog.Logger
+	slots       chan struct{}
+	timeout     time.Duration
+	max_results int64
+}
+
+/*
+	Serves queries on the logs under the directories given until
+	interrupted, letting those being streamed back finish first
+*/
+func serve_command(opts []qreader.Option, args []string, presets map[string][]string, logger *slog.Logger) {
+	if *serve_grpc == "" && *serve_http == "" {
+		fail(ErrUsage, "serve needs --grpc or --http, the address to listen on. "+serve_usage)
+	}
+	if len(args) == 0 {
+		fail(ErrUsage, "serve needs the directories whose logs can be queried. "+serve_usage)
+	}
+	if *max_queries < 1 {
+		fail(ErrUsage, fmt.Sprintf("--max-queries must be at least 1, not %d", *max_queries))
+	}
+	if *query_timeout < 0 || *query_max_results < 0 {
+		fail(ErrUsage, "--query-timeout and --query-max-results can't be negative, but may be 0 for no limit")
+	}
+
+	server := &query_server{
+		opts:        opts,
+		presets:     presets,
+		logger:      logger,
+		slots:       make(chan struct{}, *max_queries),
+		timeout:     *query_timeout,
+		max_results: *query_max_results,
+	}
+	for _, arg := range args {
+		root, err := filepath.Abs(arg)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			fail_with(err, "file", arg)
+		}
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			fail(ErrUsage, fmt.Sprintf("serve only serves directories, not %s. %s", arg, serve_usage), "file", arg)
+		}
+		server.roots = append(server.roots, root)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listeners := make([]*http.Server, 0, 2)
+	if *serve_grpc != "" {
+		// gRPC is HTTP/2, which clients speak to it without TLS from the start
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		listeners = append(listeners, &http.Server{
+			Addr:      *serve_grpc,
+			Handler:   http.HandlerFunc(server.grpc_query),
+			Protocols: protocols,
+		})
+	}
+	var rest *rest_server
+	if *serve_http != "" {
+		rest = new_rest_server(server)
+		listeners = append(listeners, &http.Server{Addr: *serve_http, Handler: rest.handler()})
+	}
+
+	done := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			done <- listener.ListenAndServe()
+		}()
+	}
+	logger.Info("serving queries", "grpc", *serve_grpc, "http", *serve_http, "dirs", server.roots,
+		"max_queries", *max_queries, "query_timeout", *query_timeout, "query_max_results", *query_max_results)
+
+	select {
+	case err := <-done:
+		if rest != nil {
+			rest.close()
+		}
+		fail_with(err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("stopping, once the queries being streamed back finish")
+	shutdown, cancel := context.WithTimeout(context.Background(), serve_grace)
+	defer cancel()
+	for _, listener := range listeners {
+		if err := listener.Shutdown(shutdown); err != nil {
+			// closing the connections stops the queries on them
+			logger.Warn("stopped before every query had finished", "addr", listener.Addr, "err", err)
+			listener.Close()
+		}
+	}
+	if rest != nil {
+		rest.close()
+	}
+}
+
+/*
+	Runs a query once there is room for it among those running, passing
+	each match to send. A query that is turned down is answered straight
+	away, rather than waiting its turn first
+*/
+func (self *query_server) run(ctx context.Context, req query_request, send func(query_record) error) error {
+	q, logs, err := self.prepare(req)
+	if err != nil {
+		return err
+	}
+	if !self.acquire(ctx) {
+		return ctx.Err()
+	}
+	defer self.release()
+
+	return self.scan(ctx, q, logs, req, send)
+}
+
+/*
+	Waits for there to be room for another query among those running,
+	reporting false if the context is done first
+*/
+func (self *query_server) acquire(ctx context.Context) bool {
+	select {
+	case self.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+/* makes room for another query, once one is done */
+func (self *query_server) release() {
+	<-self.slots
+}
+
+/*
+	Scans the logs of a query, passing each match to send until the query
+	is done or has sent as many as it may, runs out of time, the context
+	is done or send fails. A log that can't be scanned is passed over, and
+	the query answered with an error once the rest have been
+*/
+func (self *query_server) scan(ctx context.Context, q *qreader.Qreader, logs []string, req query_request, send func(query_record) error) error {
+	started := time.Now()
+	if self.timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, self.timeout)
+		defer cancel()
+	}
+
+	// the server's own limit applies to queries that ask for more, or
+	// for every match
+	limit := req.Limit
+	if self.max_results > 0 && (limit == 0 || limit > self.max_results) {
+		limit = self.max_results
+	}
+
+	sent := int64(0)
+	failed := make([]failed_log, 0)
+	for _, log := range logs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		scanner := q.Scan(log)
+		finished := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				scanner.Stop()
+			case <-finished:
+			}
+		}()
+
+		for record, err := range scanner.Seq() {
+			if err != nil {
+				failed = append(failed, failed_log{log, err})
+				break
+			}
+			if err := send(self.record(record, req.Fields)); err != nil {
+				close(finished)
+				return err
+			}
+			sent++
+			if limit > 0 && sent >= limit {
+				break
+			}
+		}
+		close(finished)
+
+		if limit > 0 && sent >= limit {
+			break
+		}
+	}
+
+	self.logger.Info("answered a query", "filters", req.Filters, "logs", len(logs), "records", sent,
+		"failed", len(failed), "elapsed", time.Since(started).Round(time.Millisecond))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(failed) > 0 {
+		return log_error(failed[0].err, fmt.Sprintf("%d of the %d logs couldn't be scanned, first %s: %s",
+			len(failed), len(logs), self.relative(failed[0].log), failed[0].err))
+	}
+
+	return nil
+}
+
+/*
+	Checks a query and returns the Qreader to run it with, given these
+	options along with the server's, and the logs it covers, or an error
+	saying what is wrong with it
+*/
+func (self *query_server) prepare(req query_request, extra ...qreader.Option) (*qreader.Qreader, []string, error) {
+	q, logs, err := self.check(req, extra)
+	if err != nil {
+		self.logger.Info("turned down a query", "filters", req.Filters, "logs", req.Logs, "err", err)
+	}
+
+	return q, logs, err
+}
+
+func (self *query_server) check(req query_request, extra []qreader.Option) (*qreader.Qreader, []string, error) {
+	if req.Limit < 0 {
+		return nil, nil, &query_error{ErrUsage, grpc_invalid_argument, "the limit can't be negative"}
+	}
+
+	rules := make([]string, 0, len(req.Filters))
+	for _, rule := range req.Filters {
+		if preset_re.MatchString(rule) {
+			preset, ok := self.presets[rule[1:]]
+			if !ok {
+				return nil, nil, &query_error{ErrUnknownPreset, grpc_invalid_argument, fmt.Sprintf("no preset named %s", rule[1:])}
+			}
+			rules = append(rules, preset...)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, nil, &query_error{ErrUsage, grpc_invalid_argument, "a query needs at least one filter"}
+	}
+
+	opts := slices.Concat(self.opts, extra)
+	if len(req.Fields) > 0 {
+		opts = append(opts, qreader.WithFields(req.Fields...))
+	}
+	q, err := qreader.NewQreader(rules, opts...)
+	if err != nil {
+		return nil, nil, &query_error{error_code(err), grpc_invalid_argument, err.Error()}
+	}
+
+	logs, err := self.find(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return q, logs, nil
+}
+
+/*
+	Returns the logs a query asks for, each of which must be under one of
+	the server's directories. Relative paths are taken to be under the
+	first of them
+*/
+func (self *query_server) find(req query_request) ([]string, error) {
+	from, ok := parse_date(req.From, false)
+	if !ok {
+		return nil, &query_error{ErrUsage, grpc_invalid_argument, fmt.Sprintf("from must be a date like 2024-05-01 or a time like 2024-05-01T10:00, not %s", req.From)}
+	}
+	to, ok := parse_date(req.To, true)
+	if !ok {
+		return nil, &query_error{ErrUsage, grpc_invalid_argument, fmt.Sprintf("to must be a date like 2024-05-01 or a time like 2024-05-01T10:00, not %s", req.To)}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return nil, &query_error{ErrUsage, grpc_invalid_argument, fmt.Sprintf("to %s is not after from %s", req.To, req.From)}
+	}
+
+	paths := req.Logs
+	if len(paths) == 0 {
+		paths = self.roots
+	}
+
+	logs := make([]string, 0)
+	for _, path := range paths {
+		if remote.IsRemote(path) || path == qreader.Stdin {
+			return nil, &query_error{ErrUsage, grpc_permission_denied, fmt.Sprintf("only logs under the server's directories can be queried, not %s", path)}
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(self.roots[0], path)
+		}
+
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, &query_error{ErrUsage, grpc_invalid_argument, fmt.Sprintf("bad glob pattern %s: %s", self.relative(path), err)}
+			}
+		}
+
+		found := 0
+		for _, match := range matches {
+			// checked before and after following links, so that paths out
+			// of the directories aren't told apart by whether they exist
+			if !self.serves(filepath.Clean(match)) {
+				return nil, &query_error{ErrUsage, grpc_permission_denied, fmt.Sprintf("only logs under the server's directories can be queried, not %s", match)}
+			}
+			real, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				return nil, log_error(err, fmt.Sprintf("no log or directory %s", self.relative(match)))
+			}
+			if !self.serves(real) {
+				return nil, &query_error{ErrUsage, grpc_permission_denied, fmt.Sprintf("only logs under the server's directories can be queried, not %s", match)}
+			}
+			info, err := os.Stat(real)
+			if err != nil {
+				return nil, log_error(err, err.Error())
+			}
+
+			if info.IsDir() {
+				walked, err := walk_logs(real, req.LogTypes, from, to)
+				if err != nil {
+					return nil, log_error(err, err.Error())
+				}
+				logs = append(logs, walked...)
+				found += len(walked)
+			} else if info.Mode().IsRegular() && log_re.MatchString(real) {
+				logs = append(logs, real)
+				found++
+			} else if len(matches) == 1 {
+				return nil, &query_error{ErrUsage, grpc_invalid_argument, fmt.Sprintf("%s is not a log or directory", self.relative(match))}
+			}
+		}
+		if found == 0 {
+			return nil, &query_error{ErrUsage, grpc_not_found, fmt.Sprintf("no matching logs found in %s", self.relative(path))}
+		}
+	}
+
+	return logs, nil
+}
+
+/* reports whether a path is one of the server's directories or under one */
+func (self *query_server) serves(path string) bool {
+	for _, root := range self.roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Returns a path relative to the server's first directory, if it is under
+	it, as a client would give it
+*/
+func (self *query_server) relative(path string) string {
+	if rel, err := filepath.Rel(self.roots[0], path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return path
+}
+
+/*
+	Returns the error a query is answered with when a log couldn't be
+	found or scanned
+*/
+func log_error(err error, message string) *query_error {
+	code := error_code(err)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return &query_error{code, grpc_not_found, message}
+	case errors.Is(err, fs.ErrPermission):
+		return &query_error{code, grpc_permission_denied, message}
+	case code == ErrMissingField || code == ErrBadFilter:
+		return &query_error{code, grpc_invalid_argument, message}
+	}
+
+	return &query_error{code, grpc_internal, message}
+}
+
+/*
+	Returns a match as it is sent back, with only the fields asked for if
+	any were
+*/
+func (self *query_server) record(record qreader.Record, fields []string) query_record {
+	log := self.relative(record.Filename)
+	if len(fields) == 0 {
+		return query_record{Log: log, Fields: record.Fields, Values: record.Values, Line: record.Line}
+	}
+
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i], _ = record.Get(field)
+	}
+	return query_record{Log: log, Fields: fields, Values: values, Line: record.Line}
+}
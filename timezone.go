@@ -0,0 +1,75 @@
+/*
+	Description:
+		Picks the timezone that times without an offset are taken in,
+		wherever they are given or read: times in filters, --from and
+		--to, and the days and hours Zeek's archive is laid out by.
+		Zeek logs themselves are always in seconds since the epoch, so
+		only how a time is written down depends on it
+*/
+
+package main
+
+import (
+	"bro-awk/config"
+	"bro-awk/filters"
+	"cmp"
+	"fmt"
+	"os"
+	"time"
+
+	// the zone database, for systems that don't have one, e.g. Windows
+	_ "time/tzdata"
+)
+
+var tz *string = flagset.String("tz", "", "")
+
+/* the timezone picked, UTC unless told otherwise */
+var timezone *time.Location = time.UTC
+
+/*
+	Sets the timezone from --tz, or else the config file, or else TZ in
+	the environment, which is taken as the local timezone. With none of
+	these, times are UTC, so that two analysts looking at the same logs
+	see the same times whatever their machines are set to
+*/
+func check_timezone(cfg *config.Config) {
+	name := cmp.Or(*tz, cfg.Timezone)
+	if name == "" {
+		if _, ok := os.LookupEnv("TZ"); !ok {
+			return
+		}
+		name = "local"
+	}
+
+	switch name {
+	case "local", "Local":
+		timezone = time.Local
+	default:
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			fail(ErrUsage, fmt.Sprintf("--tz must be UTC, local or a timezone like America/New_York, not %s", name))
+		}
+		timezone = loc
+	}
+
+	filters.Timezone = timezone
+}
+
+/*
+	Returns where times are to be written out as dates, as zeek-cut does:
+	in UTC with -u, or with -d in the timezone given to --tz or in the
+	config file, or else in the machine's own. Returns nil to leave them
+	as seconds since the epoch
+*/
+func display_timezone(cfg *config.Config) *time.Location {
+	switch {
+	case *utc_times:
+		return time.UTC
+	case *local_times && cmp.Or(*tz, cfg.Timezone) != "":
+		return timezone
+	case *local_times:
+		return time.Local
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+	Description:
+		Reports where the time went in scanning each log with --timing,
+		so that it can be told whether the storage, decompression, the
+		filters or whatever the matches are written to is holding a scan
+		up
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var show_timing *bool = flagset.Bool("timing", false, "")
+
+/*
+	Prints the timings of each log scanned on STDERR. Reading is the time
+	spent waiting on the log, of which storage went on reading it from
+	disk (or the network) and the rest on decompressing it. Splitting,
+	filtering and writing are added up over the parser workers, so may
+	come to more than the wall time
+*/
+func report_timings(q *qreader.Qreader) {
+	if !*show_timing {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "log\twall\treading\tstorage\tsplitting\tfiltering\twriting\tlines\tmatches")
+	for _, t := range q.Timings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n", t.File, round(t.Wall), round(t.Reading), round(t.Storage),
+			round(t.Splitting), round(t.Filtering), round(t.Writing), t.Lines, t.Matches)
+	}
+	w.Flush()
+}
+
+/* a duration to the millisecond, which is as precise as is worth reading */
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
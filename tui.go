@@ -0,0 +1,605 @@
+/*
+	Description:
+		Implements `--tui`, which shows the matches in a table on the
+		terminal as they come in, for a quick look through them without
+		piping them into other tools. The table scrolls both ways, is
+		searched as the search is typed, can be sorted by any column, and
+		columns can be hidden and shown again:
+
+			↑ ↓ PgUp PgDn Home End	move between rows (or j k g G)
+			← →			move between columns (or h l)
+			/ n N			search, then find the next or previous match
+			s			sort by the column, again for descending
+			x a			hide the column, show them all again
+			q			quit
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var tui *bool = flagset.Bool("tui", false, "")
+
+/* how often the table is redrawn while matches come in */
+const tui_redraw time.Duration = 200 * time.Millisecond
+
+/* widest a column is drawn, in characters */
+const tui_max_width int = 40
+
+/*
+	Diagnostics logged while the table is up, which would otherwise be
+	drawn over it, and are written to STDERR once it closes
+*/
+type held_output struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (self *held_output) Write(p []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buffer.Write(p)
+}
+
+func (self *held_output) flush() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	os.Stderr.Write(self.buffer.Bytes())
+	self.buffer.Reset()
+}
+
+var tui_diagnostics *held_output = &held_output{}
+
+/*
+	The matches and how they are being looked at. Columns are every field
+	of the matches, in the order they were first seen, since logs of
+	different types may be scanned together. Each row has a value for
+	the columns there were when it came in
+*/
+type table struct {
+	columns    []string
+	index      map[string]int
+	widths     []int
+	hidden     map[string]bool
+	only       map[string]bool
+	rows       [][]string
+	done       bool
+	err        error
+	failed     int
+	row        int
+	top        int
+	column     int
+	left       int
+	sort_by    int
+	descending bool
+	unsorted   bool
+	searching  bool
+	query      string
+	search_row int
+	message    string
+}
+
+/*
+	Starts a table of the matches, showing only the given fields at first,
+	if any are
+*/
+func new_table(fields []string) *table {
+	self := &table{index: make(map[string]int), hidden: make(map[string]bool), sort_by: -1}
+	if len(fields) > 0 && !slices.Contains(fields, qreader.DefaultFields) {
+		self.only = make(map[string]bool)
+		for _, field := range fields {
+			self.only[field] = true
+		}
+	}
+
+	return self
+}
+
+/*
+	Adds a match to the table, along with any fields it has that the
+	table doesn't yet
+*/
+func (self *table) add(record qreader.Record) {
+	values := make([]string, len(self.columns))
+	for i, field := range record.Fields {
+		if i >= len(record.Values) {
+			break
+		}
+
+		idx, ok := self.index[field]
+		if !ok {
+			idx = len(self.columns)
+			self.index[field] = idx
+			self.columns = append(self.columns, field)
+			self.widths = append(self.widths, utf8.RuneCountInString(field))
+		}
+		if idx >= len(values) {
+			values = append(values, make([]string, idx+1-len(values))...)
+		}
+
+		// values are shown safely, with control characters escaped
+		value := qreader.Sanitize(qreader.SanitizeEscape, record.Values[i], "")
+		values[idx] = value
+		self.widths[idx] = min(max(self.widths[idx], utf8.RuneCountInString(value)), tui_max_width)
+	}
+
+	self.rows = append(self.rows, values)
+	self.unsorted = self.sort_by >= 0
+}
+
+/* value of the given column of a row, empty if the row hasn't the column */
+func (self *table) value(row int, column int) string {
+	if column < len(self.rows[row]) {
+		return self.rows[row][column]
+	}
+
+	return ""
+}
+
+/*
+	Returns the columns that are shown, in order
+*/
+func (self *table) visible() []int {
+	shown := make([]int, 0, len(self.columns))
+	for i, name := range self.columns {
+		if !self.hidden[name] && (self.only == nil || self.only[name]) {
+			shown = append(shown, i)
+		}
+	}
+
+	return shown
+}
+
+/*
+	Sorts the rows by the column sorted by, as numbers if both values are
+	and as text otherwise, keeping the order they came in between equals
+*/
+func (self *table) sort() {
+	if self.sort_by < 0 {
+		return
+	}
+
+	column := self.sort_by
+	slices.SortStableFunc(self.rows, func(a []string, b []string) int {
+		var x, y string
+		if column < len(a) {
+			x = a[column]
+		}
+		if column < len(b) {
+			y = b[column]
+		}
+
+		c := strings.Compare(x, y)
+		if m, err := strconv.ParseFloat(x, 64); err == nil {
+			if n, err := strconv.ParseFloat(y, 64); err == nil {
+				c = cmp.Compare(m, n)
+			}
+		}
+		if self.descending {
+			return -c
+		}
+		return c
+	})
+	self.unsorted = false
+}
+
+/*
+	Finds the next row from the given one, in the given direction and
+	wrapping around, with a shown value containing the search
+*/
+func (self *table) find(from int, step int) {
+	self.message = ""
+	if self.query == "" || len(self.rows) == 0 {
+		return
+	}
+
+	query := strings.ToLower(self.query)
+	shown := self.visible()
+	for n := range len(self.rows) {
+		row := ((from+n*step)%len(self.rows) + len(self.rows)) % len(self.rows)
+		for _, column := range shown {
+			if strings.Contains(strings.ToLower(self.value(row, column)), query) {
+				self.row = row
+				return
+			}
+		}
+	}
+
+	self.message = fmt.Sprintf("nothing matches %q", self.query)
+}
+
+/*
+	Acts on a key pressed, given as the character it types or the name of
+	the key, returning false once it is time to quit
+*/
+func (self *table) key(key string, page int) bool {
+	if self.searching {
+		switch key {
+		case "enter":
+			self.searching = false
+		case "escape":
+			self.searching, self.query, self.row, self.message = false, "", self.search_row, ""
+		case "backspace":
+			if self.query != "" {
+				_, size := utf8.DecodeLastRuneInString(self.query)
+				self.query = self.query[:len(self.query)-size]
+				self.find(self.search_row, 1)
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				self.query += key
+				self.find(self.search_row, 1)
+			}
+		}
+		return true
+	}
+
+	self.message = ""
+	shown := self.visible()
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case "up", "k":
+		self.row--
+	case "down", "j":
+		self.row++
+	case "pgup":
+		self.row -= page
+	case "pgdn", " ":
+		self.row += page
+	case "home", "g":
+		self.row = 0
+	case "end", "G":
+		self.row = len(self.rows) - 1
+	case "left", "h":
+		self.column--
+	case "right", "l":
+		self.column++
+	case "/":
+		self.searching, self.query, self.search_row = true, "", self.row
+	case "n":
+		self.find(self.row+1, 1)
+	case "N":
+		self.find(self.row-1, -1)
+	case "s":
+		if self.column < len(shown) {
+			if self.sort_by == shown[self.column] {
+				self.descending = !self.descending
+			} else {
+				self.sort_by, self.descending = shown[self.column], false
+			}
+			self.sort()
+		}
+	case "x":
+		if len(shown) > 1 && self.column < len(shown) {
+			self.hidden[self.columns[shown[self.column]]] = true
+		}
+	case "a":
+		self.hidden, self.only = make(map[string]bool), nil
+	}
+
+	return true
+}
+
+/*
+	Draws the table to fit a terminal of the given size: the names of the
+	columns, as many rows as fit, and a line saying where things stand
+*/
+func (self *table) draw(height int, width int) string {
+	if self.unsorted {
+		self.sort()
+	}
+
+	// keep the cursor on a row and column that exist, and in view
+	body := max(height-2, 1)
+	shown := self.visible()
+	self.row = max(min(self.row, len(self.rows)-1), 0)
+	self.column = max(min(self.column, len(shown)-1), 0)
+	self.top = max(min(self.top, self.row), self.row-body+1, 0)
+	self.left = min(self.left, self.column)
+	for self.left < self.column && span(self.widths, shown[self.left:self.column+1]) > width {
+		self.left++
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+
+	// the names of the columns, with the current one picked out
+	var header strings.Builder
+	for i, column := range shown[self.left:] {
+		name := fit(self.columns[column], self.widths[column])
+		if self.left+i == self.column {
+			name = "\033[7m" + name + "\033[27m"
+		}
+		header.WriteString(name + " ")
+	}
+	b.WriteString("\033[1m" + clip(header.String(), width) + "\033[0m\033[K\r\n")
+
+	for line := range body {
+		row := self.top + line
+		if row < len(self.rows) {
+			var cells strings.Builder
+			for _, column := range shown[self.left:] {
+				cells.WriteString(fit(self.value(row, column), self.widths[column]) + " ")
+			}
+			text := clip(cells.String(), width)
+			if row == self.row {
+				text = "\033[7m" + text + "\033[0m"
+			}
+			b.WriteString(text)
+		}
+		b.WriteString("\033[K\r\n")
+	}
+
+	b.WriteString("\033[7m" + clip(self.status(), width) + "\033[K\033[0m")
+	return b.String()
+}
+
+/*
+	Says how many matches there are, whether more are coming, how they
+	are sorted and what is being searched for
+*/
+func (self *table) status() string {
+	parts := []string{}
+	if len(self.rows) == 0 {
+		parts = append(parts, "no matches")
+	} else {
+		parts = append(parts, fmt.Sprintf("row %d of %d", self.row+1, len(self.rows)))
+	}
+
+	switch {
+	case self.err != nil:
+		parts = append(parts, fmt.Sprintf("stopped: %s", self.err))
+	case !self.done:
+		parts = append(parts, "scanning...")
+	}
+	if self.failed == 1 {
+		parts = append(parts, "1 log couldn't be scanned")
+	} else if self.failed > 1 {
+		parts = append(parts, fmt.Sprintf("%d logs couldn't be scanned", self.failed))
+	}
+
+	if self.sort_by >= 0 {
+		order := "ascending"
+		if self.descending {
+			order = "descending"
+		}
+		parts = append(parts, fmt.Sprintf("sorted by %s, %s", self.columns[self.sort_by], order))
+	}
+
+	switch {
+	case self.searching:
+		parts = append(parts, "/"+self.query)
+	case self.message != "":
+		parts = append(parts, self.message)
+	default:
+		parts = append(parts, "q quit, / search, s sort, x hide, a show all")
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+/* width the given columns take up, with a space after each */
+func span(widths []int, columns []int) int {
+	total := 0
+	for _, column := range columns {
+		total += widths[column] + 1
+	}
+
+	return total
+}
+
+/* pads or cuts a value to exactly the given width */
+func fit(value string, width int) string {
+	n := utf8.RuneCountInString(value)
+	if n > width {
+		return string([]rune(value)[:width-1]) + "…"
+	}
+
+	return value + strings.Repeat(" ", width-n)
+}
+
+/* cuts a line to the width of the terminal, ignoring the escapes in it */
+func clip(line string, width int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\033' {
+			end := strings.IndexAny(line[i:], "mHK")
+			if end < 0 {
+				break
+			}
+			b.WriteString(line[i : i+end+1])
+			i += end + 1
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if n < width {
+			b.WriteRune(r)
+			n++
+		}
+		i += size
+	}
+
+	return b.String()
+}
+
+/*
+	Splits what was read from the terminal into the keys pressed, naming
+	those that don't type a character
+*/
+func decode_keys(input []byte) []string {
+	sequences := map[string]string{
+		"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+		"OA": "up", "OB": "down", "OC": "right", "OD": "left",
+		"[5~": "pgup", "[6~": "pgdn", "[H": "home", "[F": "end",
+		"[1~": "home", "[4~": "end", "OH": "home", "OF": "end",
+	}
+
+	keys := make([]string, 0)
+	for i := 0; i < len(input); {
+		switch c := input[i]; {
+		case c == 0x1b && i+1 < len(input) && (input[i+1] == '[' || input[i+1] == 'O'):
+			// escape sequences end in a letter or ~
+			end := i + 2
+			for end < len(input) && !(input[end] >= 'A' && input[end] <= 'Z' || input[end] == '~') {
+				end++
+			}
+			if name, ok := sequences[string(input[i+1:min(end+1, len(input))])]; ok {
+				keys = append(keys, name)
+			}
+			i = end + 1
+		case c == 0x1b:
+			keys = append(keys, "escape")
+			i++
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+			i++
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+			i++
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+			i++
+		default:
+			r, size := utf8.DecodeRune(input[i:])
+			keys = append(keys, string(r))
+			i += size
+		}
+	}
+
+	return keys
+}
+
+/*
+	Shows the matches in the logs in a table until the user quits. Logs
+	that can't be scanned are counted as they fail, and each reported
+	once the table is closed. --fail-fast stops the scan at the first,
+	leaving the matches from before it to look through
+*/
+func run_tui(q *qreader.Qreader, logs []string) {
+	term, err := open_terminal()
+	if err != nil {
+		fail(ErrUsage, fmt.Sprintf("--tui needs a terminal: %s", err))
+	}
+
+	t := new_table(q.PrintFields)
+
+	// scan the logs one after the other, each on its own so that one
+	// failing doesn't stop the rest
+	records := make(chan qreader.Record)
+	failed := make(chan failed_log)
+	quit := make(chan struct{})
+	stop := sync.OnceFunc(func() {
+		close(quit)
+	})
+	go func() {
+		defer close(records)
+		for _, log := range logs {
+			for record, err := range q.Scan(log).Seq() {
+				if err != nil {
+					select {
+					case failed <- failed_log{log, err}:
+					case <-quit:
+						return
+					}
+					break
+				}
+				select {
+				case records <- record:
+				case <-quit:
+					return
+				}
+			}
+		}
+	}()
+
+	// keys are read as they are pressed, for as long as the table is up
+	input := make(chan []byte)
+	go func() {
+		for {
+			buffer := make([]byte, 64)
+			n, err := term.Read(buffer)
+			if err != nil {
+				return
+			}
+			input <- buffer[:n]
+		}
+	}()
+
+	// draw on the terminal's alternate screen, leaving what was on it be
+	fmt.Fprint(term, "\033[?1049h\033[?25l\033[2J")
+	closed := false
+	close_table := func() {
+		if !closed {
+			closed = true
+			fmt.Fprint(term, "\033[?25h\033[?1049l")
+			term.restore()
+			tui_diagnostics.flush()
+		}
+	}
+	defer close_table()
+
+	ticker := time.NewTicker(tui_redraw)
+	defer ticker.Stop()
+	changed := true
+	failures := make([]failed_log, 0)
+	for running := true; running; {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				t.done, records = true, nil
+			} else {
+				t.add(record)
+			}
+			changed = true
+			continue
+		case failure := <-failed:
+			failures = append(failures, failure)
+			t.failed = len(failures)
+			if *fail_fast {
+				t.err = failure.err
+				stop()
+			}
+			changed = true
+			continue
+		case pressed := <-input:
+			height, _ := term.size()
+			for _, key := range decode_keys(pressed) {
+				if !t.key(key, max(height-3, 1)) {
+					running = false
+					break
+				}
+			}
+			changed = running
+		case <-ticker.C:
+		}
+
+		if changed {
+			fmt.Fprint(term, t.draw(term.size()))
+			changed = false
+		}
+	}
+
+	stop()
+	close_table()
+	if t.err != nil {
+		fail_with(t.err, "file", failures[0].log)
+	}
+	if len(failures) > 0 {
+		fail_logs(failures, len(logs))
+	}
+}
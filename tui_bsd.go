@@ -0,0 +1,9 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+/* requests that get and set the mode of a terminal */
+const ioctl_get_mode uintptr = syscall.TIOCGETA
+const ioctl_set_mode uintptr = syscall.TIOCSETA
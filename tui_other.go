@@ -0,0 +1,29 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+/*
+	Stands in for the terminal the table is drawn on where there's no
+	Unix terminal to draw it on, such as the Windows console
+*/
+type terminal struct{}
+
+func open_terminal() (*terminal, error) {
+	return nil, errors.New("only Unix terminals are supported")
+}
+
+func (self *terminal) size() (int, int) {
+	return 24, 80
+}
+
+func (self *terminal) Read(p []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (self *terminal) Write(p []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (self *terminal) restore() {}
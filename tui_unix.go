@@ -0,0 +1,93 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/*
+	The terminal the table is drawn on, which is read a key at a time
+	rather than a line at a time, and restored to how it was when done.
+	It is opened by name, since STDIN and STDOUT may be a log and a pipe
+*/
+type terminal struct {
+	tty   *os.File
+	saved syscall.Termios
+}
+
+/* dimensions of a terminal, as TIOCGWINSZ fills them in */
+type window_size struct {
+	rows    uint16
+	columns uint16
+	x       uint16
+	y       uint16
+}
+
+func open_terminal() (*terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &terminal{tty: tty}
+	if err := self.ioctl(ioctl_get_mode, unsafe.Pointer(&self.saved)); err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	// keys are passed on as they are pressed, unechoed, Ctrl-C included
+	raw := self.saved
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := self.ioctl(ioctl_set_mode, unsafe.Pointer(&raw)); err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	return self, nil
+}
+
+func (self *terminal) ioctl(request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, self.tty.Fd(), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+/*
+	Returns the number of rows and columns of the terminal, or the usual
+	24 by 80 if it won't say
+*/
+func (self *terminal) size() (int, int) {
+	var ws window_size
+	if err := self.ioctl(syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil || ws.rows == 0 || ws.columns == 0 {
+		return 24, 80
+	}
+
+	return int(ws.rows), int(ws.columns)
+}
+
+func (self *terminal) Read(p []byte) (int, error) {
+	return self.tty.Read(p)
+}
+
+func (self *terminal) Write(p []byte) (int, error) {
+	return self.tty.Write(p)
+}
+
+/*
+	Puts the terminal back the way it was found
+*/
+func (self *terminal) restore() {
+	self.ioctl(ioctl_set_mode, unsafe.Pointer(&self.saved))
+	self.tty.Close()
+}
@@ -0,0 +1,78 @@
+/*
+	Description:
+		Reports which build of bro-awk is running. Release builds should
+		set the values below with
+
+			go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.build_date=$(date -u +%FT%TZ)"
+
+		otherwise whatever the Go toolchain embedded is used instead
+*/
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	runtime_debug "runtime/debug"
+)
+
+var version string = ""
+var commit string = ""
+var build_date string = ""
+
+/*
+	Fills in any version details not set through -ldflags from the build
+	info embedded by the Go toolchain
+*/
+func build_info() (string, string, string) {
+	v, c, d := version, commit, build_date
+
+	if info, ok := runtime_debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = setting.Value
+				}
+			case "vcs.modified":
+				modified = (setting.Value == "true")
+			}
+		}
+		// only flag the toolchain's own revision, ldflags values are taken as given
+		if modified && commit == "" && c != "" {
+			c += "-dirty"
+		}
+	}
+
+	if v == "" {
+		v = "dev"
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+
+	return v, c, d
+}
+
+/*
+	Prints the version report for --version
+*/
+func print_version() {
+	v, c, d := build_info()
+	fmt.Printf("bro-awk %s\n", v)
+	fmt.Printf("commit:\t\t%s\n", c)
+	fmt.Printf("built:\t\t%s\n", d)
+	fmt.Printf("go:\t\t%s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,202 @@
+/*
+	Description:
+		Finds the logs inside a directory given on the command line,
+		walking it recursively and keeping only the log types and dates
+		asked for. Dates are taken from Zeek's archive layout, where each
+		day's logs are kept in a directory named after it and each log is
+		named after the hours it covers:
+
+			/logs/2024-05-01/conn.00:00:00-01:00:00.log.gz
+*/
+
+package main
+
+import (
+	"bro-awk/schema"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var log_types *string = flagset.String("log-type", "", "")
+var from_date *string = flagset.String("from", "", "")
+var to_date *string = flagset.String("to", "", "")
+
+/* the day a log belongs to, from its directory or its own name */
+var date_re *regexp.Regexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+/* the hours a rotated log covers, e.g. conn.10:00:00-11:00:00.log.gz */
+var hours_re *regexp.Regexp = regexp.MustCompile(`\.(\d{2}:\d{2}:\d{2})-(\d{2}:\d{2}:\d{2})\.`)
+
+/* layout of the days in Zeek's archive */
+const date_layout string = "2006-01-02"
+
+/* layouts of the times given to --from and --to, down to the day */
+var time_layouts []string = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", date_layout}
+
+/* the span of time given by --from and --to, zero where not given */
+var from_time, to_time time.Time
+
+/*
+	Checks that --from and --to are dates or times Zeek's layout can be
+	compared to, in the --tz timezone. A day given to --to runs through to
+	the end of that day
+*/
+func check_dates() {
+	from_time = parse_time("--from", *from_date, false)
+	to_time = parse_time("--to", *to_date, true)
+
+	if !from_time.IsZero() && !to_time.IsZero() && !to_time.After(from_time) {
+		fail(ErrUsage, fmt.Sprintf("--to %s is not after --from %s", *to_date, *from_date))
+	}
+}
+
+func parse_time(name string, value string, end bool) time.Time {
+	t, ok := parse_date(value, end)
+	if !ok {
+		fail(ErrUsage, fmt.Sprintf("%s must be a date like 2024-05-01 or a time like 2024-05-01T10:00, not %s", name, value))
+	}
+
+	return t
+}
+
+/*
+	Reads a date or time in one of time_layouts, in the --tz timezone. A
+	day that ends a span runs through to the end of the day. Nothing
+	gives the zero time
+*/
+func parse_date(value string, end bool) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+
+	for _, layout := range time_layouts {
+		if t, err := time.ParseInLocation(layout, value, timezone); err == nil {
+			if end && layout == date_layout {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+/*
+	Returns the span of time a log covers: the day in its path, narrowed
+	down to the hours in its name if it was rotated hourly (or however
+	often), in the --tz timezone. A log rotated at midnight ends on the
+	following day
+*/
+func log_span(path string) (time.Time, time.Time, bool) {
+	// the date closest to the file is the one that counts
+	dates := date_re.FindAllString(path, -1)
+	if len(dates) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	day, err := time.ParseInLocation(date_layout, dates[len(dates)-1], timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	m := hours_re.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return day, day.AddDate(0, 0, 1), true
+	}
+
+	start, err1 := time.Parse("15:04:05", m[1])
+	end, err2 := time.Parse("15:04:05", m[2])
+	if err1 != nil || err2 != nil {
+		return day, day.AddDate(0, 0, 1), true
+	}
+
+	from := day.Add(since_midnight(start))
+	to := day.Add(since_midnight(end))
+	if !to.After(from) {
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, true
+}
+
+/*
+	Notes that a log was left out of a directory, for --explain
+*/
+func prune(path string, reason string) {
+	if *explain {
+		pruned = append(pruned, pruned_log{path, reason})
+	}
+}
+
+func since_midnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+/*
+	Returns every log under the directory that matches --log-type and
+	covers any of the time between --from and --to, sorted by path. Logs
+	with no date in their path are left out whenever a range is given
+*/
+func walk_dir(dir string) []string {
+	types := make([]string, 0)
+	if *log_types != "" {
+		types = strings.Split(*log_types, ",")
+	}
+
+	logs, err := walk_logs(dir, types, from_time, to_time)
+	if err != nil {
+		fail_with(err, "file", dir)
+	}
+
+	if len(logs) == 0 {
+		fail(ErrUsage, fmt.Sprintf("no matching logs found under %s", dir), "file", dir)
+	}
+
+	return logs
+}
+
+/*
+	Returns every log under the directory of one of the types (or any
+	type, if none are given) that covers any of the time between from and
+	to, where they aren't zero
+*/
+func walk_logs(dir string, types []string, from time.Time, to time.Time) ([]string, error) {
+	logs := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !log_re.MatchString(path) {
+			return nil
+		}
+
+		if len(types) > 0 && !slices.Contains(types, schema.PathOf(path)) {
+			prune(path, fmt.Sprintf("a %s log, not one of --log-type %s", schema.PathOf(path), strings.Join(types, ",")))
+			return nil
+		}
+
+		if !from.IsZero() || !to.IsZero() {
+			start, end, ok := log_span(path)
+			if !ok {
+				prune(path, "no date in its path to tell whether it is between --from and --to")
+				return nil
+			}
+			if !from.IsZero() && !end.After(from) {
+				prune(path, fmt.Sprintf("ends at %s, by its path, before --from", end.Format(time.DateTime)))
+				return nil
+			}
+			if !to.IsZero() && !start.Before(to) {
+				prune(path, fmt.Sprintf("starts at %s, by its path, after --to", start.Format(time.DateTime)))
+				return nil
+			}
+		}
+
+		logs = append(logs, path)
+		return nil
+	})
+
+	return logs, err
+}
@@ -0,0 +1,104 @@
+/*
+	Description:
+		Implements `--watch`, which keeps an eye on an archive directory
+		and scans every compressed log that appears in it, once, as soon
+		as it has been completely written. Logs that were already there
+		when bro-awk started are left alone
+*/
+
+package main
+
+import (
+	"bro-awk/qreader"
+	"bro-awk/schema"
+	"io/fs"
+	"iter"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var watch_dir *string = flagset.String("watch", "", "")
+
+/* how often the watched directory is checked for new logs */
+var watch_interval time.Duration = 2 * time.Second
+
+/*
+	Polls the directory until the Qreader is stopped, scanning each new
+	log with it
+*/
+func watch(q *qreader.Qreader, dir string) {
+	q.Logger.Info("watching for new logs", "dir", dir)
+
+	// anything already there has been dealt with before
+	types := make([]string, 0)
+	if *log_types != "" {
+		types = strings.Split(*log_types, ",")
+	}
+	seen := make(map[string]bool)
+	for path := range archived_logs(dir, types) {
+		seen[path] = true
+	}
+
+	// a log is only scanned once its size stops changing between polls,
+	// so that one still being compressed or copied in isn't read half-done
+	pending := make(map[string]int64)
+
+	for !q.Stopped() {
+		time.Sleep(watch_interval)
+
+		for path, info := range archived_logs(dir, types) {
+			if seen[path] {
+				continue
+			}
+			size := info.Size()
+
+			last, ok := pending[path]
+			if !ok || last != size {
+				pending[path] = size
+				continue
+			}
+
+			delete(pending, path)
+			seen[path] = true
+			// one bad log shouldn't end the watch, e.g. another type of log
+			// that doesn't have the filtered fields
+			if err := q.Parse(path); err != nil {
+				q.Logger.Error("unable to scan log", "file", path, "err", err)
+			}
+		}
+	}
+}
+
+/*
+	Returns the compressed logs under the directory of any of the log
+	types (or of any type, if none are given), along with their sizes and
+	times, in path order
+*/
+func archived_logs(dir string, types []string) iter.Seq2[string, fs.FileInfo] {
+	return func(yield func(string, fs.FileInfo) bool) {
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			// the directory may change as it is walked, so skip over
+			// anything that has gone
+			if err != nil || !entry.Type().IsRegular() {
+				return nil
+			}
+			if !strings.HasSuffix(path, ".gz") || !log_re.MatchString(path) {
+				return nil
+			}
+			if len(types) > 0 && !slices.Contains(types, schema.PathOf(path)) {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if !yield(path, info) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+}